@@ -2,11 +2,16 @@ package common
 
 import (
 	//"github.com/Redundancy/go-sync"
+	"errors"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 )
 
+var ErrFileSyncCanceled = errors.New("file sync canceled")
+
 // startStats prints the stats every statsInterval
 //
 // It returns a channel which should be closed to stop the stats.
@@ -60,6 +65,12 @@ func Run(retries int, f func() error) error {
 }
 
 func RunFileSync(remote string, srcPath string, dstPath string) error {
+	return RunFileSyncWithStop(remote, srcPath, dstPath, nil)
+}
+
+// RunFileSyncWithStop is the same as RunFileSync, but the copy process
+// will be killed if the stopC is closed before finished.
+func RunFileSyncWithStop(remote string, srcPath string, dstPath string, stopC <-chan struct{}) error {
 	var cmd *exec.Cmd
 	if remote == "" {
 		log.Printf("copy local :%v to %v\n", srcPath, dstPath)
@@ -69,7 +80,23 @@ func RunFileSync(remote string, srcPath string, dstPath string) error {
 		log.Printf("copy from remote \n")
 		cmd = exec.Command("scp", "-rp", "-l", "409600", remote+":"+srcPath, dstPath)
 	}
-	err := cmd.Run()
+	err := cmd.Start()
+	if err != nil {
+		log.Printf("cmd error: %v\n", err)
+		return err
+	}
+	waitC := make(chan error, 1)
+	go func() {
+		waitC <- cmd.Wait()
+	}()
+	select {
+	case err = <-waitC:
+	case <-stopC:
+		cmd.Process.Kill()
+		<-waitC
+		log.Printf("copy to %v canceled\n", dstPath)
+		return ErrFileSyncCanceled
+	}
 	if err != nil {
 		log.Printf("cmd error: %v\n", err)
 	}
@@ -82,3 +109,18 @@ func RunFileSync(remote string, srcPath string, dstPath string) error {
 	//err = rsync.Patch()
 	//return rsync.Close()
 }
+
+// GetDirSize return the total size of the regular files under the dir
+func GetDirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
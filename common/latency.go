@@ -0,0 +1,161 @@
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// the max samples kept for each event, the same as the redis latency monitor
+const MaxLatencyHistory = 160
+
+type LatencySample struct {
+	Time      int64 `json:"time"`
+	LatencyMs int64 `json:"latency_ms"`
+}
+
+// LatencyEventStats is the latest sample and the max latency of the event
+// since reset.
+type LatencyEventStats struct {
+	Event     string `json:"event"`
+	Time      int64  `json:"time"`
+	LatencyMs int64  `json:"latency_ms"`
+	MaxMs     int64  `json:"max_ms"`
+}
+
+type latencyEvent struct {
+	// the ring of the samples, the next is the position of the next sample
+	history []LatencySample
+	next    int
+	max     int64
+}
+
+func (self *latencyEvent) last() *LatencySample {
+	if len(self.history) == 0 {
+		return nil
+	}
+	i := self.next - 1
+	if i < 0 {
+		i = len(self.history) - 1
+	}
+	return &self.history[i]
+}
+
+// LatencyMonitor record the latency spikes of the events (the command name)
+// which cost not less than the threshold, like the redis latency monitor. At
+// most one sample is kept for each event in each second, the max latency of
+// the second, and at most MaxLatencyHistory samples are kept for each event.
+type LatencyMonitor struct {
+	sync.Mutex
+	thresholdMs int64
+	events      map[string]*latencyEvent
+}
+
+// the monitor is disabled if the threshold is not positive
+func NewLatencyMonitor(thresholdMs int64) *LatencyMonitor {
+	return &LatencyMonitor{
+		thresholdMs: thresholdMs,
+		events:      make(map[string]*latencyEvent),
+	}
+}
+
+func (self *LatencyMonitor) Threshold() int64 {
+	return self.thresholdMs
+}
+
+func (self *LatencyMonitor) Record(event string, cost time.Duration) {
+	threshold := self.Threshold()
+	ms := int64(cost / time.Millisecond)
+	if threshold <= 0 || ms < threshold {
+		return
+	}
+	now := time.Now().Unix()
+	self.Lock()
+	defer self.Unlock()
+	e, ok := self.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		self.events[event] = e
+	}
+	if ms > e.max {
+		e.max = ms
+	}
+	if last := e.last(); last != nil && last.Time == now {
+		if ms > last.LatencyMs {
+			last.LatencyMs = ms
+		}
+		return
+	}
+	s := LatencySample{Time: now, LatencyMs: ms}
+	if len(e.history) < MaxLatencyHistory {
+		e.history = append(e.history, s)
+		e.next = len(e.history) % MaxLatencyHistory
+		return
+	}
+	e.history[e.next] = s
+	e.next = (e.next + 1) % MaxLatencyHistory
+}
+
+// Latest return the latest sample of all the events ordered by the event name
+func (self *LatencyMonitor) Latest() []LatencyEventStats {
+	self.Lock()
+	defer self.Unlock()
+	names := make([]string, 0, len(self.events))
+	for name := range self.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]LatencyEventStats, 0, len(names))
+	for _, name := range names {
+		e := self.events[name]
+		last := e.last()
+		if last == nil {
+			continue
+		}
+		ret = append(ret, LatencyEventStats{
+			Event:     name,
+			Time:      last.Time,
+			LatencyMs: last.LatencyMs,
+			MaxMs:     e.max,
+		})
+	}
+	return ret
+}
+
+// History return the samples of the event from the oldest to the newest
+func (self *LatencyMonitor) History(event string) []LatencySample {
+	self.Lock()
+	defer self.Unlock()
+	e, ok := self.events[event]
+	if !ok {
+		return nil
+	}
+	ret := make([]LatencySample, 0, len(e.history))
+	if len(e.history) == MaxLatencyHistory {
+		ret = append(ret, e.history[e.next:]...)
+		ret = append(ret, e.history[:e.next]...)
+	} else {
+		ret = append(ret, e.history...)
+	}
+	return ret
+}
+
+// Reset clear the samples of the events (all the events if empty), return
+// the number of the events reset.
+func (self *LatencyMonitor) Reset(events ...string) int {
+	self.Lock()
+	defer self.Unlock()
+	if len(events) == 0 {
+		n := len(self.events)
+		self.events = make(map[string]*latencyEvent)
+		return n
+	}
+	n := 0
+	for _, name := range events {
+		if _, ok := self.events[name]; ok {
+			delete(self.events, name)
+			n++
+		}
+	}
+	return n
+}
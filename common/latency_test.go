@@ -0,0 +1,65 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	m := NewLatencyMonitor(10)
+	m.Record("get", time.Millisecond*9)
+	if events := m.Latest(); len(events) != 0 {
+		t.Fatalf("the latency below the threshold should be ignored: %v", events)
+	}
+	m.Record("set", time.Millisecond*20)
+	m.Record("set", time.Millisecond*50)
+	m.Record("set", time.Millisecond*30)
+	m.Record("get", time.Millisecond*15)
+	events := m.Latest()
+	if len(events) != 2 || events[0].Event != "get" || events[1].Event != "set" {
+		t.Fatal(events)
+	}
+	// only the max latency in the same second is kept
+	if events[1].LatencyMs != 50 || events[1].MaxMs != 50 {
+		t.Fatal(events[1])
+	}
+	if h := m.History("set"); len(h) != 1 || h[0].LatencyMs != 50 {
+		t.Fatal(h)
+	}
+
+	// the history is bounded and ordered from the oldest, the sample time
+	// is moved back so each record is a new sample
+	e := m.events["set"]
+	for i := 0; i < MaxLatencyHistory+10; i++ {
+		m.Record("set", time.Millisecond*20)
+		e.last().Time -= int64(MaxLatencyHistory + 10 - i)
+	}
+	h := m.History("set")
+	if len(h) != MaxLatencyHistory {
+		t.Fatal(len(h))
+	}
+	for i := 1; i < len(h); i++ {
+		if h[i].Time <= h[i-1].Time {
+			t.Fatalf("the history should be ordered: %v", h)
+		}
+	}
+
+	if n := m.Reset("set", "noevent"); n != 1 {
+		t.Fatal(n)
+	}
+	if h := m.History("set"); len(h) != 0 {
+		t.Fatal(h)
+	}
+	if n := m.Reset(); n != 1 {
+		t.Fatal(n)
+	}
+	if events := m.Latest(); len(events) != 0 {
+		t.Fatal(events)
+	}
+
+	disabled := NewLatencyMonitor(0)
+	disabled.Record("set", time.Second)
+	if events := disabled.Latest(); len(events) != 0 {
+		t.Fatal(events)
+	}
+}
@@ -54,9 +54,253 @@ func (self *WriteStats) Copy() *WriteStats {
 	return &s
 }
 
+// BatchStats is the distribution of the write batches proposed to raft.
+type BatchStats struct {
+	// 1, 2, 4, 8, 16, ... requests in one batch
+	BatchNumStats [16]int64 `json:"batch_num_stats"`
+	// <100bytes, <1KB, 2KB, 4KB, 8KB, 16KB, 32KB, 64KB, 128KB, 256KB, 512KB, 1MB, 2MB, 4MB
+	BatchBytesStats [16]int64 `json:"batch_bytes_stats"`
+	// the time waited since the first request queued in the batch
+	// <1024us, 2ms, 4ms, 8ms, 16ms, 32ms, 64ms, 128ms, 256ms, 512ms, 1024ms, 2048ms, 4s, 8s
+	BatchLingerStats [16]int64 `json:"batch_linger_stats"`
+}
+
+func (self *BatchStats) UpdateBatchStats(num int, bytes int64, lingerUs int64) {
+	bucket := 0
+	if num > 1 {
+		bucket = int(math.Log2(float64(num)))
+	}
+	if bucket >= len(self.BatchNumStats) {
+		bucket = len(self.BatchNumStats) - 1
+	}
+	atomic.AddInt64(&self.BatchNumStats[bucket], 1)
+
+	bucket = 0
+	if bytes < 100 {
+	} else if bytes < 1024 {
+		bucket = 1
+	} else {
+		bucket = int(math.Log2(float64(bytes/1024))) + 2
+	}
+	if bucket >= len(self.BatchBytesStats) {
+		bucket = len(self.BatchBytesStats) - 1
+	}
+	atomic.AddInt64(&self.BatchBytesStats[bucket], 1)
+
+	bucket = 0
+	if lingerUs >= 1024 {
+		bucket = int(math.Log2(float64(lingerUs/1000))) + 1
+	}
+	if bucket >= len(self.BatchLingerStats) {
+		bucket = len(self.BatchLingerStats) - 1
+	}
+	atomic.AddInt64(&self.BatchLingerStats[bucket], 1)
+}
+
+func (self *BatchStats) Copy() *BatchStats {
+	var s BatchStats
+	for i := 0; i < len(self.BatchNumStats); i++ {
+		s.BatchNumStats[i] = atomic.LoadInt64(&self.BatchNumStats[i])
+		s.BatchBytesStats[i] = atomic.LoadInt64(&self.BatchBytesStats[i])
+		s.BatchLingerStats[i] = atomic.LoadInt64(&self.BatchLingerStats[i])
+	}
+	return &s
+}
+
+type ReadStats struct {
+	ReadNum       int64 `json:"read_num"`
+	InflightReads int64 `json:"inflight_reads"`
+	// <1024us, 2ms, 4ms, 8ms, 16ms, 32ms, 64ms, 128ms, 256ms, 512ms, 1024ms, 2048ms, 4s, 8s
+	ReadLatencyStats [16]int64 `json:"read_latency_stats"`
+}
+
+func (self *ReadStats) BeginRead() {
+	atomic.AddInt64(&self.InflightReads, 1)
+}
+
+func (self *ReadStats) EndRead(latencyUs int64) {
+	atomic.AddInt64(&self.InflightReads, -1)
+	atomic.AddInt64(&self.ReadNum, 1)
+	bucket := 0
+	if latencyUs < 1024 {
+	} else {
+		bucket = int(math.Log2(float64(latencyUs/1000))) + 1
+	}
+	if bucket >= len(self.ReadLatencyStats) {
+		bucket = len(self.ReadLatencyStats) - 1
+	}
+	atomic.AddInt64(&self.ReadLatencyStats[bucket], 1)
+}
+
+func (self *ReadStats) Copy() *ReadStats {
+	var s ReadStats
+	s.ReadNum = atomic.LoadInt64(&self.ReadNum)
+	s.InflightReads = atomic.LoadInt64(&self.InflightReads)
+	for i := 0; i < len(self.ReadLatencyStats); i++ {
+		s.ReadLatencyStats[i] = atomic.LoadInt64(&self.ReadLatencyStats[i])
+	}
+	return &s
+}
+
+// ExpireStats is the stats of the expire sweeper, the backlog is the expired
+// data waiting to be deleted found in the last scan (at most the sweep rate).
+// The SweepRate is the max expired data deleted in each second currently,
+// which is reduced under the heavy write load.
+// The TTLNum is the number of the data with expire time and the AvgTTLMs is
+// the average time to live of them, both are maintained by the store.
+type ExpireStats struct {
+	ActiveExpire    bool  `json:"active_expire"`
+	SweepNum        int64 `json:"sweep_num"`
+	ExpiredNum      int64 `json:"expired_num"`
+	LastExpiredNum  int64 `json:"last_expired_num"`
+	LastSweepCostUs int64 `json:"last_sweep_cost_us"`
+	Backlog         int64 `json:"backlog"`
+	SweepRate       int64 `json:"sweep_rate"`
+	TTLNum          int64 `json:"ttl_num"`
+	AvgTTLMs        int64 `json:"avg_ttl_ms"`
+}
+
+func (self *ExpireStats) UpdateSweepStats(expiredNum int64, costUs int64) {
+	atomic.AddInt64(&self.SweepNum, 1)
+	atomic.AddInt64(&self.ExpiredNum, expiredNum)
+	atomic.StoreInt64(&self.LastExpiredNum, expiredNum)
+	atomic.StoreInt64(&self.LastSweepCostUs, costUs)
+}
+
+func (self *ExpireStats) Copy() *ExpireStats {
+	var s ExpireStats
+	s.SweepNum = atomic.LoadInt64(&self.SweepNum)
+	s.ExpiredNum = atomic.LoadInt64(&self.ExpiredNum)
+	s.LastExpiredNum = atomic.LoadInt64(&self.LastExpiredNum)
+	s.LastSweepCostUs = atomic.LoadInt64(&self.LastSweepCostUs)
+	s.Backlog = atomic.LoadInt64(&self.Backlog)
+	return &s
+}
+
+// ReplicaReadStats is the read load and the replication lag of a replica,
+// which can be used to route the read to the least loaded in-sync replica.
+type ReplicaReadStats struct {
+	ID            uint64 `json:"id"`
+	Addr          string `json:"addr"`
+	IsLeader      bool   `json:"is_leader"`
+	CommitIndex   uint64 `json:"commit_index"`
+	AppliedIndex  uint64 `json:"applied_index"`
+	Lag           uint64 `json:"lag"`
+	ReadNum       int64  `json:"read_num"`
+	InflightReads int64  `json:"inflight_reads"`
+}
+
+// RaftMemberStats is the replication progress of a member from the leader view.
+type RaftMemberStats struct {
+	ID    uint64 `json:"id"`
+	Match uint64 `json:"match"`
+	Next  uint64 `json:"next"`
+	Lag   uint64 `json:"lag"`
+	State string `json:"state"`
+}
+
+// RaftStats is the raft state of the namespace partition on this node, the
+// members progress is only available on the leader.
+type RaftStats struct {
+	ID      uint64            `json:"id"`
+	Role    string            `json:"role"`
+	Term    uint64            `json:"term"`
+	Leader  uint64            `json:"leader"`
+	Members []RaftMemberStats `json:"members"`
+}
+
+// NodeIdentity is the raft member of the namespace partition on this node.
+type NodeIdentity struct {
+	Namespace string `json:"namespace"`
+	Partition int    `json:"partition"`
+	ID        uint64 `json:"id"`
+	ClusterID uint64 `json:"cluster_id"`
+	RaftAddr  string `json:"raft_addr"`
+}
+
+// RaftStateDump is the raw raft state of the namespace partition on this node
+// for debugging, the members progress is only available on the leader.
+type RaftStateDump struct {
+	ConfNodes    []uint64          `json:"conf_nodes"`
+	Term         uint64            `json:"term"`
+	Vote         uint64            `json:"vote"`
+	Commit       uint64            `json:"commit"`
+	SnapIndex    uint64            `json:"snap_index"`
+	AppliedIndex uint64            `json:"applied_index"`
+	Members      []RaftMemberStats `json:"members"`
+}
+
+// ReplPingStats is the replication latency of a member measured by the marker
+// proposed through raft, the latency of the leader is until the marker applied
+// and the latency of the follower is until it replicated the marker.
+type ReplPingStats struct {
+	ID        uint64 `json:"id"`
+	Leader    bool   `json:"leader"`
+	TimedOut  bool   `json:"timed_out"`
+	LatencyUs int64  `json:"latency_us"`
+}
+
+// ReplicaCatchupStats is the catch-up progress of a replica from the leader
+// view, the EstimatedMs is -1 if it can not be estimated yet. The replica can
+// catch up from the log if FromLog, otherwise the snapshot is needed.
+type ReplicaCatchupStats struct {
+	ID              uint64  `json:"id"`
+	State           string  `json:"state"`
+	Snapshotting    bool    `json:"snapshotting"`
+	PendingSnapshot uint64  `json:"pending_snapshot"`
+	Match           uint64  `json:"match"`
+	CommitIndex     uint64  `json:"commit_index"`
+	Lag             uint64  `json:"lag"`
+	Progress        float64 `json:"progress"`
+	InSync          bool    `json:"in_sync"`
+	EstimatedMs     int64   `json:"estimated_ms"`
+	FirstIndex      uint64  `json:"first_index"`
+	SnapCatchup     uint64  `json:"snap_catchup"`
+	FromLog         bool    `json:"from_log"`
+	SentSnapshots   int64   `json:"sent_snapshots"`
+}
+
+// WarmupStats is the data read to warm up the block cache after the snapshot
+// restored, Done is false if the warmup stopped by the size or time limit.
+type WarmupStats struct {
+	Keys   int64 `json:"keys"`
+	Bytes  int64 `json:"bytes"`
+	CostMs int64 `json:"cost_ms"`
+	Done   bool  `json:"done"`
+}
+
+// LogCompactStats is the result of the forced raft log compaction, the log
+// before the FirstIndex is truncated and the released wal files are purged.
+type LogCompactStats struct {
+	SnapIndex    uint64 `json:"snap_index"`
+	CompactIndex uint64 `json:"compact_index"`
+	FirstIndex   uint64 `json:"first_index"`
+	WALFiles     int    `json:"wal_files"`
+	PurgedWALs   int    `json:"purged_wals"`
+}
+
+// SnapshotResult is the snapshot taken on demand and the backup of it
+type SnapshotResult struct {
+	Term      uint64 `json:"term"`
+	Index     uint64 `json:"index"`
+	BackupDir string `json:"backup_dir"`
+}
+
+// the in-progress backup or snapshot transfer
+type BackupStatus struct {
+	// backup or transfer
+	Type      string `json:"type"`
+	Dir       string `json:"dir"`
+	StartTime int64  `json:"start_time"`
+	// the bytes written to the dir so far
+	Size int64 `json:"size"`
+}
+
 type TableStats struct {
 	Name   string `json:"name"`
 	KeyNum int64  `json:"key_num"`
+	// the approximate stored bytes estimated from the sampled keys
+	ApproxBytes int64 `json:"approx_bytes"`
 }
 
 type NamespaceStats struct {
@@ -64,6 +308,20 @@ type NamespaceStats struct {
 	TStats            []TableStats           `json:"table_stats"`
 	DBWriteStats      *WriteStats            `json:"db_write_stats"`
 	ClusterWriteStats *WriteStats            `json:"cluster_write_stats"`
+	ReadStats         *ReadStats             `json:"read_stats"`
+	BatchStats        *BatchStats            `json:"batch_stats"`
+	ExpireStats       *ExpireStats           `json:"expire_stats"`
+	RaftStats         RaftStats              `json:"raft_stats"`
+	CommitIndex       uint64                 `json:"commit_index"`
+	AppliedIndex      uint64                 `json:"applied_index"`
+	DurableIndex      uint64                 `json:"durable_index"`
+	StoreHealthy      bool                   `json:"store_healthy"`
+	ProposeQueueSize  int                    `json:"propose_queue_size"`
+	ProposeQueueFull  int64                  `json:"propose_queue_full"`
+	ProposeQueueLen   int                    `json:"propose_queue_len"`
+	AdminQueueLen     int                    `json:"admin_queue_len"`
+	ApplyStallNum     int64                  `json:"apply_stall_num"`
+	TypeApproxBytes   map[string]int64       `json:"type_approx_bytes"`
 	InternalStats     map[string]interface{} `json:"internal_stats"`
 	EngType           string                 `json:"eng_type"`
 }
@@ -72,4 +330,6 @@ type ServerStats struct {
 	// database stats
 	NSStats []NamespaceStats `json:"ns_stats"`
 	// other server related stats
+	// the manual compactions running of all the namespaces
+	CompactingNum int `json:"compacting_num"`
 }
@@ -3,6 +3,7 @@ package common
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"github.com/tidwall/redcon"
 	"strings"
 )
@@ -18,6 +19,10 @@ var (
 	ErrTimeout         = errors.New("queue request timeout")
 	ErrInvalidArgs     = errors.New("Invalid arguments")
 	ErrInvalidRedisKey = errors.New("invalid redis key")
+	ErrDraining        = errors.New("the node is draining, retry later")
+	ErrStoreUnhealthy  = errors.New("store unhealthy")
+	ErrNoLeader        = errors.New("no leader for the raft group, retry later")
+	ErrProposeTimeout  = errors.New("propose timeout, the write is refused by the leader or not applied in time")
 )
 
 // for out use
@@ -109,12 +114,17 @@ type InternalCommandFunc func(redcon.Command) (interface{}, error)
 type CmdRouter struct {
 	cmds         map[string]CommandFunc
 	internalCmds map[string]InternalCommandFunc
+	readCmds     map[string]bool
+	// the write command proposed as the other internal command
+	convertCmds map[string]string
 }
 
 func NewCmdRouter() *CmdRouter {
 	return &CmdRouter{
 		cmds:         make(map[string]CommandFunc),
 		internalCmds: make(map[string]InternalCommandFunc),
+		readCmds:     make(map[string]bool),
+		convertCmds:  make(map[string]string),
 	}
 }
 
@@ -126,6 +136,29 @@ func (r *CmdRouter) Register(name string, f CommandFunc) bool {
 	return true
 }
 
+// RegisterRead register the command which will not change any data
+func (r *CmdRouter) RegisterRead(name string, f CommandFunc) bool {
+	if !r.Register(name, f) {
+		return false
+	}
+	r.readCmds[name] = true
+	return true
+}
+
+// RegisterConverted register the write command which will be converted to
+// the internal command before proposing, such as the relative expire time.
+func (r *CmdRouter) RegisterConverted(name string, internal string, f CommandFunc) bool {
+	if !r.Register(name, f) {
+		return false
+	}
+	r.convertCmds[name] = internal
+	return true
+}
+
+func (r *CmdRouter) IsReadCommand(name string) bool {
+	return r.readCmds[strings.ToLower(name)]
+}
+
 func (r *CmdRouter) GetCmdHandler(name string) (CommandFunc, bool) {
 	v, ok := r.cmds[strings.ToLower(name)]
 	return v, ok
@@ -143,3 +176,25 @@ func (r *CmdRouter) GetInternalCmdHandler(name string) (InternalCommandFunc, boo
 	v, ok := r.internalCmds[strings.ToLower(name)]
 	return v, ok
 }
+
+// CheckCommands check all the write commands have the internal command to
+// apply, and the read commands are never applied. Otherwise the write will
+// fail with the invalid command after proposed.
+func (r *CmdRouter) CheckCommands() error {
+	for name := range r.cmds {
+		if r.readCmds[name] {
+			if _, ok := r.internalCmds[name]; ok {
+				return fmt.Errorf("the read command %v is registered as internal", name)
+			}
+			continue
+		}
+		internal := name
+		if v, ok := r.convertCmds[name]; ok {
+			internal = v
+		}
+		if _, ok := r.internalCmds[internal]; !ok {
+			return fmt.Errorf("the write command %v has no internal command %v", name, internal)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/tidwall/redcon"
+)
+
+func TestCmdRouterCheckCommands(t *testing.T) {
+	f := func(redcon.Conn, redcon.Command) {}
+	internal := func(redcon.Command) (interface{}, error) { return nil, nil }
+
+	r := NewCmdRouter()
+	r.RegisterRead("get", f)
+	r.Register("set", f)
+	r.RegisterConverted("expire", "pexpireat", f)
+	if err := r.CheckCommands(); err == nil {
+		t.Fatal("the write command without the internal command should fail")
+	}
+	r.RegisterInternal("set", internal)
+	if err := r.CheckCommands(); err == nil {
+		t.Fatal("the converted write command without the internal command should fail")
+	}
+	r.RegisterInternal("pexpireat", internal)
+	if err := r.CheckCommands(); err != nil {
+		t.Fatal(err)
+	}
+	r.RegisterInternal("get", internal)
+	if err := r.CheckCommands(); err == nil {
+		t.Fatal("the read command registered as internal should fail")
+	}
+}
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultApplyStallTimeout = time.Minute
+)
+
+// record the entry applying and the start time for the watchdog,
+// the zero start time means no entry is applying.
+func (self *KVNode) beginApply(index uint64) {
+	atomic.StoreUint64(&self.applyingIndex, index)
+	atomic.StoreInt64(&self.applyStart, time.Now().UnixNano())
+}
+
+func (self *KVNode) endApply() {
+	atomic.StoreInt64(&self.applyStart, 0)
+}
+
+// The slow batch is logged after applied, but the apply stalled forever
+// (such as the store deadlock) is never logged. The watchdog dump the
+// goroutine stacks once for each entry applying longer than the timeout,
+// and exit the process if crash, so the supervisor can restart the node.
+func (self *KVNode) applyWatchdogLoop() {
+	timeout := defaultApplyStallTimeout
+	crash := false
+	if self.nodeConfig != nil {
+		if self.nodeConfig.ApplyStallTimeoutMs < 0 {
+			return
+		}
+		if self.nodeConfig.ApplyStallTimeoutMs > 0 {
+			timeout = time.Duration(self.nodeConfig.ApplyStallTimeoutMs) * time.Millisecond
+		}
+		crash = self.nodeConfig.ApplyStallCrash
+	}
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	var dumped int64
+	for {
+		select {
+		case <-ticker.C:
+		case <-self.stopChan:
+			return
+		}
+		start := atomic.LoadInt64(&self.applyStart)
+		if start == 0 || start == dumped {
+			continue
+		}
+		cost := time.Duration(time.Now().UnixNano() - start)
+		if cost < timeout {
+			continue
+		}
+		dumped = start
+		atomic.AddInt64(&self.applyStallNum, 1)
+		self.dumpApplyStall(cost)
+		if crash {
+			nodeLog.Fatalf("namespace %v exit since the apply stalled for %v", self.ns, cost)
+		}
+	}
+}
+
+func (self *KVNode) dumpApplyStall(cost time.Duration) {
+	buf := make([]byte, 1024*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	status := self.raftNode.node.Status()
+	nodeLog.Warningf("namespace %v apply stalled for %v at index %v, applied: %v, commit: %v, "+
+		"role: %v, leader: %v, propose queue: %v, inflight: %v, store healthy: %v, goroutines:\n%s",
+		self.ns, cost, atomic.LoadUint64(&self.applyingIndex), atomic.LoadUint64(&self.appliedIndex),
+		status.Commit, status.RaftState.String(), status.Lead, len(self.reqProposeC),
+		atomic.LoadInt64(&self.inflightReqs), self.store.IsHealthy(), buf)
+}
+
+type applyHook func(cmdName string)
+
+// SetApplyHook set the hook called before applying each write command on this
+// replica, nil to remove the hook. This is only used by the tests to block or
+// delay the apply.
+func (self *KVNode) SetApplyHook(h func(cmdName string)) {
+	self.applyHook.Store(applyHook(h))
+}
+
+func (self *KVNode) runApplyHook(cmdName string) {
+	if h, ok := self.applyHook.Load().(applyHook); ok && h != nil {
+		h(cmdName)
+	}
+}
+
+type restoreHookFunc func(ns string)
+
+var restoreHook atomic.Value
+
+// SetRestoreHook set the hook called while restoring the snapshot on all the
+// namespaces of this process, nil to remove the hook. This is only used by
+// the tests to check the node while restoring.
+func SetRestoreHook(h func(ns string)) {
+	restoreHook.Store(restoreHookFunc(h))
+}
+
+func runRestoreHook(ns string) {
+	if h, ok := restoreHook.Load().(restoreHookFunc); ok && h != nil {
+		h(ns)
+	}
+}
@@ -0,0 +1,228 @@
+package node
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/tidwall/redcon"
+)
+
+const (
+	auditLogBufferSize     = 10000
+	defaultAuditLogMaxSize = 1024 * 1024 * 100
+)
+
+// The audit log is written asynchronously, so the apply loop will never be
+// blocked by the disk, and the entries will be dropped while the buffer is
+// full. The log file is rotated by renaming it with the rotated time suffix
+// while the size or the age exceed the limit.
+// The raft index of the last write logged is saved after flushed, and the
+// writes at or below the saved index are skipped while replaying the raft
+// log after restarted, so the writes are not logged again.
+type auditLogger struct {
+	fileName       string
+	indexFileName  string
+	maxSize        int64
+	rotateInterval time.Duration
+	logC           chan auditEntry
+	dropped        int64
+	stopC          chan struct{}
+	wg             sync.WaitGroup
+	// the raft index saved while started
+	replayedIndex uint64
+
+	f            *os.File
+	w            *bufio.Writer
+	size         int64
+	openTime     time.Time
+	writtenIndex uint64
+	savedIndex   uint64
+}
+
+type auditEntry struct {
+	line string
+	// the raft index of the write, 0 for the read
+	index uint64
+}
+
+func newAuditLogger(dir string, ns string, maxSize int64, rotateInterval time.Duration) (*auditLogger, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = defaultAuditLogMaxSize
+	}
+	l := &auditLogger{
+		fileName:       path.Join(dir, ns+".audit.log"),
+		indexFileName:  path.Join(dir, ns+".audit.index"),
+		maxSize:        maxSize,
+		rotateInterval: rotateInterval,
+		logC:           make(chan auditEntry, auditLogBufferSize),
+		stopC:          make(chan struct{}),
+	}
+	if d, err := ioutil.ReadFile(l.indexFileName); err == nil {
+		l.replayedIndex, _ = strconv.ParseUint(strings.TrimSpace(string(d)), 10, 64)
+		l.writtenIndex = l.replayedIndex
+		l.savedIndex = l.replayedIndex
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	l.wg.Add(1)
+	go l.writeLoop()
+	return l, nil
+}
+
+func (l *auditLogger) open() error {
+	f, err := os.OpenFile(l.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.w = bufio.NewWriter(f)
+	l.size = fi.Size()
+	l.openTime = time.Now()
+	return nil
+}
+
+func (l *auditLogger) rotate() {
+	l.w.Flush()
+	l.f.Close()
+	rotated := l.fileName + "." + time.Now().Format("20060102-150405.000000")
+	if err := os.Rename(l.fileName, rotated); err != nil {
+		nodeLog.Infof("rotate audit log %v failed: %v", l.fileName, err)
+	}
+	if err := l.open(); err != nil {
+		nodeLog.Infof("open audit log %v failed: %v", l.fileName, err)
+	}
+}
+
+func (l *auditLogger) needRotate() bool {
+	if l.size >= l.maxSize {
+		return true
+	}
+	return l.rotateInterval > 0 && time.Since(l.openTime) >= l.rotateInterval
+}
+
+func (l *auditLogger) write(e auditEntry) {
+	if l.f == nil {
+		return
+	}
+	n, err := l.w.WriteString(e.line)
+	if err != nil {
+		nodeLog.Infof("write audit log failed: %v", err)
+	}
+	l.size += int64(n)
+	if e.index > l.writtenIndex {
+		l.writtenIndex = e.index
+	}
+	if l.needRotate() {
+		l.rotate()
+	}
+}
+
+// flush the log and save the index of the last write flushed
+func (l *auditLogger) flush() {
+	l.w.Flush()
+	if l.writtenIndex == l.savedIndex {
+		return
+	}
+	tmp := l.indexFileName + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatUint(l.writtenIndex, 10)), 0644)
+	if err == nil {
+		err = os.Rename(tmp, l.indexFileName)
+	}
+	if err != nil {
+		nodeLog.Infof("save audit log index failed: %v", err)
+		return
+	}
+	l.savedIndex = l.writtenIndex
+}
+
+func (l *auditLogger) writeLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-l.logC:
+			l.write(e)
+		case <-ticker.C:
+			if l.f == nil {
+				continue
+			}
+			l.flush()
+			if l.size > 0 && l.needRotate() {
+				l.rotate()
+			}
+		case <-l.stopC:
+			for {
+				select {
+				case e := <-l.logC:
+					l.write(e)
+				default:
+					if l.f != nil {
+						l.flush()
+						l.f.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// log the command with the user, namespace, command name and key,
+// the entry is dropped if the buffer is full. The index is the raft index
+// of the write, 0 for the read.
+func (l *auditLogger) log(user string, ns string, cmdName string, key []byte, index uint64) {
+	if index > 0 && index <= l.replayedIndex {
+		return
+	}
+	if user == "" {
+		user = "-"
+	}
+	line := strings.Join([]string{time.Now().Format(time.RFC3339Nano),
+		user, ns, cmdName, string(key)}, "\t") + "\n"
+	select {
+	case l.logC <- auditEntry{line: line, index: index}:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+	}
+}
+
+// record the command to the audit log, the key of the read command has
+// the namespace prefix which should be removed. The index is the raft index
+// of the write applied, 0 for the read.
+func (self *KVNode) auditCommand(user string, cmd redcon.Command, isRead bool, index uint64) {
+	var key []byte
+	if len(cmd.Args) > 1 {
+		key = cmd.Args[1]
+		if isRead {
+			if _, k, err := common.ExtractNamesapce(key); err == nil {
+				key = k
+			}
+		}
+	}
+	self.audit.log(user, self.ns, strings.ToLower(string(cmd.Args[0])), key, index)
+}
+
+func (l *auditLogger) close() {
+	close(l.stopC)
+	l.wg.Wait()
+}
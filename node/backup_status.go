@@ -0,0 +1,94 @@
+package node
+
+import (
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+// track the snapshot transfer from the remote while restoring
+type snapTransfer struct {
+	sync.Mutex
+	status *common.BackupStatus
+	stopC  chan struct{}
+}
+
+// copy the backup of the snapshot from the remote, the partial files will
+// be removed if the transfer is canceled.
+func (self *KVNode) syncSnapshotBackup(syncAddr string, syncDir string, raftSnapshot raftpb.Snapshot) error {
+	ckDir := rockredis.GetCheckpointDir(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)
+	dst := path.Join(self.store.GetBackupDir(), ckDir)
+	stopC := make(chan struct{})
+	self.transfer.Lock()
+	self.transfer.status = &common.BackupStatus{
+		Type:      "transfer",
+		Dir:       dst,
+		StartTime: time.Now().UnixNano(),
+	}
+	self.transfer.stopC = stopC
+	self.transfer.Unlock()
+	defer func() {
+		self.transfer.Lock()
+		self.transfer.status = nil
+		self.transfer.stopC = nil
+		self.transfer.Unlock()
+	}()
+
+	err := common.RunFileSyncWithStop(syncAddr,
+		path.Join(rockredis.GetBackupDir(syncDir), ckDir),
+		self.store.GetBackupDir(), stopC)
+	if err == common.ErrFileSyncCanceled {
+		os.RemoveAll(dst)
+	}
+	return err
+}
+
+// GetInflightBackups return the backup and the snapshot transfer in progress.
+func (self *KVNode) GetInflightBackups() []common.BackupStatus {
+	ret := make([]common.BackupStatus, 0, 2)
+	if st := self.store.GetRunningBackup(); st != nil {
+		ret = append(ret, *st)
+	}
+	self.transfer.Lock()
+	if self.transfer.status != nil {
+		st := *self.transfer.status
+		st.Size = common.GetDirSize(st.Dir)
+		ret = append(ret, st)
+	}
+	self.transfer.Unlock()
+	return ret
+}
+
+// CancelInflightBackups cancel the backup and the snapshot transfer in progress,
+// the canceled snapshot transfer will be retried from the beginning.
+// Return the number of the canceled.
+func (self *KVNode) CancelInflightBackups() int {
+	cnt := 0
+	if self.store.CancelBackup() {
+		cnt++
+	}
+	self.transfer.Lock()
+	if self.transfer.stopC != nil {
+		close(self.transfer.stopC)
+		self.transfer.stopC = nil
+		cnt++
+	}
+	self.transfer.Unlock()
+	return cnt
+}
+
+func (self *KVNode) restoreSyncSnapshotBackup(syncAddr string, syncDir string, raftSnapshot raftpb.Snapshot) {
+	for {
+		err := self.syncSnapshotBackup(syncAddr, syncDir, raftSnapshot)
+		if err != common.ErrFileSyncCanceled || atomic.LoadInt32(&self.stopping) == 1 {
+			return
+		}
+		nodeLog.Infof("snapshot transfer canceled, retry from the beginning: %v", raftSnapshot.Metadata.String())
+	}
+}
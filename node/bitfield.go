@@ -0,0 +1,163 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/tidwall/redcon"
+)
+
+var (
+	errBitFieldOverflow = errors.New("ERR Invalid OVERFLOW type specified")
+	errBitFieldValue    = errors.New("ERR value is not an integer or out of range")
+)
+
+func parseBitFieldType(arg []byte) (bool, uint, error) {
+	if len(arg) < 2 {
+		return false, 0, rockredis.ErrBitFieldType
+	}
+	var signed bool
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+		signed = false
+	default:
+		return false, 0, rockredis.ErrBitFieldType
+	}
+	bits, err := strconv.ParseUint(string(arg[1:]), 10, 8)
+	if err != nil {
+		return false, 0, rockredis.ErrBitFieldType
+	}
+	return signed, uint(bits), nil
+}
+
+// the offset prefixed with # is multiplied by the field width
+func parseBitFieldOffset(arg []byte, bits uint) (uint64, error) {
+	mul := uint64(1)
+	if len(arg) > 0 && arg[0] == '#' {
+		mul = uint64(bits)
+		arg = arg[1:]
+	}
+	n, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil || n > uint64(rockredis.MaxValueSize)*8 {
+		return 0, rockredis.ErrBitFieldOffset
+	}
+	return n * mul, nil
+}
+
+// parse the subcommands of the bitfield, the OVERFLOW only changes the
+// overflow mode of the following SET and INCRBY.
+func parseBitFieldOps(args [][]byte) ([]rockredis.BitFieldOp, error) {
+	ops := make([]rockredis.BitFieldOp, 0, len(args)/3)
+	overflow := rockredis.BitFieldOverflowWrap
+	for i := 0; i < len(args); {
+		sub := bytes.ToLower(args[i])
+		if string(sub) == "overflow" {
+			if i+1 >= len(args) {
+				return nil, errSyntaxError
+			}
+			switch string(bytes.ToLower(args[i+1])) {
+			case "wrap":
+				overflow = rockredis.BitFieldOverflowWrap
+			case "sat":
+				overflow = rockredis.BitFieldOverflowSat
+			case "fail":
+				overflow = rockredis.BitFieldOverflowFail
+			default:
+				return nil, errBitFieldOverflow
+			}
+			i += 2
+			continue
+		}
+		var op rockredis.BitFieldOp
+		argNum := 3
+		switch string(sub) {
+		case "get":
+			op.Type = rockredis.BitFieldGet
+		case "set":
+			op.Type = rockredis.BitFieldSet
+			argNum = 4
+		case "incrby":
+			op.Type = rockredis.BitFieldIncrBy
+			argNum = 4
+		default:
+			return nil, errSyntaxError
+		}
+		if i+argNum > len(args) {
+			return nil, errSyntaxError
+		}
+		var err error
+		op.Signed, op.Bits, err = parseBitFieldType(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		op.Offset, err = parseBitFieldOffset(args[i+2], op.Bits)
+		if err != nil {
+			return nil, err
+		}
+		if argNum == 4 {
+			op.Value, err = strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, errBitFieldValue
+			}
+		}
+		op.Overflow = overflow
+		if err = rockredis.CheckBitFieldOp(op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+		i += argNum
+	}
+	return ops, nil
+}
+
+// BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+// all the subcommands are applied in one raft entry.
+func (self *KVNode) bitfieldCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	if _, err := parseBitFieldOps(cmd.Args[2:]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	_, v, ok := rebuildFirstKeyAndPropose(self, conn, cmd)
+	if !ok {
+		return
+	}
+	rets, ok := v.([]interface{})
+	if !ok {
+		conn.WriteError(errInvalidResponse.Error())
+		return
+	}
+	conn.WriteArray(len(rets))
+	for _, r := range rets {
+		if n, ok := r.(int64); ok {
+			conn.WriteInt64(n)
+		} else {
+			conn.WriteNull()
+		}
+	}
+}
+
+func (self *KVNode) localBitFieldCommand(cmd redcon.Command) (interface{}, error) {
+	ops, err := parseBitFieldOps(cmd.Args[2:])
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.Type == rockredis.BitFieldGet {
+			continue
+		}
+		if err := self.checkValueSize(int((op.Offset + uint64(op.Bits) + 7) / 8)); err != nil {
+			return nil, err
+		}
+	}
+	return self.store.BitField(cmd.Args[1], ops)
+}
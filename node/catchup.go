@@ -0,0 +1,144 @@
+package node
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/coreos/etcd/raft"
+	"github.com/tidwall/redcon"
+)
+
+var errInvalidSnapCatchup = errors.New("the snap catchup should be positive")
+
+// the catch-up rate is computed from the samples at least this interval apart
+const catchupSampleInterval = time.Second
+
+type catchupSample struct {
+	lag uint64
+	ts  time.Time
+}
+
+// track the last lag sample of each replica to estimate the catch-up rate
+type catchupTracker struct {
+	sync.Mutex
+	samples map[uint64]catchupSample
+}
+
+// estimate the time in milliseconds to catch up the lag from the lag
+// reduced since the last sample, -1 if the replica is not catching up.
+func (self *catchupTracker) estimate(id uint64, lag uint64, now time.Time) int64 {
+	self.Lock()
+	defer self.Unlock()
+	if self.samples == nil {
+		self.samples = make(map[uint64]catchupSample)
+	}
+	last, ok := self.samples[id]
+	if !ok || lag > last.lag {
+		self.samples[id] = catchupSample{lag: lag, ts: now}
+		return -1
+	}
+	cost := now.Sub(last.ts)
+	if cost >= catchupSampleInterval {
+		self.samples[id] = catchupSample{lag: lag, ts: now}
+	}
+	if last.lag == lag || cost <= 0 {
+		return -1
+	}
+	return int64(float64(lag) / float64(last.lag-lag) * float64(cost) / float64(time.Millisecond))
+}
+
+// GetReplicaCatchup return the catch-up progress of the replica from the leader
+// view, the progress is the replicated index over the commit index of the leader.
+// The replica is in sync if it is replicating the log and the lag is small
+// enough to serve the read.
+func (self *KVNode) GetReplicaCatchup(id uint64) (*common.ReplicaCatchupStats, error) {
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return nil, ErrNotLeader
+	}
+	pr, ok := status.Progress[id]
+	if !ok {
+		return nil, ErrNotMember
+	}
+	cs := &common.ReplicaCatchupStats{
+		ID:           id,
+		State:        pr.State.String(),
+		Snapshotting: pr.State == raft.ProgressStateSnapshot,
+		Match:        pr.Match,
+		CommitIndex:  status.Commit,
+		Progress:     1,
+	}
+	if cs.Snapshotting {
+		cs.PendingSnapshot = pr.PendingSnapshot
+	}
+	first, err := self.raftNode.raftStorage.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	// the follower need the entries after its match index
+	cs.FirstIndex = first
+	cs.SnapCatchup = self.raftNode.getSnapCatchup()
+	cs.FromLog = pr.Match+1 >= first
+	cs.SentSnapshots = self.raftNode.getSentSnapshots(id)
+	if status.Commit > pr.Match {
+		cs.Lag = status.Commit - pr.Match
+		cs.Progress = float64(pr.Match) / float64(status.Commit)
+	}
+	cs.InSync = pr.State == raft.ProgressStateReplicate && cs.Lag <= readyMaxApplyLag
+	if cs.InSync {
+		cs.EstimatedMs = 0
+	} else {
+		cs.EstimatedMs = self.catchup.estimate(id, cs.Lag, time.Now())
+	}
+	return cs, nil
+}
+
+// GetSnapCatchup return the number of the log entries kept after the snapshot,
+// so the follower behind within them can catch up without the snapshot.
+func (self *KVNode) GetSnapCatchup() uint64 {
+	return self.raftNode.getSnapCatchup()
+}
+
+// SetSnapCatchup change the entries kept after the snapshot, it takes effect
+// from the next log compaction. The change is proposed through the raft, so
+// all the replicas use the same value and it is kept after restarting or the
+// leader changed. The value changed overrides the config.
+func (self *KVNode) SetSnapCatchup(n uint64) error {
+	if n == 0 {
+		return errInvalidSnapCatchup
+	}
+	cmd := buildCommand([][]byte{[]byte("snapcatchup"), []byte(strconv.FormatUint(n, 10))})
+	_, err := self.proposeAdmin(cmd.Raw)
+	return err
+}
+
+func (self *KVNode) localSnapCatchupCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, common.ErrInvalidArgs
+	}
+	n, err := strconv.ParseUint(string(cmd.Args[1]), 10, 64)
+	if err != nil || n == 0 {
+		return nil, errInvalidSnapCatchup
+	}
+	if err := self.store.SaveSnapCatchup(n); err != nil {
+		return nil, err
+	}
+	self.raftNode.setSnapCatchup(n)
+	nodeLog.Infof("snap catchup changed to %v", n)
+	return nil, nil
+}
+
+// use the snap catchup restored from the snapshot if it was changed
+func (self *KVNode) loadSnapCatchup() {
+	n, err := self.store.GetSnapCatchup()
+	if err != nil {
+		nodeLog.Infof("load the snap catchup failed: %v", err)
+		return
+	}
+	if n > 0 {
+		self.raftNode.setSnapCatchup(n)
+	}
+}
@@ -0,0 +1,176 @@
+package node
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/raft"
+)
+
+var errCompactLogTimeout = errors.New("compact log timeout")
+
+const compactLogTimeout = time.Minute
+
+type compactLogReq struct {
+	// only take the snapshot, and the log is compacted as the normal snapshot
+	snapOnly     bool
+	snapTerm     uint64
+	snapi        uint64
+	compactIndex uint64
+	done         chan error
+}
+
+// the forced compact index of the log at the snapshot index snapi. The log
+// is truncated up to the snapshot index, but the entries not replicated to
+// the live followers are kept. The entries within SnapCatchup are also kept
+// for the inactive followers, so they can catch up from the log if possible.
+func (rc *raftNode) forceCompactIndex(snapi uint64) uint64 {
+	compactIndex := snapi
+	status := rc.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return compactIndex
+	}
+	catchup := rc.getSnapCatchup()
+	for id, pr := range status.Progress {
+		if id == status.ID {
+			continue
+		}
+		need := pr.Match
+		if !pr.RecentActive && snapi > catchup && need < snapi-catchup {
+			need = snapi - catchup
+		}
+		if need < compactIndex {
+			compactIndex = need
+		}
+	}
+	return compactIndex
+}
+
+// purge the wal files released by the saved snapshot, the wal file still
+// locked is in use and all the files after it are kept.
+func (rc *raftNode) purgeReleasedWAL() (int, int, error) {
+	walNames, err := rc.sortedWALNames()
+	if err != nil {
+		return 0, 0, err
+	}
+	purged := 0
+	// the last wal file is always in use
+	for i := 0; i < len(walNames)-1; i++ {
+		f := filepath.Join(rc.config.WALDir, walNames[i])
+		l, err := fileutil.TryLockFile(f, os.O_WRONLY, fileutil.PrivateFileMode)
+		if err != nil {
+			break
+		}
+		if err = os.Remove(f); err != nil {
+			l.Close()
+			return len(walNames) - purged, purged, err
+		}
+		l.Close()
+		nodeLog.Infof("purged wal file %v", f)
+		purged++
+	}
+	return len(walNames) - purged, purged, nil
+}
+
+// take the snapshot at the applied index and compact the log, this should be
+// called in the apply loop since the progress is changed.
+func (self *KVNode) forceSnapshot(np *nodeProgress, req *compactLogReq) {
+	if np.appliedi <= self.raftNode.lastIndex {
+		req.done <- errors.New("replaying local log")
+		return
+	}
+	term, err := self.raftNode.raftStorage.Term(np.appliedi)
+	if err != nil {
+		req.done <- err
+		return
+	}
+	req.snapTerm = term
+	req.snapi = np.appliedi
+	if req.snapOnly {
+		if np.appliedi == np.snapi {
+			// the snapshot at the applied index is already saved
+			req.done <- nil
+			return
+		}
+		req.compactIndex = self.raftNode.snapCompactIndex(np.appliedi)
+	} else {
+		req.compactIndex = self.raftNode.forceCompactIndex(np.appliedi)
+	}
+	nodeLog.Infof("force snapshot [applied index: %d | last snapshot index: %d | compact index: %d]",
+		np.appliedi, np.snapi, req.compactIndex)
+	err = self.raftNode.snapshotAndCompact(np.appliedi, np.confState, req.compactIndex, req.done)
+	if err != nil {
+		req.done <- err
+		return
+	}
+	if np.appliedi > np.snapi {
+		np.snapi = np.appliedi
+	}
+}
+
+// CompactLog take a snapshot at the applied index and truncate the raft log
+// up to the snapshot index immediately, then the wal files before the snapshot
+// are purged. The log needed by the live followers is never truncated.
+func (self *KVNode) CompactLog() (*common.LogCompactStats, error) {
+	req := &compactLogReq{done: make(chan error, 1)}
+	if err := self.waitForceSnapshot(req); err != nil {
+		return nil, err
+	}
+	first, err := self.raftNode.raftStorage.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	walNum, purged, err := self.raftNode.purgeReleasedWAL()
+	if err != nil {
+		return nil, err
+	}
+	return &common.LogCompactStats{
+		SnapIndex:    req.snapi,
+		CompactIndex: req.compactIndex,
+		FirstIndex:   first,
+		WALFiles:     walNum,
+		PurgedWALs:   purged,
+	}, nil
+}
+
+// SnapshotNow take a snapshot at the applied index immediately without waiting
+// for the snap count, and the log is compacted the same as the normal snapshot.
+// The backup of the snapshot is returned, ErrBackupBusy if another backup is
+// running.
+func (self *KVNode) SnapshotNow() (*common.SnapshotResult, error) {
+	req := &compactLogReq{snapOnly: true, done: make(chan error, 1)}
+	if err := self.waitForceSnapshot(req); err != nil {
+		return nil, err
+	}
+	return &common.SnapshotResult{
+		Term:      req.snapTerm,
+		Index:     req.snapi,
+		BackupDir: filepath.Join(self.store.GetBackupDir(), rockredis.GetCheckpointDir(req.snapTerm, req.snapi)),
+	}, nil
+}
+
+// send the forced snapshot to the apply loop and wait the snapshot saved
+func (self *KVNode) waitForceSnapshot(req *compactLogReq) error {
+	timer := time.NewTimer(compactLogTimeout)
+	defer timer.Stop()
+	select {
+	case self.compactLogC <- req:
+	case <-timer.C:
+		return errCompactLogTimeout
+	case <-self.stopChan:
+		return common.ErrStopped
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-timer.C:
+		return errCompactLogTimeout
+	case <-self.stopChan:
+		return common.ErrStopped
+	}
+}
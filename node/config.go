@@ -3,6 +3,68 @@ package node
 type NodeConfig struct {
 	BroadcastAddr string `json:"broadcast_addr"`
 	HttpAPIPort   int    `json:"http_api_port"`
+	// the max size of a single value and the max element count of
+	// a collection (hash, list, set, zset), 0 means no limit.
+	MaxValueSize      int `json:"max_value_size"`
+	MaxCollectionSize int `json:"max_collection_size"`
+	// the capacity of the queue buffering the write requests before proposed
+	// to raft, 0 means the default size.
+	ProposeQueueSize int `json:"propose_queue_size"`
+	// the max bytes of the requests proposed to raft in one batch,
+	// 0 means the default size.
+	MaxProposeBatchBytes int `json:"max_propose_batch_bytes"`
+	// the max bytes of a single write command (and the batch) proposed to
+	// raft, the larger command is refused. 0 means the default (32MB), and
+	// it is capped below the max message size of the raft transport.
+	MaxProposalBytes int `json:"max_proposal_bytes"`
+	// the redis api port only accept the read commands, 0 means disabled.
+	// If the read only commands is not empty, only the read commands in
+	// the list are allowed on this port.
+	ReadOnlyRedisAPIPort int      `json:"read_only_redis_api_port"`
+	ReadOnlyCommands     []string `json:"read_only_commands"`
+	// the retention of the snapshot backups, the newest num backups and the
+	// backups newer than the seconds are kept, 0 num means the default.
+	SnapRetainNum     int `json:"snap_retain_num"`
+	SnapRetainSeconds int `json:"snap_retain_seconds"`
+	// the max element count returned by the full collection read, such as
+	// HGETALL, SMEMBERS and LRANGE, 0 means no limit.
+	MaxFullReadSize int `json:"max_full_read_size"`
+	// the max number of the scan commands running at the same time in the
+	// namespace, 0 means no limit.
+	MaxConcurrentScans int `json:"max_concurrent_scans"`
+	// reject the writes while the in-sync replicas (including the leader) are
+	// less than MinISR, also reject the reads if MinISRReads. 0 means disabled.
+	MinISR      int  `json:"min_isr"`
+	MinISRReads bool `json:"min_isr_reads"`
+	// warm up the block cache by reading at most the bytes of the data in the
+	// timeout after the snapshot restored, before the node is read ready.
+	// 0 bytes means skip the warmup, 0 timeout means the default.
+	WarmupMaxBytes  int64 `json:"warmup_max_bytes"`
+	WarmupTimeoutMs int   `json:"warmup_timeout_ms"`
+	// the max time waiting the write proposed to be applied, the write on the
+	// follower is forwarded to the leader and may be refused by the leader.
+	// 0 means the default (10 seconds).
+	ProposeTimeoutMs int `json:"propose_timeout_ms"`
+	// dump the goroutine stacks if a single entry is applying longer than the
+	// timeout, and exit the process if crash. 0 means the default (1 minute),
+	// and the negative timeout disables the watchdog.
+	ApplyStallTimeoutMs int  `json:"apply_stall_timeout_ms"`
+	ApplyStallCrash     bool `json:"apply_stall_crash"`
+	// the applied write commands are recorded to the audit log in the dir if
+	// not empty, the log is rotated while the size or the age exceed the limit.
+	AuditLogDir        string `json:"audit_log_dir"`
+	AuditLogMaxSize    int64  `json:"audit_log_max_size"`
+	AuditRotateSeconds int    `json:"audit_rotate_seconds"`
+	// also record the read commands to the audit log
+	AuditReads bool `json:"audit_reads"`
+	// restart the raft group as the only member from the local data, all the
+	// other members are removed. This is only used to recover the group after
+	// the quorum is lost forever, and the uncommitted writes will be lost.
+	ForceNewCluster bool `json:"force_new_cluster"`
+	// the min and max expired data deleted by the sweeper each second, the
+	// rate is adapted between them by the write load. 0 means the default.
+	ExpireSweepMinRate int `json:"expire_sweep_min_rate"`
+	ExpireSweepMaxRate int `json:"expire_sweep_max_rate"`
 }
 
 type RaftConfig struct {
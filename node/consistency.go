@@ -0,0 +1,127 @@
+package node
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+)
+
+var errConsistencyCheckFailed = errors.New("replica consistency check failed")
+
+// the default idle timeout of the connection to the replica while checking
+const defaultConsistencyCheckTimeout = time.Minute
+
+// VerifyRangeChecksums read the range checksums (one json object per line)
+// from the reader and return the local checksum of the mismatched ranges.
+func (self *KVNode) VerifyRangeChecksums(r io.Reader) ([]rockredis.KeyRangeChecksum, error) {
+	ranges := make(chan rockredis.KeyRangeChecksum, 32)
+	mismatchC := self.store.VerifyRangeChecksums(ranges)
+	var decodeErr error
+	go func() {
+		defer close(ranges)
+		dec := json.NewDecoder(bufio.NewReader(r))
+		for {
+			var rc rockredis.KeyRangeChecksum
+			err := dec.Decode(&rc)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				decodeErr = err
+				return
+			}
+			ranges <- rc
+		}
+	}()
+	mismatched := make([]rockredis.KeyRangeChecksum, 0)
+	for rc := range mismatchC {
+		mismatched = append(mismatched, rc)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return mismatched, nil
+}
+
+// get the checksum algorithms supported by the replica, the replica not
+// supporting the negotiation only knows the legacy crc32.
+func getReplicaChecksumAlgos(c *http.Client, remoteAddr string) ([]string, error) {
+	rsp, err := c.Get("http://" + remoteAddr + "/cluster/checksum/algos")
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if rsp.StatusCode != http.StatusOK {
+		nodeLog.Infof("replica %v get checksum algorithms failed: %v", remoteAddr, rsp.Status)
+		return nil, errConsistencyCheckFailed
+	}
+	var algos []string
+	err = json.NewDecoder(rsp.Body).Decode(&algos)
+	return algos, err
+}
+
+// CheckReplicaConsistency stream the range checksums of the local data to the
+// replica at remoteAddr (the http api address), and return the mismatched ranges
+// reported by the replica. The ranges are computed by the configured checksum
+// algorithm if the replica supports it, otherwise by the legacy crc32.
+// The check fails if the connection to the replica is idle longer than the
+// timeout (the default timeout is used if not positive).
+func (self *KVNode) CheckReplicaConsistency(remoteAddr string, rangeKeyNum int,
+	timeout time.Duration) ([]rockredis.KeyRangeChecksum, error) {
+	if timeout <= 0 {
+		timeout = defaultConsistencyCheckTimeout
+	}
+	c := http.Client{Transport: newDeadlineTransport(timeout)}
+	algos, err := getReplicaChecksumAlgos(&c, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	algo := rockredis.NegotiateChecksumAlgo(algos)
+	stopC := make(chan struct{})
+	defer close(stopC)
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		for rc := range self.store.RangeChecksumList(rangeKeyNum, algo, stopC) {
+			if err := enc.Encode(&rc); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	start := time.Now()
+	req, err := http.NewRequest("POST", "http://"+remoteAddr+"/cluster/checksum/verify/"+self.ns, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	rsp, err := c.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		nodeLog.Infof("request error: %v", err)
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		nodeLog.Infof("replica %v verify checksum failed: %v", remoteAddr, rsp.Status)
+		return nil, errConsistencyCheckFailed
+	}
+	var mismatched []rockredis.KeyRangeChecksum
+	err = json.NewDecoder(rsp.Body).Decode(&mismatched)
+	if err != nil {
+		return nil, err
+	}
+	nodeLog.Infof("namespace %v check consistency with %v by %v done (cost %v), mismatched ranges: %v",
+		self.ns, remoteAddr, algo, time.Since(start), len(mismatched))
+	return mismatched, nil
+}
@@ -0,0 +1,175 @@
+package node
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+const (
+	expireSweepInterval = time.Second
+	// the default min and max expired data deleted in each sweep cycle
+	defaultExpireSweepMinRate = 100
+	defaultExpireSweepMaxRate = 1000
+)
+
+// the expired data is scanned on the leader and the delete is proposed
+// to raft, so all the replicas will delete the same expired data.
+func (self *KVNode) expireSweepLoop() {
+	ticker := time.NewTicker(expireSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&self.stopping) == 1 {
+				return
+			}
+			if !self.raftNode.isLead() {
+				continue
+			}
+			self.sweepExpiredData()
+		case <-self.stopChan:
+			return
+		}
+	}
+}
+
+// SetActiveExpire pause or resume the expire sweeper. While paused, the
+// expired data is still hidden while reading but will not be deleted.
+func (self *KVNode) SetActiveExpire(enable bool) {
+	if enable {
+		atomic.StoreInt32(&self.activeExpireOff, 0)
+	} else {
+		atomic.StoreInt32(&self.activeExpireOff, 1)
+	}
+}
+
+func (self *KVNode) IsActiveExpire() bool {
+	return atomic.LoadInt32(&self.activeExpireOff) == 0
+}
+
+func (self *KVNode) getExpireSweepRates() (int64, int64) {
+	minRate := int64(defaultExpireSweepMinRate)
+	maxRate := int64(defaultExpireSweepMaxRate)
+	if self.nodeConfig != nil {
+		if self.nodeConfig.ExpireSweepMinRate > 0 {
+			minRate = int64(self.nodeConfig.ExpireSweepMinRate)
+		}
+		if self.nodeConfig.ExpireSweepMaxRate > 0 {
+			maxRate = int64(self.nodeConfig.ExpireSweepMaxRate)
+		}
+	}
+	if maxRate < minRate {
+		maxRate = minRate
+	}
+	return minRate, maxRate
+}
+
+// return the max expired data deleted in the next sweep cycle
+func (self *KVNode) getSweepRate() int64 {
+	minRate, maxRate := self.getExpireSweepRates()
+	rate := atomic.LoadInt64(&self.sweepRate)
+	if rate == 0 {
+		return maxRate
+	}
+	if rate < minRate {
+		return minRate
+	}
+	if rate > maxRate {
+		return maxRate
+	}
+	return rate
+}
+
+// adapt the sweep rate to the write load, since the deletes proposed by the
+// sweeper compete with the writes for the propose queue. The rate is halved
+// while the in-flight writes fill a quarter of the propose queue, and doubled
+// while the writes are nearly idle.
+func (self *KVNode) adjustSweepRate() int64 {
+	minRate, maxRate := self.getExpireSweepRates()
+	rate := self.getSweepRate()
+	inflight := atomic.LoadInt64(&self.inflightReqs)
+	queueSize := int64(cap(self.reqProposeC))
+	if inflight*4 >= queueSize {
+		rate /= 2
+	} else if inflight*20 < queueSize {
+		rate *= 2
+	}
+	if rate < minRate {
+		rate = minRate
+	}
+	if rate > maxRate {
+		rate = maxRate
+	}
+	atomic.StoreInt64(&self.sweepRate, rate)
+	return rate
+}
+
+// at most the sweep rate expired hash fields and zset members will be deleted
+// in each cycle, so the proposals from the sweeper will not flood the raft
+// log or starve the writes.
+func (self *KVNode) sweepExpiredData() {
+	start := time.Now()
+	now := start.UnixNano() / int64(time.Millisecond)
+	rate := int(self.adjustSweepRate())
+	hrecs, err := self.store.ScanExpiredHashFields(now, rate)
+	if err != nil {
+		nodeLog.Infof("scan expired hash fields failed: %v", err)
+	}
+	var zrecs []common.KVRecord
+	if len(hrecs) < rate {
+		zrecs, err = self.store.ScanExpiredZSetMembers(now, rate-len(hrecs))
+		if err != nil {
+			nodeLog.Infof("scan expired zset members failed: %v", err)
+		}
+	}
+	atomic.StoreInt64(&self.expireStats.Backlog, int64(len(hrecs)+len(zrecs)))
+	if len(hrecs)+len(zrecs) == 0 || !self.IsActiveExpire() {
+		return
+	}
+	var expiredNum int64
+	defer func() {
+		self.expireStats.UpdateSweepStats(expiredNum, time.Since(start).Nanoseconds()/1000)
+	}()
+	n, err := self.proposeExpiredDel("hexpiredel", now, hrecs)
+	expiredNum += n
+	if err != nil {
+		return
+	}
+	n, _ = self.proposeExpiredDel("zexpiredel", now, zrecs)
+	expiredNum += n
+}
+
+// propose to delete the expired records grouped by the key, the Key of the
+// record is the key and the Value is the expired hash field or zset member.
+func (self *KVNode) proposeExpiredDel(delCmd string, now int64, recs []common.KVRecord) (int64, error) {
+	keys := make([]string, 0)
+	keyFields := make(map[string][][]byte)
+	for _, rec := range recs {
+		k := string(rec.Key)
+		if _, ok := keyFields[k]; !ok {
+			keys = append(keys, k)
+		}
+		keyFields[k] = append(keyFields[k], rec.Value)
+	}
+	var expiredNum int64
+	nowStr := []byte(strconv.FormatInt(now, 10))
+	for _, k := range keys {
+		args := make([][]byte, 0, len(keyFields[k])+3)
+		args = append(args, []byte(delCmd), []byte(k), nowStr)
+		args = append(args, keyFields[k]...)
+		cmd := buildCommand(args)
+		rsp, err := self.Propose(cmd.Raw)
+		if err != nil {
+			nodeLog.Infof("propose %v failed: %v, %v", delCmd, k, err)
+			return expiredNum, err
+		}
+		if n, ok := rsp.(int64); ok {
+			expiredNum += n
+		}
+	}
+	nodeLog.Debugf("%v deleted expired data for %v keys", delCmd, len(keys))
+	return expiredNum, nil
+}
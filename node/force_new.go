@@ -0,0 +1,130 @@
+package node
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+
+	"github.com/coreos/etcd/raft"
+	"github.com/coreos/etcd/raft/raftpb"
+)
+
+type uint64Sorter []uint64
+
+func (self uint64Sorter) Len() int {
+	return len(self)
+}
+func (self uint64Sorter) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self uint64Sorter) Less(i, j int) bool {
+	return self[i] < self[j]
+}
+
+// restartAsStandaloneNode restart the node as the only member of the raft
+// group. The uncommitted entries in the wal are discarded, and the conf
+// changes removing all the other members are appended and committed
+// directly, so the group can elect the leader without the lost members.
+// The new members can be added back after the group is recovered.
+func (rc *raftNode) restartAsStandaloneNode(c *raft.Config, ds DataStorage) {
+	snapshot := rc.loadSnapshot()
+	w := rc.openWAL(snapshot)
+	_, st, ents, err := w.ReadAll()
+	if err != nil {
+		w.Close()
+		log.Fatalf("failed to read WAL (%v)", err)
+	}
+	for i, ent := range ents {
+		if ent.Index > st.Commit {
+			nodeLog.Infof("discarding %d uncommitted WAL entries", len(ents)-i)
+			ents = ents[:i]
+			break
+		}
+	}
+	toAppEnts := rc.createStandaloneConfChangeEnts(getRaftMemberIDs(snapshot, ents), st.Term, st.Commit)
+	ents = append(ents, toAppEnts...)
+	if err := w.Save(raftpb.HardState{}, toAppEnts); err != nil {
+		log.Fatalf("failed to save the force conf change entries (%v)", err)
+	}
+	if len(ents) != 0 {
+		st.Commit = ents[len(ents)-1].Index
+		rc.lastIndex = st.Commit
+	}
+	nodeLog.Infof("force restart as the standalone node %v at commit index: %v, removed: %v",
+		rc.config.ID, st.Commit, len(toAppEnts))
+
+	if snapshot != nil {
+		rc.raftStorage.ApplySnapshot(*snapshot)
+	}
+	rc.raftStorage.SetHardState(st)
+	rc.raftStorage.Append(ents)
+	rc.wal = w
+	rc.node = raft.RestartNode(c)
+	advanceTicksForElection(rc.node, c.ElectionTick)
+}
+
+// the members of the raft group from the snapshot and the conf change entries
+func getRaftMemberIDs(snapshot *raftpb.Snapshot, ents []raftpb.Entry) []uint64 {
+	ids := make(map[uint64]bool)
+	if snapshot != nil {
+		for _, id := range snapshot.Metadata.ConfState.Nodes {
+			ids[id] = true
+		}
+	}
+	for _, e := range ents {
+		if e.Type != raftpb.EntryConfChange {
+			continue
+		}
+		var cc raftpb.ConfChange
+		cc.Unmarshal(e.Data)
+		switch cc.Type {
+		case raftpb.ConfChangeAddNode:
+			ids[cc.NodeID] = true
+		case raftpb.ConfChangeRemoveNode:
+			delete(ids, cc.NodeID)
+		}
+	}
+	sids := make(uint64Sorter, 0, len(ids))
+	for id := range ids {
+		sids = append(sids, id)
+	}
+	sort.Sort(sids)
+	return sids
+}
+
+// create the entries to remove all the other members, and add this node if
+// it is not the member yet.
+func (rc *raftNode) createStandaloneConfChangeEnts(ids []uint64, term uint64, index uint64) []raftpb.Entry {
+	self := uint64(rc.config.ID)
+	ents := make([]raftpb.Entry, 0, len(ids))
+	next := index + 1
+	found := false
+	appendEnt := func(cc raftpb.ConfChange) {
+		d, _ := cc.Marshal()
+		ents = append(ents, raftpb.Entry{
+			Type:  raftpb.EntryConfChange,
+			Data:  d,
+			Term:  term,
+			Index: next,
+		})
+		next++
+	}
+	for _, id := range ids {
+		if id == self {
+			found = true
+			continue
+		}
+		appendEnt(raftpb.ConfChange{Type: raftpb.ConfChangeRemoveNode, NodeID: id})
+	}
+	if !found {
+		var m MemberInfo
+		m.ID = self
+		m.ClusterID = rc.config.ClusterID
+		m.Namespace = rc.config.Namespace
+		m.DataDir = rc.config.DataDir
+		m.RaftURLs = append(m.RaftURLs, rc.config.RaftAddr)
+		d, _ := json.Marshal(m)
+		appendEnt(raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: self, Context: d})
+	}
+	return ents
+}
@@ -2,8 +2,11 @@ package node
 
 import (
 	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/tidwall/redcon"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (self *KVNode) hgetCommand(conn redcon.Conn, cmd redcon.Command) {
@@ -15,7 +18,19 @@ func (self *KVNode) hgetCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
+func (self *KVNode) checkHashFullRead(key []byte) error {
+	n, err := self.store.HLen(key)
+	if err != nil {
+		return err
+	}
+	return self.checkFullReadSize(n, "HSCAN")
+}
+
 func (self *KVNode) hgetallCommand(conn redcon.Conn, cmd redcon.Command) {
+	if err := self.checkHashFullRead(cmd.Args[1]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
 	n, valCh, err := self.store.HGetAll(cmd.Args[1])
 	if err != nil {
 		conn.WriteError("ERR for " + string(cmd.Args[0]) + " command: " + err.Error())
@@ -28,6 +43,10 @@ func (self *KVNode) hgetallCommand(conn redcon.Conn, cmd redcon.Command) {
 }
 
 func (self *KVNode) hkeysCommand(conn redcon.Conn, cmd redcon.Command) {
+	if err := self.checkHashFullRead(cmd.Args[1]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
 	n, valCh, _ := self.store.HKeys(cmd.Args[1])
 	conn.WriteArray(int(n))
 	for v := range valCh {
@@ -105,6 +124,12 @@ func (self *KVNode) hclearCommand(conn redcon.Conn, cmd redcon.Command, v interf
 // the return value of follower is ignored, return value of local leader will be
 // return to the future response.
 func (self *KVNode) localHSetCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkValueSize(len(cmd.Args[3])); err != nil {
+		return nil, err
+	}
+	if err := self.checkHashGrow(cmd.Args[1], cmd.Args[2:3]); err != nil {
+		return nil, err
+	}
 	v, err := self.store.HSet(cmd.Args[1], cmd.Args[2], cmd.Args[3])
 	return v, err
 }
@@ -115,8 +140,16 @@ func (self *KVNode) localHMsetCommand(cmd redcon.Command) (interface{}, error) {
 		return nil, common.ErrInvalidArgs
 	}
 	fvs := make([]common.KVRecord, 0, len(args)/2)
+	fields := make([][]byte, 0, len(args)/2)
 	for i := 0; i < len(args); i += 2 {
 		fvs = append(fvs, common.KVRecord{Key: args[i], Value: args[i+1]})
+		fields = append(fields, args[i])
+	}
+	if err := self.checkKVRecordsSize(fvs); err != nil {
+		return nil, err
+	}
+	if err := self.checkHashGrow(cmd.Args[1], fields); err != nil {
+		return nil, err
 	}
 	err := self.store.HMset(cmd.Args[1], fvs...)
 	return nil, err
@@ -124,7 +157,10 @@ func (self *KVNode) localHMsetCommand(cmd redcon.Command) (interface{}, error) {
 
 func (self *KVNode) localHIncrbyCommand(cmd redcon.Command) (interface{}, error) {
 	v, _ := strconv.Atoi(string(cmd.Args[3]))
-	ret, err := self.store.HIncrBy(cmd.Args[1], cmd.Args[2], int64(v))
+	if err := self.checkHashGrow(cmd.Args[1], cmd.Args[2:3]); err != nil {
+		return nil, err
+	}
+	ret, err := self.store.HIncrBy(cmd.Args[1], cmd.Args[2], int64(v), self.applyNowMs())
 	return ret, err
 }
 
@@ -141,3 +177,313 @@ func (self *KVNode) localHDelCommand(cmd redcon.Command) (interface{}, error) {
 func (self *KVNode) localHclearCommand(cmd redcon.Command) (interface{}, error) {
 	return self.store.HClear(cmd.Args[1])
 }
+
+// parse the fields for the hash field expire commands: FIELDS numfields field [field ...]
+func getHashExpireFields(args [][]byte) ([][]byte, error) {
+	if len(args) < 3 || strings.ToLower(string(args[0])) != "fields" {
+		return nil, errSyntaxError
+	}
+	num, err := strconv.Atoi(string(args[1]))
+	if err != nil || num <= 0 {
+		return nil, errSyntaxError
+	}
+	if num != len(args[2:]) {
+		return nil, errSyntaxError
+	}
+	if num >= common.MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	return args[2:], nil
+}
+
+// parse the optional condition before the fields of the hash field expire
+// commands, return the condition and the args after it.
+func getHashExpireCond(args [][]byte) (rockredis.ExpireCond, [][]byte) {
+	if len(args) == 0 {
+		return rockredis.ExpireAlways, args
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "nx":
+		return rockredis.ExpireNX, args[1:]
+	case "xx":
+		return rockredis.ExpireXX, args[1:]
+	case "gt":
+		return rockredis.ExpireGT, args[1:]
+	case "lt":
+		return rockredis.ExpireLT, args[1:]
+	}
+	return rockredis.ExpireAlways, args
+}
+
+func writeInt64Array(conn redcon.Conn, v interface{}) {
+	rsp, ok := v.([]int64)
+	if !ok {
+		conn.WriteError(errInvalidResponse.Error())
+		return
+	}
+	conn.WriteArray(len(rsp))
+	for _, n := range rsp {
+		conn.WriteInt64(n)
+	}
+}
+
+// all the hash field expire commands will be converted to hpexpireat with the
+// absolute expire time, so the command can be applied deterministically on replicas.
+// The NX, XX, GT and LT condition is kept and checked while applied.
+func (self *KVNode) hexpireFunc(conn redcon.Conn, cmd redcon.Command, unit int64, absolute bool) {
+	if len(cmd.Args) < 6 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	t, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	_, rest := getHashExpireCond(cmd.Args[3:])
+	if _, err := getHashExpireFields(rest); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	when := t * unit
+	if !absolute {
+		when += time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	if when <= 0 {
+		conn.WriteError(common.ErrInvalidArgs.Error())
+		return
+	}
+	_, key, err := common.ExtractNamesapce(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	args := make([][]byte, 0, len(cmd.Args))
+	args = append(args, []byte("hpexpireat"), key, []byte(strconv.FormatInt(when, 10)))
+	args = append(args, cmd.Args[3:]...)
+	ncmd := buildCommand(args)
+	rsp, err := self.Propose(ncmd.Raw)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeInt64Array(conn, rsp)
+}
+
+func (self *KVNode) hexpireCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.hexpireFunc(conn, cmd, 1000, false)
+}
+
+func (self *KVNode) hpexpireCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.hexpireFunc(conn, cmd, 1, false)
+}
+
+func (self *KVNode) hexpireatCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.hexpireFunc(conn, cmd, 1000, true)
+}
+
+func (self *KVNode) hpexpireatCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.hexpireFunc(conn, cmd, 1, true)
+}
+
+func (self *KVNode) hpersistCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	if _, err := getHashExpireFields(cmd.Args[2:]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	_, v, ok := rebuildFirstKeyAndPropose(self, conn, cmd)
+	if !ok {
+		return
+	}
+	writeInt64Array(conn, v)
+}
+
+func writeBulkArray(conn redcon.Conn, v interface{}) {
+	rsp, ok := v.([][]byte)
+	if !ok {
+		conn.WriteError(errInvalidResponse.Error())
+		return
+	}
+	conn.WriteArray(len(rsp))
+	for _, b := range rsp {
+		conn.WriteBulk(b)
+	}
+}
+
+// hgetdel key FIELDS numfields field [field ...]
+func (self *KVNode) hgetdelCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	if _, err := getHashExpireFields(cmd.Args[2:]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	_, v, ok := rebuildFirstKeyAndPropose(self, conn, cmd)
+	if !ok {
+		return
+	}
+	writeBulkArray(conn, v)
+}
+
+// hgetex key [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|PERSIST] FIELDS numfields field [field ...]
+// the command will be converted to hpgetexat with the absolute expire time, 0
+// for not changing the expire time and -1 for removing the expire time.
+func (self *KVNode) hgetexCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	var when int64
+	pos := 2
+	switch strings.ToLower(string(cmd.Args[pos])) {
+	case "persist":
+		when = -1
+		pos++
+	case "ex", "px", "exat", "pxat":
+		if len(cmd.Args) < 7 {
+			conn.WriteError(errSyntaxError.Error())
+			return
+		}
+		t, err := strconv.ParseInt(string(cmd.Args[pos+1]), 10, 64)
+		if err != nil || t <= 0 {
+			conn.WriteError(common.ErrInvalidArgs.Error())
+			return
+		}
+		switch strings.ToLower(string(cmd.Args[pos])) {
+		case "ex":
+			when = time.Now().UnixNano()/int64(time.Millisecond) + t*1000
+		case "px":
+			when = time.Now().UnixNano()/int64(time.Millisecond) + t
+		case "exat":
+			when = t * 1000
+		default:
+			when = t
+		}
+		pos += 2
+	}
+	if _, err := getHashExpireFields(cmd.Args[pos:]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	_, key, err := common.ExtractNamesapce(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	args := make([][]byte, 0, len(cmd.Args))
+	args = append(args, []byte("hpgetexat"), key, []byte(strconv.FormatInt(when, 10)))
+	args = append(args, cmd.Args[pos:]...)
+	ncmd := buildCommand(args)
+	rsp, err := self.Propose(ncmd.Raw)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeBulkArray(conn, rsp)
+}
+
+func (self *KVNode) httlFunc(conn redcon.Conn, cmd redcon.Command, inMs bool) {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	fields, err := getHashExpireFields(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	ttls, err := self.store.HFieldTTL(cmd.Args[1], fields...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if !inMs {
+		for i, ttl := range ttls {
+			if ttl > 0 {
+				ttls[i] = (ttl + 500) / 1000
+			}
+		}
+	}
+	writeInt64Array(conn, ttls)
+}
+
+func (self *KVNode) httlCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.httlFunc(conn, cmd, false)
+}
+
+func (self *KVNode) hpttlCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.httlFunc(conn, cmd, true)
+}
+
+func (self *KVNode) localHPexpireatCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 6 {
+		return nil, common.ErrInvalidArgs
+	}
+	when, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	cond, rest := getHashExpireCond(cmd.Args[3:])
+	fields, err := getHashExpireFields(rest)
+	if err != nil {
+		return nil, err
+	}
+	return self.store.HExpireAtCond(cmd.Args[1], when, cond, fields...)
+}
+
+func (self *KVNode) localHPersistCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 5 {
+		return nil, common.ErrInvalidArgs
+	}
+	fields, err := getHashExpireFields(cmd.Args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return self.store.HPersist(cmd.Args[1], fields...)
+}
+
+func (self *KVNode) localHGetDelCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 5 {
+		return nil, common.ErrInvalidArgs
+	}
+	fields, err := getHashExpireFields(cmd.Args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return self.store.HGetDel(cmd.Args[1], self.applyNowMs(), fields...)
+}
+
+// hpgetexat key when FIELDS numfields field [field ...]
+func (self *KVNode) localHPGetExAtCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 6 {
+		return nil, common.ErrInvalidArgs
+	}
+	when, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := getHashExpireFields(cmd.Args[3:])
+	if err != nil {
+		return nil, err
+	}
+	return self.store.HGetEx(cmd.Args[1], self.applyNowMs(), when, fields...)
+}
+
+// hexpiredel key now field [field ...]
+// delete the expired fields proposed by the expire sweeper
+func (self *KVNode) localHExpireDelCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 4 {
+		return nil, common.ErrInvalidArgs
+	}
+	now, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return self.store.HDelExpiredFields(cmd.Args[1], now, cmd.Args[3:]...)
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/etcd/raft"
+)
+
+const storeHealthCheckInterval = time.Second
+
+var errReplicaNotReady = errors.New("STALE the replica is not ready for read")
+
+// the leader with the unhealthy store will refuse the new writes and step
+// down, so a replica with the healthy store can take over the leadership.
+// Since no write is accepted while unhealthy, the store is probed until the
+// write succeeded again.
+func (self *KVNode) storeHealthCheckLoop() {
+	ticker := time.NewTicker(storeHealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&self.stopping) == 1 {
+				return
+			}
+			if self.store.IsHealthy() {
+				continue
+			}
+			if err := self.store.ProbeHealth(); err == nil {
+				nodeLog.Infof("namespace %v store is writable again", self.ns)
+				continue
+			}
+			if !self.raftNode.isLead() {
+				continue
+			}
+			nodeLog.Infof("namespace %v store is unhealthy, step down the leader", self.ns)
+			self.transferLeaderToBestMember()
+		case <-self.stopChan:
+			return
+		}
+	}
+}
+
+// InjectStoreWriteError make all the writes to the local store fail with the
+// error, nil to stop. This is only used to simulate the write failure in test.
+func (self *KVNode) InjectStoreWriteError(err error) {
+	self.store.InjectWriteError(err)
+}
+
+// the max lag of the applied index behind the commit index for the
+// replica to be ready for read
+const readyMaxApplyLag = 100
+
+// IsAlive return true if the node is not stopped and the raft event
+// loop is running, the node may be still not ready for read.
+func (self *KVNode) IsAlive() bool {
+	if atomic.LoadInt32(&self.stopping) == 1 {
+		return false
+	}
+	return atomic.LoadInt32(&self.raftNode.loopRunning) == 1
+}
+
+// IsReadReady return true if the node can serve the read, which means the
+// applied index is caught up, no snapshot is restoring and the store is healthy.
+func (self *KVNode) IsReadReady() bool {
+	if !self.IsAlive() {
+		return false
+	}
+	if atomic.LoadInt32(&self.restoring) == 1 {
+		return false
+	}
+	if !self.store.IsHealthy() {
+		return false
+	}
+	// without leader we can not know whether the applied is caught up
+	if self.raftNode.Lead() == raft.None {
+		return false
+	}
+	applied := atomic.LoadUint64(&self.appliedIndex)
+	return applied+readyMaxApplyLag >= self.raftNode.node.Status().Commit
+}
+
+// update the time the applied index caught up the commit index, the
+// staleness of the lagging replica is the time since then.
+func (self *KVNode) updateCaughtUpTime() {
+	if atomic.LoadUint64(&self.appliedIndex) >= self.raftNode.CommitIndex() {
+		atomic.StoreInt64(&self.caughtUpTime, time.Now().UnixNano())
+	}
+}
+
+// CheckReadStaleness check whether the replica can serve the read with the
+// bounded staleness, the applied index should be within maxLag entries and
+// maxLagMs milliseconds of the commit index. The negative limit is ignored.
+// The error has the leader so the client can read from the leader instead.
+func (self *KVNode) CheckReadStaleness(maxLag int64, maxLagMs int64) error {
+	if !self.IsAlive() || atomic.LoadInt32(&self.restoring) == 1 {
+		return errReplicaNotReady
+	}
+	// without leader we can not know whether the applied is caught up
+	lead := self.raftNode.Lead()
+	if lead == raft.None {
+		return errReplicaNotReady
+	}
+	applied := atomic.LoadUint64(&self.appliedIndex)
+	commit := self.raftNode.CommitIndex()
+	if applied >= commit {
+		return nil
+	}
+	lag := commit - applied
+	// the lag time is unknown if never caught up since started
+	lagMs := int64(-1)
+	if t := atomic.LoadInt64(&self.caughtUpTime); t > 0 {
+		lagMs = (time.Now().UnixNano() - t) / int64(time.Millisecond)
+	}
+	if (maxLag >= 0 && lag > uint64(maxLag)) || (maxLagMs >= 0 && (lagMs < 0 || lagMs > maxLagMs)) {
+		leader := strconv.FormatUint(lead, 10)
+		if m := self.raftNode.GetLeadMember(); m != nil {
+			leader = m.Broadcast
+		}
+		return fmt.Errorf("STALE the replica lag %v entries %vms exceed the limit, leader: %v",
+			lag, lagMs, leader)
+	}
+	return nil
+}
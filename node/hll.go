@@ -0,0 +1,34 @@
+package node
+
+import (
+	"github.com/tidwall/redcon"
+)
+
+func (self *KVNode) pfcountCommand(conn redcon.Conn, cmd redcon.Command) {
+	n, err := self.store.PFCount(cmd.Args[1:]...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt64(n)
+}
+
+func (self *KVNode) pfaddCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) pfmergeCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	conn.WriteString("OK")
+}
+
+func (self *KVNode) localPFAddCommand(cmd redcon.Command) (interface{}, error) {
+	return self.store.PFAdd(cmd.Args[1], cmd.Args[2:]...)
+}
+
+func (self *KVNode) localPFMergeCommand(cmd redcon.Command) (interface{}, error) {
+	return nil, self.store.PFMerge(cmd.Args[1], cmd.Args[2:]...)
+}
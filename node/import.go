@@ -0,0 +1,89 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/tidwall/redcon"
+)
+
+var errImportArgs = errors.New("ERR wrong number of arguments for 'kvimport' command")
+
+// kvimport key value [key value ...] [FORCE]
+// the key values are proposed as one raft entry and ingested as one sst file,
+// the FORCE is needed to overwrite the existing keys.
+func parseKVImportArgs(args [][]byte) ([][]byte, bool, error) {
+	args = args[1:]
+	force := false
+	if len(args)%2 != 0 && bytes.EqualFold(args[len(args)-1], []byte("force")) {
+		force = true
+		args = args[:len(args)-1]
+	}
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, false, errImportArgs
+	}
+	return args, force, nil
+}
+
+func importKeys(args [][]byte) [][]byte {
+	kvs, _, err := parseKVImportArgs(args)
+	if err != nil {
+		return nil
+	}
+	keys := make([][]byte, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		keys = append(keys, kvs[i])
+	}
+	return keys
+}
+
+func (self *KVNode) kvimportCommand(conn redcon.Conn, cmd redcon.Command) {
+	kvs, _, err := parseKVImportArgs(cmd.Args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(kvs)/2 > rockredis.MaxImportBatchNum {
+		conn.WriteError(errTooMuchBatchSize.Error())
+		return
+	}
+	for i := 0; i < len(kvs); i += 2 {
+		key, err := extractSameNamespaceKey(self.ns, kvs[i])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		kvs[i] = key
+	}
+	ncmd := buildCommand(cmd.Args)
+	copy(cmd.Raw[0:], ncmd.Raw[:])
+	cmd.Raw = cmd.Raw[:len(ncmd.Raw)]
+
+	rsp, err := self.Propose(cmd.Raw)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if n, ok := rsp.(int64); ok {
+		conn.WriteInt64(n)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) localKVImportCommand(cmd redcon.Command) (interface{}, error) {
+	kvs, force, err := parseKVImportArgs(cmd.Args)
+	if err != nil {
+		return nil, err
+	}
+	kvlist := make([]common.KVRecord, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		kvlist = append(kvlist, common.KVRecord{Key: kvs[i], Value: kvs[i+1]})
+	}
+	if err := self.checkKVRecordsSize(kvlist); err != nil {
+		return nil, err
+	}
+	return self.store.KVImport(force, kvlist...)
+}
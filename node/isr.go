@@ -0,0 +1,66 @@
+package node
+
+import (
+	"errors"
+
+	"github.com/coreos/etcd/raft"
+)
+
+var errNotEnoughISR = errors.New("NOREPLICAS Not enough good replicas to write.")
+
+// GetISRCount return the number of the in-sync replicas including the leader.
+// The leader counts the active followers replicating the log with the small
+// lag. The in-sync replicas are only known by the leader, so 0 is returned on
+// the follower.
+func (self *KVNode) GetISRCount() int {
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return 0
+	}
+	isr := 0
+	for id, pr := range status.Progress {
+		if id == status.ID {
+			isr++
+			continue
+		}
+		if !pr.RecentActive || pr.State != raft.ProgressStateReplicate {
+			continue
+		}
+		if status.Commit > pr.Match && status.Commit-pr.Match > readyMaxApplyLag {
+			continue
+		}
+		isr++
+	}
+	return isr
+}
+
+// check the in-sync replicas before the write, or the read if configured
+func (self *KVNode) checkMinISR(isRead bool) error {
+	if self.nodeConfig == nil || self.nodeConfig.MinISR <= 1 {
+		return nil
+	}
+	if isRead && !self.nodeConfig.MinISRReads {
+		return nil
+	}
+	// the writes on the follower are forwarded to the leader and checked
+	// while the leader receives the forwarded proposals, and the follower
+	// only serves the reads while it is in sync with the leader.
+	if !self.raftNode.isLead() {
+		if isRead && !self.IsReadReady() {
+			return errNotEnoughISR
+		}
+		return nil
+	}
+	if self.GetISRCount() < self.nodeConfig.MinISR {
+		return errNotEnoughISR
+	}
+	return nil
+}
+
+// check the proposals forwarded from the followers on the leader, the
+// forwarded control commands are checked too since they are batched with the
+// writes. The refused proposals are dropped and the follower returns
+// ErrProposeTimeout to the client.
+func (self *KVNode) checkForwardedPropose() error {
+	return self.checkMinISR(false)
+}
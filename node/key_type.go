@@ -0,0 +1,169 @@
+package node
+
+import (
+	"strconv"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+)
+
+// the data type and the keys of the command, the command will fail with the
+// WRONGTYPE error if any key is holding the data of the other type.
+type cmdKeyType struct {
+	dataType byte
+	keys     func(args [][]byte) [][]byte
+}
+
+func firstKey(args [][]byte) [][]byte {
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:2]
+}
+
+func firstTwoKeys(args [][]byte) [][]byte {
+	if len(args) < 3 {
+		return firstKey(args)
+	}
+	return args[1:3]
+}
+
+func allKeys(args [][]byte) [][]byte {
+	return args[1:]
+}
+
+// the key value pairs like mset
+func pairKeys(args [][]byte) [][]byte {
+	keys := make([][]byte, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// the keys after the numkeys at the pos like zunion
+func numKeysAt(pos int) func(args [][]byte) [][]byte {
+	return func(args [][]byte) [][]byte {
+		var keys [][]byte
+		if pos > 1 {
+			keys = append(keys, firstKey(args)...)
+		}
+		if len(args) <= pos {
+			return keys
+		}
+		num, err := strconv.Atoi(string(args[pos]))
+		if err != nil || num <= 0 || num > len(args)-pos-1 {
+			return keys
+		}
+		return append(keys, args[pos+1:pos+1+num]...)
+	}
+}
+
+// the writes like SET overwrite the key of any type and are not listed, the
+// data of the other types is deleted while applying.
+var cmdKeyTypes = map[string]cmdKeyType{
+	// kv and hyperloglog
+	"get":      {rockredis.KVType, firstKey},
+	"mget":     {rockredis.KVType, allKeys},
+	"cas":      {rockredis.KVType, firstKey},
+	"cad":      {rockredis.KVType, firstKey},
+	"append":   {rockredis.KVType, firstKey},
+	"setrange": {rockredis.KVType, firstKey},
+	"bitfield": {rockredis.KVType, firstKey},
+	"incr":     {rockredis.KVType, firstKey},
+	"plget":    {rockredis.KVType, allKeys},
+	"kvimport": {rockredis.KVType, importKeys},
+	"pfcount":  {rockredis.KVType, allKeys},
+	"pfadd":    {rockredis.KVType, firstKey},
+	"pfmerge":  {rockredis.KVType, allKeys},
+	// hash
+	"hget":       {rockredis.HashType, firstKey},
+	"hgetall":    {rockredis.HashType, firstKey},
+	"hkeys":      {rockredis.HashType, firstKey},
+	"hexists":    {rockredis.HashType, firstKey},
+	"hmget":      {rockredis.HashType, firstKey},
+	"hlen":       {rockredis.HashType, firstKey},
+	"hset":       {rockredis.HashType, firstKey},
+	"hmset":      {rockredis.HashType, firstKey},
+	"hdel":       {rockredis.HashType, firstKey},
+	"hincrby":    {rockredis.HashType, firstKey},
+	"hclear":     {rockredis.HashType, firstKey},
+	"hpexpireat": {rockredis.HashType, firstKey},
+	"hpersist":   {rockredis.HashType, firstKey},
+	"hgetdel":    {rockredis.HashType, firstKey},
+	"hpgetexat":  {rockredis.HashType, firstKey},
+	"httl":       {rockredis.HashType, firstKey},
+	"hpttl":      {rockredis.HashType, firstKey},
+	"hscan":      {rockredis.HashType, firstKey},
+	// list
+	"lindex":    {rockredis.ListType, firstKey},
+	"llen":      {rockredis.ListType, firstKey},
+	"lrange":    {rockredis.ListType, firstKey},
+	"lpop":      {rockredis.ListType, firstKey},
+	"lpush":     {rockredis.ListType, firstKey},
+	"lset":      {rockredis.ListType, firstKey},
+	"ltrim":     {rockredis.ListType, firstKey},
+	"rpop":      {rockredis.ListType, firstKey},
+	"rpush":     {rockredis.ListType, firstKey},
+	"lclear":    {rockredis.ListType, firstKey},
+	"rpoplpush": {rockredis.ListType, firstTwoKeys},
+	"lack":      {rockredis.ListType, firstKey},
+	// zset
+	"zscore":           {rockredis.ZSetType, firstKey},
+	"zcount":           {rockredis.ZSetType, firstKey},
+	"zcard":            {rockredis.ZSetType, firstKey},
+	"zlexcount":        {rockredis.ZSetType, firstKey},
+	"zrange":           {rockredis.ZSetType, firstKey},
+	"zrevrange":        {rockredis.ZSetType, firstKey},
+	"zrangebylex":      {rockredis.ZSetType, firstKey},
+	"zrangebyscore":    {rockredis.ZSetType, firstKey},
+	"zrevrangebyscore": {rockredis.ZSetType, firstKey},
+	"zrank":            {rockredis.ZSetType, firstKey},
+	"zrevrank":         {rockredis.ZSetType, firstKey},
+	"zunion":           {rockredis.ZSetType, numKeysAt(1)},
+	"zinter":           {rockredis.ZSetType, numKeysAt(1)},
+	"zdiff":            {rockredis.ZSetType, numKeysAt(1)},
+	"zdiffstore":       {rockredis.ZSetType, numKeysAt(2)},
+	"zadd":             {rockredis.ZSetType, firstKey},
+	"zpaddexat":        {rockredis.ZSetType, firstKey},
+	"zincrby":          {rockredis.ZSetType, firstKey},
+	"zrem":             {rockredis.ZSetType, firstKey},
+	"zremrangebyrank":  {rockredis.ZSetType, firstKey},
+	"zremrangebyscore": {rockredis.ZSetType, firstKey},
+	"zremrangebylex":   {rockredis.ZSetType, firstKey},
+	"zclear":           {rockredis.ZSetType, firstKey},
+	"zscan":            {rockredis.ZSetType, firstKey},
+	// set
+	"scard":     {rockredis.SetType, firstKey},
+	"sismember": {rockredis.SetType, firstKey},
+	"smembers":  {rockredis.SetType, firstKey},
+	"sadd":      {rockredis.SetType, firstKey},
+	"srem":      {rockredis.SetType, firstKey},
+	"sclear":    {rockredis.SetType, firstKey},
+	"smclear":   {rockredis.SetType, allKeys},
+	"sscan":     {rockredis.SetType, firstKey},
+}
+
+// check the type of the keys before the command is handled, so all the read
+// and the applied write commands return the same WRONGTYPE error. The read
+// command keys have the namespace which should be removed before checking.
+func (self *KVNode) checkCommandKeyType(cmdName string, args [][]byte, hasNamespace bool) error {
+	kt, ok := cmdKeyTypes[cmdName]
+	if !ok {
+		return nil
+	}
+	for _, key := range kt.keys(args) {
+		if hasNamespace {
+			var err error
+			_, key, err = common.ExtractNamesapce(key)
+			if err != nil {
+				// the invalid key will be returned by the command handler
+				continue
+			}
+		}
+		if err := self.store.CheckKeyType(key, kt.dataType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
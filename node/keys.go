@@ -1,10 +1,17 @@
 package node
 
 import (
+	"errors"
+	"strconv"
+	"strings"
+
 	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/tidwall/redcon"
 )
 
+var errSetRangeOffset = errors.New("ERR offset is out of range")
+
 func (self *KVNode) Lookup(key []byte) ([]byte, error) {
 	_, key, err := common.ExtractNamesapce(key)
 	if err != nil {
@@ -33,6 +40,50 @@ func (self *KVNode) existsCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
+// object encoding key
+func (self *KVNode) objectCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	if strings.ToLower(string(cmd.Args[1])) != "encoding" {
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'object'")
+		return
+	}
+	_, key, err := common.ExtractNamesapce(cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	enc, err := self.store.ObjectEncoding(key)
+	if err != nil {
+		conn.WriteError(err.Error())
+	} else if enc == "" {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulkString(enc)
+	}
+}
+
+// SWAP key1 key2
+// exchange the values of any type between the keys in one applied write batch.
+func (self *KVNode) swapCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	key2, err := extractSameNamespaceKey(self.ns, cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	cmd.Args[2] = key2
+	if _, _, ok := rebuildFirstKeyAndPropose(self, conn, cmd); !ok {
+		return
+	}
+	conn.WriteString("OK")
+}
+
 func (self *KVNode) mgetCommand(conn redcon.Conn, cmd redcon.Command) {
 	vals, _ := self.store.MGet(cmd.Args[1:]...)
 	conn.WriteArray(len(vals))
@@ -57,6 +108,22 @@ func (self *KVNode) setnxCommand(conn redcon.Conn, cmd redcon.Command, v interfa
 	}
 }
 
+func (self *KVNode) casCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) appendCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
 func (self *KVNode) msetCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
 	conn.WriteString("OK")
 }
@@ -81,22 +148,73 @@ func (self *KVNode) delCommand(conn redcon.Conn, cmd redcon.Command, v interface
 // the return value of follower is ignored, return value of local leader will be
 // return to the future response.
 func (self *KVNode) localSetCommand(cmd redcon.Command) (interface{}, error) {
-	err := self.store.LocalPut(cmd.Args[1], cmd.Args[2])
+	if err := self.checkValueSize(len(cmd.Args[2])); err != nil {
+		return nil, err
+	}
+	// the key holding the other type is overwritten
+	err := self.store.KVSetOverwrite(cmd.Args[1], cmd.Args[2])
 	return nil, err
 }
 
 func (self *KVNode) localSetnxCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkValueSize(len(cmd.Args[2])); err != nil {
+		return nil, err
+	}
+	// the key existing as the other type is not set
+	if err := self.store.CheckKeyType(cmd.Args[1], rockredis.KVType); err == rockredis.ErrWrongType {
+		return int64(0), nil
+	} else if err != nil {
+		return nil, err
+	}
 	v, err := self.store.SetNX(cmd.Args[1], cmd.Args[2])
 	return v, err
 }
 
+// the compare should be done while applying the command, so all the replicas
+// compare against the same replicated state.
+func (self *KVNode) localCasCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkValueSize(len(cmd.Args[3])); err != nil {
+		return nil, err
+	}
+	return self.store.KVCompareAndSet(cmd.Args[1], cmd.Args[2], cmd.Args[3])
+}
+
+func (self *KVNode) localCadCommand(cmd redcon.Command) (interface{}, error) {
+	return self.store.KVCompareAndDel(cmd.Args[1], cmd.Args[2])
+}
+
+func (self *KVNode) localAppendCommand(cmd redcon.Command) (interface{}, error) {
+	old, err := self.store.StrLen(cmd.Args[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := self.checkValueSize(int(old) + len(cmd.Args[2])); err != nil {
+		return nil, err
+	}
+	return self.store.Append(cmd.Args[1], cmd.Args[2])
+}
+
+func (self *KVNode) localSetRangeCommand(cmd redcon.Command) (interface{}, error) {
+	offset, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil || offset < 0 {
+		return nil, errSetRangeOffset
+	}
+	if err := self.checkValueSize(offset + len(cmd.Args[3])); err != nil {
+		return nil, err
+	}
+	return self.store.SetRange(cmd.Args[1], offset, cmd.Args[3])
+}
+
 func (self *KVNode) localMSetCommand(cmd redcon.Command) (interface{}, error) {
 	args := cmd.Args[1:]
 	kvlist := make([]common.KVRecord, 0, len(args)/2)
 	for i := 0; i < len(args); i += 2 {
 		kvlist = append(kvlist, common.KVRecord{Key: args[i], Value: args[i+1]})
 	}
-	err := self.store.MSet(kvlist...)
+	if err := self.checkKVRecordsSize(kvlist); err != nil {
+		return nil, err
+	}
+	err := self.store.MSetOverwrite(kvlist...)
 	return nil, err
 }
 
@@ -105,6 +223,13 @@ func (self *KVNode) localIncrCommand(cmd redcon.Command) (interface{}, error) {
 	return v, err
 }
 
+func (self *KVNode) localSwapCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, common.ErrInvalidArgs
+	}
+	return nil, self.store.Swap(cmd.Args[1], cmd.Args[2])
+}
+
 func (self *KVNode) localDelCommand(cmd redcon.Command) (interface{}, error) {
 	self.store.DelKeys(cmd.Args[1:]...)
 	return int64(len(cmd.Args[1:])), nil
@@ -0,0 +1,217 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/tidwall/redcon"
+)
+
+var (
+	errValueTooLarge      = errors.New("ERR the value size exceed the limit")
+	errCollectionTooLarge = errors.New("ERR the collection element count exceed the limit")
+	errTooManyScans       = errors.New("ERR too many scans running in the namespace, retry later")
+)
+
+const (
+	defaultMaxProposalBytes = 32 * 1024 * 1024
+	// the raft message carry at least one entry even if the entry is larger
+	// than the max message size, and the rafthttp refuse to read the message
+	// larger than 512MB. Keep the entry small enough to be sent along with
+	// the other entries in one message.
+	maxProposalBytesLimit = 512*1024*1024 - 2*raftMaxSizePerMsg
+)
+
+// return the max bytes of a single raft entry, which is checked before the
+// write command queued and while the commands are batched.
+func getMaxProposalBytes(conf *NodeConfig) int64 {
+	if conf == nil || conf.MaxProposalBytes <= 0 {
+		return defaultMaxProposalBytes
+	}
+	if conf.MaxProposalBytes > maxProposalBytesLimit {
+		return maxProposalBytesLimit
+	}
+	return int64(conf.MaxProposalBytes)
+}
+
+func checkProposalSize(size int64, limit int64) error {
+	if size > limit {
+		return fmt.Errorf("ERR the command size %v exceed the max proposal bytes %v, split it into the smaller commands",
+			size, limit)
+	}
+	return nil
+}
+
+// The limits are checked while applying the write with the limits proposed
+// by the leader in the request header, so all the replicas and the log replay
+// get the same result even if the replicas are configured differently.
+func (self *KVNode) checkValueSize(size int) error {
+	if self.applyHeader.ValueLimit <= 0 {
+		return nil
+	}
+	if int64(size) > self.applyHeader.ValueLimit {
+		return errValueTooLarge
+	}
+	return nil
+}
+
+// check whether the collection will exceed the limit after adding the members,
+// the members already in the collection will not grow the size.
+func (self *KVNode) checkCollectionSize(curSize int64, members [][]byte,
+	isMember func([]byte) bool) error {
+	limit := self.applyHeader.CollectionLimit
+	if limit <= 0 {
+		return nil
+	}
+	if curSize+int64(len(members)) <= limit {
+		return nil
+	}
+	if isMember == nil {
+		return errCollectionTooLarge
+	}
+	added := make(map[string]bool, len(members))
+	for _, m := range members {
+		if added[string(m)] || isMember(m) {
+			continue
+		}
+		added[string(m)] = true
+		if curSize+int64(len(added)) > limit {
+			return errCollectionTooLarge
+		}
+	}
+	return nil
+}
+
+// check the element count of the full collection read, the reply too large
+// will stall the node and exhaust the client buffer. The read limit is only
+// checked on the local node, so it is not required to be the same on replicas.
+func (self *KVNode) checkFullReadSize(n int64, instead string) error {
+	if self.nodeConfig == nil || self.nodeConfig.MaxFullReadSize <= 0 {
+		return nil
+	}
+	if n > int64(self.nodeConfig.MaxFullReadSize) {
+		return fmt.Errorf("ERR the collection element count %v exceed the read limit %v, use %v instead",
+			n, self.nodeConfig.MaxFullReadSize, instead)
+	}
+	return nil
+}
+
+// return the element count of the list range, the same as the LRANGE
+func listRangeCount(llen int64, start int64, stop int64) int64 {
+	if start < 0 {
+		start = llen + start
+	}
+	if stop < 0 {
+		stop = llen + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= llen {
+		stop = llen - 1
+	}
+	if start > stop {
+		return 0
+	}
+	return stop - start + 1
+}
+
+func (self *KVNode) checkKVRecordsSize(kvs []common.KVRecord) error {
+	for _, kv := range kvs {
+		if err := self.checkValueSize(len(kv.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the expired hash fields and zset members are hidden by the local time, so
+// the stored ones are counted to get the same result on all the replicas.
+func (self *KVNode) checkHashGrow(key []byte, fields [][]byte) error {
+	n, err := self.store.HStoredSize(key)
+	if err != nil {
+		return err
+	}
+	return self.checkCollectionSize(n, fields, func(f []byte) bool {
+		ok, _ := self.store.HFieldStored(key, f)
+		return ok
+	})
+}
+
+func (self *KVNode) checkListGrow(key []byte, num int) error {
+	n, err := self.store.LLen(key)
+	if err != nil {
+		return err
+	}
+	return self.checkCollectionSize(n, make([][]byte, num), nil)
+}
+
+func (self *KVNode) checkSetGrow(key []byte, members [][]byte) error {
+	n, err := self.store.SCard(key)
+	if err != nil {
+		return err
+	}
+	return self.checkCollectionSize(n, members, func(m []byte) bool {
+		v, _ := self.store.SIsMember(key, m)
+		return v == 1
+	})
+}
+
+func (self *KVNode) checkZSetGrow(key []byte, members [][]byte) error {
+	n, err := self.store.ZStoredSize(key)
+	if err != nil {
+		return err
+	}
+	return self.checkCollectionSize(n, members, func(m []byte) bool {
+		ok, _ := self.store.ZMemberStored(key, m)
+		return ok
+	})
+}
+
+// limit the page size of the collection scan by the full read limit, so the
+// collection too large for the full read can be read page by page. The page
+// should be limited here since the next cursor is decided by the page size.
+func (self *KVNode) limitScanCount(count int) int {
+	if count <= 0 {
+		return count
+	}
+	if count > common.MAX_BATCH_NUM {
+		count = common.MAX_BATCH_NUM
+	}
+	if self.nodeConfig != nil && self.nodeConfig.MaxFullReadSize > 0 &&
+		count > self.nodeConfig.MaxFullReadSize {
+		count = self.nodeConfig.MaxFullReadSize
+	}
+	return count
+}
+
+// limit the scan commands running at the same time in the namespace, so the
+// expensive scans will not degrade the other commands on the node.
+func (self *KVNode) limitScanCommand(f common.CommandFunc) common.CommandFunc {
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		rn := self.readNode()
+		if rn.nodeConfig != nil && rn.nodeConfig.MaxConcurrentScans > 0 {
+			n := atomic.AddInt32(&rn.runningScans, 1)
+			defer atomic.AddInt32(&rn.runningScans, -1)
+			if n > int32(rn.nodeConfig.MaxConcurrentScans) {
+				conn.WriteError(errTooManyScans.Error())
+				return
+			}
+		}
+		f(conn, cmd)
+	}
+}
+
+// the scan exceeding the time budget return the partial page and the cursor
+// to resume, the budget error is returned only if nothing found in the page.
+// The scan stopped by the max iterated keys always return the page (maybe
+// empty) with the cursor, just like the redis.
+func checkScanBudget(n int, err error) ([]byte, error) {
+	if e, ok := err.(*rockredis.ScanBudgetError); ok && (n > 0 || e.Limited) {
+		return e.Cursor, nil
+	}
+	return nil, err
+}
@@ -43,6 +43,14 @@ func (self *KVNode) lrangeCommand(conn redcon.Conn, cmd redcon.Command) {
 		conn.WriteError("Invalid index: " + err.Error())
 		return
 	}
+	llen, err := self.store.LLen(cmd.Args[1])
+	if err == nil {
+		err = self.checkFullReadSize(listRangeCount(llen, start, end), "LRANGE with a smaller range")
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
 
 	vlist, err := self.store.LRange(cmd.Args[1], start, end)
 	if err != nil {
@@ -155,6 +163,14 @@ func (self *KVNode) localLpopCommand(cmd redcon.Command) (interface{}, error) {
 }
 
 func (self *KVNode) localLpushCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkListGrow(cmd.Args[1], len(cmd.Args[2:])); err != nil {
+		return nil, err
+	}
+	for _, v := range cmd.Args[2:] {
+		if err := self.checkValueSize(len(v)); err != nil {
+			return nil, err
+		}
+	}
 	return self.store.LPush(cmd.Args[1], cmd.Args[2:]...)
 }
 
@@ -163,6 +179,9 @@ func (self *KVNode) localLsetCommand(cmd redcon.Command) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := self.checkValueSize(len(cmd.Args[3])); err != nil {
+		return nil, err
+	}
 
 	return nil, self.store.LSet(cmd.Args[1], index, cmd.Args[3])
 }
@@ -185,9 +204,69 @@ func (self *KVNode) localRpopCommand(cmd redcon.Command) (interface{}, error) {
 }
 
 func (self *KVNode) localRpushCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkListGrow(cmd.Args[1], len(cmd.Args[2:])); err != nil {
+		return nil, err
+	}
+	for _, v := range cmd.Args[2:] {
+		if err := self.checkValueSize(len(v)); err != nil {
+			return nil, err
+		}
+	}
 	return self.store.RPush(cmd.Args[1], cmd.Args[2:]...)
 }
 
 func (self *KVNode) localLclearCommand(cmd redcon.Command) (interface{}, error) {
 	return self.store.LClear(cmd.Args[1])
 }
+
+// RPOPLPUSH source destination
+// move the last element of the source list to the head of the destination
+// list, the destination can be used as the processing list of a consumer.
+func (self *KVNode) rpoplpushCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	dst, err := extractSameNamespaceKey(self.ns, cmd.Args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	cmd.Args[2] = dst
+	_, v, ok := rebuildFirstKeyAndPropose(self, conn, cmd)
+	if !ok {
+		return
+	}
+	rsp, ok := v.([]byte)
+	if !ok {
+		conn.WriteError("Invalid response type")
+		return
+	}
+	if rsp == nil {
+		conn.WriteNull()
+	} else {
+		conn.WriteBulk(rsp)
+	}
+}
+
+// LACK key value
+// remove the element handled by the consumer from the processing list.
+func (self *KVNode) lackCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	rsp, ok := v.(int64)
+	if !ok {
+		conn.WriteError("Invalid response type")
+		return
+	}
+	conn.WriteInt64(rsp)
+}
+
+func (self *KVNode) localRpoplpushCommand(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkListGrow(cmd.Args[2], 1); err != nil {
+		return nil, err
+	}
+	return self.store.RPopLPush(cmd.Args[1], cmd.Args[2])
+}
+
+func (self *KVNode) localLackCommand(cmd redcon.Command) (interface{}, error) {
+	return self.store.LAck(cmd.Args[1], cmd.Args[2])
+}
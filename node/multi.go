@@ -13,7 +13,7 @@ func (self *KVNode) plgetCommand(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 	for i := 1; i < len(cmd.Args); i++ {
-		_, key, err := common.ExtractNamesapce(cmd.Args[i])
+		key, err := extractSameNamespaceKey(self.ns, cmd.Args[i])
 		if err != nil {
 			conn.WriteError(err.Error())
 			return
@@ -41,7 +41,7 @@ func (self *KVNode) plsetCommand(conn redcon.Conn, cmd redcon.Command) {
 		if i%2 != 0 {
 			continue
 		}
-		_, key, err := common.ExtractNamesapce(v)
+		key, err := extractSameNamespaceKey(self.ns, v)
 		if err != nil {
 			conn.WriteError(err.Error())
 			return
@@ -73,6 +73,9 @@ func (self *KVNode) localPlsetCommand(cmd redcon.Command) (interface{}, error) {
 	for i := 1; i < len(cmd.Args); i += 2 {
 		kvpairs = append(kvpairs, common.KVRecord{Key: cmd.Args[i], Value: cmd.Args[i+1]})
 	}
-	err := self.store.MSet(kvpairs...)
+	if err := self.checkKVRecordsSize(kvpairs); err != nil {
+		return nil, err
+	}
+	err := self.store.MSetOverwrite(kvpairs...)
 	return nil, err
 }
@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,6 +29,11 @@ var (
 	errSyntaxError      = errors.New("syntax error")
 	errUnknownData      = errors.New("unknown request data type")
 	errTooMuchBatchSize = errors.New("the batch size exceed the limit")
+	ErrNotLeader        = errors.New("not raft leader")
+	ErrTransfereeLagged = errors.New("the transferee is lagging behind the leader")
+	ErrNotMember        = errors.New("not a member of the raft group")
+	errCrossSlot        = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	ErrBackupBusy       = errors.New("failed to begin backup: maybe too much backup running")
 )
 
 const (
@@ -35,6 +41,21 @@ const (
 	HTTPReq  int8 = 1
 )
 
+const (
+	// the max lag of the log entries allowed for the leader transferee
+	maxTransferLeaderLag    = 100
+	transferLeaderTimeout   = time.Second * 5
+	defaultProposeQueueSize = 200
+	// the max bytes of the entries in one raft append message
+	raftMaxSizePerMsg = 1024 * 1024
+	// flush the propose batch early while the batch is larger than this,
+	// keep it the same as the raft max message size.
+	defaultMaxProposeBatchBytes = raftMaxSizePerMsg
+	// the queue for the control proposals which are proposed ahead of the data
+	adminProposeQueueSize = 100
+	defaultProposeTimeout = time.Second * 10
+)
+
 type nodeProgress struct {
 	confState raftpb.ConfState
 	snapi     uint64
@@ -44,11 +65,15 @@ type nodeProgress struct {
 type internalReq struct {
 	reqData InternalRaftRequest
 	done    chan struct{}
+	admin   bool
 }
 
 // a key-value node backed by raft
 type KVNode struct {
 	reqProposeC       chan *internalReq
+	reqAdminC         chan *internalReq
+	compactLogC       chan *compactLogReq
+	raftStateC        chan chan nodeProgress
 	proposeC          chan<- []byte // channel for proposing updates
 	raftNode          *raftNode
 	store             *store.KVStore
@@ -59,8 +84,36 @@ type KVNode struct {
 	deleteCb          func()
 	dbWriteStats      common.WriteStats
 	clusterWriteStats common.WriteStats
+	readStats         common.ReadStats
+	batchStats        common.BatchStats
+	appliedIndex      uint64
+	caughtUpTime      int64
+	durableIndex      uint64
+	flushMutex        sync.Mutex
+	draining          int32
+	restoring         int32
+	transfer          snapTransfer
+	catchup           catchupTracker
+	audit             *auditLogger
+	inflightReqs      int64
+	proposeQueueFull  int64
+	applyStart        int64
+	applyingIndex     uint64
+	applyHeader       RequestHeader
+	applyStallNum     int64
+	expireStats       common.ExpireStats
+	activeExpireOff   int32
+	sweepRate         int64
+	runningScans      int32
+	warmupStats       atomic.Value
+	usage             atomic.Value
+	applyHook         atomic.Value
+	latency           atomic.Value
+	usageRefreshing   int32
 	ns                string
 	nodeConfig        *NodeConfig
+	// the node of the namespace while this node serves the read view
+	viewOf *KVNode
 }
 
 type KVSnapInfo struct {
@@ -100,8 +153,15 @@ func NewKVNode(kvopts *store.KVOptions, nodeConfig *NodeConfig,
 	config.WALDir = path.Join(config.DataDir, fmt.Sprintf("wal-%d", id))
 	config.SnapDir = path.Join(config.DataDir, fmt.Sprintf("snap-%d", id))
 
+	queueSize := defaultProposeQueueSize
+	if nodeConfig != nil && nodeConfig.ProposeQueueSize > 0 {
+		queueSize = nodeConfig.ProposeQueueSize
+	}
 	s := &KVNode{
-		reqProposeC: make(chan *internalReq, 200),
+		reqProposeC: make(chan *internalReq, queueSize),
+		reqAdminC:   make(chan *internalReq, adminProposeQueueSize),
+		compactLogC: make(chan *compactLogReq),
+		raftStateC:  make(chan chan nodeProgress),
 		proposeC:    proposeC,
 		store:       store.NewKVStore(kvopts),
 		stopChan:    make(chan struct{}),
@@ -111,15 +171,32 @@ func NewKVNode(kvopts *store.KVOptions, nodeConfig *NodeConfig,
 		ns:          ns,
 		nodeConfig:  nodeConfig,
 	}
+	if nodeConfig != nil && nodeConfig.AuditLogDir != "" {
+		audit, err := newAuditLogger(nodeConfig.AuditLogDir, ns, nodeConfig.AuditLogMaxSize,
+			time.Duration(nodeConfig.AuditRotateSeconds)*time.Second)
+		if err != nil {
+			nodeLog.Infof("namespace %v init audit log failed: %v", ns, err)
+		} else {
+			s.audit = audit
+		}
+	}
 	s.registerHandler()
+	if err := s.router.CheckCommands(); err != nil {
+		nodeLog.Panicf("namespace %v register commands failed: %v", ns, err)
+	}
 	commitC, errorC, raftNode := newRaftNode(config,
 		join, s, proposeC, confChangeC)
 	s.raftNode = raftNode
+	raftNode.forwardedProposeCheck = s.checkForwardedPropose
 
 	raftNode.startRaft(s)
 	// read commits from raft into KVStore map until error
 	go s.applyCommits(commitC, errorC)
 	go s.handleProposeReq()
+	go s.expireSweepLoop()
+	go s.storeHealthCheckLoop()
+	go s.prefixDropLoop()
+	go s.applyWatchdogLoop()
 	return s, confChangeC
 }
 
@@ -129,14 +206,90 @@ func (self *KVNode) Stop() {
 	}
 	self.raftNode.StopNode()
 	self.store.Close()
+	if self.audit != nil {
+		self.audit.close()
+	}
 	close(self.stopChan)
 	go self.deleteCb()
 }
 
+// Drain stop accepting the new proposals and wait the in-flight proposals
+// done (or timeout), and transfer the leadership to the in-sync member if
+// this node is leader, then stop the node.
+func (self *KVNode) Drain(timeout time.Duration) {
+	if !atomic.CompareAndSwapInt32(&self.draining, 0, 1) {
+		return
+	}
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for atomic.LoadInt64(&self.inflightReqs) > 0 {
+		if time.Now().After(deadline) {
+			nodeLog.Infof("namespace %v drain timeout, still %v requests in-flight",
+				self.ns, atomic.LoadInt64(&self.inflightReqs))
+			break
+		}
+		select {
+		case <-time.After(time.Millisecond * 10):
+		case <-self.stopChan:
+			return
+		}
+	}
+	self.transferLeaderToBestMember()
+	nodeLog.Infof("namespace %v drained, cost: %v", self.ns, time.Since(start))
+	self.Stop()
+}
+
+// transfer the leadership to the member with the most log entries
+func (self *KVNode) transferLeaderToBestMember() {
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return
+	}
+	var transferee uint64
+	var maxMatch uint64
+	for id, pr := range status.Progress {
+		if id == status.ID {
+			continue
+		}
+		if transferee == 0 || pr.Match > maxMatch {
+			transferee = id
+			maxMatch = pr.Match
+		}
+	}
+	if transferee == 0 {
+		return
+	}
+	if err := self.TransferLeadership(transferee); err != nil {
+		nodeLog.Infof("namespace %v transfer leader to %v failed: %v", self.ns, transferee, err)
+	}
+}
+
 func (self *KVNode) OptimizeDB() {
 	self.store.CompactRange()
 }
 
+// FlushApplied make sure all the applied writes are flushed to the data
+// files of the local store, and return the durable applied index. Since the
+// write response is returned after applied, all the writes responded before
+// are durable after this returned.
+func (self *KVNode) FlushApplied() (uint64, error) {
+	applied := atomic.LoadUint64(&self.appliedIndex)
+	if durable := atomic.LoadUint64(&self.durableIndex); durable >= applied {
+		return durable, nil
+	}
+	self.flushMutex.Lock()
+	defer self.flushMutex.Unlock()
+	// flushed by others while waiting the lock
+	if durable := atomic.LoadUint64(&self.durableIndex); durable >= applied {
+		return durable, nil
+	}
+	if err := self.store.Flush(); err != nil {
+		return 0, err
+	}
+	atomic.StoreUint64(&self.durableIndex, applied)
+	return applied, nil
+}
+
 func (self *KVNode) GetLeadMember() *MemberInfo {
 	return self.raftNode.GetLeadMember()
 }
@@ -144,12 +297,56 @@ func (self *KVNode) GetMembers() []*MemberInfo {
 	return self.raftNode.GetMembers()
 }
 
+// TransferLeadership transfer the leadership of this raft group to the
+// transferee, this should be called on the leader and the transferee
+// should be an in-sync member.
+func (self *KVNode) TransferLeadership(transferee uint64) error {
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return ErrNotLeader
+	}
+	if transferee == status.ID {
+		return nil
+	}
+	pr, ok := status.Progress[transferee]
+	if !ok {
+		return ErrNotMember
+	}
+	if pr.Match+maxTransferLeaderLag < status.Commit {
+		nodeLog.Infof("transfer leader to %v refused since lagging: %v, commit %v",
+			transferee, pr.Match, status.Commit)
+		return ErrTransfereeLagged
+	}
+	nodeLog.Infof("begin transfer leader to %v, progress: %v, commit: %v", transferee, pr, status.Commit)
+	return self.raftNode.transferLeadership(transferee, transferLeaderTimeout)
+}
+
 func (self *KVNode) GetStats() common.NamespaceStats {
 	tbs := self.store.GetTables()
 	var ns common.NamespaceStats
 	ns.DBWriteStats = self.dbWriteStats.Copy()
 	ns.ClusterWriteStats = self.clusterWriteStats.Copy()
+	ns.ReadStats = self.readStats.Copy()
+	ns.BatchStats = self.batchStats.Copy()
+	ns.ExpireStats = self.expireStats.Copy()
+	ns.ExpireStats.ActiveExpire = self.IsActiveExpire()
+	ns.ExpireStats.SweepRate = self.getSweepRate()
+	ns.ExpireStats.TTLNum, ns.ExpireStats.AvgTTLMs = self.store.GetFieldExpireStats()
+	ns.RaftStats = self.GetRaftStats()
+	ns.CommitIndex = self.raftNode.node.Status().Commit
+	ns.AppliedIndex = atomic.LoadUint64(&self.appliedIndex)
+	ns.DurableIndex = atomic.LoadUint64(&self.durableIndex)
+	ns.StoreHealthy = self.store.IsHealthy()
+	ns.ProposeQueueSize = cap(self.reqProposeC)
+	ns.ProposeQueueLen = len(self.reqProposeC)
+	ns.AdminQueueLen = len(self.reqAdminC)
+	ns.ProposeQueueFull = atomic.LoadInt64(&self.proposeQueueFull)
+	ns.ApplyStallNum = atomic.LoadInt64(&self.applyStallNum)
 	ns.InternalStats = self.store.GetInternalStatus()
+	us := self.getUsageStats()
+	if us != nil {
+		ns.TypeApproxBytes = us.TypeBytes
+	}
 
 	for t := range tbs {
 		cnt, err := self.store.GetTableKeyCount(t)
@@ -159,6 +356,9 @@ func (self *KVNode) GetStats() common.NamespaceStats {
 		var ts common.TableStats
 		ts.Name = string(t)
 		ts.KeyNum = cnt
+		if us != nil {
+			ts.ApproxBytes = us.TableBytes[ts.Name]
+		}
 		ns.TStats = append(ns.TStats, ts)
 	}
 	nodeLog.Info(self.store.GetStatistics())
@@ -173,34 +373,127 @@ func (self *KVNode) GetHandler(cmd string) (common.CommandFunc, bool) {
 	return self.router.GetCmdHandler(cmd)
 }
 
+// ProfileKeys return the data shape of the namespace from the sampled keys
+func (self *KVNode) ProfileKeys(sampleNum int) (*rockredis.KeyProfile, error) {
+	return self.store.ProfileKeys(sampleNum)
+}
+
+// DebugObject return the internal info of the object stored at the key
+// without the namespace, nil if the key not exist.
+func (self *KVNode) DebugObject(key []byte) (*rockredis.ObjectDebugInfo, error) {
+	return self.store.DebugObject(key)
+}
+
+func (self *KVNode) IsReadCommand(cmd string) bool {
+	return self.router.IsReadCommand(cmd)
+}
+
+// IsReadOnlyAllowed check whether the command is allowed on the read only port,
+// only the read commands in the configured read only commands are allowed.
+func (self *KVNode) IsReadOnlyAllowed(cmd string) bool {
+	if !self.router.IsReadCommand(cmd) {
+		return false
+	}
+	if self.nodeConfig == nil || len(self.nodeConfig.ReadOnlyCommands) == 0 {
+		return true
+	}
+	for _, c := range self.nodeConfig.ReadOnlyCommands {
+		if strings.EqualFold(c, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLatencyMonitor set the monitor recording the latency spikes of the
+// applied writes and the reads, the monitor is shared by all the namespaces
+// on the server.
+func (self *KVNode) SetLatencyMonitor(m *common.LatencyMonitor) {
+	self.latency.Store(m)
+}
+
+func (self *KVNode) recordLatency(event string, cost time.Duration) {
+	if m, ok := self.latency.Load().(*common.LatencyMonitor); ok && m != nil {
+		m.Record(event, cost)
+	}
+}
+
+// the node accounting the reads, the reads of the read view are accounted
+// and limited on the node of the namespace.
+func (self *KVNode) readNode() *KVNode {
+	if self.viewOf != nil {
+		return self.viewOf
+	}
+	return self
+}
+
+func (self *KVNode) registerReadHandler(name string, f common.CommandFunc) {
+	self.router.RegisterRead(name, func(conn redcon.Conn, cmd redcon.Command) {
+		rn := self.readNode()
+		rn.readStats.BeginRead()
+		start := time.Now()
+		if rn.audit != nil && rn.nodeConfig.AuditReads {
+			rn.auditCommand(conn.RemoteAddr(), cmd, true, 0)
+		}
+		if err := rn.checkMinISR(true); err != nil {
+			conn.WriteError(err.Error())
+		} else if err := self.checkCommandKeyType(name, cmd.Args, true); err != nil {
+			conn.WriteError(err.Error())
+		} else {
+			f(conn, cmd)
+		}
+		cost := time.Since(start)
+		rn.readStats.EndRead(cost.Nanoseconds() / 1000)
+		rn.recordLatency(name, cost)
+	})
+}
+
 func (self *KVNode) registerHandler() {
 	// for kv
-	self.router.Register("get", wrapReadCommandK(self.getCommand))
-	self.router.Register("mget", wrapReadCommandKK(self.mgetCommand))
-	self.router.Register("exists", wrapReadCommandK(self.existsCommand))
+	self.registerReadHandler("get", wrapReadCommandK(self.getCommand))
+	self.registerReadHandler("mget", wrapReadCommandKK(self.mgetCommand))
+	self.registerReadHandler("exists", wrapReadCommandK(self.existsCommand))
+	self.registerReadHandler("object", self.objectCommand)
 	self.router.Register("set", wrapWriteCommandKV(self, self.setCommand))
 	self.router.Register("setnx", wrapWriteCommandKV(self, self.setnxCommand))
+	self.router.Register("cas", wrapWriteCommandKSubkeyV(self, self.casCommand))
+	self.router.Register("cad", wrapWriteCommandKV(self, self.casCommand))
+	self.router.Register("append", wrapWriteCommandKV(self, self.appendCommand))
+	self.router.Register("setrange", wrapWriteCommandKSubkeyV(self, self.appendCommand))
+	self.router.Register("bitfield", self.bitfieldCommand)
 	self.router.Register("mset", wrapWriteCommandKVKV(self, self.msetCommand))
 	self.router.Register("incr", wrapWriteCommandK(self, self.incrCommand))
 	self.router.Register("del", wrapWriteCommandKK(self, self.delCommand))
-	self.router.Register("plget", self.plgetCommand)
+	self.router.Register("dropprefix", wrapWriteCommandK(self, self.dropPrefixCommand))
+	self.router.Register("swap", self.swapCommand)
+	self.registerReadHandler("plget", self.plgetCommand)
 	self.router.Register("plset", self.plsetCommand)
+	self.router.Register("kvimport", self.kvimportCommand)
 	// for hash
-	self.router.Register("hget", wrapReadCommandKSubkey(self.hgetCommand))
-	self.router.Register("hgetall", wrapReadCommandK(self.hgetallCommand))
-	self.router.Register("hkeys", wrapReadCommandK(self.hkeysCommand))
-	self.router.Register("hexists", wrapReadCommandKSubkey(self.hexistsCommand))
-	self.router.Register("hmget", wrapReadCommandKSubkeySubkey(self.hmgetCommand))
-	self.router.Register("hlen", wrapReadCommandK(self.hlenCommand))
+	self.registerReadHandler("hget", wrapReadCommandKSubkey(self.hgetCommand))
+	self.registerReadHandler("hgetall", wrapReadCommandK(self.hgetallCommand))
+	self.registerReadHandler("hkeys", wrapReadCommandK(self.hkeysCommand))
+	self.registerReadHandler("hexists", wrapReadCommandKSubkey(self.hexistsCommand))
+	self.registerReadHandler("hmget", wrapReadCommandKSubkeySubkey(self.hmgetCommand))
+	self.registerReadHandler("hlen", wrapReadCommandK(self.hlenCommand))
 	self.router.Register("hset", wrapWriteCommandKSubkeyV(self, self.hsetCommand))
 	self.router.Register("hmset", wrapWriteCommandKSubkeyVSubkeyV(self, self.hmsetCommand))
 	self.router.Register("hdel", wrapWriteCommandKSubkeySubkey(self, self.hdelCommand))
 	self.router.Register("hincrby", wrapWriteCommandKSubkeyV(self, self.hincrbyCommand))
 	self.router.Register("hclear", wrapWriteCommandK(self, self.hclearCommand))
+	self.router.RegisterConverted("hexpire", "hpexpireat", self.hexpireCommand)
+	self.router.RegisterConverted("hpexpire", "hpexpireat", self.hpexpireCommand)
+	self.router.RegisterConverted("hexpireat", "hpexpireat", self.hexpireatCommand)
+	self.router.Register("hpexpireat", self.hpexpireatCommand)
+	self.router.Register("hpersist", self.hpersistCommand)
+	self.router.Register("hgetdel", self.hgetdelCommand)
+	self.router.RegisterConverted("hgetex", "hpgetexat", self.hgetexCommand)
+	self.registerReadHandler("httl", wrapReadCommandKAnySubkey(self.httlCommand))
+	self.registerReadHandler("hpttl", wrapReadCommandKAnySubkey(self.hpttlCommand))
 	// for list
-	self.router.Register("lindex", wrapReadCommandKSubkey(self.lindexCommand))
-	self.router.Register("llen", wrapReadCommandK(self.llenCommand))
-	self.router.Register("lrange", wrapReadCommandKAnySubkey(self.lrangeCommand))
+	self.registerReadHandler("lindex", wrapReadCommandKSubkey(self.lindexCommand))
+	self.registerReadHandler("llen", wrapReadCommandK(self.llenCommand))
+	self.registerReadHandler("lrange", wrapReadCommandKAnySubkey(self.lrangeCommand))
 	self.router.Register("lpop", wrapWriteCommandK(self, self.lpopCommand))
 	self.router.Register("lpush", wrapWriteCommandKVV(self, self.lpushCommand))
 	self.router.Register("lset", self.lsetCommand)
@@ -208,19 +501,26 @@ func (self *KVNode) registerHandler() {
 	self.router.Register("rpop", wrapWriteCommandK(self, self.rpopCommand))
 	self.router.Register("rpush", wrapWriteCommandKVV(self, self.rpushCommand))
 	self.router.Register("lclear", wrapWriteCommandK(self, self.lclearCommand))
+	self.router.Register("rpoplpush", self.rpoplpushCommand)
+	self.router.Register("lack", wrapWriteCommandKV(self, self.lackCommand))
 	// for zset
-	self.router.Register("zscore", wrapReadCommandKSubkey(self.zscoreCommand))
-	self.router.Register("zcount", wrapReadCommandKAnySubkey(self.zcountCommand))
-	self.router.Register("zcard", wrapReadCommandK(self.zcardCommand))
-	self.router.Register("zlexcount", wrapReadCommandKAnySubkey(self.zlexcountCommand))
-	self.router.Register("zrange", wrapReadCommandKAnySubkey(self.zrangeCommand))
-	self.router.Register("zrevrange", wrapReadCommandKAnySubkey(self.zrevrangeCommand))
-	self.router.Register("zrangebylex", wrapReadCommandKAnySubkey(self.zrangebylexCommand))
-	self.router.Register("zrangebyscore", wrapReadCommandKAnySubkey(self.zrangebyscoreCommand))
-	self.router.Register("zrevrangebyscore", wrapReadCommandKAnySubkey(self.zrevrangebyscoreCommand))
-	self.router.Register("zrank", wrapReadCommandKSubkey(self.zrankCommand))
-	self.router.Register("zrevrank", wrapReadCommandKSubkey(self.zrevrankCommand))
+	self.registerReadHandler("zscore", wrapReadCommandKSubkey(self.zscoreCommand))
+	self.registerReadHandler("zcount", wrapReadCommandKAnySubkey(self.zcountCommand))
+	self.registerReadHandler("zcard", wrapReadCommandK(self.zcardCommand))
+	self.registerReadHandler("zlexcount", wrapReadCommandKAnySubkey(self.zlexcountCommand))
+	self.registerReadHandler("zrange", wrapReadCommandKAnySubkey(self.zrangeCommand))
+	self.registerReadHandler("zrevrange", wrapReadCommandKAnySubkey(self.zrevrangeCommand))
+	self.registerReadHandler("zrangebylex", wrapReadCommandKAnySubkey(self.zrangebylexCommand))
+	self.registerReadHandler("zrangebyscore", wrapReadCommandKAnySubkey(self.zrangebyscoreCommand))
+	self.registerReadHandler("zrevrangebyscore", wrapReadCommandKAnySubkey(self.zrevrangebyscoreCommand))
+	self.registerReadHandler("zrank", wrapReadCommandKSubkey(self.zrankCommand))
+	self.registerReadHandler("zrevrank", wrapReadCommandKSubkey(self.zrevrankCommand))
+	self.registerReadHandler("zunion", self.zunionCommand)
+	self.registerReadHandler("zinter", self.zinterCommand)
+	self.registerReadHandler("zdiff", self.zdiffCommand)
 	self.router.Register("zadd", self.zaddCommand)
+	self.router.RegisterConverted("zaddex", "zpaddexat", self.zaddexCommand)
+	self.router.Register("zdiffstore", self.zdiffstoreCommand)
 	self.router.Register("zincrby", self.zincrbyCommand)
 	self.router.Register("zrem", wrapWriteCommandKSubkeySubkey(self, self.zremCommand))
 	self.router.Register("zremrangebyrank", self.zremrangebyrankCommand)
@@ -228,35 +528,53 @@ func (self *KVNode) registerHandler() {
 	self.router.Register("zremrangebylex", self.zremrangebylexCommand)
 	self.router.Register("zclear", wrapWriteCommandK(self, self.zclearCommand))
 	// for set
-	self.router.Register("scard", wrapReadCommandK(self.scardCommand))
-	self.router.Register("sismember", wrapReadCommandKSubkey(self.sismemberCommand))
-	self.router.Register("smembers", wrapReadCommandK(self.smembersCommand))
+	self.registerReadHandler("scard", wrapReadCommandK(self.scardCommand))
+	self.registerReadHandler("sismember", wrapReadCommandKSubkey(self.sismemberCommand))
+	self.registerReadHandler("smembers", wrapReadCommandK(self.smembersCommand))
 	self.router.Register("sadd", wrapWriteCommandKSubkeySubkey(self, self.saddCommand))
 	self.router.Register("srem", wrapWriteCommandKSubkeySubkey(self, self.sremCommand))
 	self.router.Register("sclear", wrapWriteCommandK(self, self.sclearCommand))
 	self.router.Register("smclear", wrapWriteCommandKK(self, self.smclearCommand))
+	// for hyperloglog
+	self.registerReadHandler("pfcount", wrapReadCommandKK(self.pfcountCommand))
+	self.router.Register("pfadd", wrapWriteCommandKSubkeySubkey(self, self.pfaddCommand))
+	self.router.Register("pfmerge", wrapWriteCommandKK(self, self.pfmergeCommand))
+	// for the durable sequence
+	self.router.Register("nextid", self.nextidCommand)
 
 	// for scan
-	self.router.Register("scan", wrapReadCommandKAnySubkey(self.scanCommand))
-	self.router.Register("hscan", wrapReadCommandKAnySubkey(self.hscanCommand))
-	self.router.Register("sscan", wrapReadCommandKAnySubkey(self.sscanCommand))
-	self.router.Register("zscan", wrapReadCommandKAnySubkey(self.zscanCommand))
-	self.router.Register("advscan", self.advanceScanCommand)
+	self.registerReadHandler("scan", self.limitScanCommand(wrapReadCommandKAnySubkey(self.scanCommand)))
+	self.registerReadHandler("hscan", self.limitScanCommand(wrapReadCommandKAnySubkey(self.hscanCommand)))
+	self.registerReadHandler("sscan", self.limitScanCommand(wrapReadCommandKAnySubkey(self.sscanCommand)))
+	self.registerReadHandler("zscan", self.limitScanCommand(wrapReadCommandKAnySubkey(self.zscanCommand)))
+	self.registerReadHandler("advscan", self.limitScanCommand(self.advanceScanCommand))
 
 	// only write command need to be registered as internal
 	// kv
 	self.router.RegisterInternal("del", self.localDelCommand)
 	self.router.RegisterInternal("set", self.localSetCommand)
 	self.router.RegisterInternal("setnx", self.localSetnxCommand)
+	self.router.RegisterInternal("cas", self.localCasCommand)
+	self.router.RegisterInternal("cad", self.localCadCommand)
+	self.router.RegisterInternal("append", self.localAppendCommand)
+	self.router.RegisterInternal("setrange", self.localSetRangeCommand)
+	self.router.RegisterInternal("bitfield", self.localBitFieldCommand)
 	self.router.RegisterInternal("mset", self.localMSetCommand)
 	self.router.RegisterInternal("incr", self.localIncrCommand)
 	self.router.RegisterInternal("plset", self.localPlsetCommand)
+	self.router.RegisterInternal("kvimport", self.localKVImportCommand)
+	self.router.RegisterInternal("swap", self.localSwapCommand)
 	// hash
 	self.router.RegisterInternal("hset", self.localHSetCommand)
 	self.router.RegisterInternal("hmset", self.localHMsetCommand)
 	self.router.RegisterInternal("hdel", self.localHDelCommand)
 	self.router.RegisterInternal("hincrby", self.localHIncrbyCommand)
 	self.router.RegisterInternal("hclear", self.localHclearCommand)
+	self.router.RegisterInternal("hpexpireat", self.localHPexpireatCommand)
+	self.router.RegisterInternal("hpersist", self.localHPersistCommand)
+	self.router.RegisterInternal("hgetdel", self.localHGetDelCommand)
+	self.router.RegisterInternal("hpgetexat", self.localHPGetExAtCommand)
+	self.router.RegisterInternal("hexpiredel", self.localHExpireDelCommand)
 	// list
 	self.router.RegisterInternal("lpop", self.localLpopCommand)
 	self.router.RegisterInternal("lpush", self.localLpushCommand)
@@ -265,6 +583,8 @@ func (self *KVNode) registerHandler() {
 	self.router.RegisterInternal("rpop", self.localRpopCommand)
 	self.router.RegisterInternal("rpush", self.localRpushCommand)
 	self.router.RegisterInternal("lclear", self.localLclearCommand)
+	self.router.RegisterInternal("rpoplpush", self.localRpoplpushCommand)
+	self.router.RegisterInternal("lack", self.localLackCommand)
 	// zset
 	self.router.RegisterInternal("zadd", self.localZaddCommand)
 	self.router.RegisterInternal("zincrby", self.localZincrbyCommand)
@@ -273,17 +593,37 @@ func (self *KVNode) registerHandler() {
 	self.router.RegisterInternal("zremrangebyscore", self.localZremrangebyscoreCommand)
 	self.router.RegisterInternal("zremrangebylex", self.localZremrangebylexCommand)
 	self.router.RegisterInternal("zclear", self.localZclearCommand)
+	self.router.RegisterInternal("zdiffstore", self.localZdiffstoreCommand)
+	self.router.RegisterInternal("zpaddexat", self.localZPAddExAtCommand)
+	self.router.RegisterInternal("zexpiredel", self.localZExpireDelCommand)
 	// set
 	self.router.RegisterInternal("sadd", self.localSadd)
 	self.router.RegisterInternal("srem", self.localSrem)
 	self.router.RegisterInternal("sclear", self.localSclear)
 	self.router.RegisterInternal("smclear", self.localSmclear)
+	// hyperloglog
+	self.router.RegisterInternal("pfadd", self.localPFAddCommand)
+	self.router.RegisterInternal("pfmerge", self.localPFMergeCommand)
+	// sequence
+	self.router.RegisterInternal("nextid", self.localNextIDCommand)
+	// table
+	self.router.RegisterInternal("tabledrop", self.localTableDropCommand)
+	// drop the kv keys by prefix
+	self.router.RegisterInternal("dropprefix", self.localDropPrefixCommand)
+	self.router.RegisterInternal("dropprefixaccount", self.localDropPrefixAccountCommand)
+	self.router.RegisterInternal("dropprefixdone", self.localDropPrefixDoneCommand)
+	// the marker to measure the replication latency
+	self.router.RegisterInternal("replping", self.localReplPingCommand)
+	self.router.RegisterInternal("snapcatchup", self.localSnapCatchupCommand)
 }
 
 func (self *KVNode) handleProposeReq() {
 	var reqList BatchInternalRaftRequest
 	reqList.Reqs = make([]*InternalRaftRequest, 0, 100)
 	var lastReq *internalReq
+	// the request which can not be added to the full batch, it will be
+	// added to the next batch.
+	var pending *internalReq
 	defer func() {
 		if e := recover(); e != nil {
 			buf := make([]byte, 4096)
@@ -295,68 +635,157 @@ func (self *KVNode) handleProposeReq() {
 		for _, r := range reqList.Reqs {
 			self.w.Trigger(r.Header.ID, common.ErrStopped)
 		}
+		if pending != nil {
+			self.w.Trigger(pending.reqData.Header.ID, common.ErrStopped)
+		}
 		for {
 			select {
 			case r := <-self.reqProposeC:
 				self.w.Trigger(r.reqData.Header.ID, common.ErrStopped)
+			case r := <-self.reqAdminC:
+				self.w.Trigger(r.reqData.Header.ID, common.ErrStopped)
 			default:
 				break
 			}
 		}
 	}()
+	maxBatchBytes := int64(defaultMaxProposeBatchBytes)
+	if self.nodeConfig != nil && self.nodeConfig.MaxProposeBatchBytes > 0 {
+		maxBatchBytes = int64(self.nodeConfig.MaxProposeBatchBytes)
+	}
+	maxProposalBytes := getMaxProposalBytes(self.nodeConfig)
+	var batchBytes int64
+	var batchStart time.Time
+	addReq := func(r *internalReq) bool {
+		n := int64(len(r.reqData.Data))
+		if err := checkProposalSize(n, maxProposalBytes); err != nil {
+			self.w.Trigger(r.reqData.Header.ID, err)
+			return true
+		}
+		if len(reqList.Reqs) > 0 && batchBytes+n > maxProposalBytes {
+			pending = r
+			return false
+		}
+		if len(reqList.Reqs) == 0 {
+			batchStart = time.Now()
+		}
+		reqList.Reqs = append(reqList.Reqs, &r.reqData)
+		batchBytes += n
+		lastReq = r
+		return true
+	}
+	// the admin proposals are added to the batch ahead of the queued data
+	// proposals, so they will not be starved under the heavy write load.
+	drainAdmin := func() {
+		for pending == nil {
+			select {
+			case r := <-self.reqAdminC:
+				addReq(r)
+			default:
+				return
+			}
+		}
+	}
 	for {
-		select {
-		case r := <-self.reqProposeC:
-			reqList.Reqs = append(reqList.Reqs, &r.reqData)
-			lastReq = r
-		default:
-			if len(reqList.Reqs) == 0 {
-				select {
-				case r := <-self.reqProposeC:
-					reqList.Reqs = append(reqList.Reqs, &r.reqData)
-					lastReq = r
-				case <-self.stopChan:
-					return
+		if pending != nil {
+			r := pending
+			pending = nil
+			addReq(r)
+		}
+		drainAdmin()
+		// skip reading more requests while the batch is full
+		if pending == nil {
+			select {
+			case r := <-self.reqProposeC:
+				if addReq(r) && batchBytes < maxBatchBytes {
+					continue
 				}
-			}
-			reqList.ReqNum = int32(len(reqList.Reqs))
-			buffer, err := reqList.Marshal()
-			if err != nil {
-				nodeLog.Infof("failed to marshal request: %v", err)
-				for _, r := range reqList.Reqs {
-					self.w.Trigger(r.Header.ID, err)
+			default:
+				if len(reqList.Reqs) == 0 {
+					select {
+					case r := <-self.reqAdminC:
+						addReq(r)
+					case r := <-self.reqProposeC:
+						addReq(r)
+					case <-self.stopChan:
+						return
+					}
 				}
-				reqList.Reqs = reqList.Reqs[:0]
-				continue
 			}
-			lastReq.done = make(chan struct{})
-			//nodeLog.Infof("handle req %v, marshal buffer: %v, raw: %v, %v", len(reqList.Reqs),
-			//	realN, buffer, reqList.Reqs)
-			start := time.Now()
-			self.proposeC <- buffer
-			select {
-			case <-lastReq.done:
-			case <-self.stopChan:
-				return
-			}
-			cost := time.Since(start)
-			if len(reqList.Reqs) >= 100 && cost >= time.Second || (cost >= time.Second*2) {
-				nodeLog.Infof("slow for batch: %v, %v", len(reqList.Reqs), cost)
+		}
+		if len(reqList.Reqs) == 0 {
+			// the only request is refused
+			continue
+		}
+		reqList.ReqNum = int32(len(reqList.Reqs))
+		buffer, err := reqList.Marshal()
+		if err != nil {
+			nodeLog.Infof("failed to marshal request: %v", err)
+			for _, r := range reqList.Reqs {
+				self.w.Trigger(r.Header.ID, err)
 			}
 			reqList.Reqs = reqList.Reqs[:0]
-			lastReq = nil
+			batchBytes = 0
+			continue
+		}
+		//nodeLog.Infof("handle req %v, marshal buffer: %v, raw: %v, %v", len(reqList.Reqs),
+		//	realN, buffer, reqList.Reqs)
+		start := time.Now()
+		self.batchStats.UpdateBatchStats(len(reqList.Reqs), batchBytes,
+			start.Sub(batchStart).Nanoseconds()/1000)
+		self.proposeC <- buffer
+		select {
+		case <-lastReq.done:
+		case <-self.stopChan:
+			return
+		}
+		cost := time.Since(start)
+		if len(reqList.Reqs) >= 100 && cost >= time.Second || (cost >= time.Second*2) {
+			nodeLog.Infof("slow for batch: %v, %v", len(reqList.Reqs), cost)
 		}
+		reqList.Reqs = reqList.Reqs[:0]
+		batchBytes = 0
+		lastReq = nil
 	}
 }
 
 func (self *KVNode) queueRequest(req *internalReq) (interface{}, error) {
+	atomic.AddInt64(&self.inflightReqs, 1)
+	defer atomic.AddInt64(&self.inflightReqs, -1)
+	if atomic.LoadInt32(&self.draining) == 1 {
+		return nil, common.ErrDraining
+	}
+	if !self.store.IsHealthy() {
+		return nil, common.ErrStoreUnhealthy
+	}
+	// the proposal on the follower is forwarded to the leader by raft, but
+	// it will be dropped while no leader elected.
+	if self.raftNode.Lead() == raft.None {
+		return nil, common.ErrNoLeader
+	}
+	if !req.admin {
+		if err := self.checkMinISR(false); err != nil {
+			return nil, err
+		}
+	}
+	maxBytes := getMaxProposalBytes(self.nodeConfig)
+	if err := checkProposalSize(int64(len(req.reqData.Data)), maxBytes); err != nil {
+		return nil, err
+	}
 	start := time.Now()
+	// the batch proposing is waiting the last request of the batch done
+	req.done = make(chan struct{})
 	ch := self.w.Register(req.reqData.Header.ID)
+	reqC := self.reqProposeC
+	if req.admin {
+		reqC = self.reqAdminC
+	}
 	select {
-	case self.reqProposeC <- req:
+	case reqC <- req:
 	default:
+		atomic.AddInt64(&self.proposeQueueFull, 1)
 		select {
-		case self.reqProposeC <- req:
+		case reqC <- req:
 		case <-self.stopChan:
 			self.w.Trigger(req.reqData.Header.ID, common.ErrStopped)
 		case <-time.After(time.Second * 3):
@@ -364,32 +793,58 @@ func (self *KVNode) queueRequest(req *internalReq) (interface{}, error) {
 		}
 	}
 	//nodeLog.Infof("queue request: %v", req.reqData.String())
+	timer := time.NewTimer(self.getProposeTimeout())
+	defer timer.Stop()
 	var err error
 	var rsp interface{}
 	var ok bool
 	select {
 	case rsp = <-ch:
-		if req.done != nil {
-			close(req.done)
-		}
 		if err, ok = rsp.(error); ok {
 			rsp = nil
 		} else {
 			err = nil
 		}
+	case <-timer.C:
+		// the proposal forwarded to the leader is dropped if refused, stop
+		// waiting it and the result is ignored if applied later.
+		self.w.Trigger(req.reqData.Header.ID, nil)
+		rsp = nil
+		err = common.ErrProposeTimeout
 	case <-self.stopChan:
 		rsp = nil
 		err = common.ErrStopped
 	}
+	close(req.done)
 	self.clusterWriteStats.UpdateWriteStats(int64(len(req.reqData.Data)), time.Since(start).Nanoseconds()/1000)
 	return rsp, err
 }
 
-func (self *KVNode) Propose(buf []byte) (interface{}, error) {
+func (self *KVNode) getProposeTimeout() time.Duration {
+	if self.nodeConfig == nil || self.nodeConfig.ProposeTimeoutMs <= 0 {
+		return defaultProposeTimeout
+	}
+	return time.Duration(self.nodeConfig.ProposeTimeoutMs) * time.Millisecond
+}
+
+// newRequestHeader create the header proposed with the request. The limits of
+// the leader are proposed with the write, so all the replicas apply the write
+// with the same limits.
+func (self *KVNode) newRequestHeader(dataType int32) *RequestHeader {
 	h := &RequestHeader{
-		ID:       self.raftNode.reqIDGen.Next(),
-		DataType: 0,
+		ID:        self.raftNode.reqIDGen.Next(),
+		DataType:  dataType,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
 	}
+	if self.nodeConfig != nil {
+		h.ValueLimit = int64(self.nodeConfig.MaxValueSize)
+		h.CollectionLimit = int64(self.nodeConfig.MaxCollectionSize)
+	}
+	return h
+}
+
+func (self *KVNode) Propose(buf []byte) (interface{}, error) {
+	h := self.newRequestHeader(0)
 	raftReq := InternalRaftRequest{
 		Header: h,
 		Data:   buf,
@@ -400,11 +855,25 @@ func (self *KVNode) Propose(buf []byte) (interface{}, error) {
 	return self.queueRequest(req)
 }
 
-func (self *KVNode) HTTPPropose(buf []byte) (interface{}, error) {
-	h := &RequestHeader{
-		ID:       self.raftNode.reqIDGen.Next(),
-		DataType: int32(HTTPReq),
+// proposeAdmin propose the control command (such as the replication ping)
+// through the admin queue, which will be proposed ahead of the data writes.
+// The commands changing the data should be proposed as the data writes, so
+// they are not reordered with the writes queued before.
+func (self *KVNode) proposeAdmin(buf []byte) (interface{}, error) {
+	h := self.newRequestHeader(0)
+	raftReq := InternalRaftRequest{
+		Header: h,
+		Data:   buf,
+	}
+	req := &internalReq{
+		reqData: raftReq,
+		admin:   true,
 	}
+	return self.queueRequest(req)
+}
+
+func (self *KVNode) HTTPPropose(buf []byte) (interface{}, error) {
+	h := self.newRequestHeader(int32(HTTPReq))
 	raftReq := InternalRaftRequest{
 		Header: h,
 		Data:   buf,
@@ -435,6 +904,7 @@ func (self *KVNode) applySnapshot(np *nodeProgress, applyEvent *applyInfo) {
 	np.confState = applyEvent.snapshot.Metadata.ConfState
 	np.snapi = applyEvent.snapshot.Metadata.Index
 	np.appliedi = applyEvent.snapshot.Metadata.Index
+	atomic.StoreUint64(&self.appliedIndex, np.appliedi)
 }
 
 func (self *KVNode) applyAll(np *nodeProgress, applyEvent *applyInfo) bool {
@@ -455,8 +925,10 @@ func (self *KVNode) applyAll(np *nodeProgress, applyEvent *applyInfo) bool {
 	}
 	var shouldStop bool
 	var confChanged bool
+	defer self.endApply()
 	for i := range ents {
 		evnt := ents[i]
+		self.beginApply(evnt.Index)
 		switch evnt.Type {
 		case raftpb.EntryNormal:
 			if evnt.Data != nil {
@@ -487,16 +959,26 @@ func (self *KVNode) applyAll(np *nodeProgress, applyEvent *applyInfo) bool {
 								self.w.Trigger(reqID, common.ErrInvalidCommand)
 							} else {
 								cmdStart := time.Now()
-								v, err := h(cmd)
+								self.applyHeader = *req.Header
+								self.runApplyHook(cmdName)
+								var v interface{}
+								err := self.checkCommandKeyType(cmdName, cmd.Args, false)
+								if err == nil {
+									v, err = h(cmd)
+								}
 								cmdCost := time.Since(cmdStart)
 								if cmdCost >= time.Millisecond*500 {
 									nodeLog.Infof("slow write command: %v, cost: %v", string(cmd.Raw), cmdCost)
 								}
 								self.dbWriteStats.UpdateWriteStats(int64(len(cmd.Raw)), cmdCost.Nanoseconds()/1000)
+								self.recordLatency(cmdName, cmdCost)
 								// write the future response or error
 								if err != nil {
 									self.w.Trigger(reqID, err)
 								} else {
+									if self.audit != nil {
+										self.auditCommand("", cmd, false, evnt.Index)
+									}
 									self.w.Trigger(reqID, v)
 								}
 							}
@@ -522,6 +1004,7 @@ func (self *KVNode) applyAll(np *nodeProgress, applyEvent *applyInfo) bool {
 			confChanged = true
 		}
 		np.appliedi = evnt.Index
+		atomic.StoreUint64(&self.appliedIndex, np.appliedi)
 		if evnt.Index == self.raftNode.lastIndex {
 			nodeLog.Infof("replay finished at index: %v\n", evnt.Index)
 		}
@@ -538,6 +1021,17 @@ func (self *KVNode) applyAll(np *nodeProgress, applyEvent *applyInfo) bool {
 	return confChanged
 }
 
+// applyNowMs return the time (unix time in milliseconds) proposed with the
+// request applying. The handlers depending on the time should use it instead
+// of the local clock, so all the replicas and the log replay get the same
+// result. The entries proposed before the timestamp added use the local clock.
+func (self *KVNode) applyNowMs() int64 {
+	if self.applyHeader.Timestamp > 0 {
+		return self.applyHeader.Timestamp
+	}
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
 func (self *KVNode) applyCommits(commitC <-chan applyInfo, errorC <-chan error) {
 	defer func() {
 		self.Stop()
@@ -557,8 +1051,13 @@ func (self *KVNode) applyCommits(commitC <-chan applyInfo, errorC <-chan error)
 		case ent := <-commitC:
 			confChanged := self.applyAll(&np, &ent)
 			<-ent.raftDone
+			self.updateCaughtUpTime()
 			self.maybeTriggerSnapshot(&np, confChanged)
 			self.raftNode.handleSendSnapshot(&np)
+		case req := <-self.compactLogC:
+			self.forceSnapshot(&np, req)
+		case rsp := <-self.raftStateC:
+			rsp <- copyNodeProgress(&np)
 		case err, ok := <-errorC:
 			if !ok {
 				return
@@ -598,14 +1097,35 @@ func (self *KVNode) GetSnapshot(term uint64, index uint64) (Snapshot, error) {
 	var si KVSnapInfo
 	si.BackupInfo = self.store.Backup(term, index)
 	if si.BackupInfo == nil {
-		return nil, errors.New("failed to begin backup: maybe too much backup running")
+		return nil, ErrBackupBusy
 	}
+	go func(bi *rockredis.BackupInfo) {
+		if _, err := bi.GetResult(); err == nil {
+			self.purgeOldBackups()
+		}
+	}(si.BackupInfo)
 	si.WaitReady()
 	si.LeaderInfo = self.raftNode.GetLeadMember()
 	si.Members = self.raftNode.GetMembers()
 	return &si, nil
 }
 
+// purge the old backups by the retention policy, the backup of the current
+// raft snapshot is kept since the lagging follower may still need it.
+func (self *KVNode) purgeOldBackups() {
+	snap, err := self.raftNode.raftStorage.Snapshot()
+	if err != nil {
+		return
+	}
+	keepNum := 0
+	var keepDuration time.Duration
+	if self.nodeConfig != nil {
+		keepNum = self.nodeConfig.SnapRetainNum
+		keepDuration = time.Duration(self.nodeConfig.SnapRetainSeconds) * time.Second
+	}
+	self.store.PurgeOldBackups(keepNum, keepDuration, snap.Metadata.Term, snap.Metadata.Index)
+}
+
 func (self *KVNode) RestoreFromSnapshot(startup bool, raftSnapshot raftpb.Snapshot) error {
 	snapshot := raftSnapshot.Data
 	var si KVSnapInfo
@@ -613,6 +1133,9 @@ func (self *KVNode) RestoreFromSnapshot(startup bool, raftSnapshot raftpb.Snapsh
 	if err != nil {
 		return err
 	}
+	atomic.StoreInt32(&self.restoring, 1)
+	defer atomic.StoreInt32(&self.restoring, 0)
+	runRestoreHook(self.ns)
 	self.raftNode.RestoreMembers(si.Members)
 	nodeLog.Infof("should recovery from snapshot here: %v", raftSnapshot.String())
 	// while startup we can use the local snapshot to restart,
@@ -638,12 +1161,28 @@ func (self *KVNode) RestoreFromSnapshot(startup bool, raftSnapshot raftpb.Snapsh
 		// copy backup data from the remote leader node, and recovery backup from it
 		// if local has some old backup data, we should use rsync to sync the data file
 		// use the rocksdb backup/checkpoint interface to backup data
-		common.RunFileSync(syncAddr,
-			path.Join(rockredis.GetBackupDir(syncDir),
-				rockredis.GetCheckpointDir(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)),
-			self.store.GetBackupDir())
+		self.restoreSyncSnapshotBackup(syncAddr, syncDir, raftSnapshot)
+	}
+	err = self.store.Restore(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)
+	if hasBackup && rockredis.IsCorruption(err) {
+		// the local backup can not be used, copy it from the others
+		nodeLog.Infof("the local backup for snapshot %v is corrupted: %v, copy from remote",
+			raftSnapshot.Metadata.Index, err)
+		self.store.ClearBackup(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)
+		syncAddr, syncDir := self.GetValidBackupInfo(raftSnapshot)
+		if syncAddr == "" && syncDir == "" {
+			return err
+		}
+		self.restoreSyncSnapshotBackup(syncAddr, syncDir, raftSnapshot)
+		err = self.store.Restore(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)
+	}
+	if err != nil {
+		return err
 	}
-	return self.store.Restore(raftSnapshot.Metadata.Term, raftSnapshot.Metadata.Index)
+	self.loadSnapCatchup()
+	// the node is not read ready until warmed up
+	self.warmupAfterRestore()
+	return nil
 }
 
 func (self *KVNode) CheckLocalBackup(snapData []byte) (bool, error) {
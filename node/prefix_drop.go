@@ -0,0 +1,109 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/tidwall/redcon"
+)
+
+const prefixDropCheckInterval = time.Second
+
+// dropprefix prefix
+// reply 1 if dropped, 0 if the prefix is already hidden by the dropping prefix
+func (self *KVNode) dropPrefixCommand(conn redcon.Conn, cmd redcon.Command, v interface{}) {
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) localDropPrefixCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, common.ErrInvalidArgs
+	}
+	return self.store.DropKVPrefix(cmd.Args[1])
+}
+
+func (self *KVNode) localDropPrefixAccountCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, common.ErrInvalidArgs
+	}
+	return nil, self.store.ApplyKVPrefixDropChunk(cmd.Args[1], cmd.Args[2])
+}
+
+func (self *KVNode) localDropPrefixDoneCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 2 {
+		return nil, common.ErrInvalidArgs
+	}
+	return nil, self.store.FinishDropKVPrefix(cmd.Args[1])
+}
+
+// The dropping prefixes are handled out of the apply. The leader scans the
+// keys under the prefix and proposes the account chunks, all the replicas
+// compact the prefix in background once accounted, and then the leader
+// proposes to finish the drop, so the replicas remove the drop marker at the
+// same raft index. The follower not compacted yet deletes the keys left while
+// finishing.
+func (self *KVNode) prefixDropLoop() {
+	ticker := time.NewTicker(prefixDropCheckInterval)
+	defer ticker.Stop()
+	compacted := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&self.stopping) == 1 {
+				return
+			}
+			prefixes := self.store.GetDroppingKVPrefixes()
+			dropping := make(map[string]bool, len(prefixes))
+			for _, prefix := range prefixes {
+				dropping[string(prefix)] = true
+				if !self.store.IsKVPrefixDropAccounted(prefix) {
+					if self.raftNode.isLead() {
+						self.proposePrefixDropAccount(prefix)
+					}
+					continue
+				}
+				if !compacted[string(prefix)] {
+					self.store.CompactKVPrefix(prefix)
+					compacted[string(prefix)] = true
+				}
+				if !self.raftNode.isLead() {
+					continue
+				}
+				cmd := buildCommand([][]byte{[]byte("dropprefixdone"), prefix})
+				if _, err := self.Propose(cmd.Raw); err != nil {
+					nodeLog.Infof("namespace %v finish dropping prefix %v failed: %v",
+						self.ns, string(prefix), err)
+				}
+			}
+			for prefix := range compacted {
+				if !dropping[prefix] {
+					delete(compacted, prefix)
+				}
+			}
+		case <-self.stopChan:
+			return
+		}
+	}
+}
+
+func (self *KVNode) proposePrefixDropAccount(prefix []byte) {
+	chunks, err := self.store.GetKVPrefixDropChunks(prefix)
+	if err != nil {
+		nodeLog.Infof("namespace %v account dropping prefix %v failed: %v",
+			self.ns, string(prefix), err)
+		return
+	}
+	for _, chunk := range chunks {
+		cmd := buildCommand([][]byte{[]byte("dropprefixaccount"), prefix, chunk})
+		if _, err := self.Propose(cmd.Raw); err != nil {
+			nodeLog.Infof("namespace %v account dropping prefix %v failed: %v",
+				self.ns, string(prefix), err)
+			return
+		}
+	}
+}
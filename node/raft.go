@@ -95,6 +95,9 @@ type raftNode struct {
 	join      bool   // node is joining an existing cluster
 	lastIndex uint64 // index of log at start
 	lead      uint64
+	commit    uint64 // the latest commit index known by this node
+	// set while the raft event loop is running
+	loopRunning int32
 
 	// raft backing for the commit/error channel
 	node        raft.Node
@@ -112,6 +115,14 @@ type raftNode struct {
 	ds                DataStorage
 	msgSnapC          chan raftpb.Message
 	inflightSnapshots int64
+	// the log entries kept after the snapshot for the lagging followers
+	snapCatchup int64
+	snapMutex   sync.Mutex
+	// the number of the snapshots sent to each follower
+	sentSnapshots map[uint64]int64
+	// check the proposals forwarded from the followers while leader, the
+	// refused proposals are dropped.
+	forwardedProposeCheck func() error
 }
 
 // newRaftNode initiates a raft instance and returns a committed log entry
@@ -146,6 +157,7 @@ func newRaftNode(rconfig *RaftConfig, join bool, ds DataStorage, proposeC <-chan
 		ds:          ds,
 		reqIDGen:    idutil.NewGenerator(uint16(rconfig.ID), time.Now()),
 		msgSnapC:    make(chan raftpb.Message, maxInFlightMsgSnap),
+		snapCatchup: int64(rconfig.SnapCatchup),
 		// rest of structure populated after WAL replay
 	}
 	return commitC, errorC, rc
@@ -210,7 +222,11 @@ func (rc *raftNode) applyConfChange(cc raftpb.ConfChange, confState *raftpb.Conf
 			nodeLog.Info("I've been removed from the cluster! Shutting down.")
 			return true, nil
 		}
-		rc.transport.RemovePeer(types.ID(cc.NodeID))
+		// the member may never be added to the transport if removed while
+		// restarted as the standalone node.
+		if rc.transport.Get(types.ID(cc.NodeID)) != nil {
+			rc.transport.RemovePeer(types.ID(cc.NodeID))
+		}
 	case raftpb.ConfChangeUpdateNode:
 		var m MemberInfo
 		json.Unmarshal(cc.Context, &m)
@@ -261,6 +277,15 @@ func (rc *raftNode) openWAL(snapshot *raftpb.Snapshot) *wal.WAL {
 func (rc *raftNode) replayWAL(snapshot *raftpb.Snapshot) *wal.WAL {
 	w := rc.openWAL(snapshot)
 	meta, st, ents, err := w.ReadAll()
+	if err == io.ErrUnexpectedEOF {
+		// the torn write after the unclean shutdown, never repair twice
+		w.Close()
+		if !rc.repairWAL() {
+			log.Fatalf("failed to repair WAL (%v)", err)
+		}
+		w = rc.openWAL(snapshot)
+		meta, st, ents, err = w.ReadAll()
+	}
 	if err != nil {
 		w.Close()
 		log.Fatalf("failed to read WAL (%v)", err)
@@ -297,13 +322,16 @@ func (rc *raftNode) startRaft(ds DataStorage) {
 		ElectionTick:    10,
 		HeartbeatTick:   1,
 		Storage:         rc.raftStorage,
-		MaxSizePerMsg:   1024 * 1024,
+		MaxSizePerMsg:   raftMaxSizePerMsg,
 		MaxInflightMsgs: 256,
 		CheckQuorum:     true,
 		Logger:          nodeLog,
 	}
 
-	if oldwal {
+	forceNew := rc.config.nodeConfig != nil && rc.config.nodeConfig.ForceNewCluster
+	if oldwal && forceNew {
+		rc.restartAsStandaloneNode(c, ds)
+	} else if oldwal {
 		rc.restartNode(c, ds)
 	} else {
 		rc.wal = rc.openWAL(nil)
@@ -419,7 +447,8 @@ func (rc *raftNode) proposeMyself(cc raftpb.ConfChange) {
 	}
 }
 
-func (rc *raftNode) restartNode(c *raft.Config, ds DataStorage) {
+// load the newest snapshot and restore the data storage from it
+func (rc *raftNode) loadSnapshot() *raftpb.Snapshot {
 	snapshot, err := rc.snapshotter.Load()
 	if err != nil && err != snap.ErrNoSnapshot {
 		nodeLog.Panic(err)
@@ -434,7 +463,11 @@ func (rc *raftNode) restartNode(c *raft.Config, ds DataStorage) {
 			nodeLog.Panic(err)
 		}
 	}
+	return snapshot
+}
 
+func (rc *raftNode) restartNode(c *raft.Config, ds DataStorage) {
+	snapshot := rc.loadSnapshot()
 	rc.wal = rc.replayWAL(snapshot)
 	rc.node = raft.RestartNode(c)
 	advanceTicksForElection(rc.node, c.ElectionTick)
@@ -496,6 +529,12 @@ func (rc *raftNode) handleSendSnapshot(np *nodeProgress) {
 		snapMsg := snap.NewMessage(m, snapRC, 0)
 		nodeLog.Infof("begin send snapshot: %v", snapMsg.String())
 		rc.transport.SendSnapshot(*snapMsg)
+		rc.snapMutex.Lock()
+		if rc.sentSnapshots == nil {
+			rc.sentSnapshots = make(map[uint64]int64)
+		}
+		rc.sentSnapshots[m.To]++
+		rc.snapMutex.Unlock()
 		rc.wg.Add(1)
 		go func() {
 			defer rc.wg.Done()
@@ -516,7 +555,41 @@ func (rc *raftNode) handleSendSnapshot(np *nodeProgress) {
 	}
 }
 
+// the follower behind the leader within the SnapCatchup entries can catch up
+// from the log kept after the snapshot, otherwise the snapshot is sent.
+func (rc *raftNode) getSnapCatchup() uint64 {
+	return uint64(atomic.LoadInt64(&rc.snapCatchup))
+}
+
+func (rc *raftNode) setSnapCatchup(n uint64) {
+	atomic.StoreInt64(&rc.snapCatchup, int64(n))
+}
+
+func (rc *raftNode) getSentSnapshots(id uint64) int64 {
+	rc.snapMutex.Lock()
+	defer rc.snapMutex.Unlock()
+	return rc.sentSnapshots[id]
+}
+
 func (rc *raftNode) beginSnapshot(snapi uint64, confState raftpb.ConfState) error {
+	return rc.snapshotAndCompact(snapi, confState, rc.snapCompactIndex(snapi), nil)
+}
+
+// the compact index of the log after the snapshot at snapi, the entries
+// within SnapCatchup are kept for the followers to catch up from the log.
+func (rc *raftNode) snapCompactIndex(snapi uint64) uint64 {
+	compactIndex := uint64(1)
+	if catchup := rc.getSnapCatchup(); snapi > catchup {
+		compactIndex = snapi - catchup
+	}
+	return compactIndex
+}
+
+// create the snapshot at snapi and compact the log to the compactIndex after
+// the snapshot is saved. The done will receive the result if not nil, and the
+// snapshot is skipped if a newer one is already saved.
+func (rc *raftNode) snapshotAndCompact(snapi uint64, confState raftpb.ConfState,
+	compactIndex uint64, done chan<- error) error {
 	// here we can just begin snapshot, to freeze the state of storage
 	// and we can copy data async below
 	// TODO: do we need the snapshot while we already make our data stable on disk?
@@ -544,33 +617,40 @@ func (rc *raftNode) beginSnapshot(snapi uint64, confState raftpb.ConfState) erro
 		// TODO: now we can do the actually snapshot for copy
 		snap, err := rc.raftStorage.CreateSnapshot(snapi, &confState, data)
 		if err != nil {
-			if err == raft.ErrSnapOutOfDate {
+			if err != raft.ErrSnapOutOfDate {
+				panic(err)
+			}
+			if done == nil {
 				return
 			}
-			panic(err)
-		}
-		if err := rc.saveSnap(snap); err != nil {
-			panic(err)
+		} else {
+			if err := rc.saveSnap(snap); err != nil {
+				panic(err)
+			}
+			nodeLog.Infof("saved snapshot at index %d", snap.Metadata.Index)
 		}
-		nodeLog.Infof("saved snapshot at index %d", snap.Metadata.Index)
 
-		compactIndex := uint64(1)
-		if snapi > uint64(rc.config.SnapCatchup) {
-			compactIndex = snapi - uint64(rc.config.SnapCatchup)
-		}
 		if err := rc.raftStorage.Compact(compactIndex); err != nil {
-			if err == raft.ErrCompacted {
-				return
+			if err != raft.ErrCompacted {
+				panic(err)
 			}
-			panic(err)
+			if done != nil {
+				done <- nil
+			}
+			return
 		}
 		nodeLog.Infof("compacted log at index %d", compactIndex)
+		if done != nil {
+			done <- nil
+		}
 	}()
 	return nil
 }
 
 func (rc *raftNode) serveChannels() {
 	defer rc.wal.Close()
+	atomic.StoreInt32(&rc.loopRunning, 1)
+	defer atomic.StoreInt32(&rc.loopRunning, 0)
 
 	ticker := time.NewTicker(200 * time.Millisecond)
 	defer ticker.Stop()
@@ -620,6 +700,9 @@ func (rc *raftNode) serveChannels() {
 				atomic.StoreUint64(&rc.lead, rd.SoftState.Lead)
 				isLeader = rd.RaftState == raft.StateLeader
 			}
+			if !raft.IsEmptyHardState(rd.HardState) {
+				atomic.StoreUint64(&rc.commit, rd.HardState.Commit)
+			}
 			raftDone := make(chan struct{}, 1)
 			rc.publishEntries(rd.CommittedEntries, rd.Snapshot, raftDone)
 			if isLeader {
@@ -696,6 +779,32 @@ func (rc *raftNode) serveRaft() {
 func (rc *raftNode) Lead() uint64 { return atomic.LoadUint64(&rc.lead) }
 func (rc *raftNode) isLead() bool { return atomic.LoadUint64(&rc.lead) == uint64(rc.config.ID) }
 
+// CommitIndex return the latest commit index known by this node, the follower
+// learns it from the leader heartbeat and append.
+func (rc *raftNode) CommitIndex() uint64 {
+	return atomic.LoadUint64(&rc.commit)
+}
+
+// transfer the leadership to the transferee and wait until the leader changed or timeout.
+// The caller should check the transferee is a caught-up member.
+func (rc *raftNode) transferLeadership(transferee uint64, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rc.node.TransferLeadership(ctx, uint64(rc.config.ID), transferee)
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+	for rc.Lead() != transferee {
+		select {
+		case <-ctx.Done():
+			return common.ErrTimeout
+		case <-rc.stopc:
+			return common.ErrStopped
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 type memberSorter []*MemberInfo
 
 func (self memberSorter) Less(i, j int) bool {
@@ -752,6 +861,14 @@ func (rc *raftNode) RestoreMembers(mems []*MemberInfo) {
 }
 
 func (rc *raftNode) Process(ctx context.Context, m raftpb.Message) error {
+	if m.Type == raftpb.MsgProp && rc.forwardedProposeCheck != nil && rc.isLead() {
+		if err := rc.forwardedProposeCheck(); err != nil {
+			// the follower stops waiting the dropped proposal after the
+			// propose timeout
+			nodeLog.Infof("drop the proposal forwarded from %v: %v", m.From, err)
+			return nil
+		}
+	}
 	return rc.node.Step(ctx, m)
 }
 func (rc *raftNode) IsIDRemoved(id uint64) bool  { return false }
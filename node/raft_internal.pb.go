@@ -31,6 +31,9 @@ var _ = math.Inf
 type RequestHeader struct {
 	ID               uint64 `protobuf:"varint,1,opt" json:"ID"`
 	DataType         int32  `protobuf:"varint,2,opt,name=data_type" json:"data_type"`
+	Timestamp        int64  `protobuf:"varint,3,opt,name=timestamp" json:"timestamp"`
+	ValueLimit       int64  `protobuf:"varint,4,opt,name=value_limit" json:"value_limit"`
+	CollectionLimit  int64  `protobuf:"varint,5,opt,name=collection_limit" json:"collection_limit"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
@@ -109,6 +112,51 @@ func (m *RequestHeader) Unmarshal(data []byte) error {
 					break
 				}
 			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timestamp", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.Timestamp |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ValueLimit", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.ValueLimit |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CollectionLimit", wireType)
+			}
+			for shift := uint(0); ; shift += 7 {
+				if index >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[index]
+				index++
+				m.CollectionLimit |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			var sizeOfWire int
 			for {
@@ -310,6 +358,9 @@ func (m *RequestHeader) Size() (n int) {
 	_ = l
 	n += 1 + sovRaftInternal(uint64(m.ID))
 	n += 1 + sovRaftInternal(uint64(m.DataType))
+	n += 1 + sovRaftInternal(uint64(m.Timestamp))
+	n += 1 + sovRaftInternal(uint64(m.ValueLimit))
+	n += 1 + sovRaftInternal(uint64(m.CollectionLimit))
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -383,6 +434,15 @@ func (m *RequestHeader) MarshalTo(data []byte) (n int, err error) {
 	data[i] = 0x10
 	i++
 	i = encodeVarintRaftInternal(data, i, uint64(m.DataType))
+	data[i] = 0x18
+	i++
+	i = encodeVarintRaftInternal(data, i, uint64(m.Timestamp))
+	data[i] = 0x20
+	i++
+	i = encodeVarintRaftInternal(data, i, uint64(m.ValueLimit))
+	data[i] = 0x28
+	i++
+	i = encodeVarintRaftInternal(data, i, uint64(m.CollectionLimit))
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+var errRaftStateTimeout = errors.New("get raft state timeout")
+
+const raftStateTimeout = time.Second * 5
+
+// the progress is owned by the apply loop, so the copy is returned
+func copyNodeProgress(np *nodeProgress) nodeProgress {
+	c := *np
+	c.confState.Nodes = append([]uint64(nil), np.confState.Nodes...)
+	return c
+}
+
+// DumpRaftState return the conf state and the progress of the apply loop,
+// the hard state and the members progress of the raft. Nothing is changed.
+func (self *KVNode) DumpRaftState() (*common.RaftStateDump, error) {
+	rsp := make(chan nodeProgress, 1)
+	timer := time.NewTimer(raftStateTimeout)
+	defer timer.Stop()
+	select {
+	case self.raftStateC <- rsp:
+	case <-timer.C:
+		return nil, errRaftStateTimeout
+	case <-self.stopChan:
+		return nil, common.ErrStopped
+	}
+	var np nodeProgress
+	select {
+	case np = <-rsp:
+	case <-timer.C:
+		return nil, errRaftStateTimeout
+	case <-self.stopChan:
+		return nil, common.ErrStopped
+	}
+	status := self.raftNode.node.Status()
+	rs := self.GetRaftStats()
+	nodes := np.confState.Nodes
+	sort.Sort(uint64Sorter(nodes))
+	return &common.RaftStateDump{
+		ConfNodes:    nodes,
+		Term:         status.Term,
+		Vote:         status.Vote,
+		Commit:       status.Commit,
+		SnapIndex:    np.snapi,
+		AppliedIndex: np.appliedi,
+		Members:      rs.Members,
+	}, nil
+}
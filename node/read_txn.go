@@ -0,0 +1,111 @@
+package node
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/tidwall/redcon"
+)
+
+const (
+	DefaultReadTxnTimeout = time.Second * 10
+	MaxReadTxnTimeout     = time.Minute
+)
+
+var (
+	errReadTxnExpired = errors.New("ERR read transaction expired or committed")
+	errReadTxnWrite   = errors.New("ERR only the read commands are allowed in the read transaction")
+)
+
+// ReadTxn serve the read commands from the same pinned store snapshot, so all
+// the reads in the transaction see the same point-in-time data. The reads are
+// not proposed to raft, and the data contains at least the writes applied
+// before the transaction began. The snapshot is tracked by the store and
+// released after the timeout even if the transaction is not committed.
+type ReadTxn struct {
+	sync.Mutex
+	// the node serving the commands from the store view, the reads are
+	// accounted and limited on the node of the namespace
+	node     *KVNode
+	applied  uint64
+	released bool
+}
+
+// BeginReadTxn pin the store snapshot for the read transaction.
+func (self *KVNode) BeginReadTxn(timeout time.Duration) (*ReadTxn, error) {
+	if timeout <= 0 {
+		timeout = DefaultReadTxnTimeout
+	}
+	if timeout > MaxReadTxnTimeout {
+		timeout = MaxReadTxnTimeout
+	}
+	// the applied writes are all in the snapshot taken after
+	applied := atomic.LoadUint64(&self.appliedIndex)
+	view, err := self.store.NewReadView(timeout)
+	if err != nil {
+		return nil, err
+	}
+	n := &KVNode{
+		raftNode:   self.raftNode,
+		store:      view,
+		stopChan:   self.stopChan,
+		router:     common.NewCmdRouter(),
+		audit:      self.audit,
+		ns:         self.ns,
+		nodeConfig: self.nodeConfig,
+		viewOf:     self,
+	}
+	n.registerHandler()
+	return &ReadTxn{
+		node:    n,
+		applied: applied,
+	}, nil
+}
+
+// AppliedIndex return the applied index while the transaction began.
+func (self *ReadTxn) AppliedIndex() uint64 {
+	return self.applied
+}
+
+// Handle serve the read command from the snapshot of the transaction.
+func (self *ReadTxn) Handle(conn redcon.Conn, cmd redcon.Command) {
+	cmdName := strings.ToLower(string(cmd.Args[0]))
+	self.Lock()
+	defer self.Unlock()
+	if self.released {
+		conn.WriteError(errReadTxnExpired.Error())
+		return
+	}
+	h, ok := self.node.router.GetCmdHandler(cmdName)
+	if !ok {
+		conn.WriteError("ERR unknown command '" + cmdName + "'")
+		return
+	}
+	if !self.node.router.IsReadCommand(cmdName) {
+		conn.WriteError(errReadTxnWrite.Error())
+		return
+	}
+	// the snapshot may be expired or released by the store
+	if !self.node.store.LockReadView() {
+		conn.WriteError(errReadTxnExpired.Error())
+		return
+	}
+	defer self.node.store.UnlockReadView()
+	h(conn, cmd)
+}
+
+// Commit release the snapshot of the transaction, it is safe to commit more
+// than once.
+func (self *ReadTxn) Commit() {
+	self.Lock()
+	defer self.Unlock()
+	if self.released {
+		return
+	}
+	self.released = true
+	self.node.store.ReleaseReadView()
+}
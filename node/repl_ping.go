@@ -0,0 +1,98 @@
+package node
+
+import (
+	"sort"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/coreos/etcd/raft"
+	"github.com/tidwall/redcon"
+)
+
+// the interval to check the followers progress while waiting the marker
+const replPingCheckInterval = time.Millisecond
+
+type replPingStatsSorter []common.ReplPingStats
+
+func (self replPingStatsSorter) Less(i, j int) bool {
+	return self[i].ID < self[j].ID
+}
+func (self replPingStatsSorter) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self replPingStatsSorter) Len() int {
+	return len(self)
+}
+
+// ReplPing propose a no-op marker through raft and measure the latency until
+// it is applied on the leader and replicated to each follower. The follower
+// not replicated the marker in the timeout is reported as timed out. The
+// leader is the first and the followers are ordered by the id.
+func (self *KVNode) ReplPing(timeout time.Duration) ([]common.ReplPingStats, error) {
+	if self.raftNode.node.Status().RaftState != raft.StateLeader {
+		return nil, ErrNotLeader
+	}
+	start := time.Now()
+	cmd := buildCommand([][]byte{[]byte("replping")})
+	if _, err := self.proposeAdmin(cmd.Raw); err != nil {
+		return nil, err
+	}
+	applied := time.Since(start)
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return nil, ErrNotLeader
+	}
+	// the marker is committed at or before the current commit index
+	index := status.Commit
+	stats := make([]common.ReplPingStats, 0, len(status.Progress))
+	stats = append(stats, common.ReplPingStats{
+		ID:        status.ID,
+		Leader:    true,
+		LatencyUs: int64(applied / time.Microsecond),
+	})
+	pending := make(map[uint64]bool, len(status.Progress))
+	for id := range status.Progress {
+		if id != status.ID {
+			pending[id] = true
+		}
+	}
+	deadline := start.Add(timeout)
+	for {
+		now := time.Now()
+		for id := range pending {
+			if pr, ok := status.Progress[id]; ok && pr.Match >= index {
+				stats = append(stats, common.ReplPingStats{
+					ID:        id,
+					LatencyUs: int64(now.Sub(start) / time.Microsecond),
+				})
+				delete(pending, id)
+			}
+		}
+		if len(pending) == 0 || now.After(deadline) {
+			break
+		}
+		select {
+		case <-time.After(replPingCheckInterval):
+		case <-self.stopChan:
+			return nil, common.ErrStopped
+		}
+		status = self.raftNode.node.Status()
+	}
+	for id := range pending {
+		stats = append(stats, common.ReplPingStats{
+			ID:        id,
+			TimedOut:  true,
+			LatencyUs: -1,
+		})
+	}
+	sort.Sort(replPingStatsSorter(stats[1:]))
+	return stats, nil
+}
+
+// the marker of the replication ping changes nothing
+func (self *KVNode) localReplPingCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 1 {
+		return nil, common.ErrInvalidArgs
+	}
+	return nil, nil
+}
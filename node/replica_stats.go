@@ -0,0 +1,148 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/coreos/etcd/raft"
+)
+
+// GetReplicaReadStats return the read load and the lag of the applied
+// log of this replica.
+func (self *KVNode) GetReplicaReadStats() common.ReplicaReadStats {
+	status := self.raftNode.node.Status()
+	rs := common.ReplicaReadStats{
+		ID:            status.ID,
+		IsLeader:      status.RaftState == raft.StateLeader,
+		CommitIndex:   status.Commit,
+		AppliedIndex:  atomic.LoadUint64(&self.appliedIndex),
+		ReadNum:       atomic.LoadInt64(&self.readStats.ReadNum),
+		InflightReads: atomic.LoadInt64(&self.readStats.InflightReads),
+	}
+	if rs.CommitIndex > rs.AppliedIndex {
+		rs.Lag = rs.CommitIndex - rs.AppliedIndex
+	}
+	return rs
+}
+
+// GetReplicaLags return the lag of the log entries of each member
+// from the leader view, nil if this node is not leader.
+func (self *KVNode) GetReplicaLags() map[uint64]uint64 {
+	status := self.raftNode.node.Status()
+	if status.RaftState != raft.StateLeader {
+		return nil
+	}
+	lags := make(map[uint64]uint64, len(status.Progress))
+	for id, pr := range status.Progress {
+		if status.Commit > pr.Match {
+			lags[id] = status.Commit - pr.Match
+		} else {
+			lags[id] = 0
+		}
+	}
+	return lags
+}
+
+// GetIdentity return the raft member id and the cluster id of this replica,
+// the namespace has only one partition currently.
+func (self *KVNode) GetIdentity() common.NodeIdentity {
+	return common.NodeIdentity{
+		Namespace: self.ns,
+		Partition: 0,
+		ID:        uint64(self.raftNode.config.ID),
+		ClusterID: self.raftNode.config.ClusterID,
+		RaftAddr:  self.raftNode.config.RaftAddr,
+	}
+}
+
+// GetRaftStats return the raft role, term and leader of this replica, and the
+// progress of all the members if this replica is leader.
+func (self *KVNode) GetRaftStats() common.RaftStats {
+	status := self.raftNode.node.Status()
+	rs := common.RaftStats{
+		ID:      status.ID,
+		Role:    status.RaftState.String(),
+		Term:    status.Term,
+		Leader:  status.Lead,
+		Members: make([]common.RaftMemberStats, 0, len(status.Progress)),
+	}
+	for id, pr := range status.Progress {
+		ms := common.RaftMemberStats{
+			ID:    id,
+			Match: pr.Match,
+			Next:  pr.Next,
+			State: pr.State.String(),
+		}
+		if status.Commit > pr.Match {
+			ms.Lag = status.Commit - pr.Match
+		}
+		rs.Members = append(rs.Members, ms)
+	}
+	sort.Sort(raftMemberStatsSorter(rs.Members))
+	return rs
+}
+
+type raftMemberStatsSorter []common.RaftMemberStats
+
+func (self raftMemberStatsSorter) Less(i, j int) bool {
+	return self[i].ID < self[j].ID
+}
+func (self raftMemberStatsSorter) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self raftMemberStatsSorter) Len() int {
+	return len(self)
+}
+
+// GetReadReplicas return the read stats of all the replicas in this raft group,
+// the stats of the remote replicas are queried from the http api. If this node is
+// leader, the lag of the replica is the max of the lag reported by itself and
+// the lag from the leader view.
+func (self *KVNode) GetReadReplicas() []common.ReplicaReadStats {
+	lags := self.GetReplicaLags()
+	myID := uint64(self.raftNode.config.ID)
+	members := self.raftNode.GetMembers()
+	replicas := make([]common.ReplicaReadStats, 0, len(members))
+	hasMine := false
+	for _, m := range members {
+		if m == nil {
+			continue
+		}
+		var rs common.ReplicaReadStats
+		if m.ID == myID {
+			hasMine = true
+			rs = self.GetReplicaReadStats()
+		} else {
+			c := http.Client{Transport: newDeadlineTransport(time.Second)}
+			rsp, err := c.Get("http://" + m.Broadcast + ":" +
+				strconv.Itoa(m.HttpAPIPort) + "/cluster/readstats/" + self.ns)
+			if err != nil {
+				nodeLog.Infof("request error: %v", err)
+				continue
+			}
+			err = json.NewDecoder(rsp.Body).Decode(&rs)
+			rsp.Body.Close()
+			if err != nil || rsp.StatusCode != http.StatusOK {
+				nodeLog.Infof("get read stats from %v failed: %v, %v", m.ID, rsp.Status, err)
+				continue
+			}
+		}
+		rs.Addr = m.Broadcast + ":" + strconv.Itoa(m.HttpAPIPort)
+		if lag, ok := lags[m.ID]; ok && lag > rs.Lag {
+			rs.Lag = lag
+		}
+		replicas = append(replicas, rs)
+	}
+	// the member info of myself may be not ready while starting
+	if !hasMine {
+		rs := self.GetReplicaReadStats()
+		rs.Addr = self.nodeConfig.BroadcastAddr + ":" + strconv.Itoa(self.nodeConfig.HttpAPIPort)
+		replicas = append(replicas, rs)
+	}
+	return replicas
+}
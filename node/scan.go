@@ -6,8 +6,11 @@ import (
 	"github.com/tidwall/redcon"
 	"strconv"
 	"strings"
+	"time"
 )
 
+const newScanSnapshot = "new"
+
 func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, err error) {
 	if len(args) == 0 {
 		return
@@ -47,8 +50,48 @@ func parseScanArgs(args [][]byte) (cursor []byte, match string, count int, err e
 	return
 }
 
+// extract the [SNAPSHOT id] [SNAPSHOTTTL seconds] from the scan args,
+// and return the other args
+func parseScanSnapshotArgs(args [][]byte) (rest [][]byte, snapID string, ttl time.Duration, err error) {
+	rest = make([][]byte, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "snapshot":
+			if i+1 >= len(args) {
+				err = common.ErrInvalidArgs
+				return
+			}
+			snapID = string(args[i+1])
+			i++
+		case "snapshotttl":
+			if i+1 >= len(args) {
+				err = common.ErrInvalidArgs
+				return
+			}
+			var sec int64
+			sec, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return
+			}
+			if sec <= 0 {
+				err = common.ErrInvalidArgs
+				return
+			}
+			ttl = time.Duration(sec) * time.Second
+			i++
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return
+}
+
 // SCAN cursor [MATCH match] [COUNT count]
 // scan only kv type, cursor is table:key
+// Since the cursor is the last returned key and the keys are scanned in
+// order, a full iteration returns every key present for the whole scan at
+// least once. The namespace has no partitions, so the cursor carries no
+// topology info.
 func (self *KVNode) scanCommand(conn redcon.Conn, cmd redcon.Command) {
 	if len(cmd.Args) < 2 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
@@ -63,12 +106,15 @@ func (self *KVNode) scanCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 	var ay [][]byte
 	ay, err = self.store.Scan(common.KV, cursor, count, match)
+	resume, err := checkScanBudget(len(ay), err)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
 	}
 	var nextCursor []byte
-	if len(ay) < count || (count == 0 && len(ay) == 0) {
+	if resume != nil {
+		nextCursor = resume
+	} else if len(ay) < count || (count == 0 && len(ay) == 0) {
 		nextCursor = []byte("")
 	} else {
 		nextCursor = ay[len(ay)-1]
@@ -82,10 +128,19 @@ func (self *KVNode) scanCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
-// ADVSCAN cursor type [MATCH match] [COUNT count]
+// ADVSCAN cursor type [MATCH match] [COUNT count] [SNAPSHOT id] [SNAPSHOTTTL seconds]
 // here cursor is the scan key for start, (table:key)
 // and the response will return the next start key for next scan,
 // (note: it is not the "0" as the redis scan to indicate the end of scan)
+// If SNAPSHOT is given, the scan will read from the pinned db snapshot on this node,
+// use "new" to pin a new snapshot and the snapshot id will be returned as the
+// third element of the response, the following scan should use the returned id.
+// The snapshot will be released while the scan finished or after the ttl,
+// and the scan using the released snapshot will fail.
+// The cursor holds no iterator state, so the scan can be resumed by the cursor
+// on any replica, such as the new leader after failover. But the snapshot is
+// pinned only on this node, the scan resumed on the other node should pin a
+// new snapshot there.
 func (self *KVNode) advanceScanCommand(conn redcon.Conn, cmd redcon.Command) {
 	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
@@ -116,7 +171,12 @@ func (self *KVNode) advanceScanCommand(conn redcon.Conn, cmd redcon.Command) {
 	cmd.Args[1] = key
 	cmd.Args[1], cmd.Args[2] = cmd.Args[2], cmd.Args[1]
 
-	cursor, match, count, err := parseScanArgs(cmd.Args[2:])
+	scanArgs, snapID, snapTTL, err := parseScanSnapshotArgs(cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	cursor, match, count, err := parseScanArgs(scanArgs)
 
 	if err != nil {
 		conn.WriteError(err.Error())
@@ -125,7 +185,19 @@ func (self *KVNode) advanceScanCommand(conn redcon.Conn, cmd redcon.Command) {
 
 	var ay [][]byte
 
-	ay, err = self.store.Scan(dataType, cursor, count, match)
+	if snapID == "" {
+		ay, err = self.store.Scan(dataType, cursor, count, match)
+	} else {
+		if strings.ToLower(snapID) == newScanSnapshot {
+			snapID, err = self.store.NewScanSnapshot(snapTTL)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+		}
+		ay, err = self.store.ScanWithSnapshot(snapID, dataType, cursor, count, match)
+	}
+	resume, err := checkScanBudget(len(ay), err)
 
 	if err != nil {
 		conn.WriteError(err.Error())
@@ -133,18 +205,30 @@ func (self *KVNode) advanceScanCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 
 	var nextCursor []byte
-	if len(ay) < count || (count == 0 && len(ay) == 0) {
+	if resume != nil {
+		nextCursor = resume
+	} else if len(ay) < count || (count == 0 && len(ay) == 0) {
 		nextCursor = []byte("")
 	} else {
 		nextCursor = ay[len(ay)-1]
 	}
 
-	conn.WriteArray(2)
+	if snapID == "" {
+		conn.WriteArray(2)
+	} else {
+		if len(nextCursor) == 0 {
+			self.store.ReleaseScanSnapshot(snapID)
+		}
+		conn.WriteArray(3)
+	}
 	conn.WriteBulk(nextCursor)
 	conn.WriteArray(len(ay))
 	for _, v := range ay {
 		conn.WriteBulk(v)
 	}
+	if snapID != "" {
+		conn.WriteBulkString(snapID)
+	}
 	return
 }
 
@@ -164,17 +248,21 @@ func (self *KVNode) hscanCommand(conn redcon.Conn, cmd redcon.Command) {
 		conn.WriteError(err.Error())
 		return
 	}
+	count = self.limitScanCount(count)
 
 	var ay []common.KVRecord
 
 	ay, err = self.store.HScan(key, cursor, count, match)
+	resume, err := checkScanBudget(len(ay), err)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
 	}
 
 	var nextCursor []byte
-	if len(ay) < count || (count == 0 && len(ay) == 0) {
+	if resume != nil {
+		nextCursor = resume
+	} else if len(ay) < count || (count == 0 && len(ay) == 0) {
 		nextCursor = []byte("")
 	} else {
 		nextCursor = ay[len(ay)-1].Key
@@ -206,15 +294,19 @@ func (self *KVNode) sscanCommand(conn redcon.Conn, cmd redcon.Command) {
 		conn.WriteError(err.Error())
 		return
 	}
+	count = self.limitScanCount(count)
 
 	var ay [][]byte
 	ay, err = self.store.SScan(key, cursor, count, match)
+	resume, err := checkScanBudget(len(ay), err)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
 	}
 	var nextCursor []byte
-	if len(ay) < count || (count == 0 && len(ay) == 0) {
+	if resume != nil {
+		nextCursor = resume
+	} else if len(ay) < count || (count == 0 && len(ay) == 0) {
 		nextCursor = []byte("")
 	} else {
 		nextCursor = ay[len(ay)-1]
@@ -244,10 +336,12 @@ func (self *KVNode) zscanCommand(conn redcon.Conn, cmd redcon.Command) {
 		conn.WriteError(err.Error())
 		return
 	}
+	count = self.limitScanCount(count)
 
 	var ay []common.ScorePair
 
 	ay, err = self.store.ZScan(key, cursor, count, match)
+	resume, err := checkScanBudget(len(ay), err)
 
 	if err != nil {
 		conn.WriteError(err.Error())
@@ -261,7 +355,9 @@ func (self *KVNode) zscanCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 
 	var nextCursor []byte
-	if len(ay) < count || (count == 0 && len(ay) == 0) {
+	if resume != nil {
+		nextCursor = resume
+	} else if len(ay) < count || (count == 0 && len(ay) == 0) {
 		nextCursor = []byte("")
 	} else {
 		nextCursor = ay[len(ay)-1].Member
@@ -0,0 +1,50 @@
+package node
+
+import (
+	"strconv"
+
+	"github.com/tidwall/redcon"
+)
+
+// NEXTID key [count]
+// allocate count (default 1) ids from the durable sequence and return the
+// first id of the allocated range, the range is [first, first+count).
+func (self *KVNode) nextidCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	if len(cmd.Args) == 3 {
+		n, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		if n <= 0 {
+			conn.WriteError("ERR the sequence count should be positive")
+			return
+		}
+	}
+
+	_, v, ok := rebuildFirstKeyAndPropose(self, conn, cmd)
+	if !ok {
+		return
+	}
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) localNextIDCommand(cmd redcon.Command) (interface{}, error) {
+	count := int64(1)
+	if len(cmd.Args) > 2 {
+		n, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		count = n
+	}
+	return self.store.NextID(cmd.Args[1], count)
+}
@@ -24,6 +24,14 @@ func (self *KVNode) sismemberCommand(conn redcon.Conn, cmd redcon.Command) {
 }
 
 func (self *KVNode) smembersCommand(conn redcon.Conn, cmd redcon.Command) {
+	n, err := self.store.SCard(cmd.Args[1])
+	if err == nil {
+		err = self.checkFullReadSize(n, "SSCAN")
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
 	v, err := self.store.SMembers(cmd.Args[1])
 	if err != nil {
 		conn.WriteError(err.Error())
@@ -69,6 +77,9 @@ func (self *KVNode) smclearCommand(conn redcon.Conn, cmd redcon.Command, v inter
 }
 
 func (self *KVNode) localSadd(cmd redcon.Command) (interface{}, error) {
+	if err := self.checkSetGrow(cmd.Args[1], cmd.Args[2:]); err != nil {
+		return nil, err
+	}
 	return self.store.SAdd(cmd.Args[1], cmd.Args[2:]...)
 }
 
@@ -0,0 +1,67 @@
+package node
+
+import (
+	"strconv"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/tidwall/redcon"
+)
+
+// the max keys deleted in one proposal while dropping the table, so
+// the apply of a large table will not block the raft log for long.
+const dropTableBatchNum = 1000
+
+func (self *KVNode) GetTableNames() []string {
+	names := make([]string, 0)
+	for t := range self.store.GetTables() {
+		names = append(names, string(t))
+	}
+	return names
+}
+
+func (self *KVNode) GetTableStats(table string) (common.TableStats, error) {
+	var ts common.TableStats
+	cnt, err := self.store.GetTableKeyCount([]byte(table))
+	if err != nil {
+		return ts, err
+	}
+	ts.Name = table
+	ts.KeyNum = cnt
+	if us := self.getUsageStats(); us != nil {
+		ts.ApproxBytes = us.TableBytes[table]
+	}
+	return ts, nil
+}
+
+// DropTable delete all the keys in the table by proposing the bounded
+// batch deletes to raft, so all the replicas will drop the same keys.
+func (self *KVNode) DropTable(table string) (int64, error) {
+	var total int64
+	limit := []byte(strconv.Itoa(dropTableBatchNum))
+	for {
+		cmd := buildCommand([][]byte{[]byte("tabledrop"), []byte(table), limit})
+		rsp, err := self.Propose(cmd.Raw)
+		if err != nil {
+			return total, err
+		}
+		n, ok := rsp.(int64)
+		if !ok {
+			return total, errInvalidResponse
+		}
+		total += n
+		if n < dropTableBatchNum {
+			return total, nil
+		}
+	}
+}
+
+func (self *KVNode) localTableDropCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) != 3 {
+		return nil, common.ErrInvalidArgs
+	}
+	limit, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		return nil, err
+	}
+	return self.store.DropTableKeys(cmd.Args[1], limit)
+}
@@ -0,0 +1,37 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+)
+
+const usageRefreshInterval = time.Minute
+
+type usageResult struct {
+	stats *rockredis.UsageStats
+	time  time.Time
+}
+
+// return the last estimated usage of the tables and the data types, the usage
+// is estimated from the approximate sizes in background if it is out of date,
+// so the stats can be gotten cheaply. Nil if never estimated.
+func (self *KVNode) getUsageStats() *rockredis.UsageStats {
+	r, _ := self.usage.Load().(usageResult)
+	if time.Since(r.time) >= usageRefreshInterval &&
+		atomic.CompareAndSwapInt32(&self.usageRefreshing, 0, 1) {
+		go self.refreshUsageStats()
+	}
+	return r.stats
+}
+
+func (self *KVNode) refreshUsageStats() {
+	defer atomic.StoreInt32(&self.usageRefreshing, 0)
+	us, err := self.store.EstimateUsage()
+	if err != nil {
+		nodeLog.Infof("namespace %v estimate usage failed: %v", self.ns, err)
+		return
+	}
+	self.usage.Store(usageResult{stats: us, time: time.Now()})
+}
@@ -13,6 +13,19 @@ func SetLogger(level int32, logger common.Logger) {
 	nodeLog.Logger = logger
 }
 
+// all the keys in a multi-key command should belong to the same namespace,
+// since the command can only be handled by one raft group.
+func extractSameNamespaceKey(ns string, rawKey []byte) ([]byte, error) {
+	keyNs, key, err := common.ExtractNamesapce(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyNs != ns {
+		return nil, errCrossSlot
+	}
+	return key, nil
+}
+
 func buildCommand(args [][]byte) redcon.Command {
 	// build a pipeline command
 	buf := make([]byte, 0, 128)
@@ -129,8 +142,13 @@ func wrapReadCommandKK(f common.CommandFunc) common.CommandFunc {
 			conn.WriteError(errTooMuchBatchSize.Error())
 			return
 		}
+		ns, _, err := common.ExtractNamesapce(cmd.Args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 		for i := 1; i < len(cmd.Args); i++ {
-			_, key, err := common.ExtractNamesapce(cmd.Args[i])
+			key, err := extractSameNamespaceKey(ns, cmd.Args[i])
 			if err != nil {
 				conn.WriteError(err.Error())
 				return
@@ -166,8 +184,13 @@ func wrapWriteCommandKK(kvn *KVNode, f common.CommandRspFunc) common.CommandFunc
 			conn.WriteError(errTooMuchBatchSize.Error())
 			return
 		}
+		ns, _, err := common.ExtractNamesapce(args[0])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 		for i, v := range args {
-			_, key, err := common.ExtractNamesapce(v)
+			key, err := extractSameNamespaceKey(ns, v)
 			if err != nil {
 				conn.WriteError(err.Error())
 				return
@@ -265,11 +288,16 @@ func wrapWriteCommandKVKV(kvn *KVNode, f common.CommandRspFunc) common.CommandFu
 			return
 		}
 		args := cmd.Args[1:]
+		ns, _, err := common.ExtractNamesapce(args[0])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 		for i, v := range args {
 			if i%2 != 0 {
 				continue
 			}
-			_, key, err := common.ExtractNamesapce(v)
+			key, err := extractSameNamespaceKey(ns, v)
 			if err != nil {
 				conn.WriteError(err.Error())
 				return
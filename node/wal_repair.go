@@ -0,0 +1,60 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/pkg/fileutil"
+	"github.com/coreos/etcd/wal"
+)
+
+// the sorted wal file names, the broken files are excluded
+func (rc *raftNode) sortedWALNames() ([]string, error) {
+	names, err := fileutil.ReadDir(rc.config.WALDir)
+	if err != nil {
+		return nil, err
+	}
+	walNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, ".wal") {
+			walNames = append(walNames, name)
+		}
+	}
+	sort.Strings(walNames)
+	return walNames, nil
+}
+
+// the last wal file is the only file may be torn by the unclean shutdown
+func (rc *raftNode) lastWALFile() string {
+	walNames, err := rc.sortedWALNames()
+	if err != nil || len(walNames) == 0 {
+		return ""
+	}
+	return filepath.Join(rc.config.WALDir, walNames[len(walNames)-1])
+}
+
+func walFileSize(f string) int64 {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return -1
+	}
+	return fi.Size()
+}
+
+// repair the torn write at the tail of the last wal file by truncating it to
+// the last valid record, the broken file is kept as the .broken file. The
+// entries truncated are not committed by this node, so the leader will send
+// them again if they are committed by the others.
+func (rc *raftNode) repairWAL() bool {
+	f := rc.lastWALFile()
+	before := walFileSize(f)
+	nodeLog.Warningf("the wal file %v is torn (size %v), try repairing", f, before)
+	if !wal.Repair(rc.config.WALDir) {
+		return false
+	}
+	nodeLog.Warningf("the wal file %v repaired, truncated from size %v to %v, the broken file is saved as %v",
+		f, before, walFileSize(f), f+".broken")
+	return true
+}
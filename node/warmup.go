@@ -0,0 +1,32 @@
+package node
+
+import (
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+const defaultWarmupTimeout = time.Second * 10
+
+// warm up the block cache after the snapshot restored, skipped if the warmup
+// size is not configured.
+func (self *KVNode) warmupAfterRestore() {
+	if self.nodeConfig == nil || self.nodeConfig.WarmupMaxBytes <= 0 {
+		return
+	}
+	timeout := defaultWarmupTimeout
+	if self.nodeConfig.WarmupTimeoutMs > 0 {
+		timeout = time.Duration(self.nodeConfig.WarmupTimeoutMs) * time.Millisecond
+	}
+	ws := self.store.Warmup(self.nodeConfig.WarmupMaxBytes, timeout)
+	nodeLog.Infof("warmup after restore: %v keys, %v bytes, cost %vms, done: %v",
+		ws.Keys, ws.Bytes, ws.CostMs, ws.Done)
+	self.warmupStats.Store(ws)
+}
+
+// GetWarmupStats return the last warmup after the snapshot restored, false if
+// never warmed up.
+func (self *KVNode) GetWarmupStats() (common.WarmupStats, bool) {
+	ws, ok := self.warmupStats.Load().(common.WarmupStats)
+	return ws, ok
+}
@@ -4,13 +4,21 @@ import (
 	"bytes"
 	"errors"
 	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/tidwall/redcon"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
-	errInvalidRange = errors.New("Invalid range string")
+	errInvalidRange  = errors.New("Invalid range string")
+	errInvalidKeyNum = errors.New("ERR numkeys should be greater than 0 and not exceed the keys")
+	errWeightsKeyNum = errors.New("ERR the number of weights should be the same as the keys")
+	errZSetAggregate = errors.New("ERR aggregate should be SUM, MIN or MAX")
+	errZAddNXAndXX   = errors.New("ERR XX and NX options at the same time are not compatible")
+	errZAddGTLTAndNX = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	errZAddIncrPair  = errors.New("ERR INCR option supports a single increment-element pair")
 )
 
 func getScoreRange(left []byte, right []byte) (int64, int64, error) {
@@ -350,12 +358,13 @@ func (self *KVNode) zrevrankCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
+// zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 func (self *KVNode) zaddCommand(conn redcon.Conn, cmd redcon.Command) {
-	if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+	if len(cmd.Args) < 4 {
 		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
 		return
 	}
-	_, err := getScorePairs(cmd.Args[2:])
+	_, incr, _, err := parseZAddArgs(cmd.Args[2:])
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
@@ -365,11 +374,18 @@ func (self *KVNode) zaddCommand(conn redcon.Conn, cmd redcon.Command) {
 	if !ok {
 		return
 	}
+	if incr && v == nil {
+		// the increment is not allowed by the options
+		conn.WriteNull()
+		return
+	}
 	rsp, ok := v.(int64)
-	if ok {
-		conn.WriteInt64(rsp)
-	} else {
+	if !ok {
 		conn.WriteError(errInvalidResponse.Error())
+	} else if incr {
+		conn.WriteBulkString(strconv.FormatInt(rsp, 10))
+	} else {
+		conn.WriteInt64(rsp)
 	}
 }
 
@@ -502,20 +518,82 @@ func getScorePairs(args [][]byte) ([]common.ScorePair, error) {
 	return mlist, nil
 }
 
+// parse the options and the score pairs of the zadd, return whether INCR
+func parseZAddArgs(args [][]byte) (rockredis.ZAddFlags, bool, []common.ScorePair, error) {
+	var flags rockredis.ZAddFlags
+	incr := false
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "nx" {
+			flags.NX = true
+		} else if opt == "xx" {
+			flags.XX = true
+		} else if opt == "gt" {
+			flags.GT = true
+		} else if opt == "lt" {
+			flags.LT = true
+		} else if opt == "ch" {
+			flags.CH = true
+		} else if opt == "incr" {
+			incr = true
+		} else {
+			break
+		}
+	}
+	args = args[i:]
+	if flags.NX && flags.XX {
+		return flags, incr, nil, errZAddNXAndXX
+	}
+	if (flags.GT && flags.LT) || ((flags.GT || flags.LT) && flags.NX) {
+		return flags, incr, nil, errZAddGTLTAndNX
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return flags, incr, nil, errSyntaxError
+	}
+	if incr && len(args) != 2 {
+		return flags, incr, nil, errZAddIncrPair
+	}
+	mlist, err := getScorePairs(args)
+	return flags, incr, mlist, err
+}
+
 func (self *KVNode) localZaddCommand(cmd redcon.Command) (interface{}, error) {
-	if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+	if len(cmd.Args) < 4 {
 		return nil, common.ErrInvalidArgs
 	}
 
-	mlist, err := getScorePairs(cmd.Args[2:])
+	flags, incr, mlist, err := parseZAddArgs(cmd.Args[2:])
 	if err != nil {
 		return nil, err
 	}
-	v, err := self.store.ZAdd(cmd.Args[1], mlist...)
-	if err != nil {
+	if incr {
+		return self.zincrby(cmd.Args[1], flags, mlist[0].Score, mlist[0].Member)
+	}
+	members := make([][]byte, 0, len(mlist))
+	for _, m := range mlist {
+		members = append(members, m.Member)
+	}
+	if err := self.checkZSetGrow(cmd.Args[1], members); err != nil {
+		return nil, err
+	}
+	if flags == (rockredis.ZAddFlags{}) {
+		return self.store.ZAdd(cmd.Args[1], mlist...)
+	}
+	return self.store.ZAddWithFlags(cmd.Args[1], self.applyNowMs(), flags, mlist...)
+}
+
+// the zincrby and the zadd incr, the nil is returned if the increment is not
+// allowed by the options.
+func (self *KVNode) zincrby(key []byte, flags rockredis.ZAddFlags, delta int64, member []byte) (interface{}, error) {
+	if err := self.checkZSetGrow(key, [][]byte{member}); err != nil {
+		return nil, err
+	}
+	score, ok, err := self.store.ZIncrByWithFlags(key, self.applyNowMs(), flags, delta, member)
+	if err != nil || !ok {
 		return nil, err
 	}
-	return v, nil
+	return score, nil
 }
 
 func (self *KVNode) localZincrbyCommand(cmd redcon.Command) (interface{}, error) {
@@ -527,7 +605,7 @@ func (self *KVNode) localZincrbyCommand(cmd redcon.Command) (interface{}, error)
 	if err != nil {
 		return nil, err
 	}
-	return self.store.ZIncrBy(cmd.Args[1], delta, cmd.Args[3])
+	return self.zincrby(cmd.Args[1], rockredis.ZAddFlags{}, delta, cmd.Args[3])
 }
 
 func (self *KVNode) localZremCommand(cmd redcon.Command) (interface{}, error) {
@@ -535,7 +613,7 @@ func (self *KVNode) localZremCommand(cmd redcon.Command) (interface{}, error) {
 		return nil, common.ErrInvalidArgs
 	}
 
-	return self.store.ZRem(cmd.Args[1], cmd.Args[2:]...)
+	return self.store.ZRem(cmd.Args[1], self.applyNowMs(), cmd.Args[2:]...)
 }
 
 func (self *KVNode) localZremrangebyrankCommand(cmd redcon.Command) (interface{}, error) {
@@ -551,7 +629,7 @@ func (self *KVNode) localZremrangebyrankCommand(cmd redcon.Command) (interface{}
 		return nil, err
 	}
 
-	return self.store.ZRemRangeByRank(cmd.Args[1], int(start), int(stop))
+	return self.store.ZRemRangeByRank(cmd.Args[1], self.applyNowMs(), int(start), int(stop))
 }
 
 func (self *KVNode) localZremrangebyscoreCommand(cmd redcon.Command) (interface{}, error) {
@@ -563,7 +641,7 @@ func (self *KVNode) localZremrangebyscoreCommand(cmd redcon.Command) (interface{
 	if err != nil {
 		return nil, err
 	}
-	return self.store.ZRemRangeByScore(cmd.Args[1], min, max)
+	return self.store.ZRemRangeByScore(cmd.Args[1], self.applyNowMs(), min, max)
 }
 
 func (self *KVNode) localZremrangebylexCommand(cmd redcon.Command) (interface{}, error) {
@@ -575,7 +653,7 @@ func (self *KVNode) localZremrangebylexCommand(cmd redcon.Command) (interface{},
 	if err != nil {
 		return nil, err
 	}
-	return self.store.ZRemRangeByLex(cmd.Args[1], min, max, rt)
+	return self.store.ZRemRangeByLex(cmd.Args[1], self.applyNowMs(), min, max, rt)
 }
 
 func (self *KVNode) localZclearCommand(cmd redcon.Command) (interface{}, error) {
@@ -584,3 +662,270 @@ func (self *KVNode) localZclearCommand(cmd redcon.Command) (interface{}, error)
 	}
 	return self.store.ZClear(cmd.Args[1])
 }
+
+// parse the numkeys and the keys in the same namespace, return the keys
+// without the namespace and the args after the keys.
+func parseZSetSrcKeys(ns string, args [][]byte) ([][]byte, [][]byte, error) {
+	if len(args) < 2 {
+		return nil, nil, errInvalidKeyNum
+	}
+	num, err := strconv.Atoi(string(args[0]))
+	if err != nil || num <= 0 || num > len(args)-1 {
+		return nil, nil, errInvalidKeyNum
+	}
+	if num >= common.MAX_BATCH_NUM {
+		return nil, nil, errTooMuchBatchSize
+	}
+	keys := args[1 : num+1]
+	for i, rawKey := range keys {
+		if ns == "" {
+			ns, _, err = common.ExtractNamesapce(rawKey)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		key, err := extractSameNamespaceKey(ns, rawKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[i] = key
+	}
+	return keys, args[num+1:], nil
+}
+
+func writeScorePairs(conn redcon.Conn, vlist []common.ScorePair, needScore bool) {
+	if needScore {
+		conn.WriteArray(len(vlist) * 2)
+	} else {
+		conn.WriteArray(len(vlist))
+	}
+	for _, d := range vlist {
+		conn.WriteBulk(d.Member)
+		if needScore {
+			conn.WriteBulkString(strconv.FormatInt(d.Score, 10))
+		}
+	}
+}
+
+// zunion/zinter numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func (self *KVNode) zunionInterFunc(conn redcon.Conn, cmd redcon.Command, isInter bool) {
+	keys, args, err := parseZSetSrcKeys("", cmd.Args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	var weights []int64
+	aggregate := rockredis.AggregateSum
+	needScore := false
+	for len(args) > 0 {
+		switch strings.ToLower(string(args[0])) {
+		case "weights":
+			if len(args) < len(keys)+1 {
+				conn.WriteError(errWeightsKeyNum.Error())
+				return
+			}
+			weights = make([]int64, len(keys))
+			for i := range keys {
+				weights[i], err = strconv.ParseInt(string(args[i+1]), 10, 64)
+				if err != nil {
+					conn.WriteError(err.Error())
+					return
+				}
+			}
+			args = args[len(keys)+1:]
+		case "aggregate":
+			if len(args) < 2 {
+				conn.WriteError(errSyntaxError.Error())
+				return
+			}
+			switch strings.ToLower(string(args[1])) {
+			case "sum":
+				aggregate = rockredis.AggregateSum
+			case "min":
+				aggregate = rockredis.AggregateMin
+			case "max":
+				aggregate = rockredis.AggregateMax
+			default:
+				conn.WriteError(errZSetAggregate.Error())
+				return
+			}
+			args = args[2:]
+		case "withscores":
+			needScore = true
+			args = args[1:]
+		default:
+			conn.WriteError(errSyntaxError.Error())
+			return
+		}
+	}
+	var vlist []common.ScorePair
+	if isInter {
+		vlist, err = self.store.ZInter(keys, weights, aggregate)
+	} else {
+		vlist, err = self.store.ZUnion(keys, weights, aggregate)
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeScorePairs(conn, vlist, needScore)
+}
+
+func (self *KVNode) zunionCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.zunionInterFunc(conn, cmd, false)
+}
+
+func (self *KVNode) zinterCommand(conn redcon.Conn, cmd redcon.Command) {
+	self.zunionInterFunc(conn, cmd, true)
+}
+
+// zdiff numkeys key [key ...] [WITHSCORES]
+func (self *KVNode) zdiffCommand(conn redcon.Conn, cmd redcon.Command) {
+	keys, args, err := parseZSetSrcKeys("", cmd.Args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	needScore := false
+	if len(args) == 1 && strings.ToLower(string(args[0])) == "withscores" {
+		needScore = true
+	} else if len(args) != 0 {
+		conn.WriteError(errSyntaxError.Error())
+		return
+	}
+	vlist, err := self.store.ZDiff(keys...)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	writeScorePairs(conn, vlist, needScore)
+}
+
+// zdiffstore destination numkeys key [key ...]
+func (self *KVNode) zdiffstoreCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 4 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	ns, dest, err := common.ExtractNamesapce(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	_, args, err := parseZSetSrcKeys(ns, cmd.Args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if len(args) != 0 {
+		conn.WriteError(errSyntaxError.Error())
+		return
+	}
+	cmd.Args[1] = dest
+	ncmd := buildCommand(cmd.Args)
+	copy(cmd.Raw[0:], ncmd.Raw[:])
+	cmd.Raw = cmd.Raw[:len(ncmd.Raw)]
+	v, err := self.Propose(cmd.Raw)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+func (self *KVNode) localZdiffstoreCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 4 {
+		return nil, common.ErrInvalidArgs
+	}
+	keys := cmd.Args[3:]
+	now := self.applyNowMs()
+	if self.applyHeader.CollectionLimit > 0 {
+		vlist, err := self.store.ZDiffAt(now, keys...)
+		if err != nil {
+			return nil, err
+		}
+		if err := self.checkCollectionSize(0, make([][]byte, len(vlist)), nil); err != nil {
+			return nil, err
+		}
+	}
+	return self.store.ZDiffStore(cmd.Args[1], now, keys...)
+}
+
+// zaddex key ttl score member [score member ...]
+// the members are added with the expire time in seconds, the command will be
+// converted to zpaddexat with the absolute expire time in milliseconds.
+func (self *KVNode) zaddexCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 5 || len(cmd.Args)%2 != 1 {
+		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+		return
+	}
+	ttl, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil || ttl <= 0 {
+		conn.WriteError(common.ErrInvalidArgs.Error())
+		return
+	}
+	if _, err := getScorePairs(cmd.Args[3:]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	when := time.Now().UnixNano()/int64(time.Millisecond) + ttl*1000
+	_, key, err := common.ExtractNamesapce(cmd.Args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	args := make([][]byte, 0, len(cmd.Args))
+	args = append(args, []byte("zpaddexat"), key, []byte(strconv.FormatInt(when, 10)))
+	args = append(args, cmd.Args[3:]...)
+	ncmd := buildCommand(args)
+	v, err := self.Propose(ncmd.Raw)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if rsp, ok := v.(int64); ok {
+		conn.WriteInt64(rsp)
+	} else {
+		conn.WriteError(errInvalidResponse.Error())
+	}
+}
+
+// zpaddexat key when score member [score member ...]
+func (self *KVNode) localZPAddExAtCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 5 {
+		return nil, common.ErrInvalidArgs
+	}
+	when, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	mlist, err := getScorePairs(cmd.Args[3:])
+	if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, 0, len(mlist))
+	for _, m := range mlist {
+		members = append(members, m.Member)
+	}
+	if err := self.checkZSetGrow(cmd.Args[1], members); err != nil {
+		return nil, err
+	}
+	return self.store.ZAddExAt(cmd.Args[1], when, mlist...)
+}
+
+// zexpiredel key now member [member ...]
+// delete the expired members proposed by the expire sweeper
+func (self *KVNode) localZExpireDelCommand(cmd redcon.Command) (interface{}, error) {
+	if len(cmd.Args) < 4 {
+		return nil, common.ErrInvalidArgs
+	}
+	now, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return self.store.ZDelExpiredMembers(cmd.Args[1], now, cmd.Args[3:]...)
+}
@@ -0,0 +1,59 @@
+package rockredis
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+var ErrBackupCanceled = errors.New("backup canceled")
+
+// track the backup in progress, only one backup can run at the same time
+// since the backup loop handles the backup one by one.
+type runningBackup struct {
+	sync.Mutex
+	bi *BackupInfo
+	// only for test, called before the checkpoint is saved
+	beforeSave func()
+}
+
+func (rb *runningBackup) set(bi *BackupInfo) {
+	rb.Lock()
+	rb.bi = bi
+	rb.Unlock()
+}
+
+func (rb *runningBackup) get() *BackupInfo {
+	rb.Lock()
+	defer rb.Unlock()
+	return rb.bi
+}
+
+// GetRunningBackup return the status of the backup in progress, nil if no backup running.
+func (r *RockDB) GetRunningBackup() *common.BackupStatus {
+	bi := r.running.get()
+	if bi == nil {
+		return nil
+	}
+	return &common.BackupStatus{
+		Type:      "backup",
+		Dir:       bi.backupDir,
+		StartTime: bi.startTime.UnixNano(),
+		Size:      common.GetDirSize(bi.backupDir),
+	}
+}
+
+// CancelBackup cancel the backup in progress, the partial checkpoint will
+// be removed and the backup will fail with ErrBackupCanceled.
+// Return false if no backup running.
+func (r *RockDB) CancelBackup() bool {
+	bi := r.running.get()
+	if bi == nil {
+		return false
+	}
+	atomic.StoreInt32(&bi.canceled, 1)
+	dbLog.Infof("canceling the backup: %v", bi.backupDir)
+	return true
+}
@@ -0,0 +1,113 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDBCancelBackup(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	if err := db.KVSet([]byte("test:backup_cancel"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if db.GetRunningBackup() != nil {
+		t.Fatal("should have no running backup")
+	}
+	if db.CancelBackup() {
+		t.Fatal("cancel should fail without running backup")
+	}
+	// block the backup to simulate a long running backup
+	saving := make(chan struct{})
+	resume := make(chan struct{})
+	db.running.beforeSave = func() {
+		close(saving)
+		<-resume
+	}
+	bi := db.Backup(1, 1)
+	if bi == nil {
+		t.Fatal("begin backup failed")
+	}
+	<-saving
+	st := db.GetRunningBackup()
+	if st == nil {
+		t.Fatal("the running backup should be listed")
+	}
+	if st.Dir != bi.backupDir || st.StartTime == 0 {
+		t.Fatalf("the running backup status mismatch: %v", st)
+	}
+	if !db.CancelBackup() {
+		t.Fatal("cancel the running backup failed")
+	}
+	close(resume)
+	if _, err := bi.GetResult(); err != ErrBackupCanceled {
+		t.Fatalf("the canceled backup should fail: %v", err)
+	}
+	if _, err := os.Stat(bi.backupDir); !os.IsNotExist(err) {
+		t.Fatalf("the partial checkpoint should be removed: %v", err)
+	}
+	if db.GetRunningBackup() != nil {
+		t.Fatal("should have no running backup after canceled")
+	}
+
+	// the retry should start clean
+	db.running.beforeSave = nil
+	var bi2 *BackupInfo
+	for i := 0; i < 100; i++ {
+		if bi2 = db.Backup(1, 1); bi2 != nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if bi2 == nil {
+		t.Fatal("begin backup failed")
+	}
+	if _, err := bi2.GetResult(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsLocalBackupOK(1, 1); !ok {
+		t.Fatalf("the retried backup should be ok: %v", err)
+	}
+}
+
+func TestDBBackupBusy(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	saving := make(chan struct{})
+	resume := make(chan struct{})
+	db.running.beforeSave = func() {
+		close(saving)
+		<-resume
+	}
+	bi := db.Backup(1, 1)
+	if bi == nil {
+		t.Fatal("begin backup failed")
+	}
+	<-saving
+	if db.Backup(1, 2) != nil {
+		t.Fatal("the backup should be refused while another backup running")
+	}
+	close(resume)
+	if _, err := bi.GetResult(); err != nil {
+		t.Fatal(err)
+	}
+	db.running.beforeSave = nil
+	var bi2 *BackupInfo
+	for i := 0; i < 100; i++ {
+		if bi2 = db.Backup(1, 2); bi2 != nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if bi2 == nil {
+		t.Fatal("the backup should begin after the running one done")
+	}
+	if _, err := bi2.GetResult(); err != nil {
+		t.Fatal(err)
+	}
+}
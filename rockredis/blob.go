@@ -0,0 +1,165 @@
+package rockredis
+
+import (
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+// while the value separation is enabled, the large kv values are stored in
+// the blob column family, so the compaction of the main lsm tree will not
+// rewrite the large values again and again. The separated values share the
+// reference encoding with the value deduplication, and all the column
+// families are saved in the same checkpoint, so the backup is consistent.
+const blobCFName = "blob"
+
+// the stored kv values can not be decoded without knowing the format, so the
+// format is persisted while the first time opened with the deduplication or
+// the separation enabled, and the values are always stored with the reference
+// header after that, even if the config is changed.
+const valueFormatRef byte = 1
+
+var valueFormatKey = []byte{ValueFormatType}
+
+// whether the config need the kv values stored with the reference header
+func (cfg *RockConfig) isValueRefEnabled() bool {
+	return cfg.EnableValueDedup || cfg.BlobMinValueSize > 0
+}
+
+// the min size of the value stored as the reference
+func (cfg *RockConfig) refMinValueSize() int {
+	if cfg.BlobMinValueSize > 0 {
+		return cfg.BlobMinValueSize
+	}
+	return dedupMinValueSize
+}
+
+func newBlobOptions() *gorocksdb.Options {
+	bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
+	// the blob is only read by the point lookup of the value hash
+	bbto.SetFilterPolicy(gorocksdb.NewBloomFilter(10))
+	opts := gorocksdb.NewDefaultOptions()
+	opts.SetBlockBasedTableFactory(bbto)
+	// the universal compaction rewrites the large values less times than
+	// the level compaction at the cost of more space.
+	opts.SetCompactionStyle(gorocksdb.UniversalCompactionStyle)
+	opts.SetWriteBufferSize(1024 * 1024 * 128)
+	opts.SetMaxWriteBufferNumber(4)
+	opts.SetTargetFileSizeBase(1024 * 1024 * 256)
+	return opts
+}
+
+func (r *RockDB) loadValueFormat() error {
+	v, err := r.eng.GetBytes(r.defaultReadOpts, valueFormatKey)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		r.valueRef = len(v) > 0 && v[0] == valueFormatRef
+		return nil
+	}
+	r.valueRef = false
+	if !r.cfg.isValueRefEnabled() {
+		return nil
+	}
+	if r.hasKVData() {
+		dbLog.Infof("the kv values already stored without the reference header, " +
+			"the value deduplication and separation are ignored")
+		return nil
+	}
+	if err := r.eng.Put(r.defaultWriteOpts, valueFormatKey, []byte{valueFormatRef}); err != nil {
+		return err
+	}
+	r.valueRef = true
+	return nil
+}
+
+func (r *RockDB) hasKVData() bool {
+	start := []byte{KVType}
+	it := r.newRangeIterator(start, prefixRangeStop(start), common.RangeROpen, false)
+	defer it.Close()
+	return it.Valid()
+}
+
+// the column family can not be skipped while opening the db, so the blob
+// column family once created should always be opened.
+func (r *RockDB) hasBlobCF() bool {
+	names, err := gorocksdb.ListColumnFamilies(r.dbOpts, r.GetDataDir())
+	if err != nil {
+		// the db not created yet
+		return false
+	}
+	for _, name := range names {
+		if name == blobCFName {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RockDB) openEng() error {
+	if r.blobOpts == nil && r.hasBlobCF() {
+		r.dbOpts.SetCreateIfMissingColumnFamilies(true)
+		r.blobOpts = newBlobOptions()
+	}
+	if r.blobOpts == nil {
+		eng, err := gorocksdb.OpenDb(r.dbOpts, r.GetDataDir())
+		if err != nil {
+			return err
+		}
+		r.eng = eng
+		return nil
+	}
+	eng, cfs, err := gorocksdb.OpenDbColumnFamilies(r.dbOpts, r.GetDataDir(),
+		[]string{"default", blobCFName}, []*gorocksdb.Options{r.dbOpts, r.blobOpts})
+	if err != nil {
+		return err
+	}
+	r.eng = eng
+	r.cfs = cfs
+	r.blobCF = cfs[1]
+	return nil
+}
+
+func (r *RockDB) closeEng() {
+	for _, cf := range r.cfs {
+		cf.Destroy()
+	}
+	r.cfs = nil
+	r.blobCF = nil
+	r.eng.Close()
+	r.eng = nil
+}
+
+// get the shared value by the encoded value key
+func (r *RockDB) getRefValue(ek []byte) ([]byte, error) {
+	if r.blobCF == nil {
+		return r.eng.GetBytes(r.defaultReadOpts, ek)
+	}
+	s, err := r.eng.GetCF(r.defaultReadOpts, r.blobCF, ek)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Free()
+	if !s.Exists() {
+		// the value shared before the separation enabled
+		return r.eng.GetBytes(r.defaultReadOpts, ek)
+	}
+	v := make([]byte, s.Size())
+	copy(v, s.Data())
+	return v, nil
+}
+
+func (r *RockDB) putRefValue(wb *gorocksdb.WriteBatch, ek []byte, value []byte) {
+	if r.blobCF == nil {
+		wb.Put(ek, value)
+	} else {
+		wb.PutCF(r.blobCF, ek, value)
+	}
+}
+
+func (r *RockDB) deleteRefValue(wb *gorocksdb.WriteBatch, ek []byte) {
+	wb.Delete(ek)
+	if r.blobCF != nil {
+		wb.DeleteCF(r.blobCF, ek)
+	}
+}
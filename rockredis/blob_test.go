@@ -0,0 +1,270 @@
+package rockredis
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+func getTestBlobDB(t testing.TB, blobMinSize int) *RockDB {
+	cfg := NewRockConfig()
+	var err error
+	cfg.DataDir, err = ioutil.TempDir("", fmt.Sprintf("rockredis-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BlobMinValueSize = blobMinSize
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func getTestLargeValue(seed int, size int) []byte {
+	v := make([]byte, size)
+	for i := range v {
+		v[i] = byte((i + seed) % 256)
+	}
+	return v
+}
+
+// the total size of the keys and values in the main lsm tree
+func getMainTreeSize(db *RockDB) int {
+	size := 0
+	it := NewDBRangeIterator(db.eng, nil, nil, common.RangeClose, false)
+	for ; it.Valid(); it.Next() {
+		size += len(it.RefKey()) + len(it.RefValue())
+	}
+	it.Close()
+	return size
+}
+
+func TestKVValueSeparation(t *testing.T) {
+	db := getTestBlobDB(t, 1024)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	num := 50
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("test:kv_blob_%d", i))
+		if err := db.KVSet(key, getTestLargeValue(i, 64*1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.KVSet([]byte("test:kv_blob_small"), []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.KVGet([]byte("test:kv_blob_small")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "small" {
+		t.Fatal(string(v))
+	}
+	vals, errs := db.MGet([]byte("test:kv_blob_1"), []byte("test:kv_blob_small"))
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	} else if !bytes.Equal(vals[0], getTestLargeValue(1, 64*1024)) || string(vals[1]) != "small" {
+		t.Fatal("mget value mismatch")
+	}
+	// the large values should not be stored in the main lsm tree
+	if size := getMainTreeSize(db); size > 64*1024 {
+		t.Fatalf("the main lsm tree is too large: %v", size)
+	}
+
+	var bi *BackupInfo
+	for j := 0; j < 100; j++ {
+		if bi = db.Backup(1, 1); bi != nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if bi == nil {
+		t.Fatal("begin backup failed")
+	}
+	if _, err := bi.GetResult(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsLocalBackupOK(1, 1); !ok {
+		t.Fatal(err)
+	}
+
+	// change the values after backup, the restore should revert all of them
+	for i := 0; i < num/2; i++ {
+		key := []byte(fmt.Sprintf("test:kv_blob_%d", i))
+		if err := db.KVDel(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.KVSet([]byte("test:kv_blob_small"), getTestLargeValue(num, 64*1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.KVSet([]byte(fmt.Sprintf("test:kv_blob_%d", num-1)), []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	db.CompactRange()
+
+	if err := db.Restore(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < num; i++ {
+		key := []byte(fmt.Sprintf("test:kv_blob_%d", i))
+		if v, err := db.KVGet(key); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(v, getTestLargeValue(i, 64*1024)) {
+			t.Fatalf("the value of %v mismatch after restore", string(key))
+		}
+	}
+	if v, err := db.KVGet([]byte("test:kv_blob_small")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "small" {
+		t.Fatal(string(v))
+	}
+	stats, err := db.GetDedupStats()
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != int64(num) || stats.TotalValues != int64(num) {
+		t.Fatal(stats)
+	}
+	if db.GetInternalStatus()["blob-sst-files-size"] == nil {
+		t.Fatal("the blob status should be returned")
+	}
+}
+
+func writeLargeValues(t testing.TB, db *RockDB, num int, keyNum int) {
+	for i := 0; i < num; i++ {
+		key := []byte("test:kv_blob_write_" + strconv.Itoa(i%keyNum))
+		if err := db.KVSet(key, getTestLargeValue(i, 16*1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKVValueSeparationCompaction(t *testing.T) {
+	inline := getTestBlobDB(t, 0)
+	defer os.RemoveAll(inline.cfg.DataDir)
+	defer inline.Close()
+	separated := getTestBlobDB(t, 1024)
+	defer os.RemoveAll(separated.cfg.DataDir)
+	defer separated.Close()
+
+	writeLargeValues(t, inline, 2000, 500)
+	writeLargeValues(t, separated, 2000, 500)
+	var rg gorocksdb.Range
+	inline.limitedCompactRange(rg)
+	separated.limitedCompactRange(rg)
+	inlineSize, _ := strconv.ParseInt(inline.GetInternalPropertyStatus("rocksdb.total-sst-files-size"), 10, 64)
+	separatedSize, _ := strconv.ParseInt(separated.GetInternalPropertyStatus("rocksdb.total-sst-files-size"), 10, 64)
+	// the compaction of the main lsm tree only rewrites the small references
+	if inlineSize == 0 || separatedSize*10 > inlineSize {
+		t.Fatalf("the main lsm tree should be much smaller: %v, %v", separatedSize, inlineSize)
+	}
+	if inlineWrite, separatedWrite := getCompactWriteBytes(inline), getCompactWriteBytes(separated); separatedWrite*10 > inlineWrite {
+		t.Fatalf("the compaction write should drop: %v, %v", separatedWrite, inlineWrite)
+	}
+}
+
+// the compaction write bytes from the rocksdb statistics
+func getCompactWriteBytes(db *RockDB) int64 {
+	for _, line := range strings.Split(db.GetStatistics(), "\n") {
+		if !strings.HasPrefix(line, "rocksdb.compact.write.bytes ") {
+			continue
+		}
+		pos := strings.LastIndex(line, ":")
+		n, _ := strconv.ParseInt(strings.TrimSpace(line[pos+1:]), 10, 64)
+		return n
+	}
+	return 0
+}
+
+func reopenTestValueRefDB(t *testing.T, dataDir string, dedup bool, blobMinSize int) *RockDB {
+	cfg := NewRockConfig()
+	cfg.DataDir = dataDir
+	cfg.EnableValueDedup = dedup
+	cfg.BlobMinValueSize = blobMinSize
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func checkTestKVValue(t *testing.T, db *RockDB, key []byte, value []byte) {
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, value) {
+		t.Fatalf("value mismatch for %s: %v", key, len(v))
+	}
+}
+
+func TestValueFormatPersisted(t *testing.T) {
+	db := getTestBlobDB(t, 1024)
+	dataDir := db.cfg.DataDir
+	defer os.RemoveAll(dataDir)
+	large := getTestLargeValue(1, 4096)
+	if err := db.KVSet([]byte("test:kv_format_large"), large); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.KVSet([]byte("test:kv_format_small"), []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// the format and the blob column family are kept after the config changed
+	db = reopenTestValueRefDB(t, dataDir, false, 0)
+	if !db.valueRef || db.blobCF == nil {
+		t.Fatalf("the value format should be persisted: %v, %v", db.valueRef, db.blobCF)
+	}
+	checkTestKVValue(t, db, []byte("test:kv_format_large"), large)
+	checkTestKVValue(t, db, []byte("test:kv_format_small"), []byte("small"))
+	large2 := getTestLargeValue(2, 4096)
+	if err := db.KVSet([]byte("test:kv_format_large2"), large2); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	db = reopenTestValueRefDB(t, dataDir, true, 1024)
+	checkTestKVValue(t, db, []byte("test:kv_format_large2"), large2)
+	db.Close()
+
+	// the values stored without the header are not decoded after enabled
+	plain := getTestBlobDB(t, 0)
+	plainDir := plain.cfg.DataDir
+	defer os.RemoveAll(plainDir)
+	if err := plain.KVSet([]byte("test:kv_format_large"), large); err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	plain = reopenTestValueRefDB(t, plainDir, true, 1024)
+	defer plain.Close()
+	if plain.valueRef {
+		t.Fatal("the value format should not be changed while the values stored")
+	}
+	checkTestKVValue(t, plain, []byte("test:kv_format_large"), large)
+}
+
+func BenchmarkKVSetLargeValue(b *testing.B) {
+	for _, blobMinSize := range []int{0, 1024} {
+		b.Run("blob_min_size_"+strconv.Itoa(blobMinSize), func(b *testing.B) {
+			db := getTestBlobDB(b, blobMinSize)
+			defer os.RemoveAll(db.cfg.DataDir)
+			defer db.Close()
+			b.SetBytes(16 * 1024)
+			b.ResetTimer()
+			writeLargeValues(b, db, b.N, 1000)
+			var rg gorocksdb.Range
+			db.limitedCompactRange(rg)
+			b.StopTimer()
+			b.Logf("compaction write bytes of the main lsm tree: %v", getCompactWriteBytes(db))
+		})
+	}
+}
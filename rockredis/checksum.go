@@ -0,0 +1,154 @@
+package rockredis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+const (
+	defaultChecksumRangeKeys = 10000
+)
+
+// KeyRangeChecksum is the checksum of all the key-values in range [Start, End),
+// nil Start or End means the range is unbounded at that side.
+// The ranges generated from one replica is continuous, so it can be used to
+// verify all the data on another replica. The Checksum is the crc32 of the
+// range if the Algo is empty, otherwise the range is computed by the Algo
+// negotiated with the replica and the Sum64 is the checksum.
+type KeyRangeChecksum struct {
+	Start    []byte `json:"start"`
+	End      []byte `json:"end"`
+	KeyNum   int64  `json:"key_num"`
+	Checksum uint32 `json:"checksum"`
+	Algo     string `json:"algo,omitempty"`
+	Sum64    uint64 `json:"sum64,omitempty"`
+}
+
+func (self *KeyRangeChecksum) Equal(other *KeyRangeChecksum) bool {
+	return self.KeyNum == other.KeyNum && self.Algo == other.Algo &&
+		self.Checksum == other.Checksum && self.Sum64 == other.Sum64
+}
+
+func (self *KeyRangeChecksum) setSum(h hash.Hash) {
+	if self.Algo == "" {
+		self.Checksum = h.(hash.Hash32).Sum32()
+		return
+	}
+	self.Sum64 = checksumSum(h)
+	self.Checksum = uint32(self.Sum64)
+}
+
+// the legacy crc32 is left empty in the range, so the replica not knowing the
+// algorithms can verify it.
+func rangeChecksumAlgo(algo string) string {
+	if algo == ChecksumCRC32 {
+		return ""
+	}
+	return algo
+}
+
+func checksumKV(h hash.Hash, key []byte, value []byte) {
+	var lenBuf [8]byte
+	binary.BigEndian.PutUint32(lenBuf[:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(lenBuf[4:], uint32(len(value)))
+	h.Write(lenBuf[:])
+	h.Write(key)
+	h.Write(value)
+}
+
+// RangeChecksumList split all the data into continuous key ranges with at most
+// rangeKeyNum keys in each range, and stream the checksum of each range
+// computed by the algo (the empty algo means the legacy crc32).
+// All the ranges are computed from the same db snapshot. The iteration will be
+// stopped if stopC is closed.
+func (r *RockDB) RangeChecksumList(rangeKeyNum int, algo string, stopC <-chan struct{}) chan KeyRangeChecksum {
+	if rangeKeyNum <= 0 {
+		rangeKeyNum = defaultChecksumRangeKeys
+	}
+	algo = rangeChecksumAlgo(algo)
+	h, err := NewChecksumHash(algo)
+	if err != nil {
+		dbLog.Infof("checksum algorithm %q unknown, use %v", algo, ChecksumCRC32)
+		algo = ""
+		h, _ = NewChecksumHash(algo)
+	}
+	retChan := make(chan KeyRangeChecksum, 32)
+	it := NewSnapshotDBRangeIterator(r.eng, nil, nil, common.RangeClose, false)
+	go func() {
+		defer close(retChan)
+		defer it.Close()
+		cur := KeyRangeChecksum{Algo: algo}
+		for ; it.Valid(); it.Next() {
+			if cur.KeyNum >= int64(rangeKeyNum) {
+				cur.End = it.Key()
+				cur.setSum(h)
+				select {
+				case retChan <- cur:
+				case <-stopC:
+					return
+				}
+				cur = KeyRangeChecksum{Start: cur.End, Algo: algo}
+				h.Reset()
+			}
+			checksumKV(h, it.RefKey(), it.RefValue())
+			cur.KeyNum++
+		}
+		cur.setSum(h)
+		select {
+		case retChan <- cur:
+		case <-stopC:
+		}
+	}()
+	return retChan
+}
+
+// VerifyRangeChecksums compute the checksum of the local data for each of the
+// incoming ranges and stream the local checksum of the mismatched ranges.
+// The incoming ranges should be ordered and not overlapped (as generated by RangeChecksumList),
+// so we can check all the ranges in one pass on the same db snapshot.
+// The range of the unknown algorithm is always mismatched.
+func (r *RockDB) VerifyRangeChecksums(ranges <-chan KeyRangeChecksum) chan KeyRangeChecksum {
+	retChan := make(chan KeyRangeChecksum, 32)
+	it := NewSnapshotDBRangeIterator(r.eng, nil, nil, common.RangeClose, false)
+	go func() {
+		defer close(retChan)
+		defer it.Close()
+		hashes := make(map[string]hash.Hash)
+		for remote := range ranges {
+			local := KeyRangeChecksum{Start: remote.Start, End: remote.End, Algo: remote.Algo}
+			h, ok := hashes[remote.Algo]
+			if !ok {
+				var err error
+				if h, err = NewChecksumHash(remote.Algo); err == nil {
+					hashes[remote.Algo] = h
+				} else {
+					// the keys in range are still iterated by the crc32
+					dbLog.Infof("range [%v, %v) checksum algorithm %q unknown", remote.Start, remote.End, remote.Algo)
+					local.Algo = ""
+					h, _ = NewChecksumHash(local.Algo)
+				}
+			}
+			h.Reset()
+			for ; it.Valid(); it.Next() {
+				if remote.Start != nil && bytes.Compare(it.RefKey(), remote.Start) < 0 {
+					continue
+				}
+				if remote.End != nil && bytes.Compare(it.RefKey(), remote.End) >= 0 {
+					break
+				}
+				checksumKV(h, it.RefKey(), it.RefValue())
+				local.KeyNum++
+			}
+			local.setSum(h)
+			if !local.Equal(&remote) {
+				dbLog.Infof("range [%v, %v) mismatch, local: %v, %v, remote: %v, %v",
+					local.Start, local.End, local.KeyNum, local.Checksum, remote.KeyNum, remote.Checksum)
+				retChan <- local
+			}
+		}
+	}()
+	return retChan
+}
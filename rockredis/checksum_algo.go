@@ -0,0 +1,97 @@
+package rockredis
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"sync"
+)
+
+// the hash algorithms of the data integrity checks, the crc32 is the legacy
+// algorithm known by all the replicas, and the xxhash is the default.
+const (
+	ChecksumCRC32  = "crc32"
+	ChecksumCRC32C = "crc32c"
+	ChecksumSHA256 = "sha256"
+	ChecksumXXHash = "xxhash"
+)
+
+var ErrUnknownChecksumAlgo = errors.New("unknown checksum algorithm")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var checksumAlgo = struct {
+	sync.Mutex
+	name string
+}{name: ChecksumXXHash}
+
+// SetChecksumAlgo set the preferred hash algorithm of the data integrity
+// checks in the process, the empty name means the default xxhash.
+func SetChecksumAlgo(name string) error {
+	if name == "" {
+		name = ChecksumXXHash
+	}
+	if _, err := NewChecksumHash(name); err != nil {
+		return err
+	}
+	checksumAlgo.Lock()
+	checksumAlgo.name = name
+	checksumAlgo.Unlock()
+	return nil
+}
+
+// GetChecksumAlgo return the preferred hash algorithm of the data integrity
+// checks.
+func GetChecksumAlgo() string {
+	checksumAlgo.Lock()
+	defer checksumAlgo.Unlock()
+	return checksumAlgo.name
+}
+
+// GetChecksumAlgos return all the hash algorithms supported.
+func GetChecksumAlgos() []string {
+	return []string{ChecksumCRC32, ChecksumCRC32C, ChecksumSHA256, ChecksumXXHash}
+}
+
+// NegotiateChecksumAlgo return the preferred algorithm if it is supported by
+// the peer, otherwise the legacy crc32. The peer not knowing the algorithms
+// (nil) only supports the crc32.
+func NegotiateChecksumAlgo(peerAlgos []string) string {
+	algo := GetChecksumAlgo()
+	for _, a := range peerAlgos {
+		if a == algo {
+			return algo
+		}
+	}
+	return ChecksumCRC32
+}
+
+// NewChecksumHash return the hash of the algorithm, the empty name means the
+// legacy crc32.
+func NewChecksumHash(name string) (hash.Hash, error) {
+	switch name {
+	case "", ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumXXHash:
+		return newXXHash64(), nil
+	}
+	return nil, ErrUnknownChecksumAlgo
+}
+
+// checksumSum return the checksum of the hash as uint64, the longer sum is
+// truncated to the first 8 bytes.
+func checksumSum(h hash.Hash) uint64 {
+	var buf [8]byte
+	sum := h.Sum(nil)
+	if len(sum) >= len(buf) {
+		return binary.BigEndian.Uint64(sum)
+	}
+	copy(buf[len(buf)-len(sum):], sum)
+	return binary.BigEndian.Uint64(buf[:])
+}
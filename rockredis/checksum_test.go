@@ -0,0 +1,221 @@
+package rockredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func checkReplicaRanges(db1 *RockDB, db2 *RockDB, rangeKeyNum int) []KeyRangeChecksum {
+	stopC := make(chan struct{})
+	defer close(stopC)
+	mismatched := make([]KeyRangeChecksum, 0)
+	for r := range db2.VerifyRangeChecksums(db1.RangeChecksumList(rangeKeyNum, GetChecksumAlgo(), stopC)) {
+		mismatched = append(mismatched, r)
+	}
+	return mismatched
+}
+
+func TestRangeChecksumConsistent(t *testing.T) {
+	db1 := getTestDB(t)
+	defer os.RemoveAll(db1.cfg.DataDir)
+	defer db1.Close()
+	db2 := getTestDB(t)
+	defer os.RemoveAll(db2.cfg.DataDir)
+	defer db2.Close()
+
+	if ret := checkReplicaRanges(db1, db2, 10); len(ret) != 0 {
+		t.Fatalf("empty db should be consistent: %v", ret)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("test:checksum_key_%03d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := db1.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := db2.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ret := checkReplicaRanges(db1, db2, 10); len(ret) != 0 {
+		t.Fatalf("identical db should be consistent: %v", ret)
+	}
+	if ret := checkReplicaRanges(db2, db1, 7); len(ret) != 0 {
+		t.Fatalf("identical db should be consistent: %v", ret)
+	}
+}
+
+func TestRangeChecksumDivergent(t *testing.T) {
+	db1 := getTestDB(t)
+	defer os.RemoveAll(db1.cfg.DataDir)
+	defer db1.Close()
+	db2 := getTestDB(t)
+	defer os.RemoveAll(db2.cfg.DataDir)
+	defer db2.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("test:checksum_key_%03d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := db1.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if i == 55 {
+			value = []byte("diverged")
+		}
+		if err := db2.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	divergedKey := []byte("test:checksum_key_055")
+	ret := checkReplicaRanges(db1, db2, 10)
+	if len(ret) != 1 {
+		t.Fatalf("should have only one mismatched range: %v", ret)
+	}
+	if ret[0].Start == nil || ret[0].End == nil {
+		t.Fatalf("the mismatched range should be bounded: %v", ret[0])
+	}
+	rangeStart := ret[0].Start
+	rangeEnd := ret[0].End
+	if string(rangeStart) > string(encodeKVKey(divergedKey)) || string(rangeEnd) <= string(encodeKVKey(divergedKey)) {
+		t.Fatalf("the mismatched range should contain the diverged key: %v", ret[0])
+	}
+
+	// key only exist on one replica
+	if err := db2.KVDel([]byte("test:checksum_key_000")); err != nil {
+		t.Fatal(err)
+	}
+	ret = checkReplicaRanges(db1, db2, 10)
+	if len(ret) != 2 {
+		t.Fatalf("should have two mismatched ranges: %v", ret)
+	}
+	if ret[0].Start != nil {
+		t.Fatalf("the first range should be mismatched: %v", ret[0])
+	}
+	if err := db2.KVSet([]byte("test:checksum_key_999"), []byte("extra")); err != nil {
+		t.Fatal(err)
+	}
+	ret = checkReplicaRanges(db1, db2, 10)
+	if len(ret) != 3 {
+		t.Fatalf("should have three mismatched ranges: %v", ret)
+	}
+	if ret[2].End != nil {
+		t.Fatalf("the last range should be mismatched: %v", ret[2])
+	}
+}
+
+func TestRangeChecksumAlgo(t *testing.T) {
+	defer SetChecksumAlgo("")
+	if GetChecksumAlgo() != ChecksumXXHash {
+		t.Fatalf("the default checksum algorithm should be xxhash: %v", GetChecksumAlgo())
+	}
+	// the peer not knowing the xxhash falls back to the crc32
+	if algo := NegotiateChecksumAlgo([]string{ChecksumCRC32, ChecksumCRC32C, ChecksumSHA256}); algo != ChecksumCRC32 {
+		t.Fatalf("the peer without xxhash should use the crc32: %v", algo)
+	}
+	if algo := NegotiateChecksumAlgo(GetChecksumAlgos()); algo != ChecksumXXHash {
+		t.Fatalf("the xxhash should be used if supported: %v", algo)
+	}
+	if err := SetChecksumAlgo("md5"); err != ErrUnknownChecksumAlgo {
+		t.Fatalf("the unknown algorithm should be rejected: %v", err)
+	}
+	// the preferred algorithm is used only if the peer supports it
+	if err := SetChecksumAlgo(ChecksumSHA256); err != nil {
+		t.Fatal(err)
+	}
+	if algo := NegotiateChecksumAlgo(nil); algo != ChecksumCRC32 {
+		t.Fatalf("the legacy peer should use the crc32: %v", algo)
+	}
+	if algo := NegotiateChecksumAlgo([]string{ChecksumCRC32, ChecksumCRC32C}); algo != ChecksumCRC32 {
+		t.Fatalf("the unsupported algorithm should not be used: %v", algo)
+	}
+	if algo := NegotiateChecksumAlgo(GetChecksumAlgos()); algo != ChecksumSHA256 {
+		t.Fatalf("the supported algorithm should be used: %v", algo)
+	}
+
+	db1 := getTestDB(t)
+	defer os.RemoveAll(db1.cfg.DataDir)
+	defer db1.Close()
+	db2 := getTestDB(t)
+	defer os.RemoveAll(db2.cfg.DataDir)
+	defer db2.Close()
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("test:checksum_key_%03d", i))
+		value := []byte(fmt.Sprintf("value_%d", i))
+		if err := db1.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := db2.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, algo := range GetChecksumAlgos() {
+		stopC := make(chan struct{})
+		ranges := make(chan KeyRangeChecksum, 32)
+		for r := range db1.RangeChecksumList(10, algo, stopC) {
+			if algo == ChecksumCRC32 && (r.Algo != "" || r.Sum64 != 0) {
+				t.Fatalf("the crc32 range should be in the legacy format: %v", r)
+			}
+			if algo != ChecksumCRC32 && r.Algo != algo {
+				t.Fatalf("the range should be computed by %v: %v", algo, r)
+			}
+			ranges <- r
+		}
+		close(stopC)
+		close(ranges)
+		for r := range db2.VerifyRangeChecksums(ranges) {
+			t.Fatalf("identical db should be consistent by %v: %v", algo, r)
+		}
+	}
+
+	if err := db2.KVSet([]byte("test:checksum_key_055"), []byte("diverged")); err != nil {
+		t.Fatal(err)
+	}
+	for _, algo := range GetChecksumAlgos() {
+		if err := SetChecksumAlgo(algo); err != nil {
+			t.Fatal(err)
+		}
+		if ret := checkReplicaRanges(db1, db2, 10); len(ret) != 1 {
+			t.Fatalf("the diverged range should be detected by %v: %v", algo, ret)
+		}
+	}
+
+	ranges := make(chan KeyRangeChecksum, 1)
+	ranges <- KeyRangeChecksum{KeyNum: 100, Algo: "md5"}
+	close(ranges)
+	if ret := <-db1.VerifyRangeChecksums(ranges); ret.KeyNum != 100 || ret.Algo == "md5" {
+		t.Fatalf("the range of the unknown algorithm should be mismatched: %v", ret)
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	// the reference results of XXH64 with the zero seed
+	tests := []struct {
+		input string
+		sum   uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Call me Ishmael. Some years ago--never mind how long precisely-", 0x02a2e85470d6fd96},
+	}
+	for _, tt := range tests {
+		h := newXXHash64()
+		h.Write([]byte(tt.input))
+		if h.Sum64() != tt.sum {
+			t.Errorf("xxhash of %q mismatch: %x", tt.input, h.Sum64())
+		}
+		// the sum should be the same while written in pieces
+		h.Reset()
+		for i := 0; i < len(tt.input); i += 7 {
+			end := i + 7
+			if end > len(tt.input) {
+				end = len(tt.input)
+			}
+			h.Write([]byte(tt.input[i:end]))
+		}
+		if h.Sum64() != tt.sum || checksumSum(h) != tt.sum {
+			t.Errorf("xxhash of %q written in pieces mismatch: %x", tt.input, h.Sum64())
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package rockredis
+
+import (
+	"sync"
+
+	"github.com/absolute8511/gorocksdb"
+)
+
+// limit the manual compactions running at the same time in the process,
+// since all the namespaces on the node share the same disk, compacting
+// too many namespaces at once will stall the foreground writes and reads.
+type compactLimiter struct {
+	sync.Mutex
+	cond    *sync.Cond
+	max     int
+	running int
+}
+
+func newCompactLimiter() *compactLimiter {
+	l := &compactLimiter{}
+	l.cond = sync.NewCond(&l.Mutex)
+	return l
+}
+
+var globalCompactLimiter = newCompactLimiter()
+
+func (l *compactLimiter) acquire() {
+	l.Lock()
+	for l.max > 0 && l.running >= l.max {
+		l.cond.Wait()
+	}
+	l.running++
+	l.Unlock()
+}
+
+func (l *compactLimiter) release() {
+	l.Lock()
+	l.running--
+	l.Unlock()
+	l.cond.Broadcast()
+}
+
+// SetMaxConcurrentCompactions set the max manual compactions of all the
+// namespaces running at the same time, the others are deferred until one
+// is done. 0 means no limit.
+func SetMaxConcurrentCompactions(n int) {
+	l := globalCompactLimiter
+	l.Lock()
+	l.max = n
+	l.Unlock()
+	l.cond.Broadcast()
+}
+
+// GetCompactingNum return the number of the manual compactions running in the process.
+func GetCompactingNum() int {
+	l := globalCompactLimiter
+	l.Lock()
+	defer l.Unlock()
+	return l.running
+}
+
+func (r *RockDB) limitedCompactRange(rg gorocksdb.Range) {
+	globalCompactLimiter.acquire()
+	defer globalCompactLimiter.release()
+	r.eng.CompactRange(rg)
+}
+
+func (r *RockDB) limitedCompactBlob(rg gorocksdb.Range) {
+	globalCompactLimiter.acquire()
+	defer globalCompactLimiter.release()
+	r.eng.CompactRangeCF(r.blobCF, rg)
+}
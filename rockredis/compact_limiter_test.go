@@ -0,0 +1,53 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompactLimiter(t *testing.T) {
+	db1 := getTestDB(t)
+	defer os.RemoveAll(db1.cfg.DataDir)
+	defer db1.Close()
+	db2 := getTestDB(t)
+	defer os.RemoveAll(db2.cfg.DataDir)
+	defer db2.Close()
+
+	SetMaxConcurrentCompactions(1)
+	defer SetMaxConcurrentCompactions(0)
+
+	// hold the limiter as the compaction of db1 is running
+	globalCompactLimiter.acquire()
+	if n := GetCompactingNum(); n != 1 {
+		t.Fatalf("compacting num mismatch: %v", n)
+	}
+	done := make(chan struct{})
+	go func() {
+		db2.CompactRange()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the compaction should be deferred while another is running")
+	case <-time.After(time.Millisecond * 200):
+	}
+	if n := GetCompactingNum(); n != 1 {
+		t.Fatalf("the deferred compaction should not be counted: %v", n)
+	}
+	globalCompactLimiter.release()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("the deferred compaction should run after the running one done")
+	}
+	if n := GetCompactingNum(); n != 0 {
+		t.Fatalf("compacting num mismatch: %v", n)
+	}
+
+	// no limit
+	SetMaxConcurrentCompactions(0)
+	globalCompactLimiter.acquire()
+	db1.CompactRange()
+	globalCompactLimiter.release()
+}
@@ -15,6 +15,13 @@ const (
 	NoneType byte = 0
 	// 0~10 reserved for system usage
 
+	// the persisted format of the kv values
+	ValueFormatType byte = 1
+	// the key written to probe the unhealthy store
+	HealthProbeType byte = 2
+	// the snap catchup changed through the raft
+	SnapCatchupType byte = 3
+
 	// table count, stats, index, schema, and etc.
 	TableMetaType byte = 10
 
@@ -32,6 +39,17 @@ const (
 	SSizeType  byte = 30
 
 	JSONType byte = 31
+	// the expire time for the hash field
+	HFieldExpType byte = 32
+	// the shared values and the reference count for the value deduplication
+	DedupValueType byte = 33
+	DedupRefType   byte = 34
+	// the marker for the kv key prefix which is dropping
+	KVPrefixDropType byte = 36
+	// the durable sequence counter
+	SeqType byte = 37
+	// the expire time for the zset member
+	ZMemberExpType byte = 38
 
 	// this type has a custom partition key length
 	// to allow all the data store in the same partition
@@ -44,6 +62,11 @@ const (
 	// and scan periodically to delete the expired keys
 	ExpTimeType byte = 101
 	ExpMetaType byte = 102
+	// the expire time index for the hash fields, used to scan and delete
+	// the expired hash fields
+	HFieldExpTimeType byte = 103
+	// the expire time index for the zset members
+	ZMemberExpTimeType byte = 104
 )
 
 var (
@@ -0,0 +1,27 @@
+package rockredis
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsCorruption return whether the error is the data corruption reported by
+// the rocksdb.
+func IsCorruption(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Corruption")
+}
+
+// move the corrupted data dir aside as the .corrupt dir and open an empty db.
+// The data of the node is always rebuilt from the raft snapshot and the raft
+// log while starting, so the empty db will be restored from the local backup,
+// or copied from the others if the local backup is corrupted too.
+func (r *RockDB) openEngAfterCorruption(openErr error) error {
+	corruptDir := r.GetDataDir() + ".corrupt." + strconv.FormatInt(time.Now().UnixNano(), 10)
+	dbLog.Infof("the db %v is corrupted: %v, moved to %v", r.GetDataDir(), openErr, corruptDir)
+	if err := os.Rename(r.GetDataDir(), corruptDir); err != nil {
+		return err
+	}
+	return r.openEng()
+}
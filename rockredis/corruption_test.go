@@ -0,0 +1,46 @@
+package rockredis
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenCorruptedDB(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+
+	key := []byte("test:corrupted_db")
+	if err := db.KVSet(key, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	dataDir := db.GetDataDir()
+	cfg := NewRockConfig()
+	cfg.DataDir = db.cfg.DataDir
+	db.Close()
+
+	// the CURRENT file not ending with the newline is reported as corruption
+	if err := ioutil.WriteFile(path.Join(dataDir, "CURRENT"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatalf("the corrupted db should be moved aside: %v", err)
+	}
+	defer db.Close()
+	if v, err := db.KVGet(key); err != nil || v != nil {
+		t.Fatalf("the db should be empty: %v, %v", v, err)
+	}
+	dirs, err := filepath.Glob(dataDir + ".corrupt.*")
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("the corrupted data should be kept: %v, %v", dirs, err)
+	}
+	if _, err := os.Stat(path.Join(dirs[0], "CURRENT")); err != nil {
+		t.Fatalf("the corrupted files should be kept: %v", err)
+	}
+	if err := db.KVSet(key, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package rockredis
+
+import (
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+// at most the sample number of elements are iterated for the DEBUG OBJECT
+const debugObjectSampleNum = 1000
+
+// ObjectDebugInfo is the internal info of the object for the DEBUG OBJECT
+// command. The SerializedLength is the length of all the stored elements and
+// the Length is the element number.
+type ObjectDebugInfo struct {
+	Encoding         string
+	SerializedLength int64
+	Length           int64
+}
+
+// the element range of the collection and the length of the element
+var debugObjectTypes = []struct {
+	dataType byte
+	sizeFunc func(*RockDB, []byte) (int64, error)
+	elemLen  func(k []byte, v []byte) int64
+}{
+	{HashType, (*RockDB).HLen, func(k []byte, v []byte) int64 {
+		_, f, _ := hDecodeHashKey(k)
+		return int64(len(f) + len(v))
+	}},
+	{ListType, (*RockDB).LLen, func(k []byte, v []byte) int64 {
+		return int64(len(v))
+	}},
+	{SetType, (*RockDB).SCard, func(k []byte, v []byte) int64 {
+		_, m, _ := sDecodeSetKey(k)
+		return int64(len(m))
+	}},
+	{ZSetType, (*RockDB).ZCard, func(k []byte, v []byte) int64 {
+		_, m, _ := zDecodeSetKey(k)
+		return int64(len(m) + len(v))
+	}},
+}
+
+func (db *RockDB) elementRange(dataType byte, key []byte) ([]byte, []byte, uint8, error) {
+	switch dataType {
+	case HashType:
+		return hEncodeStartKey(key), hEncodeStopKey(key), common.RangeROpen, nil
+	case ListType:
+		headSeq, tailSeq, _, err := db.lGetMeta(lEncodeMetaKey(key))
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return lEncodeListKey(key, headSeq), lEncodeListKey(key, tailSeq), common.RangeClose, nil
+	case SetType:
+		return sEncodeStartKey(key), sEncodeStopKey(key), common.RangeROpen, nil
+	default:
+		return zEncodeStartSetKey(key), zEncodeStopSetKey(key), common.RangeROpen, nil
+	}
+}
+
+// DebugObject return the internal info of the object stored at the key, nil
+// if the key not exist. The element number is read from the meta, and the
+// serialized length of the large collection is estimated from the first
+// sample elements.
+func (db *RockDB) DebugObject(key []byte) (*ObjectDebugInfo, error) {
+	enc, err := db.ObjectEncoding(key)
+	if err != nil || enc == "" {
+		return nil, err
+	}
+	info := &ObjectDebugInfo{Encoding: enc}
+	v, err := db.KVGet(key)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		info.SerializedLength = int64(len(v))
+		info.Length = 1
+		return info, nil
+	}
+	for _, t := range debugObjectTypes {
+		n, err := t.sizeFunc(db, key)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			continue
+		}
+		info.Length = n
+		start, stop, rtype, err := db.elementRange(t.dataType, key)
+		if err != nil {
+			return nil, err
+		}
+		it := db.newRangeIterator(start, stop, rtype, false)
+		var sampled int64
+		for ; it.Valid() && sampled < debugObjectSampleNum; it.Next() {
+			info.SerializedLength += t.elemLen(it.Key(), it.Value())
+			sampled++
+		}
+		it.Close()
+		if sampled > 0 && sampled < n {
+			info.SerializedLength = info.SerializedLength * n / sampled
+		}
+		return info, nil
+	}
+	return info, nil
+}
@@ -0,0 +1,76 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestDebugObject(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	if info, err := db.DebugObject([]byte("test:debug_nokey")); err != nil {
+		t.Fatal(err)
+	} else if info != nil {
+		t.Fatalf("the key not exist should have no info: %v", info)
+	}
+
+	kvKey := []byte("test:debug_kv")
+	if err := db.KVSet(kvKey, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := db.DebugObject(kvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingEmbStr || info.SerializedLength != 5 || info.Length != 1 {
+		t.Fatalf("the kv info mismatch: %v", info)
+	}
+
+	hashKey := []byte("test:debug_hash")
+	if err := db.HMset(hashKey, common.KVRecord{Key: []byte("f1"), Value: []byte("v1")},
+		common.KVRecord{Key: []byte("f2"), Value: []byte("v2")}); err != nil {
+		t.Fatal(err)
+	}
+	info, err = db.DebugObject(hashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingHashtable || info.SerializedLength != 8 || info.Length != 2 {
+		t.Fatalf("the hash info mismatch: %v", info)
+	}
+
+	listKey := []byte("test:debug_list")
+	for i := 0; i < 300; i++ {
+		if _, err := db.RPush(listKey, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err = db.DebugObject(listKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingQuicklist || info.SerializedLength != 300 || info.Length != 300 {
+		t.Fatalf("the list info mismatch: %v", info)
+	}
+
+	// the large collection is estimated from the sample elements
+	largeKey := []byte("test:debug_list_large")
+	elems := make([][]byte, 0, debugObjectSampleNum*3)
+	for i := 0; i < debugObjectSampleNum*3; i++ {
+		elems = append(elems, []byte("vv"))
+	}
+	if _, err := db.RPush(largeKey, elems...); err != nil {
+		t.Fatal(err)
+	}
+	info, err = db.DebugObject(largeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Length != int64(len(elems)) || info.SerializedLength != int64(len(elems)*2) {
+		t.Fatalf("the large list info mismatch: %v", info)
+	}
+}
@@ -0,0 +1,196 @@
+package rockredis
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/absolute8511/gorocksdb"
+)
+
+// while the value format is the reference format, all the kv values are
+// stored with a header byte to tell whether the value is stored inline or
+// stored as a reference to the shared value.
+const (
+	dedupInlineValue byte = 0
+	dedupRefValue    byte = 1
+	// only the large value will be deduplicated
+	dedupMinValueSize = 1024
+)
+
+var (
+	errDedupValue = errors.New("invalid deduplicated value")
+)
+
+type DedupStats struct {
+	UniqueValues int64 `json:"unique_values"`
+	TotalValues  int64 `json:"total_values"`
+}
+
+func encodeDedupValueKey(h []byte) []byte {
+	buf := make([]byte, len(h)+1)
+	buf[0] = DedupValueType
+	copy(buf[1:], h)
+	return buf
+}
+
+func encodeDedupRefKey(h []byte) []byte {
+	buf := make([]byte, len(h)+1)
+	buf[0] = DedupRefType
+	copy(buf[1:], h)
+	return buf
+}
+
+func encodeDedupStatsKey() []byte {
+	return []byte{DedupRefType}
+}
+
+func (db *RockDB) GetDedupStats() (DedupStats, error) {
+	var stats DedupStats
+	v, err := db.eng.GetBytes(db.defaultReadOpts, encodeDedupStatsKey())
+	if err != nil || v == nil {
+		return stats, err
+	}
+	if len(v) != 16 {
+		return stats, errDedupValue
+	}
+	stats.UniqueValues = int64(binary.BigEndian.Uint64(v[:8]))
+	stats.TotalValues = int64(binary.BigEndian.Uint64(v[8:]))
+	return stats, nil
+}
+
+func (db *RockDB) decodeKVValue(stored []byte) ([]byte, error) {
+	if !db.valueRef || stored == nil {
+		return stored, nil
+	}
+	if len(stored) == 0 {
+		return nil, errDedupValue
+	}
+	switch stored[0] {
+	case dedupInlineValue:
+		return stored[1:], nil
+	case dedupRefValue:
+		v, err := db.getRefValue(encodeDedupValueKey(stored[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			dbLog.Infof("the deduplicated value is missing: %v", stored[1:])
+			return nil, errDedupValue
+		}
+		return v, nil
+	default:
+		return nil, errDedupValue
+	}
+}
+
+// get the kv value by the encoded kv key
+func (db *RockDB) kvGet(ek []byte) ([]byte, error) {
+	v, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+	if err != nil {
+		return nil, err
+	}
+	return db.decodeKVValue(v)
+}
+
+// dedupWriter collect all the reference changes of the shared values
+// in one write batch, nil writer means the values are stored without the
+// reference header.
+type dedupWriter struct {
+	db       *RockDB
+	refDelta map[string]int64
+	payloads map[string][]byte
+}
+
+func (db *RockDB) newDedupWriter() *dedupWriter {
+	if !db.valueRef {
+		return nil
+	}
+	return &dedupWriter{
+		db:       db,
+		refDelta: make(map[string]int64),
+		payloads: make(map[string][]byte),
+	}
+}
+
+// return the value which should be stored for the kv key
+func (w *dedupWriter) encodeValue(value []byte) []byte {
+	if w == nil {
+		return value
+	}
+	// all the values are stored inline if the config changed to disable
+	// both the deduplication and separation
+	if !w.db.cfg.isValueRefEnabled() || len(value) < w.db.cfg.refMinValueSize() {
+		buf := make([]byte, len(value)+1)
+		buf[0] = dedupInlineValue
+		copy(buf[1:], value)
+		return buf
+	}
+	h := sha256.Sum256(value)
+	hs := string(h[:])
+	w.refDelta[hs]++
+	if _, ok := w.payloads[hs]; !ok {
+		w.payloads[hs] = value
+	}
+	buf := make([]byte, len(h)+1)
+	buf[0] = dedupRefValue
+	copy(buf[1:], h[:])
+	return buf
+}
+
+// release the reference of the old stored value which is overwritten or deleted
+func (w *dedupWriter) releaseValue(stored []byte) {
+	if w == nil || len(stored) == 0 || stored[0] != dedupRefValue {
+		return
+	}
+	w.refDelta[string(stored[1:])]--
+}
+
+func (w *dedupWriter) flush(wb *gorocksdb.WriteBatch) error {
+	if w == nil || len(w.refDelta) == 0 {
+		return nil
+	}
+	stats, err := w.db.GetDedupStats()
+	if err != nil {
+		return err
+	}
+	for h, delta := range w.refDelta {
+		if delta == 0 {
+			continue
+		}
+		refKey := encodeDedupRefKey([]byte(h))
+		cnt, err := Int64(w.db.eng.GetBytes(w.db.defaultReadOpts, refKey))
+		if err != nil {
+			return err
+		}
+		newCnt := cnt + delta
+		if newCnt <= 0 {
+			if newCnt < 0 {
+				dbLog.Infof("the reference of deduplicated value is invalid: %v, %v", cnt, delta)
+			}
+			wb.Delete(refKey)
+			w.db.deleteRefValue(wb, encodeDedupValueKey([]byte(h)))
+			if cnt > 0 {
+				stats.UniqueValues--
+				stats.TotalValues -= cnt
+			}
+			continue
+		}
+		if cnt <= 0 {
+			payload, ok := w.payloads[h]
+			if !ok {
+				return errDedupValue
+			}
+			w.db.putRefValue(wb, encodeDedupValueKey([]byte(h)), payload)
+			stats.UniqueValues++
+			cnt = 0
+		}
+		wb.Put(refKey, PutInt64(newCnt))
+		stats.TotalValues += newCnt - cnt
+	}
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[:8], uint64(stats.UniqueValues))
+	binary.BigEndian.PutUint64(buf[8:], uint64(stats.TotalValues))
+	wb.Put(encodeDedupStatsKey(), buf)
+	return nil
+}
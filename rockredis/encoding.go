@@ -0,0 +1,58 @@
+package rockredis
+
+import (
+	"strconv"
+)
+
+// The encoding names compatible with the redis OBJECT ENCODING command.
+const (
+	EncodingInt       = "int"
+	EncodingEmbStr    = "embstr"
+	EncodingRaw       = "raw"
+	EncodingHashtable = "hashtable"
+	EncodingQuicklist = "quicklist"
+	EncodingSkiplist  = "skiplist"
+)
+
+const embStrMaxLen = 44
+
+// ObjectEncoding return the encoding of the object stored at the key,
+// empty string if the key not exist. The elements of the collection are
+// stored one key for each, so the collection always has the full encoding.
+func (db *RockDB) ObjectEncoding(key []byte) (string, error) {
+	if err := checkKeySize(key); err != nil {
+		return "", err
+	}
+	v, err := db.KVGet(key)
+	if err != nil {
+		return "", err
+	}
+	if v != nil {
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil && len(v) <= 20 {
+			return EncodingInt, nil
+		}
+		if len(v) <= embStrMaxLen {
+			return EncodingEmbStr, nil
+		}
+		return EncodingRaw, nil
+	}
+	types := []struct {
+		sizeFunc func([]byte) (int64, error)
+		encoding string
+	}{
+		{db.HLen, EncodingHashtable},
+		{db.LLen, EncodingQuicklist},
+		{db.SCard, EncodingHashtable},
+		{db.ZCard, EncodingSkiplist},
+	}
+	for _, t := range types {
+		n, err := t.sizeFunc(key)
+		if err != nil {
+			return "", err
+		}
+		if n > 0 {
+			return t.encoding, nil
+		}
+	}
+	return "", nil
+}
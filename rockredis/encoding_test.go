@@ -0,0 +1,62 @@
+package rockredis
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func checkObjEncoding(t *testing.T, db *RockDB, key []byte, expected string) {
+	enc, err := db.ObjectEncoding(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc != expected {
+		t.Fatalf("encoding of %v should be %v, actual: %v", string(key), expected, enc)
+	}
+}
+
+func TestObjEncoding(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	hkey := []byte("test:hash_encoding")
+	checkObjEncoding(t, db, hkey, "")
+	if _, err := db.HSet(hkey, []byte("f0"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	checkObjEncoding(t, db, hkey, EncodingHashtable)
+	if _, err := db.HClear(hkey); err != nil {
+		t.Fatal(err)
+	}
+	checkObjEncoding(t, db, hkey, "")
+
+	lkey := []byte("test:list_encoding")
+	if _, err := db.RPush(lkey, []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	checkObjEncoding(t, db, lkey, EncodingQuicklist)
+
+	skey := []byte("test:set_encoding")
+	if _, err := db.SAdd(skey, []byte("1"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	checkObjEncoding(t, db, skey, EncodingHashtable)
+
+	zkey := []byte("test:zset_encoding")
+	if _, err := db.ZAdd(zkey, common.ScorePair{Score: 1, Member: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	checkObjEncoding(t, db, zkey, EncodingSkiplist)
+
+	kkey := []byte("test:kv_encoding")
+	db.KVSet(kkey, []byte("12345"))
+	checkObjEncoding(t, db, kkey, EncodingInt)
+	db.KVSet(kkey, []byte("short value"))
+	checkObjEncoding(t, db, kkey, EncodingEmbStr)
+	db.KVSet(kkey, []byte(strings.Repeat("v", 100)))
+	checkObjEncoding(t, db, kkey, EncodingRaw)
+}
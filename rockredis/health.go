@@ -0,0 +1,73 @@
+package rockredis
+
+import (
+	"sync/atomic"
+
+	"github.com/absolute8511/gorocksdb"
+)
+
+// the store is marked as unhealthy after too many continuous write failures
+// (such as disk full or IO errors), and it will be healthy again after
+// any write succeeded.
+const unhealthyWriteFailures = 3
+
+// the key written to probe whether the unhealthy store can be written again
+var healthProbeKey = []byte{HealthProbeType}
+
+type injectedError struct {
+	err error
+}
+
+type storeHealth struct {
+	writeFailures int32
+	// only used to simulate the write failure in test
+	injectedErr atomic.Value
+}
+
+// InjectWriteError make all the writes to the store fail with the error, nil
+// to stop. This is only used to simulate the write failure in test.
+func (db *RockDB) InjectWriteError(err error) {
+	db.health.injectedErr.Store(injectedError{err})
+}
+
+func (db *RockDB) writeBatch(wb *gorocksdb.WriteBatch) error {
+	return db.doWrite(func() error {
+		return db.eng.Write(db.defaultWriteOpts, wb)
+	})
+}
+
+// run the write to the store and update the store health by the result
+func (db *RockDB) doWrite(write func() error) error {
+	var err error
+	if ie, ok := db.health.injectedErr.Load().(injectedError); ok && ie.err != nil {
+		err = ie.err
+	} else {
+		err = write()
+	}
+	if err != nil {
+		if atomic.AddInt32(&db.health.writeFailures, 1) == unhealthyWriteFailures {
+			dbLog.Infof("store is unhealthy after %v write failures: %v", unhealthyWriteFailures, err)
+		}
+		return err
+	}
+	if atomic.SwapInt32(&db.health.writeFailures, 0) >= unhealthyWriteFailures {
+		dbLog.Infof("store is healthy again")
+	}
+	return nil
+}
+
+// IsHealthy return false if the recent writes to the store failed continuously
+func (db *RockDB) IsHealthy() bool {
+	return atomic.LoadInt32(&db.health.writeFailures) < unhealthyWriteFailures
+}
+
+// ProbeHealth write to the store to check whether the store can be written
+// again. The writes are refused while the store is unhealthy, so the store
+// should be probed to become healthy after the failure is fixed. The probe
+// only deletes the probe key, so no data is left to differ between replicas.
+func (db *RockDB) ProbeHealth() error {
+	wb := gorocksdb.NewWriteBatch()
+	defer wb.Destroy()
+	wb.Delete(healthProbeKey)
+	return db.writeBatch(wb)
+}
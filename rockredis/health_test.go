@@ -0,0 +1,60 @@
+package rockredis
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStoreHealthWithWriteFailures(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:store_health")
+	if err := db.KVSet(key, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if !db.IsHealthy() {
+		t.Fatal("store should be healthy")
+	}
+	db.InjectWriteError(errors.New("IO error: No space left on device"))
+	for i := 0; i < unhealthyWriteFailures; i++ {
+		if !db.IsHealthy() {
+			t.Fatalf("store should be healthy before %v write failures", unhealthyWriteFailures)
+		}
+		if err := db.KVSet(key, []byte("v2")); err == nil {
+			t.Fatal("the write should fail")
+		}
+	}
+	if db.IsHealthy() {
+		t.Fatal("store should be unhealthy after continuous write failures")
+	}
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v1" {
+		t.Fatalf("the failed write should not change the value: %v", string(v))
+	}
+
+	if err := db.ProbeHealth(); err == nil {
+		t.Fatal("the probe should fail while the write failure not fixed")
+	}
+	if db.IsHealthy() {
+		t.Fatal("store should be unhealthy while the probe failed")
+	}
+	db.InjectWriteError(nil)
+	if err := db.ProbeHealth(); err != nil {
+		t.Fatal(err)
+	}
+	if !db.IsHealthy() {
+		t.Fatal("store should be healthy again after the probe succeeded")
+	}
+	if err := db.KVSet(key, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v3" {
+		t.Fatalf("the value mismatch after recovered: %v", string(v))
+	}
+}
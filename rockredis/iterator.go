@@ -141,6 +141,48 @@ func NewSnapshotDBRangeIterator(db *gorocksdb.DB, min []byte, max []byte, rtype
 	}
 }
 
+// the snapshot is owned by the caller and will not be released while the iterator closed
+func NewDBRangeIteratorWithSnapshot(db *gorocksdb.DB, snap *gorocksdb.Snapshot, min []byte, max []byte,
+	rtype uint8, reverse bool) *RangeLimitedIterator {
+	readOpts := gorocksdb.NewDefaultReadOptions()
+	readOpts.SetFillCache(false)
+	readOpts.SetVerifyChecksums(false)
+	readOpts.SetSnapshot(snap)
+	it := db.NewIterator(readOpts)
+	dbit := &DBIterator{
+		Iterator: it,
+		snap:     nil,
+		ro:       readOpts,
+	}
+	if !reverse {
+		return NewRangeIterator(dbit, &Range{Min: min, Max: max, Type: rtype})
+	} else {
+		return NewRevRangeIterator(dbit, &Range{Min: min, Max: max, Type: rtype})
+	}
+}
+
+// the snapshot is owned by the caller and will not be released while the iterator closed
+func NewDBRangeLimitIteratorWithSnapshot(db *gorocksdb.DB, snap *gorocksdb.Snapshot, min []byte, max []byte,
+	rtype uint8, offset int, count int, reverse bool) *RangeLimitedIterator {
+	readOpts := gorocksdb.NewDefaultReadOptions()
+	readOpts.SetFillCache(false)
+	readOpts.SetVerifyChecksums(false)
+	readOpts.SetSnapshot(snap)
+	it := db.NewIterator(readOpts)
+	dbit := &DBIterator{
+		Iterator: it,
+		snap:     nil,
+		ro:       readOpts,
+	}
+	if !reverse {
+		return NewRangeLimitIterator(dbit, &Range{Min: min, Max: max, Type: rtype},
+			&Limit{Offset: offset, Count: count})
+	} else {
+		return NewRevRangeLimitIterator(dbit, &Range{Min: min, Max: max, Type: rtype},
+			&Limit{Offset: offset, Count: count})
+	}
+}
+
 type RangeLimitedIterator struct {
 	Iterator
 	l Limit
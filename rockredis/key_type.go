@@ -0,0 +1,134 @@
+package rockredis
+
+import (
+	"errors"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// the meta key of each data type, the key exists as the type only if the
+// meta key exists.
+var keyTypeMetas = []struct {
+	dataType   byte
+	encodeMeta func([]byte) []byte
+}{
+	{KVType, encodeKVKey},
+	{HashType, hEncodeSizeKey},
+	{ListType, lEncodeMetaKey},
+	{SetType, sEncodeSizeKey},
+	{ZSetType, zEncodeSizeKey},
+}
+
+// return the types other than the dataType the key exists as, all the meta
+// keys are read in one multi get.
+func (db *RockDB) getOtherKeyTypes(key []byte, dataType byte) ([]byte, error) {
+	types := make([]byte, 0, len(keyTypeMetas))
+	eks := make([][]byte, 0, len(keyTypeMetas))
+	for _, t := range keyTypeMetas {
+		if t.dataType == dataType {
+			continue
+		}
+		ek := t.encodeMeta(key)
+		if t.dataType == KVType && db.isKVKeyDropped(ek) {
+			continue
+		}
+		types = append(types, t.dataType)
+		eks = append(eks, ek)
+	}
+	vals := make([][]byte, len(eks))
+	errs := make([]error, len(eks))
+	db.eng.MultiGetBytes(db.defaultReadOpts, eks, vals, errs)
+	existing := types[:0]
+	for i, t := range types {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if vals[i] != nil {
+			existing = append(existing, t)
+		}
+	}
+	return existing, nil
+}
+
+// CheckKeyType return ErrWrongType if the key is holding the data of the type
+// other than the dataType. The key not exist can be used as any type, and the
+// invalid key is left to the command. The key existing as the dataType can not
+// hold the other types, so the other meta keys are only read if the key not
+// exist as the dataType.
+func (db *RockDB) CheckKeyType(key []byte, dataType byte) error {
+	if err := checkKeySize(key); err != nil {
+		return nil
+	}
+	for _, t := range keyTypeMetas {
+		if t.dataType != dataType {
+			continue
+		}
+		ek := t.encodeMeta(key)
+		if t.dataType == KVType && db.isKVKeyDropped(ek) {
+			break
+		}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+		if err != nil || v != nil {
+			return err
+		}
+	}
+	types, err := db.getOtherKeyTypes(key, dataType)
+	if err != nil {
+		return err
+	}
+	if len(types) > 0 {
+		return ErrWrongType
+	}
+	return nil
+}
+
+// delete the data of the key holding the types other than the kv into the
+// batch, return true if the key is cleared. The key is overwritten as the kv
+// in the same batch, so it is still counted in the table, and the caller
+// should put the table key count after the deletes.
+func (db *RockDB) clearOtherKVTypes(key []byte, wb *gorocksdb.WriteBatch, d *fieldExpireDelta) (bool, error) {
+	if err := checkKeySize(key); err != nil {
+		return false, nil
+	}
+	types, err := db.getOtherKeyTypes(key, KVType)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range types {
+		switch t {
+		case HashType:
+			db.hDeleteAll(key, wb, d)
+		case ListType:
+			err = db.lDeleteAll(key, wb)
+		case SetType:
+			db.sDelete(key, wb)
+		case ZSetType:
+			_, err = db.zDelete(key, wb)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(types) > 0, nil
+}
+
+// delete all the list items in the batch, the large list is not deleted by
+// the files in range, since the delete should be in the same batch.
+func (db *RockDB) lDeleteAll(key []byte, wb *gorocksdb.WriteBatch) error {
+	mk := lEncodeMetaKey(key)
+	headSeq, tailSeq, _, err := db.lGetMeta(mk)
+	if err != nil {
+		return err
+	}
+	it := db.newRangeIterator(lEncodeListKey(key, headSeq), lEncodeListKey(key, tailSeq),
+		common.RangeClose, false)
+	for ; it.Valid(); it.Next() {
+		wb.Delete(it.RefKey())
+	}
+	it.Close()
+	wb.Delete(mk)
+	return nil
+}
@@ -0,0 +1,128 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestCheckKeyType(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	allTypes := []byte{KVType, HashType, ListType, SetType, ZSetType}
+	key := []byte("test:key_type")
+	for _, tp := range allTypes {
+		if err := db.CheckKeyType(key, tp); err != nil {
+			t.Fatalf("the key not exist should be any type: %v", err)
+		}
+	}
+
+	writes := []struct {
+		dataType byte
+		set      func() error
+		clear    func() error
+	}{
+		{KVType, func() error { return db.KVSet(key, []byte("v")) },
+			func() error { return db.KVDel(key) }},
+		{HashType, func() error { _, err := db.HSet(key, []byte("f"), []byte("v")); return err },
+			func() error { _, err := db.HClear(key); return err }},
+		{ListType, func() error { _, err := db.LPush(key, []byte("v")); return err },
+			func() error { _, err := db.LClear(key); return err }},
+		{SetType, func() error { _, err := db.SAdd(key, []byte("v")); return err },
+			func() error { _, err := db.SClear(key); return err }},
+		{ZSetType, func() error { _, err := db.ZAdd(key, common.ScorePair{Score: 1, Member: []byte("v")}); return err },
+			func() error { _, err := db.ZClear(key); return err }},
+	}
+	for _, w := range writes {
+		if err := w.set(); err != nil {
+			t.Fatal(err)
+		}
+		for _, tp := range allTypes {
+			err := db.CheckKeyType(key, tp)
+			if tp == w.dataType && err != nil {
+				t.Fatalf("type %v should be allowed: %v", tp, err)
+			} else if tp != w.dataType && err != ErrWrongType {
+				t.Fatalf("type %v should be wrong type for %v: %v", tp, w.dataType, err)
+			}
+		}
+		if err := w.clear(); err != nil {
+			t.Fatal(err)
+		}
+		for _, tp := range allTypes {
+			if err := db.CheckKeyType(key, tp); err != nil {
+				t.Fatalf("the key cleared should be any type: %v", err)
+			}
+		}
+	}
+}
+
+func TestKVSetOverwrite(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:clear_key_type")
+	if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HExpireAt(key, nowMs()+100000, []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.KVSetOverwrite(key, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.HLen(key); err != nil || n != 0 {
+		t.Fatalf("the hash should be deleted: %v, %v", n, err)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 0 {
+		t.Fatalf("the field expire of the hash should be deleted: %v", num)
+	}
+	if v, err := db.KVGet(key); err != nil || string(v) != "v" {
+		t.Fatalf("the kv should be set: %v, %v", v, err)
+	}
+	if err := db.CheckKeyType(key, KVType); err != nil {
+		t.Fatalf("the key should be the kv only: %v", err)
+	}
+	if err := db.CheckKeyType(key, HashType); err != ErrWrongType {
+		t.Fatalf("the key should be wrong type for the hash: %v", err)
+	}
+	// the overwritten key is counted once in the table
+	if n, err := db.GetTableKeyCount([]byte("test")); err != nil || n != 1 {
+		t.Fatalf("the table key count mismatch: %v, %v", n, err)
+	}
+
+	listKey := []byte("test:clear_key_type_list")
+	setKey := []byte("test:clear_key_type_set")
+	zsetKey := []byte("test:clear_key_type_zset")
+	if _, err := db.RPush(listKey, []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd(setKey, []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(zsetKey, common.ScorePair{Score: 1, Member: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MSetOverwrite(common.KVRecord{Key: listKey, Value: []byte("v")},
+		common.KVRecord{Key: setKey, Value: []byte("v")},
+		common.KVRecord{Key: zsetKey, Value: []byte("v")},
+		common.KVRecord{Key: []byte("test:clear_key_type_new"), Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range [][]byte{listKey, setKey, zsetKey} {
+		for _, tp := range []byte{ListType, SetType, ZSetType} {
+			if err := db.CheckKeyType(k, tp); err != ErrWrongType {
+				t.Fatalf("the key %s should be the kv only: %v", k, err)
+			}
+		}
+	}
+	if n, err := db.LLen(listKey); err != nil || n != 0 {
+		t.Fatalf("the list should be deleted: %v, %v", n, err)
+	}
+	if n, err := db.GetTableKeyCount([]byte("test")); err != nil || n != 5 {
+		t.Fatalf("the table key count mismatch: %v, %v", n, err)
+	}
+}
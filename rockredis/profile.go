@@ -0,0 +1,289 @@
+package rockredis
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+const (
+	DefaultProfileSampleNum = 1000
+	MaxProfileSampleNum     = 100000
+)
+
+// the upper bounds of the size buckets, the last bucket is for the size
+// larger than all the bounds. The size is the value length of the kv and
+// the element number of the collections.
+var profileSizeBounds = []int64{16, 128, 1024, 8192}
+
+var profileTypes = []struct {
+	storeType byte
+	name      string
+}{
+	{KVType, "string"},
+	{HSizeType, "hash"},
+	{LMetaType, "list"},
+	{SSizeType, "set"},
+	{ZSizeType, "zset"},
+}
+
+// TypeProfile is the histogram of the sampled keys of one data type, the
+// EstimatedNum is the estimated key number of this type in the db.
+type TypeProfile struct {
+	SampleNum    int64   `json:"sample_num"`
+	EstimatedNum int64   `json:"estimated_num"`
+	SizeBuckets  []int64 `json:"size_buckets"`
+	WithTTL      int64   `json:"with_ttl"`
+}
+
+// KeyProfile is the data shape of the db estimated from the sampled keys,
+// all the keys are read from the same db snapshot.
+type KeyProfile struct {
+	KeyNum     int64                   `json:"key_num"`
+	SampleNum  int64                   `json:"sample_num"`
+	SizeBounds []int64                 `json:"size_bounds"`
+	Types      map[string]*TypeProfile `json:"types"`
+}
+
+func getProfileSizeBucket(size int64) int {
+	for i, b := range profileSizeBounds {
+		if size <= b {
+			return i
+		}
+	}
+	return len(profileSizeBounds)
+}
+
+func (db *RockDB) getSnapshotKeyNum(snap *gorocksdb.Snapshot) int64 {
+	var total int64
+	it := NewDBRangeIteratorWithSnapshot(db.eng, snap, encodeTableMetaStartKey(),
+		encodeTableMetaStopKey(), common.RangeOpen, false)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		n, err := Int64(it.Value(), nil)
+		if err != nil {
+			continue
+		}
+		total += n
+	}
+	return total
+}
+
+// count the keys in the range up to the limit, return the count, the average
+// bytes of the counted keys and whether all the keys in the range are counted.
+func (db *RockDB) countRangeKeys(snap *gorocksdb.Snapshot, minKey []byte, maxKey []byte, limit int) (int64, int64, bool) {
+	it := NewDBRangeIteratorWithSnapshot(db.eng, snap, minKey, maxKey, common.RangeOpen, false)
+	defer it.Close()
+	var n, total int64
+	for ; it.Valid(); it.Next() {
+		if n >= int64(limit) {
+			return n, total / n, false
+		}
+		n++
+		total += int64(len(it.RefKey()) + len(it.RefValue()))
+	}
+	if n > 0 {
+		total /= n
+	}
+	return n, total, true
+}
+
+// return a random key between the first and the last key by interpolating
+// the bytes after the common prefix.
+func randomSeekKey(r *rand.Rand, first []byte, last []byte) []byte {
+	c := 0
+	for c < len(first) && c < len(last) && first[c] == last[c] {
+		c++
+	}
+	var lo, hi [8]byte
+	copy(lo[:], first[c:])
+	copy(hi[:], last[c:])
+	a := binary.BigEndian.Uint64(lo[:])
+	b := binary.BigEndian.Uint64(hi[:])
+	x := a
+	if b > a {
+		x = a + uint64(r.Float64()*float64(b-a))
+	}
+	key := make([]byte, c+8)
+	copy(key, first[:c])
+	binary.BigEndian.PutUint64(key[c:], x)
+	return key
+}
+
+// call fn with about num keys sampled from the range having about total keys.
+// The small range is iterated with the random skip, and the large range is
+// sampled by num random seeks between the first and the last key, so the keys
+// read are bounded by the small range size or the sample number.
+func (db *RockDB) sampleRangeKeys(snap *gorocksdb.Snapshot, minKey []byte, maxKey []byte,
+	total int64, num int, small bool, r *rand.Rand, fn func(ek []byte, value []byte)) {
+	if num <= 0 {
+		return
+	}
+	it := NewDBRangeIteratorWithSnapshot(db.eng, snap, minKey, maxKey, common.RangeOpen, false)
+	defer it.Close()
+	if small {
+		rate := float64(num) / float64(total)
+		for ; it.Valid(); it.Next() {
+			if rate < 1 && r.Float64() >= rate {
+				continue
+			}
+			fn(it.Key(), it.Value())
+		}
+		return
+	}
+	if !it.Valid() {
+		return
+	}
+	first := it.Key()
+	rit := NewDBRangeIteratorWithSnapshot(db.eng, snap, minKey, maxKey, common.RangeOpen, true)
+	if !rit.Valid() {
+		rit.Close()
+		return
+	}
+	last := rit.Key()
+	rit.Close()
+	for i := 0; i < num; i++ {
+		it.Seek(randomSeekKey(r, first, last))
+		if !it.Valid() {
+			continue
+		}
+		fn(it.Key(), it.Value())
+	}
+}
+
+// ProfileKeys sample about sampleNum keys from the db snapshot and return the
+// histogram of the key types, sizes and the ttl. The keys of each type are
+// counted up to sampleNum, the type with no more keys is counted exactly and
+// sampled with the random skip. The key number of the larger types is split
+// from the remaining keys of the tables by the approximate sizes, and their
+// keys are sampled by the random seeks, so at most about sampleNum keys of
+// each type are read and the collection elements are never read.
+func (db *RockDB) ProfileKeys(sampleNum int) (*KeyProfile, error) {
+	if sampleNum <= 0 {
+		sampleNum = DefaultProfileSampleNum
+	}
+	if sampleNum > MaxProfileSampleNum {
+		sampleNum = MaxProfileSampleNum
+	}
+	snap := gorocksdb.NewSnapshot(db.eng)
+	defer snap.Release()
+
+	p := &KeyProfile{
+		SizeBounds: profileSizeBounds,
+		Types:      make(map[string]*TypeProfile, len(profileTypes)),
+	}
+	p.KeyNum = db.getSnapshotKeyNum(snap)
+
+	counts := make([]int64, len(profileTypes))
+	avgBytes := make([]int64, len(profileTypes))
+	small := make([]bool, len(profileTypes))
+	largeTypes := make([]int, 0, len(profileTypes))
+	largeRanges := make([]gorocksdb.Range, 0, len(profileTypes))
+	var total int64
+	for i, tp := range profileTypes {
+		minKey, maxKey, err := buildScanKeyRange(tp.storeType, nil)
+		if err != nil {
+			return nil, err
+		}
+		counts[i], avgBytes[i], small[i] = db.countRangeKeys(snap, minKey, maxKey, sampleNum)
+		if small[i] {
+			total += counts[i]
+		} else {
+			largeTypes = append(largeTypes, i)
+			largeRanges = append(largeRanges, gorocksdb.Range{Start: minKey, Limit: maxKey})
+		}
+	}
+	if len(largeTypes) > 0 {
+		sizes := db.eng.GetApproximateSizes(largeRanges)
+		weights := make([]float64, len(largeTypes))
+		var sumWeight float64
+		for j, i := range largeTypes {
+			if avgBytes[i] > 0 {
+				weights[j] = float64(sizes[j]) / float64(avgBytes[i])
+			}
+			sumWeight += weights[j]
+		}
+		left := p.KeyNum - total
+		for j, i := range largeTypes {
+			n := left / int64(len(largeTypes))
+			if sumWeight > 0 {
+				n = int64(float64(left) * weights[j] / sumWeight)
+			}
+			// the type has more keys than counted
+			if n <= int64(sampleNum) {
+				n = int64(sampleNum) + 1
+			}
+			counts[i] = n
+			total += n
+		}
+	}
+
+	rate := float64(1)
+	if total > int64(sampleNum) {
+		rate = float64(sampleNum) / float64(total)
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i, tp := range profileTypes {
+		tps := &TypeProfile{
+			SizeBuckets:  make([]int64, len(profileSizeBounds)+1),
+			EstimatedNum: counts[i],
+		}
+		p.Types[tp.name] = tps
+		minKey, maxKey, err := buildScanKeyRange(tp.storeType, nil)
+		if err != nil {
+			return nil, err
+		}
+		storeType := tp.storeType
+		num := int(float64(counts[i])*rate + 0.5)
+		db.sampleRangeKeys(snap, minKey, maxKey, counts[i], num, small[i], r, func(ek []byte, value []byte) {
+			if storeType == KVType && db.isKVKeyDropped(ek) {
+				return
+			}
+			key, err := decodeScanKey(storeType, ek)
+			if err != nil {
+				return
+			}
+			size, hasTTL := db.profileKeySize(snap, storeType, key, value)
+			tps.SampleNum++
+			tps.SizeBuckets[getProfileSizeBucket(size)]++
+			if hasTTL {
+				tps.WithTTL++
+			}
+		})
+		p.SampleNum += tps.SampleNum
+	}
+	return p, nil
+}
+
+// return the size of the sampled key and whether the key has the data
+// with the expire time, only the hash fields can expire currently.
+func (db *RockDB) profileKeySize(snap *gorocksdb.Snapshot, storeType byte, key []byte, meta []byte) (int64, bool) {
+	switch storeType {
+	case KVType:
+		v, err := db.decodeKVValue(meta)
+		if err != nil {
+			return int64(len(meta)), false
+		}
+		return int64(len(v)), false
+	case LMetaType:
+		if len(meta) < 16 {
+			return 0, false
+		}
+		headSeq := int64(binary.BigEndian.Uint64(meta[0:8]))
+		tailSeq := int64(binary.BigEndian.Uint64(meta[8:16]))
+		return tailSeq - headSeq + 1, false
+	case HSizeType:
+		size, _ := Int64(meta, nil)
+		it := NewDBRangeIteratorWithSnapshot(db.eng, snap, hEncodeFieldExpStartKey(key),
+			hEncodeFieldExpStopKey(key), common.RangeROpen, false)
+		hasTTL := it.Valid()
+		it.Close()
+		return size, hasTTL
+	default:
+		size, _ := Int64(meta, nil)
+		return size, false
+	}
+}
@@ -0,0 +1,93 @@
+package rockredis
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestProfileKeys(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	// 50% string, 30% hash with a third of them having ttl and 20% list
+	total := 2000
+	listValues := make([][]byte, 200)
+	for i := range listValues {
+		listValues[i] = []byte("v")
+	}
+	for i := 0; i < total; i++ {
+		key := []byte("test:profile_" + strconv.Itoa(i))
+		switch i % 10 {
+		case 0, 1, 2, 3, 4:
+			if err := db.KVSet(key, []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		case 5, 6, 7:
+			if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+			if i%10 == 6 {
+				if _, err := db.HExpireAt(key, nowMs()+100000, []byte("f")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		default:
+			if _, err := db.RPush(key, listValues...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// all the keys are profiled if less than the sample number
+	p, err := db.ProfileKeys(total * 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.KeyNum != int64(total) || p.SampleNum != int64(total) {
+		t.Fatalf("all the keys should be sampled: %v, %v", p.KeyNum, p.SampleNum)
+	}
+	if p.Types["string"].SampleNum != 1000 || p.Types["hash"].SampleNum != 600 ||
+		p.Types["list"].SampleNum != 400 || p.Types["set"].SampleNum != 0 {
+		t.Fatalf("the type histogram mismatch: %v", p.Types)
+	}
+	if p.Types["hash"].WithTTL != 200 || p.Types["string"].WithTTL != 0 {
+		t.Fatalf("the ttl histogram mismatch: %v, %v", p.Types["hash"], p.Types["string"])
+	}
+	if p.Types["string"].SizeBuckets[0] != 1000 || p.Types["list"].SizeBuckets[2] != 400 {
+		t.Fatalf("the size histogram mismatch: %v, %v", p.Types["string"], p.Types["list"])
+	}
+
+	// the list is small enough to be counted exactly, the string and the
+	// hash are estimated from the approximate sizes and sampled by seeks
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err = db.ProfileKeys(400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Types["list"].EstimatedNum != 400 {
+		t.Fatalf("the small type should be counted exactly: %v", p.Types["list"])
+	}
+	if p.SampleNum < 200 || p.SampleNum > 600 {
+		t.Fatalf("the sample number should be near 400: %v", p.SampleNum)
+	}
+	expected := map[string]float64{"string": 0.5, "hash": 0.3, "list": 0.2}
+	for name, ratio := range expected {
+		tp := p.Types[name]
+		sampled := float64(tp.SampleNum) / float64(p.SampleNum)
+		if math.Abs(sampled-ratio) > 0.1 {
+			t.Fatalf("the sampled ratio of %v %v should be near %v", name, sampled, ratio)
+		}
+		if math.Abs(float64(tp.EstimatedNum)/float64(total)-ratio) > 0.15 {
+			t.Fatalf("the estimated number of %v %v mismatch", name, tp.EstimatedNum)
+		}
+	}
+	hashTTL := float64(p.Types["hash"].WithTTL) / float64(p.Types["hash"].SampleNum)
+	if math.Abs(hashTTL-1.0/3) > 0.2 {
+		t.Fatalf("the sampled ttl ratio of hash %v should be near 1/3", hashTTL)
+	}
+}
@@ -0,0 +1,114 @@
+package rockredis
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/absolute8511/gorocksdb"
+)
+
+// NewReadView return the view of the db reading from a pinned snapshot, so
+// all the reads of the view see the same point-in-time data. The view can
+// not be written, and should be released after used. The snapshot is tracked
+// and limited with the scan snapshots, and released after the ttl or while
+// the db is closed.
+func (db *RockDB) NewReadView(ttl time.Duration) (*RockDB, error) {
+	if ttl <= 0 {
+		ttl = DefaultScanSnapshotTTL
+	}
+	if ttl > MaxScanSnapshotTTL {
+		ttl = MaxScanSnapshotTTL
+	}
+	ss := db.scanSnaps
+	ss.Lock()
+	defer ss.Unlock()
+	if len(ss.snaps) >= maxScanSnapshotNum {
+		return nil, errTooMuchScanSnapshot
+	}
+	ss.nextID++
+	id := "view-" + strconv.FormatInt(ss.nextID, 10)
+	gen := db.pins.pin()
+	snap := gorocksdb.NewSnapshot(db.eng)
+	readOpts := gorocksdb.NewDefaultReadOptions()
+	readOpts.SetVerifyChecksums(false)
+	readOpts.SetSnapshot(snap)
+	s := &scanSnapshot{
+		snap:     snap,
+		pins:     db.pins,
+		gen:      gen,
+		readOpts: readOpts,
+	}
+	s.timer = time.AfterFunc(ttl, func() {
+		dbLog.Infof("read view %v expired", id)
+		ss.remove(id)
+	})
+	ss.snaps[id] = s
+	view := &RockDB{
+		cfg:             db.cfg,
+		eng:             db.eng,
+		dbOpts:          db.dbOpts,
+		blobOpts:        db.blobOpts,
+		blobCF:          db.blobCF,
+		cfs:             db.cfs,
+		defaultReadOpts: readOpts,
+		quit:            db.quit,
+		scanSnaps:       db.scanSnaps,
+		pins:            db.pins,
+		readSnap:        snap,
+		readView:        s,
+		readViewID:      id,
+		valueRef:        db.valueRef,
+	}
+	// the prefixes dropping while the snapshot pinned
+	db.dropped.RLock()
+	view.dropped.prefixes = append([][]byte(nil), db.dropped.prefixes...)
+	view.dropped.gens = append([]uint64(nil), db.dropped.gens...)
+	view.dropped.accounted = append([]bool(nil), db.dropped.accounted...)
+	view.dropped.updateNum()
+	db.dropped.RUnlock()
+	return view, nil
+}
+
+// LockReadView lock the read view before reading, so the snapshot will not be
+// released while reading. Return false if the view is already released.
+func (db *RockDB) LockReadView() bool {
+	if db.readView == nil {
+		return false
+	}
+	db.readView.Lock()
+	if db.readView.released {
+		db.readView.Unlock()
+		return false
+	}
+	return true
+}
+
+func (db *RockDB) UnlockReadView() {
+	db.readView.Unlock()
+}
+
+// ReleaseReadView release the snapshot pinned by the read view, the view
+// can not be used after released.
+func (db *RockDB) ReleaseReadView() {
+	if db.readView == nil {
+		return
+	}
+	db.scanSnaps.remove(db.readViewID)
+}
+
+func (db *RockDB) newRangeIterator(min []byte, max []byte, rtype uint8,
+	reverse bool) *RangeLimitedIterator {
+	if db.readSnap != nil {
+		return NewDBRangeIteratorWithSnapshot(db.eng, db.readSnap, min, max, rtype, reverse)
+	}
+	return NewDBRangeIterator(db.eng, min, max, rtype, reverse)
+}
+
+func (db *RockDB) newRangeLimitIterator(min []byte, max []byte, rtype uint8,
+	offset int, count int, reverse bool) *RangeLimitedIterator {
+	if db.readSnap != nil {
+		return NewDBRangeLimitIteratorWithSnapshot(db.eng, db.readSnap, min, max, rtype,
+			offset, count, reverse)
+	}
+	return NewDBRangeLimitIterator(db.eng, min, max, rtype, offset, count, reverse)
+}
@@ -0,0 +1,114 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestReadViewSnapshot(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:read_view_kv")
+	hkey := []byte("test:read_view_hash")
+	zkey := []byte("test:read_view_zset")
+	if err := db.KVSet(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet(hkey, []byte("f1"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(zkey, common.ScorePair{Score: 1, Member: []byte("m1")}); err != nil {
+		t.Fatal(err)
+	}
+	view, err := db.NewReadView(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.ReleaseReadView()
+
+	if err := db.KVSet(key, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet(hkey, []byte("f2"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(zkey, common.ScorePair{Score: 2, Member: []byte("m2")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := view.KVGet(key); err != nil || string(v) != "1" {
+		t.Fatalf("the view should read the snapshot: %s, %v", v, err)
+	}
+	if v, err := db.KVGet(key); err != nil || string(v) != "2" {
+		t.Fatalf("the db should read the latest: %s, %v", v, err)
+	}
+	n, ch, err := view.HGetAll(hkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := 0
+	for range ch {
+		fields++
+	}
+	if n != 1 || fields != 1 {
+		t.Fatalf("the view should read the snapshot: %v, %v", n, fields)
+	}
+	if pairs, err := view.ZRange(zkey, 0, -1); err != nil || len(pairs) != 1 {
+		t.Fatalf("the view should read the snapshot: %v, %v", pairs, err)
+	}
+	if pairs, err := db.ZRange(zkey, 0, -1); err != nil || len(pairs) != 2 {
+		t.Fatalf("the db should read the latest: %v, %v", pairs, err)
+	}
+}
+
+func TestReadViewTracked(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	views := make([]*RockDB, 0, maxScanSnapshotNum)
+	for i := 0; i < maxScanSnapshotNum; i++ {
+		view, err := db.NewReadView(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		views = append(views, view)
+	}
+	if _, err := db.NewReadView(0); err != errTooMuchScanSnapshot {
+		t.Fatalf("the read views should be limited with the scan snapshots: %v", err)
+	}
+	views[0].ReleaseReadView()
+	if views[0].LockReadView() {
+		t.Fatal("the released view should not be locked")
+	}
+	view, err := db.NewReadView(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	views[0] = view
+
+	// all the views are released by the db
+	if !views[1].LockReadView() {
+		t.Fatal("the view should be locked before released")
+	}
+	views[1].UnlockReadView()
+	db.scanSnaps.releaseAll()
+	for _, v := range views {
+		if v.LockReadView() {
+			t.Fatal("the view should be released by the db")
+		}
+	}
+
+	view, err = db.NewReadView(time.Millisecond * 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if view.LockReadView() {
+		t.Fatal("the view should be released after the ttl")
+	}
+}
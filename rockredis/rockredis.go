@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,8 +34,40 @@ func GetCheckpointDir(term uint64, index uint64) string {
 
 type RockConfig struct {
 	DataDir          string
-	DefaultReadOpts  *gorocksdb.ReadOptions
-	DefaultWriteOpts *gorocksdb.WriteOptions
+	EnableValueDedup bool
+	// the kv value not less than this size is stored in the blob column
+	// family, 0 means the value separation is disabled.
+	BlobMinValueSize int
+	// the time budget of each scan call in milliseconds, the scan exceeding
+	// the budget returns the cursor to resume, 0 means no limit.
+	ScanTimeBudgetMs int
+	// the background jobs parallelism, 0 means use the default.
+	// Note the thread pool of the rocksdb env is shared in the process,
+	// so the thread pool size will affect all the namespaces on the node.
+	MaxBackgroundCompactions int
+	MaxBackgroundFlushes     int
+	BackgroundLowThreads     int
+	BackgroundHighThreads    int
+	DefaultReadOpts          *gorocksdb.ReadOptions
+	DefaultWriteOpts         *gorocksdb.WriteOptions
+}
+
+const (
+	defaultMaxBackgroundCompactions = 4
+	defaultMaxBackgroundFlushes     = 2
+	maxBackgroundJobs               = 64
+)
+
+var errBackgroundJobsConfig = errors.New("invalid background jobs config")
+
+func (cfg *RockConfig) checkBackgroundJobs() error {
+	for _, n := range []int{cfg.MaxBackgroundCompactions, cfg.MaxBackgroundFlushes,
+		cfg.BackgroundLowThreads, cfg.BackgroundHighThreads} {
+		if n < 0 || n > maxBackgroundJobs {
+			return errBackgroundJobsConfig
+		}
+	}
+	return nil
 }
 
 func NewRockConfig() *RockConfig {
@@ -77,42 +110,80 @@ func (self CheckpointSortNames) Less(i, j int) bool {
 	return lterm < rterm
 }
 
-func purgeOldCheckpoint(keepNum int, checkpointDir string) {
+// purge the old checkpoints, keep the newest keepNum checkpoints and the
+// checkpoints modified in keepDuration. The checkpoint named keepName will
+// never be purged.
+func purgeOldCheckpoint(keepNum int, keepDuration time.Duration, keepName string, checkpointDir string) []string {
 	defer func() {
 		if e := recover(); e != nil {
 			dbLog.Infof("purge old checkpoint failed: %v", e)
 		}
 	}()
+	if keepNum < 1 {
+		keepNum = 1
+	}
 	checkpointList, err := filepath.Glob(path.Join(checkpointDir, "*-*"))
 	if err != nil {
-		return
-	}
-	if len(checkpointList) > keepNum {
-		sortedNameList := CheckpointSortNames(checkpointList)
-		sort.Sort(sortedNameList)
-		for i := 0; i < len(sortedNameList)-keepNum; i++ {
-			os.RemoveAll(sortedNameList[i])
-			dbLog.Infof("clean checkpoint : %v", sortedNameList[i])
+		return nil
+	}
+	if len(checkpointList) <= keepNum {
+		return nil
+	}
+	sortedNameList := CheckpointSortNames(checkpointList)
+	sort.Sort(sortedNameList)
+	purged := make([]string, 0)
+	for i := 0; i < len(sortedNameList)-keepNum; i++ {
+		if path.Base(sortedNameList[i]) == keepName {
+			continue
 		}
+		if keepDuration > 0 {
+			fi, err := os.Stat(sortedNameList[i])
+			if err == nil && time.Since(fi.ModTime()) < keepDuration {
+				continue
+			}
+		}
+		os.RemoveAll(sortedNameList[i])
+		dbLog.Infof("clean checkpoint : %v", sortedNameList[i])
+		purged = append(purged, sortedNameList[i])
 	}
+	return purged
 }
 
 type RockDB struct {
 	cfg              *RockConfig
 	eng              *gorocksdb.DB
 	dbOpts           *gorocksdb.Options
+	blobOpts         *gorocksdb.Options
+	blobCF           *gorocksdb.ColumnFamilyHandle
+	cfs              []*gorocksdb.ColumnFamilyHandle
 	defaultWriteOpts *gorocksdb.WriteOptions
 	defaultReadOpts  *gorocksdb.ReadOptions
 	wb               *gorocksdb.WriteBatch
 	quit             chan struct{}
 	wg               sync.WaitGroup
 	backupC          chan *BackupInfo
+	running          runningBackup
+	scanSnaps        *scanSnapshots
+	health           storeHealth
+	dropped          droppedPrefixes
+	pins             *snapshotPins
+	fieldExp         fieldExpireStats
+	// the kv values are stored with the reference header
+	valueRef bool
+	// the snapshot of the read view, nil for the db
+	readSnap *gorocksdb.Snapshot
+	// the read view tracked with the scan snapshots of the db
+	readView   *scanSnapshot
+	readViewID string
 }
 
 func OpenRockDB(cfg *RockConfig) (*RockDB, error) {
 	if len(cfg.DataDir) == 0 {
 		return nil, errors.New("config error")
 	}
+	if err := cfg.checkBackgroundJobs(); err != nil {
+		return nil, err
+	}
 
 	os.MkdirAll(cfg.DataDir, common.DIR_PERM)
 	// options need be adjust due to using hdd or sdd, please reference
@@ -139,8 +210,27 @@ func OpenRockDB(cfg *RockConfig) (*RockDB, error) {
 	opts.SetMaxBytesForLevelBase(1024 * 1024 * 1024 * 2)
 	opts.SetMinWriteBufferNumberToMerge(2)
 	opts.SetTargetFileSizeBase(1024 * 1024 * 128)
-	opts.SetMaxBackgroundFlushes(2)
-	opts.SetMaxBackgroundCompactions(4)
+	if cfg.MaxBackgroundFlushes > 0 {
+		opts.SetMaxBackgroundFlushes(cfg.MaxBackgroundFlushes)
+	} else {
+		opts.SetMaxBackgroundFlushes(defaultMaxBackgroundFlushes)
+	}
+	if cfg.MaxBackgroundCompactions > 0 {
+		opts.SetMaxBackgroundCompactions(cfg.MaxBackgroundCompactions)
+	} else {
+		opts.SetMaxBackgroundCompactions(defaultMaxBackgroundCompactions)
+	}
+	if cfg.BackgroundLowThreads > 0 || cfg.BackgroundHighThreads > 0 {
+		env := gorocksdb.NewDefaultEnv()
+		// the low priority pool is used by compaction, and the high for flush
+		if cfg.BackgroundLowThreads > 0 {
+			env.SetBackgroundThreads(cfg.BackgroundLowThreads)
+		}
+		if cfg.BackgroundHighThreads > 0 {
+			env.SetHighPriorityBackgroundThreads(cfg.BackgroundHighThreads)
+		}
+		opts.SetEnv(env)
+	}
 	opts.SetMinLevelToCompress(3)
 	// we use table, so we use prefix seek feature
 	opts.SetPrefixExtractor(gorocksdb.NewFixedPrefixTransform(3))
@@ -159,12 +249,28 @@ func OpenRockDB(cfg *RockConfig) (*RockDB, error) {
 		wb:               gorocksdb.NewWriteBatch(),
 		backupC:          make(chan *BackupInfo),
 		quit:             make(chan struct{}),
+		scanSnaps:        newScanSnapshots(),
+		pins:             newSnapshotPins(),
 	}
-	eng, err := gorocksdb.OpenDb(opts, db.GetDataDir())
-	if err != nil {
+	opts.SetCompactionFilter(db.newPrefixDropFilter())
+	if cfg.BlobMinValueSize > 0 {
+		opts.SetCreateIfMissingColumnFamilies(true)
+		db.blobOpts = newBlobOptions()
+	}
+	if err := db.openEng(); IsCorruption(err) {
+		err = db.openEngAfterCorruption(err)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if err := db.loadValueFormat(); err != nil {
+		db.closeEng()
 		return nil, err
 	}
-	db.eng = eng
+	db.loadDroppedPrefixes()
+	db.loadFieldExpireStats()
 	os.MkdirAll(db.GetBackupDir(), common.DIR_PERM)
 
 	db.wg.Add(1)
@@ -192,19 +298,39 @@ func (r *RockDB) GetDataDir() string {
 }
 
 func (r *RockDB) reOpen() error {
-	var err error
-	r.eng, err = gorocksdb.OpenDb(r.dbOpts, r.GetDataDir())
-	return err
+	if err := r.openEng(); err != nil {
+		return err
+	}
+	if err := r.loadValueFormat(); err != nil {
+		return err
+	}
+	r.loadDroppedPrefixes()
+	r.loadFieldExpireStats()
+	return nil
 }
 
 func (r *RockDB) CompactRange() {
 	var rg gorocksdb.Range
-	r.eng.CompactRange(rg)
+	r.limitedCompactRange(rg)
+	if r.blobCF != nil {
+		// reclaim the space of the released blob values
+		r.limitedCompactBlob(rg)
+	}
+}
+
+// Flush flush the memtables to the sst files and wait until done,
+// so all the writes before are durable in the data files.
+func (r *RockDB) Flush() error {
+	opts := gorocksdb.NewDefaultFlushOptions()
+	defer opts.Destroy()
+	opts.SetWait(true)
+	return r.eng.Flush(opts)
 }
 
 func (r *RockDB) Close() {
 	close(r.quit)
 	r.wg.Wait()
+	r.scanSnaps.releaseAll()
 	if r.defaultReadOpts != nil {
 		r.defaultReadOpts.Destroy()
 	}
@@ -212,7 +338,7 @@ func (r *RockDB) Close() {
 		r.defaultWriteOpts.Destroy()
 	}
 	if r.eng != nil {
-		r.eng.Close()
+		r.closeEng()
 	}
 }
 
@@ -241,6 +367,22 @@ func (r *RockDB) GetInternalStatus() map[string]interface{} {
 	status["cur-size-all-mem-tables"] = memStr
 	memStr = r.eng.GetProperty("rocksdb.cur-size-active-mem-table")
 	status["cur-size-active-mem-tables"] = memStr
+	status["num-entries-active-mem-table"] = r.eng.GetProperty("rocksdb.num-entries-active-mem-table")
+	if r.cfg.BackgroundLowThreads > 0 {
+		status["background-low-threads"] = r.cfg.BackgroundLowThreads
+	}
+	if r.cfg.BackgroundHighThreads > 0 {
+		status["background-high-threads"] = r.cfg.BackgroundHighThreads
+	}
+	if r.valueRef {
+		if ds, err := r.GetDedupStats(); err == nil {
+			status["dedup-unique-values"] = ds.UniqueValues
+			status["dedup-total-values"] = ds.TotalValues
+		}
+	}
+	if r.blobCF != nil {
+		status["blob-sst-files-size"] = r.eng.GetPropertyCF("rocksdb.total-sst-files-size", r.blobCF)
+	}
 	return status
 }
 
@@ -269,6 +411,8 @@ type BackupInfo struct {
 	done      chan struct{}
 	rsp       []byte
 	err       error
+	startTime time.Time
+	canceled  int32
 }
 
 func newBackupInfo(dir string) *BackupInfo {
@@ -276,9 +420,14 @@ func newBackupInfo(dir string) *BackupInfo {
 		backupDir: dir,
 		started:   make(chan struct{}),
 		done:      make(chan struct{}),
+		startTime: time.Now(),
 	}
 }
 
+func (self *BackupInfo) isCanceled() bool {
+	return atomic.LoadInt32(&self.canceled) == 1
+}
+
 func (self *BackupInfo) WaitReady() {
 	select {
 	case <-self.started:
@@ -303,6 +452,8 @@ func (r *RockDB) backupLoop() {
 
 			func() {
 				defer close(rsp.done)
+				r.running.set(rsp)
+				defer r.running.set(nil)
 				dbLog.Infof("begin backup to:%v \n", rsp.backupDir)
 				start := time.Now()
 				ck, err := gorocksdb.NewCheckpoint(r.eng)
@@ -319,17 +470,28 @@ func (r *RockDB) backupLoop() {
 				time.AfterFunc(time.Second*2, func() {
 					close(rsp.started)
 				})
+				if r.running.beforeSave != nil {
+					r.running.beforeSave()
+				}
+				if rsp.isCanceled() {
+					dbLog.Infof("backup canceled: %v", rsp.backupDir)
+					rsp.err = ErrBackupCanceled
+					return
+				}
 				err = ck.Save(rsp.backupDir)
+				if err == nil && rsp.isCanceled() {
+					err = ErrBackupCanceled
+				}
 				if err != nil {
 					dbLog.Infof("save checkpoint failed: %v", err)
+					// remove the partial checkpoint, so the retry can start clean
+					os.RemoveAll(rsp.backupDir)
 					rsp.err = err
 					return
 				}
 				cost := time.Now().Sub(start)
 				dbLog.Infof("backup done (cost %v), check point to: %v\n", cost.String(), rsp.backupDir)
-				// purge some old checkpoint
 				rsp.rsp = []byte(rsp.backupDir)
-				purgeOldCheckpoint(MAX_CHECKPOINT_NUM, r.GetBackupDir())
 			}()
 		case <-r.quit:
 			return
@@ -349,6 +511,18 @@ func (r *RockDB) Backup(term uint64, index uint64) *BackupInfo {
 	return bi
 }
 
+// PurgeOldBackups remove the old backup checkpoints, keep the newest keepNum
+// backups (MAX_CHECKPOINT_NUM if 0) and the backups newer than keepDuration.
+// The backup of the keepTerm and keepIndex will never be removed.
+// Return the removed backup dirs.
+func (r *RockDB) PurgeOldBackups(keepNum int, keepDuration time.Duration,
+	keepTerm uint64, keepIndex uint64) []string {
+	if keepNum <= 0 {
+		keepNum = MAX_CHECKPOINT_NUM
+	}
+	return purgeOldCheckpoint(keepNum, keepDuration, GetCheckpointDir(keepTerm, keepIndex), r.GetBackupDir())
+}
+
 func (r *RockDB) IsLocalBackupOK(term uint64, index uint64) (bool, error) {
 	backupDir := r.GetBackupDir()
 	checkpointDir := GetCheckpointDir(term, index)
@@ -414,7 +588,11 @@ func (r *RockDB) Restore(term uint64, index uint64) error {
 	checkpointDir := GetCheckpointDir(term, index)
 	start := time.Now()
 	dbLog.Infof("begin restore from checkpoint: %v\n", checkpointDir)
-	r.eng.Close()
+	r.scanSnaps.releaseAll()
+	if r.eng != nil {
+		// closed already if the last restore failed
+		r.closeEng()
+	}
 	// 1. remove all files in current db except sst files
 	// 2. get the list of sst in checkpoint
 	// 3. remove all the sst files not in the checkpoint list
@@ -470,6 +648,12 @@ func (r *RockDB) Restore(term uint64, index uint64) error {
 	dbLog.Infof("restore done, cost: %v\n", time.Now().Sub(start))
 	if err != nil {
 		dbLog.Infof("reopen the restored db failed:  %v\n", err)
+		if IsCorruption(err) {
+			// the sst files kept may be corrupted, so the next restore
+			// should copy all the files
+			os.RemoveAll(r.GetDataDir())
+			os.MkdirAll(r.GetDataDir(), common.DIR_PERM)
+		}
 	}
 	return err
 }
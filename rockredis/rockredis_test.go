@@ -5,6 +5,10 @@ import (
 	"github.com/absolute8511/ZanRedisDB/common"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -91,3 +95,124 @@ func TestRockDB(t *testing.T) {
 		t.Fatal(len(v))
 	}
 }
+
+func TestRockDBBackgroundJobsConfig(t *testing.T) {
+	cfg := NewRockConfig()
+	var err error
+	cfg.DataDir, err = ioutil.TempDir("", fmt.Sprintf("rockredis-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cfg.DataDir)
+	cfg.MaxBackgroundCompactions = 6
+	cfg.MaxBackgroundFlushes = 3
+	cfg.BackgroundLowThreads = 6
+	cfg.BackgroundHighThreads = 3
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	optionFiles, err := filepath.Glob(path.Join(db.GetDataDir(), "OPTIONS-*"))
+	if err != nil || len(optionFiles) == 0 {
+		t.Fatalf("rocksdb options file not found: %v", err)
+	}
+	sort.Strings(optionFiles)
+	options, err := ioutil.ReadFile(optionFiles[len(optionFiles)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []string{"max_background_compactions=6", "max_background_flushes=3"} {
+		if !strings.Contains(string(options), o) {
+			t.Fatalf("option %v not passed to rocksdb", o)
+		}
+	}
+	// the thread pools are shared by all the db in the process, so the
+	// threads may be more than configured
+	if low, high, ok := countRocksDBThreads(); !ok {
+		t.Log("the threads of the process can not be listed")
+	} else if high == 0 && low < 6+3 {
+		// the older rocksdb names all the background threads as rocksdb:bg
+		t.Fatalf("thread pool size not passed to rocksdb: %v", low)
+	} else if high > 0 && (low < 6 || high < 3) {
+		t.Fatalf("thread pool size not passed to rocksdb: %v, %v", low, high)
+	}
+
+	cfg2 := NewRockConfig()
+	cfg2.DataDir = path.Join(cfg.DataDir, "invalid")
+	cfg2.MaxBackgroundCompactions = -1
+	if _, err := OpenRockDB(cfg2); err == nil {
+		t.Fatal("should fail with the invalid background jobs config")
+	}
+}
+
+func TestRockDBPurgeOldBackups(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	if err := db.KVSet([]byte("test:purge_backup"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 6; i++ {
+		var bi *BackupInfo
+		for j := 0; j < 100; j++ {
+			if bi = db.Backup(1, i); bi != nil {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		if bi == nil {
+			t.Fatal("begin backup failed")
+		}
+		if _, err := bi.GetResult(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the backup of the current raft snapshot should be kept even it is old
+	purged := db.PurgeOldBackups(2, 0, 1, 2)
+	if len(purged) != 3 {
+		t.Fatalf("the old backups should be purged: %v", purged)
+	}
+	for i := uint64(1); i <= 6; i++ {
+		_, err := os.Stat(path.Join(db.GetBackupDir(), GetCheckpointDir(1, i)))
+		kept := i == 2 || i >= 5
+		if kept && err != nil {
+			t.Fatalf("the backup %v should be kept: %v", i, err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Fatalf("the backup %v should be purged: %v", i, err)
+		}
+	}
+	// the recent backups are kept by the duration
+	if purged := db.PurgeOldBackups(1, time.Hour, 1, 6); len(purged) != 0 {
+		t.Fatalf("the recent backups should be kept: %v", purged)
+	}
+	if purged := db.PurgeOldBackups(1, 0, 1, 6); len(purged) != 2 {
+		t.Fatalf("the old backups should be purged: %v", purged)
+	}
+}
+
+// count the rocksdb background threads of the low and high priority pools
+// by the thread names of the process.
+func countRocksDBThreads() (int, int, bool) {
+	comms, err := filepath.Glob("/proc/self/task/*/comm")
+	if err != nil || len(comms) == 0 {
+		return 0, 0, false
+	}
+	low, high := 0, 0
+	for _, f := range comms {
+		d, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(d))
+		if strings.HasPrefix(name, "rocksdb:high") {
+			high++
+		} else if strings.HasPrefix(name, "rocksdb:low") || strings.HasPrefix(name, "rocksdb:bg") {
+			low++
+		}
+	}
+	return low, high, true
+}
@@ -53,7 +53,7 @@ func buildMatchRegexp(match string) (glob.Glob, error) {
 
 func (db *RockDB) buildScanIterator(minKey []byte, maxKey []byte) *RangeLimitedIterator {
 	tp := common.RangeOpen
-	return NewDBRangeIterator(db.eng, minKey, maxKey, tp, false)
+	return db.newRangeIterator(minKey, maxKey, tp, false)
 }
 
 func buildScanKeyRange(storeDataType byte, key []byte) (minKey []byte, maxKey []byte, err error) {
@@ -130,36 +130,75 @@ func checkScanCount(count int) int {
 
 func (db *RockDB) scanGeneric(storeDataType byte, key []byte, count int,
 	match string) ([][]byte, error) {
-
-	r, err := buildMatchRegexp(match)
+	minKey, maxKey, err := buildScanKeyRange(storeDataType, key)
 	if err != nil {
 		return nil, err
 	}
+	it := db.buildScanIterator(minKey, maxKey)
+	return db.scanGenericWithIter(storeDataType, it, count, match)
+}
 
-	minKey, maxKey, err := buildScanKeyRange(storeDataType, key)
+func (db *RockDB) scanGenericWithIter(storeDataType byte, it *RangeLimitedIterator, count int,
+	match string) ([][]byte, error) {
+	r, err := buildMatchRegexp(match)
 	if err != nil {
+		it.Close()
 		return nil, err
 	}
 	count = checkScanCount(count)
 
-	it := db.buildScanIterator(minKey, maxKey)
-
 	v := make([][]byte, 0, count)
 
+	now := nowMs()
+	budget := db.newScanBudget()
+	var last []byte
 	for i := 0; it.Valid() && i < count; it.Next() {
-		if k, err := decodeScanKey(storeDataType, it.Key()); err != nil {
+		if err := budget.check(last); err != nil {
+			it.Close()
+			return v, err
+		}
+		if storeDataType == KVType && db.isKVKeyDropped(it.Key()) {
+			continue
+		}
+		k, err := decodeScanKey(storeDataType, it.Key())
+		if err != nil {
+			continue
+		}
+		last = k
+		if r != nil && !r.Match(string(k)) {
 			continue
-		} else if r != nil && !r.Match(string(k)) {
+		} else if db.isScanKeyExpired(storeDataType, k, it.Value(), now) {
 			continue
-		} else {
-			v = append(v, k)
-			i++
 		}
+		v = append(v, k)
+		i++
 	}
 	it.Close()
 	return v, nil
 }
 
+// the key is logically expired if all the data of the key is expired but
+// not deleted by the sweeper yet, the meta is the value of the scanned meta
+// key. Only the hash fields and the zset members can expire currently.
+func (db *RockDB) isScanKeyExpired(storeDataType byte, key []byte, meta []byte, now int64) bool {
+	switch storeDataType {
+	case HSizeType:
+		size, err := Int64(meta, nil)
+		if err != nil || size <= 0 {
+			return false
+		}
+		return int64(len(db.hExpiredFields(key, now))) >= size
+	case ZSizeType:
+		size, err := Int64(meta, nil)
+		if err != nil || size <= 0 {
+			return false
+		}
+		return int64(len(db.zExpiredMembers(key, now))) >= size
+	default:
+		return false
+	}
+}
+
 // for specail data scan
 func buildSpecificDataScanKeyRange(storeDataType byte, key []byte, cursor []byte) (minKey []byte, maxKey []byte, err error) {
 	if minKey, err = encodeSpecificDataScanMinKey(storeDataType, key, cursor); err != nil {
@@ -234,11 +273,21 @@ func (db *RockDB) hScanGeneric(key []byte, cursor []byte, count int, match strin
 	}
 	defer it.Close()
 
+	now := nowMs()
+	budget := db.newSubScanBudget(count)
+	var last []byte
 	for i := 0; it.Valid() && i < count; it.Next() {
+		if err := budget.check(last); err != nil {
+			return v, err
+		}
 		_, f, err := hDecodeHashKey(it.Key())
 		if err != nil {
 			return nil, err
-		} else if r != nil && !r.Match(string(f)) {
+		}
+		last = f
+		if r != nil && !r.Match(string(f)) {
+			continue
+		} else if db.hIsFieldExpired(key, f, now) {
 			continue
 		}
 		v = append(v, common.KVRecord{Key: f, Value: it.Value()})
@@ -265,11 +314,18 @@ func (db *RockDB) sScanGeneric(key []byte, cursor []byte, count int, match strin
 	}
 	defer it.Close()
 
+	budget := db.newSubScanBudget(count)
+	var last []byte
 	for i := 0; it.Valid() && i < count; it.Next() {
+		if err := budget.check(last); err != nil {
+			return v, err
+		}
 		_, m, err := sDecodeSetKey(it.Key())
 		if err != nil {
 			return nil, err
-		} else if r != nil && !r.Match(string(m)) {
+		}
+		last = m
+		if r != nil && !r.Match(string(m)) {
 			continue
 		}
 
@@ -299,11 +355,21 @@ func (db *RockDB) zScanGeneric(key []byte, cursor []byte, count int, match strin
 	}
 	defer it.Close()
 
+	now := nowMs()
+	budget := db.newSubScanBudget(count)
+	var last []byte
 	for i := 0; it.Valid() && i < count; it.Next() {
+		if err := budget.check(last); err != nil {
+			return v, err
+		}
 		_, m, err := zDecodeSetKey(it.Key())
 		if err != nil {
 			return nil, err
-		} else if r != nil && !r.Match(string(m)) {
+		}
+		last = m
+		if r != nil && !r.Match(string(m)) {
+			continue
+		} else if db.zIsMemberExpired(key, m, now) {
 			continue
 		}
 
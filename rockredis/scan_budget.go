@@ -0,0 +1,68 @@
+package rockredis
+
+import (
+	"time"
+)
+
+// check the time budget after every some keys iterated
+const scanBudgetCheckNum = 64
+
+// the sub key scan of the hash, set and zset stops after iterated so many
+// times of the count elements, so the filtered scan on the huge collection
+// will be paginated by the cursor.
+const scanExamineFactor = 10
+
+// ScanBudgetError is returned while the scan exceed the time budget, the
+// scan can be resumed from the cursor which is the last key iterated.
+// Limited is set while the scan stopped after the max keys iterated, which
+// is a normal page with less (or no) items.
+type ScanBudgetError struct {
+	Cursor  []byte
+	Limited bool
+}
+
+func (e *ScanBudgetError) Error() string {
+	return "ERR scan budget exceeded, resume with cursor " + string(e.Cursor)
+}
+
+type scanBudget struct {
+	deadline time.Time
+	n        int
+	maxKeys  int
+}
+
+func (db *RockDB) newScanBudget() *scanBudget {
+	if db.cfg.ScanTimeBudgetMs <= 0 {
+		return nil
+	}
+	return &scanBudget{
+		deadline: time.Now().Add(time.Duration(db.cfg.ScanTimeBudgetMs) * time.Millisecond),
+	}
+}
+
+// the budget for the sub keys scan of a collection, limit both the time and
+// the number of the sub keys iterated in one scan.
+func (db *RockDB) newSubScanBudget(count int) *scanBudget {
+	b := db.newScanBudget()
+	if b == nil {
+		b = &scanBudget{}
+	}
+	b.maxKeys = count * scanExamineFactor
+	return b
+}
+
+// return the budget error if the budget is exceeded, the last is the last
+// key iterated and the scan can not stop before any key iterated.
+func (b *scanBudget) check(last []byte) error {
+	if b == nil || last == nil {
+		return nil
+	}
+	b.n++
+	if b.maxKeys > 0 && b.n >= b.maxKeys {
+		return &ScanBudgetError{Cursor: last, Limited: true}
+	}
+	if b.deadline.IsZero() || b.n%scanBudgetCheckNum != 0 || time.Now().Before(b.deadline) {
+		return nil
+	}
+	return &ScanBudgetError{Cursor: last}
+}
@@ -0,0 +1,108 @@
+package rockredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestHScanHugeHashPaginated(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:scan_huge_hash")
+	fieldNum := 1000000
+	batch := make([]common.KVRecord, 0, 1000)
+	for i := 0; i < fieldNum; i++ {
+		batch = append(batch, common.KVRecord{Key: []byte(fmt.Sprintf("f_%07d", i)), Value: []byte("v")})
+		if len(batch) == cap(batch) {
+			if err := db.HMset(key, batch...); err != nil {
+				t.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	recs, err := db.HScan(key, nil, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 100 || string(recs[99].Key) != "f_0000099" {
+		t.Fatalf("the scan should return only the count fields: %v", len(recs))
+	}
+
+	// the scan matching nothing stop after some fields iterated
+	recs, err = db.HScan(key, nil, 100, "nomatch*")
+	e, ok := err.(*ScanBudgetError)
+	if !ok || !e.Limited || len(recs) != 0 {
+		t.Fatalf("the scan should stop with the cursor: %v, %v", err, len(recs))
+	}
+	if string(e.Cursor) != fmt.Sprintf("f_%07d", 100*scanExamineFactor-1) {
+		t.Fatalf("the scan should stop after the max fields iterated: %s", e.Cursor)
+	}
+
+	seen := make(map[string]bool, fieldNum)
+	var cursor []byte
+	for {
+		recs, err := db.HScan(key, cursor, 5000, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			if seen[string(r.Key)] {
+				t.Fatalf("the field %s scanned twice", r.Key)
+			}
+			seen[string(r.Key)] = true
+		}
+		if len(recs) < 5000 {
+			break
+		}
+		cursor = recs[len(recs)-1].Key
+	}
+	if len(seen) != fieldNum {
+		t.Fatalf("the scan should cover all the fields: %v", len(seen))
+	}
+}
+
+func TestSScanMatchPaginated(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:scan_match_set")
+	members := make([][]byte, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		members = append(members, []byte(fmt.Sprintf("m_%04d", i)))
+	}
+	if _, err := db.SAdd(key, members...); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the last member matched, the scan must be resumed by the cursor
+	var cursor []byte
+	var found [][]byte
+	pages := 0
+	for {
+		v, err := db.SScan(key, cursor, 10, "m_0999")
+		pages++
+		if e, ok := err.(*ScanBudgetError); ok && e.Limited {
+			found = append(found, v...)
+			cursor = e.Cursor
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, v...)
+		break
+	}
+	if len(found) != 1 || string(found[0]) != "m_0999" {
+		t.Fatalf("the scan should find the last member: %q", found)
+	}
+	if pages < 1000/(10*scanExamineFactor) {
+		t.Fatalf("the scan should be paginated: %v", pages)
+	}
+}
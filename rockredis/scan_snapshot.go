@@ -0,0 +1,152 @@
+package rockredis
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+const (
+	DefaultScanSnapshotTTL = time.Minute
+	MaxScanSnapshotTTL     = time.Hour
+	// limit the pinned snapshots since the snapshot will prevent
+	// the old data from being compacted
+	maxScanSnapshotNum = 16
+)
+
+var (
+	ErrScanSnapshotExpired = errors.New("scan snapshot expired or not found")
+	errTooMuchScanSnapshot = errors.New("too much scan snapshots pinned")
+)
+
+type scanSnapshot struct {
+	sync.Mutex
+	snap *gorocksdb.Snapshot
+	pins *snapshotPins
+	gen  uint64
+	// the read options of the read view reading from the snapshot
+	readOpts *gorocksdb.ReadOptions
+	released bool
+	timer    *time.Timer
+}
+
+func (s *scanSnapshot) release() {
+	s.Lock()
+	if !s.released {
+		s.released = true
+		s.timer.Stop()
+		if s.readOpts != nil {
+			s.readOpts.Destroy()
+		}
+		s.snap.Release()
+		s.pins.unpin(s.gen)
+	}
+	s.Unlock()
+}
+
+// scanSnapshots hold the pinned db snapshots for the scan cursors and the
+// read views, each snapshot will be released after the ttl even if the scan
+// is not finished.
+type scanSnapshots struct {
+	sync.Mutex
+	nextID int64
+	snaps  map[string]*scanSnapshot
+}
+
+func newScanSnapshots() *scanSnapshots {
+	return &scanSnapshots{
+		snaps: make(map[string]*scanSnapshot),
+	}
+}
+
+func (self *scanSnapshots) get(id string) (*scanSnapshot, bool) {
+	self.Lock()
+	s, ok := self.snaps[id]
+	self.Unlock()
+	return s, ok
+}
+
+func (self *scanSnapshots) remove(id string) {
+	self.Lock()
+	s, ok := self.snaps[id]
+	delete(self.snaps, id)
+	self.Unlock()
+	if ok {
+		s.release()
+	}
+}
+
+func (self *scanSnapshots) releaseAll() {
+	self.Lock()
+	snaps := self.snaps
+	self.snaps = make(map[string]*scanSnapshot)
+	self.Unlock()
+	for _, s := range snaps {
+		s.release()
+	}
+}
+
+// NewScanSnapshot pin a db snapshot for the scan cursor, and return the id of
+// the snapshot. The snapshot will be released after the ttl.
+func (db *RockDB) NewScanSnapshot(ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = DefaultScanSnapshotTTL
+	}
+	if ttl > MaxScanSnapshotTTL {
+		ttl = MaxScanSnapshotTTL
+	}
+	ss := db.scanSnaps
+	ss.Lock()
+	defer ss.Unlock()
+	if len(ss.snaps) >= maxScanSnapshotNum {
+		return "", errTooMuchScanSnapshot
+	}
+	ss.nextID++
+	id := strconv.FormatInt(ss.nextID, 10)
+	gen := db.pins.pin()
+	s := &scanSnapshot{
+		snap: gorocksdb.NewSnapshot(db.eng),
+		pins: db.pins,
+		gen:  gen,
+	}
+	s.timer = time.AfterFunc(ttl, func() {
+		dbLog.Infof("scan snapshot %v expired", id)
+		ss.remove(id)
+	})
+	ss.snaps[id] = s
+	return id, nil
+}
+
+// ReleaseScanSnapshot release the snapshot after the scan finished
+func (db *RockDB) ReleaseScanSnapshot(id string) {
+	db.scanSnaps.remove(id)
+}
+
+// ScanWithSnapshot is the same as Scan but read from the pinned snapshot,
+// ErrScanSnapshotExpired will be returned if the snapshot is released.
+func (db *RockDB) ScanWithSnapshot(snapID string, dataType common.DataType, cursor []byte,
+	count int, match string) ([][]byte, error) {
+	storeDataType, err := getDataStoreType(dataType)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := db.scanSnaps.get(snapID)
+	if !ok {
+		return nil, ErrScanSnapshotExpired
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.released {
+		return nil, ErrScanSnapshotExpired
+	}
+	minKey, maxKey, err := buildScanKeyRange(storeDataType, cursor)
+	if err != nil {
+		return nil, err
+	}
+	it := NewDBRangeIteratorWithSnapshot(db.eng, s.snap, minKey, maxKey, common.RangeOpen, false)
+	return db.scanGenericWithIter(storeDataType, it, count, match)
+}
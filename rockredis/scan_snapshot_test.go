@@ -0,0 +1,57 @@
+package rockredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestScanWithSnapshot(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		db.KVSet([]byte(fmt.Sprintf("test:snapscan_%d", i)), []byte("v"))
+	}
+	snapID, err := db.NewScanSnapshot(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ay, err := db.ScanWithSnapshot(snapID, common.KV, []byte("test:"), 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ay) != 5 {
+		t.Fatalf("scan result mismatch: %v", len(ay))
+	}
+	for i := 5; i < 10; i++ {
+		db.KVDel([]byte(fmt.Sprintf("test:snapscan_%d", i)))
+	}
+	ay, err = db.ScanWithSnapshot(snapID, common.KV, ay[len(ay)-1], 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ay) != 5 {
+		t.Fatalf("the deleted keys should be scanned from the snapshot: %v", len(ay))
+	}
+	if ay, _ := db.Scan(common.KV, []byte("test:"), 10, ""); len(ay) != 5 {
+		t.Fatalf("the deleted keys should not be scanned without snapshot: %v", len(ay))
+	}
+	db.ReleaseScanSnapshot(snapID)
+	if _, err := db.ScanWithSnapshot(snapID, common.KV, nil, 10, ""); err != ErrScanSnapshotExpired {
+		t.Fatalf("scan with the released snapshot should fail: %v", err)
+	}
+
+	snapID, err = db.NewScanSnapshot(time.Millisecond * 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if _, err := db.ScanWithSnapshot(snapID, common.KV, nil, 10, ""); err != ErrScanSnapshotExpired {
+		t.Fatalf("scan with the expired snapshot should fail: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package rockredis
+
+// the snap catchup changed at runtime is stored with the data, so it is
+// restored from the snapshot and replayed from the raft log while restarting.
+var snapCatchupKey = []byte{SnapCatchupType}
+
+// SaveSnapCatchup persist the snap catchup applied from the raft log.
+func (db *RockDB) SaveSnapCatchup(n uint64) error {
+	db.wb.Clear()
+	db.wb.Put(snapCatchupKey, PutInt64(int64(n)))
+	return db.writeBatch(db.wb)
+}
+
+// GetSnapCatchup return the snap catchup persisted, 0 if never changed.
+func (db *RockDB) GetSnapCatchup() (uint64, error) {
+	return Uint64(db.eng.GetBytes(db.defaultReadOpts, snapCatchupKey))
+}
@@ -0,0 +1,169 @@
+package rockredis
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+// the meta keys encoded as [type][key]
+var swapMetaTypes = []byte{KVType, HSizeType, LMetaType, SSizeType, ZSizeType}
+
+// the data keys encoded as [type][key len][key][the rest]
+var swapDataTypes = []byte{HashType, HFieldExpType, ListType, SetType, ZSetType, ZScoreType, ZMemberExpType}
+
+// the stored record of the key, the raw key is encoded again by the store
+// type and the rest part after the key.
+type keyRecord struct {
+	storeType byte
+	rest      []byte
+	value     []byte
+}
+
+func encodeKeyRecord(r keyRecord, key []byte) []byte {
+	switch r.storeType {
+	case KVType, HSizeType, LMetaType, SSizeType, ZSizeType:
+		buf := make([]byte, len(key)+1)
+		buf[0] = r.storeType
+		copy(buf[1:], key)
+		return buf
+	}
+	return encodeDataKeyPrefix(r.storeType, key, r.rest)
+}
+
+func encodeDataKeyPrefix(storeType byte, key []byte, rest []byte) []byte {
+	buf := make([]byte, 1+2+len(key)+len(rest))
+	buf[0] = storeType
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	copy(buf[3+len(key):], rest)
+	return buf
+}
+
+// return the hash field (or the zset member) and the expire time of the
+// expire record
+func (r keyRecord) fieldExpire() ([]byte, int64, bool) {
+	switch {
+	case r.storeType == HFieldExpType && len(r.rest) > 0 && r.rest[0] == hashStartSep:
+	case r.storeType == ZMemberExpType && len(r.rest) > 0 && r.rest[0] == zsetStartMemSep:
+	default:
+		return nil, 0, false
+	}
+	when, err := Int64(r.value, nil)
+	if err != nil {
+		return nil, 0, false
+	}
+	return r.rest[1:], when, true
+}
+
+// the expire time index key of the expire record
+func (r keyRecord) expireTimeKey(key []byte, field []byte, when int64) []byte {
+	if r.storeType == ZMemberExpType {
+		return zEncodeMemberExpTimeKey(when, key, field)
+	}
+	return hEncodeFieldExpTimeKey(when, key, field)
+}
+
+// collect all the stored records of the key, the expire time index of the
+// hash fields and zset members is not included since it can be rebuilt from
+// the expire records.
+func (db *RockDB) getKeyRecords(key []byte, limit int) ([]keyRecord, error) {
+	var recs []keyRecord
+	for _, t := range swapMetaTypes {
+		r := keyRecord{storeType: t}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, encodeKeyRecord(r, key))
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			r.value = v
+			recs = append(recs, r)
+		}
+	}
+	for _, t := range swapDataTypes {
+		start := encodeDataKeyPrefix(t, key, nil)
+		it := db.newRangeIterator(start, prefixRangeStop(start), common.RangeROpen, false)
+		for ; it.Valid(); it.Next() {
+			if len(recs) >= limit {
+				it.Close()
+				return nil, errTooMuchBatchSize
+			}
+			recs = append(recs, keyRecord{
+				storeType: t,
+				rest:      it.Key()[len(start):],
+				value:     it.Value(),
+			})
+		}
+		it.Close()
+	}
+	return recs, nil
+}
+
+// Swap exchange the data of any type (including the expire time of the hash
+// fields and zset members) between the two keys in one write batch. The missing key is swapped
+// as the empty value, so the other key is moved if only one key exists.
+func (db *RockDB) Swap(key1 []byte, key2 []byte) error {
+	table1, _, err := db.convertKVWriteKey(key1)
+	if err != nil {
+		return err
+	}
+	table2, _, err := db.convertKVWriteKey(key2)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(key1, key2) {
+		return nil
+	}
+	recs1, err := db.getKeyRecords(key1, RANGE_DELETE_NUM)
+	if err != nil {
+		return err
+	}
+	recs2, err := db.getKeyRecords(key2, RANGE_DELETE_NUM-len(recs1))
+	if err != nil {
+		return err
+	}
+
+	wb := db.wb
+	wb.Clear()
+	// the old records are deleted before the new records written, so the
+	// records of the same stored key are overwritten in the batch.
+	for _, r := range recs1 {
+		wb.Delete(encodeKeyRecord(r, key1))
+		if field, when, ok := r.fieldExpire(); ok {
+			wb.Delete(r.expireTimeKey(key1, field, when))
+		}
+	}
+	for _, r := range recs2 {
+		wb.Delete(encodeKeyRecord(r, key2))
+		if field, when, ok := r.fieldExpire(); ok {
+			wb.Delete(r.expireTimeKey(key2, field, when))
+		}
+	}
+	for _, r := range recs1 {
+		wb.Put(encodeKeyRecord(r, key2), r.value)
+		if field, when, ok := r.fieldExpire(); ok {
+			wb.Put(r.expireTimeKey(key2, field, when), nil)
+		}
+	}
+	for _, r := range recs2 {
+		wb.Put(encodeKeyRecord(r, key1), r.value)
+		if field, when, ok := r.fieldExpire(); ok {
+			wb.Put(r.expireTimeKey(key1, field, when), nil)
+		}
+	}
+
+	if !bytes.Equal(table1, table2) && (len(recs1) == 0) != (len(recs2) == 0) {
+		var delta int64 = 1
+		if len(recs1) == 0 {
+			delta = -1
+		}
+		if _, err = db.IncrTableKeyCount(table1, -delta, wb); err != nil {
+			return err
+		}
+		if _, err = db.IncrTableKeyCount(table2, delta, wb); err != nil {
+			return err
+		}
+	}
+	return db.writeBatch(wb)
+}
@@ -0,0 +1,116 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestSwapKVAndHash(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	kvKey := []byte("test:swap_kv")
+	hKey := []byte("test:swap_hash")
+	if err := db.KVSet(kvKey, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HMset(hKey, common.KVRecord{Key: []byte("f1"), Value: []byte("hv1")},
+		common.KVRecord{Key: []byte("f2"), Value: []byte("hv2")}); err != nil {
+		t.Fatal(err)
+	}
+	when := nowMs() + 100000
+	if _, err := db.HExpireAt(hKey, when, []byte("f1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Swap(kvKey, hKey); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.KVGet(hKey); err != nil || string(v) != "v1" {
+		t.Fatalf("the hash key should hold the string: %s, %v", v, err)
+	}
+	if n, err := db.HLen(kvKey); err != nil || n != 2 {
+		t.Fatalf("the kv key should hold the hash: %v, %v", n, err)
+	}
+	if v, err := db.HGet(kvKey, []byte("f2")); err != nil || string(v) != "hv2" {
+		t.Fatalf("the hash field should be swapped: %s, %v", v, err)
+	}
+	if v, _ := db.KVGet(kvKey); v != nil {
+		t.Fatalf("the string should be moved: %s", v)
+	}
+	if n, _ := db.HLen(hKey); n != 0 {
+		t.Fatalf("the hash should be moved: %v", n)
+	}
+	if err := db.CheckKeyType(kvKey, HashType); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckKeyType(hKey, KVType); err != nil {
+		t.Fatal(err)
+	}
+	ttls, err := db.HFieldTTL(kvKey, []byte("f1"), []byte("f2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttls[0] <= 0 || ttls[0] > 100000 || ttls[1] != HFieldNoExpire {
+		t.Fatalf("the field expire should be swapped: %v", ttls)
+	}
+	recs, err := db.ScanExpiredHashFields(when, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || string(recs[0].Key) != string(kvKey) || string(recs[0].Value) != "f1" {
+		t.Fatalf("the field expire index should be moved: %v", recs)
+	}
+	if n, _ := db.GetTableKeyCount([]byte("test")); n != 2 {
+		t.Fatalf("the table key count should not change: %v", n)
+	}
+
+	// swap back
+	if err := db.Swap(hKey, kvKey); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.KVGet(kvKey); string(v) != "v1" {
+		t.Fatalf("the string should be swapped back: %s", v)
+	}
+	if v, _ := db.HGet(hKey, []byte("f1")); string(v) != "hv1" {
+		t.Fatalf("the hash should be swapped back: %s", v)
+	}
+}
+
+func TestSwapMissingKey(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:swap_exist")
+	missing := []byte("test2:swap_missing")
+	if _, err := db.SAdd(key, []byte("m1"), []byte("m2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Swap(key, missing); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.SCard(key); n != 0 {
+		t.Fatalf("the key should be missing after swapped: %v", n)
+	}
+	if n, _ := db.SCard(missing); n != 2 {
+		t.Fatalf("the set should be moved: %v", n)
+	}
+	if n, _ := db.GetTableKeyCount([]byte("test")); n != 0 {
+		t.Fatalf("the key should be moved out of the table: %v", n)
+	}
+	if n, _ := db.GetTableKeyCount([]byte("test2")); n != 1 {
+		t.Fatalf("the key should be moved into the table: %v", n)
+	}
+
+	// both missing
+	if err := db.Swap([]byte("test:swap_none1"), []byte("test:swap_none2")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := db.GetTableKeyCount([]byte("test")); n != 0 {
+		t.Fatalf("swap the missing keys should not change the table: %v", n)
+	}
+}
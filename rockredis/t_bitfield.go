@@ -0,0 +1,234 @@
+package rockredis
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	BitFieldGet byte = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+const (
+	BitFieldOverflowWrap byte = iota
+	BitFieldOverflowSat
+	BitFieldOverflowFail
+)
+
+var (
+	ErrBitFieldType   = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrBitFieldOffset = errors.New("ERR bit offset is not an integer or out of range")
+)
+
+// BitFieldOp is one GET/SET/INCRBY operation of the bitfield command, the
+// Overflow is the overflow mode in effect for the SET and INCRBY.
+type BitFieldOp struct {
+	Type     byte
+	Signed   bool
+	Bits     uint
+	Offset   uint64
+	Value    int64
+	Overflow byte
+}
+
+// CheckBitFieldOp check the field type and the bit offset of the operation
+func CheckBitFieldOp(op BitFieldOp) error {
+	if op.Bits < 1 || op.Bits > 64 || (!op.Signed && op.Bits > 63) {
+		return ErrBitFieldType
+	}
+	if op.Offset > uint64(MaxValueSize)*8-uint64(op.Bits) {
+		return ErrBitFieldOffset
+	}
+	return nil
+}
+
+// the bits are addressed from the most significant bit of the first byte,
+// the bits beyond the value are read as zero.
+func getUnsignedBitField(v []byte, offset uint64, bits uint) uint64 {
+	var n uint64
+	for i := uint(0); i < bits; i++ {
+		pos := offset >> 3
+		var bit uint64
+		if pos < uint64(len(v)) {
+			bit = uint64(v[pos]>>(7-uint(offset&7))) & 1
+		}
+		n = (n << 1) | bit
+		offset++
+	}
+	return n
+}
+
+func getSignedBitField(v []byte, offset uint64, bits uint) int64 {
+	n := getUnsignedBitField(v, offset, bits)
+	if bits < 64 && n&(uint64(1)<<(bits-1)) != 0 {
+		n |= math.MaxUint64 << bits
+	}
+	return int64(n)
+}
+
+func setUnsignedBitField(v []byte, offset uint64, bits uint, n uint64) {
+	for i := uint(0); i < bits; i++ {
+		pos := offset >> 3
+		mask := byte(1) << (7 - uint(offset&7))
+		if n&(uint64(1)<<(bits-1-i)) != 0 {
+			v[pos] |= mask
+		} else {
+			v[pos] &^= mask
+		}
+		offset++
+	}
+}
+
+// unsignedBitFieldAdd return the value of v+incr in the unsigned field,
+// the overflow is -1 if underflow, 1 if overflow and 0 if not overflow.
+func unsignedBitFieldAdd(v uint64, incr int64, bits uint, mode byte) (uint64, int) {
+	max := uint64(math.MaxUint64) >> (64 - bits)
+	wrapped := (v + uint64(incr)) & max
+	if v > max || (incr > 0 && uint64(incr) > max-v) {
+		if mode == BitFieldOverflowSat {
+			return max, 1
+		}
+		return wrapped, 1
+	}
+	if incr < 0 && uint64(-incr) > v {
+		if mode == BitFieldOverflowSat {
+			return 0, -1
+		}
+		return wrapped, -1
+	}
+	return wrapped, 0
+}
+
+// signedBitFieldAdd return the value of v+incr in the signed field,
+// the overflow is -1 if underflow, 1 if overflow and 0 if not overflow.
+func signedBitFieldAdd(v int64, incr int64, bits uint, mode byte) (int64, int) {
+	max := int64(math.MaxInt64 >> (64 - bits))
+	min := -max - 1
+	sum := uint64(v) + uint64(incr)
+	if bits < 64 {
+		if sum&(uint64(1)<<(bits-1)) != 0 {
+			sum |= math.MaxUint64 << bits
+		} else {
+			sum &^= math.MaxUint64 << bits
+		}
+	}
+	wrapped := int64(sum)
+	if v > max || (incr > 0 && (v >= 0 || bits < 64) && incr > max-v) {
+		if mode == BitFieldOverflowSat {
+			return max, 1
+		}
+		return wrapped, 1
+	}
+	if v < min || (incr < 0 && (v < 0 || bits < 64) && incr < min-v) {
+		if mode == BitFieldOverflowSat {
+			return min, -1
+		}
+		return wrapped, -1
+	}
+	return wrapped, 0
+}
+
+// BitField apply all the operations to the string value in order, the
+// result has one element for each operation and it is nil for the
+// operation failed by the overflow under the FAIL mode.
+func (db *RockDB) BitField(key []byte, ops []BitFieldOp) ([]interface{}, error) {
+	var maxWrite uint64
+	hasWrite := false
+	for _, op := range ops {
+		if err := CheckBitFieldOp(op); err != nil {
+			return nil, err
+		}
+		if op.Type != BitFieldGet {
+			hasWrite = true
+			if end := op.Offset + uint64(op.Bits); end > maxWrite {
+				maxWrite = end
+			}
+		}
+	}
+
+	table, ek, err := db.convertKVWriteKey(key)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+	if err != nil {
+		return nil, err
+	}
+	v, err := db.decodeKVValue(stored)
+	if err != nil {
+		return nil, err
+	}
+	if hasWrite {
+		// the value is extended to hold the largest written field
+		needLen := int((maxWrite + 7) / 8)
+		if needLen > len(v) {
+			v = append(v, make([]byte, needLen-len(v))...)
+		}
+	}
+
+	rets := make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		switch op.Type {
+		case BitFieldGet:
+			if op.Signed {
+				rets = append(rets, getSignedBitField(v, op.Offset, op.Bits))
+			} else {
+				rets = append(rets, int64(getUnsignedBitField(v, op.Offset, op.Bits)))
+			}
+		case BitFieldSet, BitFieldIncrBy:
+			var old, n uint64
+			var overflow int
+			if op.Signed {
+				oldv := getSignedBitField(v, op.Offset, op.Bits)
+				var nv int64
+				if op.Type == BitFieldSet {
+					nv, overflow = signedBitFieldAdd(op.Value, 0, op.Bits, op.Overflow)
+				} else {
+					nv, overflow = signedBitFieldAdd(oldv, op.Value, op.Bits, op.Overflow)
+				}
+				old, n = uint64(oldv), uint64(nv)
+			} else {
+				old = getUnsignedBitField(v, op.Offset, op.Bits)
+				if op.Type == BitFieldSet {
+					n, overflow = unsignedBitFieldAdd(uint64(op.Value), 0, op.Bits, op.Overflow)
+				} else {
+					n, overflow = unsignedBitFieldAdd(old, op.Value, op.Bits, op.Overflow)
+				}
+			}
+			if overflow != 0 && op.Overflow == BitFieldOverflowFail {
+				rets = append(rets, nil)
+				continue
+			}
+			setUnsignedBitField(v, op.Offset, op.Bits, n)
+			if op.Type == BitFieldSet {
+				rets = append(rets, int64(old))
+			} else {
+				rets = append(rets, int64(n))
+			}
+		}
+	}
+	if !hasWrite {
+		return rets, nil
+	}
+
+	db.wb.Clear()
+	if stored == nil {
+		_, err = db.IncrTableKeyCount(table, 1, db.wb)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(ek, dw.encodeValue(v))
+	if err = dw.flush(db.wb); err != nil {
+		return nil, err
+	}
+	err = db.writeBatch(db.wb)
+	if err != nil {
+		return nil, err
+	}
+	return rets, nil
+}
@@ -0,0 +1,124 @@
+package rockredis
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func checkBitFieldResult(t *testing.T, rets []interface{}, expected ...interface{}) {
+	if len(rets) != len(expected) {
+		t.Fatalf("result number mismatch: %v, %v", rets, expected)
+	}
+	for i, v := range expected {
+		if rets[i] != v {
+			t.Fatalf("result %v mismatch: %v, %v", i, rets, expected)
+		}
+	}
+}
+
+func TestDBBitField(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:bitfield")
+	// the get only should not create the key
+	rets, err := db.BitField(key, []BitFieldOp{{Type: BitFieldGet, Bits: 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(0))
+	if n, _ := db.KVExists(key); n != 0 {
+		t.Fatal("the get should not create the key")
+	}
+
+	rets, err = db.BitField(key, []BitFieldOp{
+		{Type: BitFieldSet, Bits: 8, Offset: 0, Value: 255},
+		{Type: BitFieldGet, Signed: true, Bits: 8, Offset: 0},
+		{Type: BitFieldGet, Bits: 4, Offset: 0},
+		{Type: BitFieldSet, Bits: 8, Offset: 8, Value: 'a'},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(0), int64(-1), int64(15), int64(0))
+	v, err := db.KVGet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "\xffa" {
+		t.Fatalf("the value mismatch: %q", v)
+	}
+
+	// the unsigned wrap and the fields crossing the byte boundary
+	rets, err = db.BitField(key, []BitFieldOp{
+		{Type: BitFieldSet, Bits: 16, Offset: 20, Value: 0x1234},
+		{Type: BitFieldGet, Bits: 16, Offset: 20},
+		{Type: BitFieldIncrBy, Bits: 2, Offset: 100, Value: 5},
+		{Type: BitFieldIncrBy, Bits: 2, Offset: 100, Value: -2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(0), int64(0x1234), int64(1), int64(3))
+
+	rets, err = db.BitField(key, []BitFieldOp{
+		{Type: BitFieldIncrBy, Signed: true, Bits: 64, Offset: 200, Value: math.MaxInt64},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 64, Offset: 200, Value: 1},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 64, Offset: 200, Value: 1, Overflow: BitFieldOverflowSat},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(math.MaxInt64), int64(math.MinInt64), int64(math.MinInt64+1))
+
+	if _, err := db.BitField(key, []BitFieldOp{{Type: BitFieldGet, Bits: 64}}); err != ErrBitFieldType {
+		t.Fatalf("the u64 should be invalid: %v", err)
+	}
+	if _, err := db.BitField(key, []BitFieldOp{{Type: BitFieldGet, Bits: 8, Offset: uint64(MaxValueSize) * 8}}); err != ErrBitFieldOffset {
+		t.Fatalf("the offset should be out of range: %v", err)
+	}
+}
+
+func TestDBBitFieldOverflow(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:bitfield_overflow")
+	rets, err := db.BitField(key, []BitFieldOp{
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: 100, Overflow: BitFieldOverflowSat},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: 100, Overflow: BitFieldOverflowSat},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: -300, Overflow: BitFieldOverflowSat},
+		{Type: BitFieldSet, Signed: true, Bits: 8, Value: 200, Overflow: BitFieldOverflowSat},
+		{Type: BitFieldGet, Signed: true, Bits: 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(100), int64(127), int64(-128), int64(-128), int64(127))
+
+	rets, err = db.BitField(key, []BitFieldOp{
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: 1, Overflow: BitFieldOverflowFail},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: -1, Overflow: BitFieldOverflowFail},
+		{Type: BitFieldSet, Bits: 4, Offset: 8, Value: 16, Overflow: BitFieldOverflowFail},
+		{Type: BitFieldIncrBy, Bits: 4, Offset: 8, Value: -1, Overflow: BitFieldOverflowFail},
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: 1, Overflow: BitFieldOverflowWrap},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed operations should not change the value
+	checkBitFieldResult(t, rets, nil, int64(126), nil, nil, int64(127))
+
+	rets, err = db.BitField(key, []BitFieldOp{
+		{Type: BitFieldIncrBy, Signed: true, Bits: 8, Value: 1},
+		{Type: BitFieldIncrBy, Bits: 4, Offset: 8, Value: -1, Overflow: BitFieldOverflowSat},
+		{Type: BitFieldIncrBy, Bits: 4, Offset: 8, Value: 100, Overflow: BitFieldOverflowSat},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBitFieldResult(t, rets, int64(-128), int64(0), int64(15))
+}
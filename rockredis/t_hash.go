@@ -151,7 +151,17 @@ func (db *RockDB) hSetField(hkey []byte, field []byte, value []byte, wb *gorocks
 	return created, nil
 }
 
+// the expired fields not deleted yet are not counted
 func (db *RockDB) HLen(hkey []byte) (int64, error) {
+	size, err := db.hSize(hkey)
+	if err != nil || size <= 0 {
+		return size, err
+	}
+	return size - int64(len(db.hExpiredFields(hkey, nowMs()))), nil
+}
+
+// the number of the stored fields including the expired ones
+func (db *RockDB) hSize(hkey []byte) (int64, error) {
 	if err := checkKeySize(hkey); err != nil {
 		return 0, err
 	}
@@ -160,6 +170,21 @@ func (db *RockDB) HLen(hkey []byte) (int64, error) {
 	return Int64(v, err)
 }
 
+// HStoredSize return the number of the stored fields including the expired
+// ones not deleted yet, so the result does not depend on the local time.
+func (db *RockDB) HStoredSize(hkey []byte) (int64, error) {
+	return db.hSize(hkey)
+}
+
+// HFieldStored return whether the field is stored even if it is expired.
+func (db *RockDB) HFieldStored(key []byte, field []byte) (bool, error) {
+	if err := checkHashKFSize(key, field); err != nil {
+		return false, err
+	}
+	v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+	return v != nil, err
+}
+
 func (db *RockDB) hIncrSize(hkey []byte, delta int64, wb *gorocksdb.WriteBatch) (int64, error) {
 	sk := hEncodeSizeKey(hkey)
 
@@ -190,8 +215,16 @@ func (db *RockDB) HSet(key []byte, field []byte, value []byte) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	// set the field will clear the expire time
+	d := newFieldExpireDelta()
+	if _, err := db.hDelFieldExpire(key, field, db.wb, d); err != nil {
+		return 0, err
+	}
 
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	err = db.writeBatch(db.wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return created, err
 }
 
@@ -207,6 +240,7 @@ func (db *RockDB) HMset(key []byte, args ...common.KVRecord) error {
 		return err
 	}
 	db.wb.Clear()
+	d := newFieldExpireDelta()
 
 	var num int64 = 0
 	for i := 0; i < len(args); i++ {
@@ -223,6 +257,9 @@ func (db *RockDB) HMset(key []byte, args ...common.KVRecord) error {
 			num++
 		}
 		db.wb.Put(ek, args[i].Value)
+		if _, err := db.hDelFieldExpire(key, args[i].Key, db.wb, d); err != nil {
+			return err
+		}
 	}
 	if newNum, err := db.hIncrSize(key, num, db.wb); err != nil {
 		return err
@@ -233,7 +270,10 @@ func (db *RockDB) HMset(key []byte, args ...common.KVRecord) error {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	err = db.writeBatch(db.wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return err
 }
 
@@ -242,7 +282,11 @@ func (db *RockDB) HGet(key []byte, field []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	return db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+	v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+	if v != nil && db.hIsFieldExpired(key, field, nowMs()) {
+		return nil, nil
+	}
+	return v, err
 }
 
 func (db *RockDB) HKeyExists(key []byte) (int64, error) {
@@ -286,6 +330,7 @@ func (db *RockDB) HDel(key []byte, args ...[]byte) (int64, error) {
 
 	db.wb.Clear()
 	wb := db.wb
+	d := newFieldExpireDelta()
 
 	var ek []byte
 	var v []byte
@@ -304,6 +349,9 @@ func (db *RockDB) HDel(key []byte, args ...[]byte) (int64, error) {
 		} else {
 			num++
 			wb.Delete(ek)
+			if _, err := db.hDelFieldExpire(key, args[i], wb, d); err != nil {
+				return 0, err
+			}
 		}
 	}
 
@@ -316,16 +364,82 @@ func (db *RockDB) HDel(key []byte, args ...[]byte) (int64, error) {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return num, err
 }
 
-func (db *RockDB) hDeleteAll(hkey []byte, wb *gorocksdb.WriteBatch) int64 {
+// HGetDel return the values of the fields and delete the fields in the same
+// write batch. The value is nil if the field not exist or expired at now, and
+// the duplicate field is deleted only once.
+func (db *RockDB) HGetDel(key []byte, now int64, fields ...[]byte) ([][]byte, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	table, _, err := convertRedisKeyToDBHKey(key, fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	wb := db.wb
+	wb.Clear()
+	d := newFieldExpireDelta()
+	deleted := make(map[string]bool, len(fields))
+	ret := make([][]byte, len(fields))
+	var num int64
+	for i, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return nil, err
+		}
+		if deleted[string(field)] {
+			continue
+		}
+		ek := hEncodeHashKey(key, field)
+		v, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		deleted[string(field)] = true
+		if !db.hIsFieldExpired(key, field, now) {
+			ret[i] = v
+		}
+		num++
+		wb.Delete(ek)
+		if _, err := db.hDelFieldExpire(key, field, wb, d); err != nil {
+			return nil, err
+		}
+	}
+	if num == 0 {
+		return ret, nil
+	}
+	if newNum, err := db.hIncrSize(key, -num, wb); err != nil {
+		return nil, err
+	} else if newNum == 0 {
+		if _, err := db.IncrTableKeyCount(table, -1, wb); err != nil {
+			return nil, err
+		}
+	}
+	err = db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
+	return ret, err
+}
+
+func (db *RockDB) hDeleteAll(hkey []byte, wb *gorocksdb.WriteBatch, d *fieldExpireDelta) int64 {
 	sk := hEncodeSizeKey(hkey)
 	start := hEncodeStartKey(hkey)
 	stop := hEncodeStopKey(hkey)
 
-	it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 	defer it.Close()
 	var num int64 = 0
 	for ; it.Valid(); it.Next() {
@@ -335,6 +449,7 @@ func (db *RockDB) hDeleteAll(hkey []byte, wb *gorocksdb.WriteBatch) int64 {
 	}
 
 	wb.Delete(sk)
+	db.hDelAllFieldExpire(hkey, wb, d)
 	return num
 }
 
@@ -343,7 +458,8 @@ func (db *RockDB) HClear(hkey []byte) (int64, error) {
 		return 0, err
 	}
 
-	hlen, err := db.HLen(hkey)
+	// the expired fields not deleted yet are counted too
+	hlen, err := db.hSize(hkey)
 	if err != nil {
 		return 0, err
 	}
@@ -363,7 +479,8 @@ func (db *RockDB) HClear(hkey []byte) (int64, error) {
 	}
 	wb := db.wb
 	wb.Clear()
-	db.hDeleteAll(hkey, wb)
+	d := newFieldExpireDelta()
+	db.hDeleteAll(hkey, wb, d)
 	if hlen > 0 {
 		_, err = db.IncrTableKeyCount(table, -1, wb)
 		if err != nil {
@@ -371,7 +488,10 @@ func (db *RockDB) HClear(hkey []byte) (int64, error) {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return hlen, err
 }
 
@@ -381,7 +501,9 @@ func (db *RockDB) HMclear(keys ...[]byte) {
 	}
 }
 
-func (db *RockDB) HIncrBy(key []byte, field []byte, delta int64) (int64, error) {
+// increase the field by the delta, the field expired at the given time is
+// increased from 0 and its expire time is removed.
+func (db *RockDB) HIncrBy(key []byte, field []byte, delta int64, now int64) (int64, error) {
 	if err := checkHashKFSize(key, field); err != nil {
 		return 0, err
 	}
@@ -391,10 +513,17 @@ func (db *RockDB) HIncrBy(key []byte, field []byte, delta int64) (int64, error)
 	var ek []byte
 	var err error
 
-	ek = hEncodeHashKey(key, field)
+	d := newFieldExpireDelta()
 	var n int64 = 0
-	if n, err = StrInt64(db.eng.GetBytes(db.defaultReadOpts, ek)); err != nil {
-		return 0, err
+	if db.hIsFieldExpired(key, field, now) {
+		if _, err = db.hDelFieldExpire(key, field, wb, d); err != nil {
+			return 0, err
+		}
+	} else {
+		ek = hEncodeHashKey(key, field)
+		if n, err = StrInt64(db.eng.GetBytes(db.defaultReadOpts, ek)); err != nil {
+			return 0, err
+		}
 	}
 
 	n += delta
@@ -404,7 +533,10 @@ func (db *RockDB) HIncrBy(key []byte, field []byte, delta int64) (int64, error)
 		return 0, err
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return n, err
 }
 
@@ -414,22 +546,27 @@ func (db *RockDB) HGetAll(key []byte) (int64, chan common.KVRecordRet, error) {
 	}
 
 	v := make(chan common.KVRecordRet, 16)
-	len, err := db.HLen(key)
+	hlen, err := db.hSize(key)
 	if err != nil {
 		return 0, nil, err
 	}
-	if len >= MAX_BATCH_NUM {
-		return len, nil, errTooMuchBatchSize
+	if hlen >= MAX_BATCH_NUM {
+		return hlen, nil, errTooMuchBatchSize
 	}
+	expired := db.hExpiredFields(key, nowMs())
+	hlen -= int64(len(expired))
 
 	go func() {
 		start := hEncodeStartKey(key)
 		stop := hEncodeStopKey(key)
-		it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+		it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 		defer it.Close()
 		defer close(v)
 		for ; it.Valid(); it.Next() {
 			_, f, err := hDecodeHashKey(it.Key())
+			if expired[string(f)] {
+				continue
+			}
 			v <- common.KVRecordRet{
 				common.KVRecord{Key: f, Value: it.Value()},
 				err,
@@ -437,7 +574,7 @@ func (db *RockDB) HGetAll(key []byte) (int64, chan common.KVRecordRet, error) {
 		}
 	}()
 
-	return len, v, nil
+	return hlen, v, nil
 }
 
 func (db *RockDB) HKeys(key []byte) (int64, chan common.KVRecordRet, error) {
@@ -445,23 +582,28 @@ func (db *RockDB) HKeys(key []byte) (int64, chan common.KVRecordRet, error) {
 		return 0, nil, err
 	}
 
-	len, err := db.HLen(key)
+	hlen, err := db.hSize(key)
 	if err != nil {
 		return 0, nil, err
 	}
-	if len >= MAX_BATCH_NUM {
-		return len, nil, errTooMuchBatchSize
+	if hlen >= MAX_BATCH_NUM {
+		return hlen, nil, errTooMuchBatchSize
 	}
+	expired := db.hExpiredFields(key, nowMs())
+	hlen -= int64(len(expired))
 	v := make(chan common.KVRecordRet, 16)
 
 	go func() {
 		start := hEncodeStartKey(key)
 		stop := hEncodeStopKey(key)
-		it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+		it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 		defer it.Close()
 		defer close(v)
 		for ; it.Valid(); it.Next() {
 			_, f, err := hDecodeHashKey(it.Key())
+			if expired[string(f)] {
+				continue
+			}
 			v <- common.KVRecordRet{
 				common.KVRecord{Key: f, Value: nil},
 				err,
@@ -469,7 +611,7 @@ func (db *RockDB) HKeys(key []byte) (int64, chan common.KVRecordRet, error) {
 		}
 	}()
 
-	return len, v, nil
+	return hlen, v, nil
 }
 
 func (db *RockDB) HValues(key []byte) (int64, chan common.KVRecordRet, error) {
@@ -477,23 +619,30 @@ func (db *RockDB) HValues(key []byte) (int64, chan common.KVRecordRet, error) {
 		return 0, nil, err
 	}
 
-	len, err := db.HLen(key)
+	hlen, err := db.hSize(key)
 	if err != nil {
 		return 0, nil, err
 	}
-	if len >= MAX_BATCH_NUM {
-		return len, nil, errTooMuchBatchSize
+	if hlen >= MAX_BATCH_NUM {
+		return hlen, nil, errTooMuchBatchSize
 	}
+	expired := db.hExpiredFields(key, nowMs())
+	hlen -= int64(len(expired))
 
 	v := make(chan common.KVRecordRet, 16)
 
 	go func() {
 		start := hEncodeStartKey(key)
 		stop := hEncodeStopKey(key)
-		it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+		it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 		defer it.Close()
 		defer close(v)
 		for ; it.Valid(); it.Next() {
+			if expired != nil {
+				if _, f, err := hDecodeHashKey(it.Key()); err == nil && expired[string(f)] {
+					continue
+				}
+			}
 			v <- common.KVRecordRet{
 				common.KVRecord{Key: nil, Value: it.Value()},
 				nil,
@@ -501,5 +650,5 @@ func (db *RockDB) HValues(key []byte) (int64, chan common.KVRecordRet, error) {
 		}
 	}()
 
-	return len, v, nil
+	return hlen, v, nil
 }
@@ -0,0 +1,549 @@
+package rockredis
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+var (
+	errHFieldExpKey     = errors.New("invalid hash field expire key")
+	errHFieldExpTimeKey = errors.New("invalid hash field expire time key")
+)
+
+const (
+	// the field not exist
+	HFieldNotExist int64 = -2
+	// the field has no expire time
+	HFieldNoExpire int64 = -1
+)
+
+func hEncodeFieldExpKey(key []byte, field []byte) []byte {
+	buf := hEncodeHashKey(key, field)
+	buf[0] = HFieldExpType
+	return buf
+}
+
+func hDecodeFieldExpKey(ek []byte) ([]byte, []byte, error) {
+	if len(ek) == 0 || ek[0] != HFieldExpType {
+		return nil, nil, errHFieldExpKey
+	}
+	buf := make([]byte, len(ek))
+	copy(buf, ek)
+	buf[0] = HashType
+	return hDecodeHashKey(buf)
+}
+
+func hEncodeFieldExpStartKey(key []byte) []byte {
+	return hEncodeFieldExpKey(key, nil)
+}
+
+func hEncodeFieldExpStopKey(key []byte) []byte {
+	k := hEncodeFieldExpKey(key, nil)
+	k[len(k)-1] = hashStopSep
+	return k
+}
+
+func hEncodeFieldExpTimeKey(when int64, key []byte, field []byte) []byte {
+	buf := make([]byte, 1+8+2+len(key)+len(field))
+	pos := 0
+	buf[pos] = HFieldExpTimeType
+	pos++
+	binary.BigEndian.PutUint64(buf[pos:], uint64(when))
+	pos += 8
+	binary.BigEndian.PutUint16(buf[pos:], uint16(len(key)))
+	pos += 2
+	copy(buf[pos:], key)
+	pos += len(key)
+	copy(buf[pos:], field)
+	return buf
+}
+
+func hDecodeFieldExpTimeKey(ek []byte) (int64, []byte, []byte, error) {
+	pos := 0
+	if pos+1+8+2 > len(ek) || ek[pos] != HFieldExpTimeType {
+		return 0, nil, nil, errHFieldExpTimeKey
+	}
+	pos++
+	when := int64(binary.BigEndian.Uint64(ek[pos:]))
+	pos += 8
+	keyLen := int(binary.BigEndian.Uint16(ek[pos:]))
+	pos += 2
+	if pos+keyLen > len(ek) {
+		return 0, nil, nil, errHFieldExpTimeKey
+	}
+	key := ek[pos : pos+keyLen]
+	pos += keyLen
+	return when, key, ek[pos:], nil
+}
+
+func hEncodeFieldExpTimeStartKey() []byte {
+	return []byte{HFieldExpTimeType}
+}
+
+func hEncodeFieldExpTimeStopKey(when int64) []byte {
+	buf := make([]byte, 1+8)
+	buf[0] = HFieldExpTimeType
+	binary.BigEndian.PutUint64(buf[1:], uint64(when))
+	return buf
+}
+
+// the number and the expire time sum of the hash fields with expire time,
+// which is loaded while the db opened or restored and updated by the writes.
+type fieldExpireStats struct {
+	num     int64
+	whenSum int64
+}
+
+// the expire time changes in one write batch, only the first change of the
+// field is counted since the later reads can not see the change in the batch.
+type fieldExpireDelta struct {
+	changed map[string]bool
+	num     int64
+	whenSum int64
+}
+
+func newFieldExpireDelta() *fieldExpireDelta {
+	return &fieldExpireDelta{changed: make(map[string]bool)}
+}
+
+func (d *fieldExpireDelta) change(key []byte, field []byte, old int64, when int64) {
+	k := string(hEncodeFieldExpKey(key, field))
+	if d.changed[k] {
+		return
+	}
+	d.changed[k] = true
+	if old > 0 {
+		d.num--
+		d.whenSum -= old
+	}
+	if when > 0 {
+		d.num++
+		d.whenSum += when
+	}
+}
+
+// apply the changes after the write batch is written
+func (db *RockDB) applyFieldExpireDelta(d *fieldExpireDelta) {
+	if d.num != 0 {
+		atomic.AddInt64(&db.fieldExp.num, d.num)
+	}
+	if d.whenSum != 0 {
+		atomic.AddInt64(&db.fieldExp.whenSum, d.whenSum)
+	}
+}
+
+func (db *RockDB) loadFieldExpireStats() {
+	start := hEncodeFieldExpTimeStartKey()
+	stop := prefixRangeStop(start)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
+	defer it.Close()
+	var num, whenSum int64
+	for ; it.Valid(); it.Next() {
+		when, _, _, err := hDecodeFieldExpTimeKey(it.Key())
+		if err != nil {
+			continue
+		}
+		num++
+		whenSum += when
+	}
+	atomic.StoreInt64(&db.fieldExp.num, num)
+	atomic.StoreInt64(&db.fieldExp.whenSum, whenSum)
+}
+
+// GetFieldExpireStats return the number of the hash fields with expire time
+// and the average time to live (in milliseconds) of them. The expired fields
+// not deleted yet are also counted.
+func (db *RockDB) GetFieldExpireStats() (int64, int64) {
+	num := atomic.LoadInt64(&db.fieldExp.num)
+	if num <= 0 {
+		return 0, 0
+	}
+	avgTTL := atomic.LoadInt64(&db.fieldExp.whenSum)/num - nowMs()
+	if avgTTL < 0 {
+		avgTTL = 0
+	}
+	return num, avgTTL
+}
+
+func nowMs() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// return the expire time (unix time in milliseconds) of the field, 0 if no expire
+func (db *RockDB) hGetFieldExpire(key []byte, field []byte) (int64, error) {
+	v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeFieldExpKey(key, field))
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return Int64(v, err)
+}
+
+func (db *RockDB) hSetFieldExpire(key []byte, field []byte, when int64, wb *gorocksdb.WriteBatch,
+	d *fieldExpireDelta) error {
+	old, err := db.hGetFieldExpire(key, field)
+	if err != nil {
+		return err
+	}
+	d.change(key, field, old, when)
+	if old > 0 {
+		wb.Delete(hEncodeFieldExpTimeKey(old, key, field))
+	}
+	wb.Put(hEncodeFieldExpKey(key, field), PutInt64(when))
+	wb.Put(hEncodeFieldExpTimeKey(when, key, field), nil)
+	return nil
+}
+
+// remove the expire time for the field, return true if the field has expire time
+func (db *RockDB) hDelFieldExpire(key []byte, field []byte, wb *gorocksdb.WriteBatch,
+	d *fieldExpireDelta) (bool, error) {
+	old, err := db.hGetFieldExpire(key, field)
+	if err != nil {
+		return false, err
+	}
+	if old <= 0 {
+		return false, nil
+	}
+	d.change(key, field, old, 0)
+	wb.Delete(hEncodeFieldExpKey(key, field))
+	wb.Delete(hEncodeFieldExpTimeKey(old, key, field))
+	return true, nil
+}
+
+func (db *RockDB) hDelAllFieldExpire(key []byte, wb *gorocksdb.WriteBatch, d *fieldExpireDelta) {
+	start := hEncodeFieldExpStartKey(key)
+	stop := hEncodeFieldExpStopKey(key)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		_, field, err := hDecodeFieldExpKey(it.Key())
+		if err != nil {
+			continue
+		}
+		when, _ := Int64(it.Value(), nil)
+		d.change(key, field, when, 0)
+		wb.Delete(it.Key())
+		wb.Delete(hEncodeFieldExpTimeKey(when, key, field))
+	}
+}
+
+func (db *RockDB) hIsFieldExpired(key []byte, field []byte, now int64) bool {
+	when, err := db.hGetFieldExpire(key, field)
+	if err != nil {
+		return false
+	}
+	return when > 0 && when <= now
+}
+
+// return all the expired fields of the hash at the given time
+func (db *RockDB) hExpiredFields(key []byte, now int64) map[string]bool {
+	start := hEncodeFieldExpStartKey(key)
+	stop := hEncodeFieldExpStopKey(key)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
+	defer it.Close()
+	var expired map[string]bool
+	for ; it.Valid(); it.Next() {
+		when, _ := Int64(it.Value(), nil)
+		if when <= 0 || when > now {
+			continue
+		}
+		_, field, err := hDecodeFieldExpKey(it.Key())
+		if err != nil {
+			continue
+		}
+		if expired == nil {
+			expired = make(map[string]bool)
+		}
+		expired[string(field)] = true
+	}
+	return expired
+}
+
+// ExpireCond is the condition of setting the expire time, the same as the
+// NX, XX, GT and LT options of redis.
+type ExpireCond int
+
+const (
+	ExpireAlways ExpireCond = iota
+	// only if no expire time
+	ExpireNX
+	// only if has expire time
+	ExpireXX
+	// only if the new expire time is greater, no expire time is infinite
+	ExpireGT
+	// only if the new expire time is less, no expire time is infinite
+	ExpireLT
+)
+
+// whether the expire time can be changed from the old, 0 if no expire time.
+func (c ExpireCond) allow(old int64, when int64) bool {
+	switch c {
+	case ExpireNX:
+		return old == 0
+	case ExpireXX:
+		return old > 0
+	case ExpireGT:
+		return old > 0 && when > old
+	case ExpireLT:
+		return old == 0 || when < old
+	}
+	return true
+}
+
+// HExpireAt set the expire time (unix time in milliseconds) for the fields.
+// For each field, return HFieldNotExist if the field not exist, otherwise return 1.
+// The expired fields will be hidden from read and deleted later by HDelExpiredFields
+func (db *RockDB) HExpireAt(key []byte, when int64, fields ...[]byte) ([]int64, error) {
+	return db.HExpireAtCond(key, when, ExpireAlways, fields...)
+}
+
+// HExpireAtCond is the same as HExpireAt, but return 0 for the field if the
+// condition is not met.
+func (db *RockDB) HExpireAtCond(key []byte, when int64, cond ExpireCond, fields ...[]byte) ([]int64, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	if when <= 0 {
+		return nil, common.ErrInvalidArgs
+	}
+	wb := db.wb
+	wb.Clear()
+	d := newFieldExpireDelta()
+	ret := make([]int64, len(fields))
+	for i, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return nil, err
+		}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			ret[i] = HFieldNotExist
+			continue
+		}
+		if cond != ExpireAlways {
+			old, err := db.hGetFieldExpire(key, field)
+			if err != nil {
+				return nil, err
+			}
+			if !cond.allow(old, when) {
+				ret[i] = 0
+				continue
+			}
+		}
+		if err := db.hSetFieldExpire(key, field, when, wb, d); err != nil {
+			return nil, err
+		}
+		ret[i] = 1
+	}
+	err := db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
+	return ret, err
+}
+
+// HPersist remove the expire time of the fields.
+// For each field, return HFieldNotExist if the field not exist, HFieldNoExpire if the field
+// has no expire time, otherwise return 1.
+func (db *RockDB) HPersist(key []byte, fields ...[]byte) ([]int64, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	wb := db.wb
+	wb.Clear()
+	d := newFieldExpireDelta()
+	ret := make([]int64, len(fields))
+	for i, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return nil, err
+		}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			ret[i] = HFieldNotExist
+			continue
+		}
+		removed, err := db.hDelFieldExpire(key, field, wb, d)
+		if err != nil {
+			return nil, err
+		}
+		if removed {
+			ret[i] = 1
+		} else {
+			ret[i] = HFieldNoExpire
+		}
+	}
+	err := db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
+	return ret, err
+}
+
+// HGetEx return the values of the fields and change the expire time of the
+// returned fields in the same write batch. The expire time is set to when
+// (unix time in milliseconds) if positive, removed if negative and not
+// changed if 0. The value is nil if the field not exist or expired at now.
+func (db *RockDB) HGetEx(key []byte, now int64, when int64, fields ...[]byte) ([][]byte, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	wb := db.wb
+	wb.Clear()
+	d := newFieldExpireDelta()
+	ret := make([][]byte, len(fields))
+	for i, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return nil, err
+		}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil || db.hIsFieldExpired(key, field, now) {
+			continue
+		}
+		ret[i] = v
+		if when > 0 {
+			err = db.hSetFieldExpire(key, field, when, wb, d)
+		} else if when < 0 {
+			_, err = db.hDelFieldExpire(key, field, wb, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if when == 0 {
+		return ret, nil
+	}
+	err := db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
+	return ret, err
+}
+
+// HFieldTTL return the remaining time to live (in milliseconds) of the fields.
+// For each field, return HFieldNotExist if the field not exist (or expired), HFieldNoExpire if
+// the field has no expire time.
+func (db *RockDB) HFieldTTL(key []byte, fields ...[]byte) ([]int64, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return nil, errTooMuchBatchSize
+	}
+	now := nowMs()
+	ret := make([]int64, len(fields))
+	for i, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return nil, err
+		}
+		v, err := db.eng.GetBytes(db.defaultReadOpts, hEncodeHashKey(key, field))
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			ret[i] = HFieldNotExist
+			continue
+		}
+		when, err := db.hGetFieldExpire(key, field)
+		if err != nil {
+			return nil, err
+		}
+		if when <= 0 {
+			ret[i] = HFieldNoExpire
+		} else if when <= now {
+			ret[i] = HFieldNotExist
+		} else {
+			ret[i] = when - now
+		}
+	}
+	return ret, nil
+}
+
+// ScanExpiredHashFields return at most limit hash fields which expired before the given time.
+// The Key of the returned record is the hash key and the Value is the field.
+func (db *RockDB) ScanExpiredHashFields(now int64, limit int) ([]common.KVRecord, error) {
+	start := hEncodeFieldExpTimeStartKey()
+	stop := hEncodeFieldExpTimeStopKey(now)
+	it := db.newRangeLimitIterator(start, stop, common.RangeClose, 0, limit, false)
+	defer it.Close()
+	ret := make([]common.KVRecord, 0)
+	for ; it.Valid(); it.Next() {
+		when, key, field, err := hDecodeFieldExpTimeKey(it.Key())
+		if err != nil {
+			return ret, err
+		}
+		if when > now {
+			break
+		}
+		ret = append(ret, common.KVRecord{Key: key, Value: field})
+	}
+	return ret, nil
+}
+
+// HDelExpiredFields delete the fields which expired before the given time,
+// the fields not expired (maybe changed after scanned) will be ignored.
+// Since the time is given in the command, this can be applied deterministically
+// on all the replicas.
+func (db *RockDB) HDelExpiredFields(key []byte, now int64, fields ...[]byte) (int64, error) {
+	if len(fields) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	table := extractTableFromRedisKey(key)
+	if len(table) == 0 {
+		return 0, errTableName
+	}
+	wb := db.wb
+	wb.Clear()
+	d := newFieldExpireDelta()
+	var num int64
+	for _, field := range fields {
+		if err := checkHashKFSize(key, field); err != nil {
+			return 0, err
+		}
+		when, err := db.hGetFieldExpire(key, field)
+		if err != nil {
+			return 0, err
+		}
+		if when <= 0 || when > now {
+			continue
+		}
+		d.change(key, field, when, 0)
+		wb.Delete(hEncodeFieldExpKey(key, field))
+		wb.Delete(hEncodeFieldExpTimeKey(when, key, field))
+		ek := hEncodeHashKey(key, field)
+		v, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+		if err != nil {
+			return 0, err
+		}
+		if v != nil {
+			num++
+			wb.Delete(ek)
+		}
+	}
+	if num > 0 {
+		if newNum, err := db.hIncrSize(key, -num, wb); err != nil {
+			return 0, err
+		} else if newNum == 0 {
+			_, err = db.IncrTableKeyCount(table, -1, wb)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	err := db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
+	return num, err
+}
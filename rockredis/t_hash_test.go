@@ -165,12 +165,417 @@ func TestHashKeyIncrBy(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	r, _ := db.HIncrBy(key, []byte("hello"), 3)
+	r, _ := db.HIncrBy(key, []byte("hello"), 3, nowMs())
 	if r != 3 {
 		t.Error(r)
 	}
-	r, _ = db.HIncrBy(key, []byte("hello"), -6)
+	r, _ = db.HIncrBy(key, []byte("hello"), -6, nowMs())
 	if r != -3 {
 		t.Error(r)
 	}
+
+	// the field keeps the expire time before expired
+	now := nowMs()
+	if _, err := db.HExpireAt(key, now+1000, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := db.HIncrBy(key, []byte("hello"), 1, now); err != nil {
+		t.Fatal(err)
+	} else if r != -2 {
+		t.Error(r)
+	}
+	if when, _ := db.hGetFieldExpire(key, []byte("hello")); when != now+1000 {
+		t.Fatalf("the expire time should be kept: %v", when)
+	}
+	// the expired field is increased from 0 and persisted
+	if r, err := db.HIncrBy(key, []byte("hello"), 5, now+1000); err != nil {
+		t.Fatal(err)
+	} else if r != 5 {
+		t.Errorf("the expired field should be increased from 0: %v", r)
+	}
+	if when, _ := db.hGetFieldExpire(key, []byte("hello")); when != 0 {
+		t.Fatalf("the expire time of the expired field should be removed: %v", when)
+	}
+	if n, _ := db.HLen(key); n != 1 {
+		t.Fatalf("the hash length mismatch: %v", n)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 0 {
+		t.Fatalf("the fields with expire time mismatch: %v", num)
+	}
+}
+
+func TestHashFieldExpire(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+
+	key := []byte("test:testdb_hash_field_expire")
+	if err := db.HMset(key, common.KVRecord{Key: []byte("a"), Value: []byte("1")},
+		common.KVRecord{Key: []byte("b"), Value: []byte("2")},
+		common.KVRecord{Key: []byte("c"), Value: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	now := nowMs()
+	ret, err := db.HExpireAt(key, now-1, []byte("a"), []byte("nofield"))
+	if err != nil {
+		t.Fatal(err)
+	} else if ret[0] != 1 || ret[1] != HFieldNotExist {
+		t.Fatal(ret)
+	}
+	ret, err = db.HExpireAt(key, now+100000, []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	} else if ret[0] != 1 {
+		t.Fatal(ret)
+	}
+
+	if v, err := db.HGet(key, []byte("a")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("expired field should not be returned: %v", string(v))
+	}
+	n, ch, err := db.HGetAll(key)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	for v := range ch {
+		if string(v.Rec.Key) == "a" {
+			t.Fatal("expired field should not be returned in hgetall")
+		}
+	}
+	n, ch, err = db.HKeys(key)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	for v := range ch {
+		if string(v.Rec.Key) == "a" {
+			t.Fatal("expired field should not be returned in hkeys")
+		}
+	}
+
+	ttls, err := db.HFieldTTL(key, []byte("a"), []byte("b"), []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	} else if ttls[0] != HFieldNotExist || ttls[1] <= 0 || ttls[2] != HFieldNoExpire {
+		t.Fatal(ttls)
+	}
+
+	expired, err := db.ScanExpiredHashFields(nowMs(), 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 1 {
+		t.Fatal(expired)
+	} else if string(expired[0].Key) != string(key) || string(expired[0].Value) != "a" {
+		t.Fatal(expired)
+	}
+	// the expired field not deleted yet is not counted
+	if n, err := db.HLen(key); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	// the not expired field should be ignored while deleting
+	if n, err := db.HDelExpiredFields(key, nowMs(), []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := db.HLen(key); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	if expired, err := db.ScanExpiredHashFields(nowMs(), 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+
+	if ret, err := db.HPersist(key, []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	} else if ret[0] != 1 || ret[1] != HFieldNoExpire {
+		t.Fatal(ret)
+	}
+	// set field should clear the expire time
+	if _, err := db.HExpireAt(key, now+100000, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet(key, []byte("c"), []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if ttls, err := db.HFieldTTL(key, []byte("c")); err != nil {
+		t.Fatal(err)
+	} else if ttls[0] != HFieldNoExpire {
+		t.Fatal(ttls)
+	}
+	if _, err := db.HExpireAt(key, now+100000, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HClear(key); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := db.ScanExpiredHashFields(now+200000, 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+}
+
+func TestHashFieldExpireCond(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+
+	key := []byte("test:testdb_hash_field_expire_cond")
+	if err := db.HMset(key, common.KVRecord{Key: []byte("ttl"), Value: []byte("1")},
+		common.KVRecord{Key: []byte("nottl"), Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	when := nowMs() + 100000
+	if _, err := db.HExpireAt(key, when, []byte("ttl")); err != nil {
+		t.Fatal(err)
+	}
+	fieldExpire := func(field string) int64 {
+		v, err := db.hGetFieldExpire(key, []byte(field))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		cond     ExpireCond
+		when     int64
+		expected []int64
+	}{
+		{ExpireNX, when + 1000, []int64{0, 1, HFieldNotExist}},
+		{ExpireXX, when + 1000, []int64{1, 0, HFieldNotExist}},
+		// no expire time is infinite for GT and LT
+		{ExpireGT, when + 2000, []int64{1, 0, HFieldNotExist}},
+		{ExpireGT, when, []int64{0, 0, HFieldNotExist}},
+		{ExpireLT, when, []int64{1, 1, HFieldNotExist}},
+		{ExpireLT, when + 2000, []int64{0, 1, HFieldNotExist}},
+	}
+	for _, tt := range tests {
+		if _, err := db.HPersist(key, []byte("nottl")); err != nil {
+			t.Fatal(err)
+		}
+		oldTTL := fieldExpire("ttl")
+		ret, err := db.HExpireAtCond(key, tt.when, tt.cond, []byte("ttl"), []byte("nottl"), []byte("nofield"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range tt.expected {
+			if ret[i] != v {
+				t.Fatalf("cond %v at %v should return %v: %v", tt.cond, tt.when, tt.expected, ret)
+			}
+		}
+		if ret[0] == 1 && fieldExpire("ttl") != tt.when {
+			t.Fatalf("the expire time should be changed: %v", fieldExpire("ttl"))
+		} else if ret[0] == 0 && fieldExpire("ttl") != oldTTL {
+			t.Fatalf("the skipped expire time should be unchanged: %v", fieldExpire("ttl"))
+		}
+		if ret[1] == 0 && fieldExpire("nottl") != 0 {
+			t.Fatalf("the skipped field should have no expire time: %v", fieldExpire("nottl"))
+		}
+	}
+}
+
+func TestHashFieldExpireStats(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+
+	key := []byte("test:testdb_hash_field_expire_stats")
+	if err := db.HMset(key, common.KVRecord{Key: []byte("a"), Value: []byte("1")},
+		common.KVRecord{Key: []byte("b"), Value: []byte("2")},
+		common.KVRecord{Key: []byte("c"), Value: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	if num, avgTTL := db.GetFieldExpireStats(); num != 0 || avgTTL != 0 {
+		t.Fatalf("no field should have expire time: %v, %v", num, avgTTL)
+	}
+	now := nowMs()
+	// the duplicate field should be counted once
+	if _, err := db.HExpireAt(key, now+100000, []byte("a"), []byte("b"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HExpireAt(key, now+300000, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	num, avgTTL := db.GetFieldExpireStats()
+	if num != 3 {
+		t.Fatalf("the fields with expire time mismatch: %v", num)
+	}
+	if avgTTL <= 100000 || avgTTL > 500000/3 {
+		t.Fatalf("the average ttl mismatch: %v", avgTTL)
+	}
+	// change the expire time should not change the number
+	if _, err := db.HExpireAt(key, now-1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 3 {
+		t.Fatalf("the fields with expire time mismatch: %v", num)
+	}
+	if n, err := db.HDelExpiredFields(key, nowMs(), []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 2 {
+		t.Fatalf("the deleted expired field should not be counted: %v", num)
+	}
+	if _, err := db.HPersist(key, []byte("b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 1 {
+		t.Fatalf("the persisted field should not be counted: %v", num)
+	}
+
+	// the stats should be loaded after reopen
+	db.Close()
+	db, err := OpenRockDB(db.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if num, _ := db.GetFieldExpireStats(); num != 1 {
+		t.Fatalf("the loaded stats mismatch: %v", num)
+	}
+	if _, err := db.HClear(key); err != nil {
+		t.Fatal(err)
+	}
+	if num, avgTTL := db.GetFieldExpireStats(); num != 0 || avgTTL != 0 {
+		t.Fatalf("the cleared fields should not be counted: %v, %v", num, avgTTL)
+	}
+}
+
+func TestHashScanExcludeExpired(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	keys := [][]byte{[]byte("test:scan_expired_a"), []byte("test:scan_expired_b"),
+		[]byte("test:scan_expired_c")}
+	for _, key := range keys {
+		if err := db.HMset(key, common.KVRecord{Key: []byte("f1"), Value: []byte("1")},
+			common.KVRecord{Key: []byte("f2"), Value: []byte("2")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := nowMs()
+	// all the fields of a expired and only part of the fields of b expired
+	if _, err := db.HExpireAt(keys[0], now-1, []byte("f1"), []byte("f2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HExpireAt(keys[1], now-1, []byte("f1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HExpireAt(keys[2], now+100000, []byte("f1"), []byte("f2")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := db.Scan(common.HASH, []byte("test:scan_expired"), 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 || string(v[0]) != string(keys[1]) || string(v[1]) != string(keys[2]) {
+		t.Fatalf("the expired hash should not be scanned: %q", v)
+	}
+	recs, err := db.HScan(keys[1], nil, 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || string(recs[0].Key) != "f2" {
+		t.Fatalf("the expired field should not be scanned: %v", recs)
+	}
+}
+
+func TestHashGetDelAndGetEx(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:testdb_hash_getdel")
+	if err := db.HMset(key, common.KVRecord{Key: []byte("a"), Value: []byte("1")},
+		common.KVRecord{Key: []byte("b"), Value: []byte("2")},
+		common.KVRecord{Key: []byte("c"), Value: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	now := nowMs()
+	if _, err := db.HExpireAt(key, now-1, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	// the expired field is deleted but not returned
+	v, err := db.HGetDel(key, now, []byte("a"), []byte("a"), []byte("c"), []byte("nofield"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 4 || string(v[0]) != "1" || v[1] != nil || v[2] != nil || v[3] != nil {
+		t.Fatalf("hgetdel values mismatch: %q", v)
+	}
+	if n, err := db.HLen(key); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if expired, err := db.ScanExpiredHashFields(nowMs(), 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+
+	v, err = db.HGetEx(key, now, now+100000, []byte("b"), []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(v[0]) != "2" || v[1] != nil {
+		t.Fatalf("hgetex values mismatch: %q", v)
+	}
+	if ttls, err := db.HFieldTTL(key, []byte("b")); err != nil {
+		t.Fatal(err)
+	} else if ttls[0] <= 0 {
+		t.Fatal(ttls)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 1 {
+		t.Fatal(num)
+	}
+	// the field expired at the given time is neither returned nor changed
+	v, err = db.HGetEx(key, now+200000, -1, []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	} else if v[0] != nil {
+		t.Fatalf("hgetex values mismatch: %q", v)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 1 {
+		t.Fatal(num)
+	}
+	v, err = db.HGetEx(key, now, -1, []byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(v[0]) != "2" {
+		t.Fatalf("hgetex values mismatch: %q", v)
+	}
+	if ttls, err := db.HFieldTTL(key, []byte("b")); err != nil {
+		t.Fatal(err)
+	} else if ttls[0] != HFieldNoExpire {
+		t.Fatal(ttls)
+	}
+	if num, _ := db.GetFieldExpireStats(); num != 0 {
+		t.Fatal(num)
+	}
+
+	if v, err = db.HGetDel(key, now, []byte("b")); err != nil {
+		t.Fatal(err)
+	} else if string(v[0]) != "2" {
+		t.Fatalf("hgetdel values mismatch: %q", v)
+	}
+	if n, err := db.HKeyExists(key); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("the hash should be removed after all the fields deleted")
+	}
+	if num, err := db.GetTableKeyCount([]byte("test")); err != nil {
+		t.Fatal(err)
+	} else if num != 0 {
+		t.Fatal(num)
+	}
 }
@@ -0,0 +1,258 @@
+package rockredis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// The HyperLogLog is stored as a kv string value with a header (the magic
+// and the encoding) and the registers. The sparse encoding only store the
+// non-zero registers as (index, value) pairs, and the dense encoding store
+// all the registers one byte for each. The encoding is decided only by the
+// registers, so the stored value is the same on all the replicas.
+const (
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+	hllHeaderLen = 5
+
+	hllDense  byte = 0
+	hllSparse byte = 1
+	// the sparse pair is uint16 index and uint8 register value
+	hllSparsePairLen = 3
+	// switch to the dense encoding while the sparse is not smaller any more
+	hllSparseMaxRegs = hllRegisters / hllSparsePairLen
+)
+
+var (
+	hllMagic      = []byte("HYLL")
+	ErrInvalidHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+)
+
+func hllMix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// return the register index and the rank (the position of the first 1 bit) for the element
+func hllHashElem(elem []byte) (int, uint8) {
+	h := fnv.New64a()
+	h.Write(elem)
+	x := hllMix64(h.Sum64())
+	index := int(x & (hllRegisters - 1))
+	x >>= hllPrecision
+	// make sure the loop will stop
+	x |= 1 << (64 - hllPrecision)
+	rank := uint8(1)
+	for x&1 == 0 {
+		rank++
+		x >>= 1
+	}
+	return index, rank
+}
+
+// decode the stored value to the dense registers, nil value will be decoded as empty registers
+func hllDecode(v []byte) ([]byte, error) {
+	regs := make([]byte, hllRegisters)
+	if v == nil {
+		return regs, nil
+	}
+	if len(v) < hllHeaderLen || !bytes.Equal(v[:len(hllMagic)], hllMagic) {
+		return nil, ErrInvalidHLL
+	}
+	body := v[hllHeaderLen:]
+	switch v[len(hllMagic)] {
+	case hllDense:
+		if len(body) != hllRegisters {
+			return nil, ErrInvalidHLL
+		}
+		copy(regs, body)
+	case hllSparse:
+		if len(body)%hllSparsePairLen != 0 {
+			return nil, ErrInvalidHLL
+		}
+		last := -1
+		for pos := 0; pos < len(body); pos += hllSparsePairLen {
+			index := int(binary.BigEndian.Uint16(body[pos:]))
+			if index >= hllRegisters || index <= last {
+				return nil, ErrInvalidHLL
+			}
+			regs[index] = body[pos+2]
+			last = index
+		}
+	default:
+		return nil, ErrInvalidHLL
+	}
+	return regs, nil
+}
+
+func hllEncode(regs []byte) []byte {
+	nonZero := 0
+	for _, r := range regs {
+		if r != 0 {
+			nonZero++
+		}
+	}
+	if nonZero > hllSparseMaxRegs {
+		buf := make([]byte, hllHeaderLen+hllRegisters)
+		copy(buf, hllMagic)
+		buf[len(hllMagic)] = hllDense
+		copy(buf[hllHeaderLen:], regs)
+		return buf
+	}
+	buf := make([]byte, hllHeaderLen+nonZero*hllSparsePairLen)
+	copy(buf, hllMagic)
+	buf[len(hllMagic)] = hllSparse
+	pos := hllHeaderLen
+	for i, r := range regs {
+		if r == 0 {
+			continue
+		}
+		binary.BigEndian.PutUint16(buf[pos:], uint16(i))
+		buf[pos+2] = r
+		pos += hllSparsePairLen
+	}
+	return buf
+}
+
+func hllEstimate(regs []byte) int64 {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// use the linear counting for the small cardinality
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(e + 0.5)
+}
+
+// merge the registers of src into dst by max, return whether dst is changed
+func hllMerge(dst []byte, src []byte) bool {
+	changed := false
+	for i, r := range src {
+		if r > dst[i] {
+			dst[i] = r
+			changed = true
+		}
+	}
+	return changed
+}
+
+// return the table, the encoded kv key, the stored value and the registers of the key
+func (db *RockDB) hllGet(key []byte) ([]byte, []byte, []byte, []byte, error) {
+	table, ek, err := convertRedisKeyToDBKVKey(key)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if db.isKVKeyDropped(ek) {
+		return table, ek, nil, make([]byte, hllRegisters), nil
+	}
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, ek)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	v, err := db.decodeKVValue(stored)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	regs, err := hllDecode(v)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return table, ek, stored, regs, nil
+}
+
+func (db *RockDB) hllPut(table []byte, ek []byte, stored []byte, regs []byte) error {
+	if db.isKVKeyDropped(ek) {
+		return ErrKeyPrefixDropping
+	}
+	db.wb.Clear()
+	if stored == nil {
+		if _, err := db.IncrTableKeyCount(table, 1, db.wb); err != nil {
+			return err
+		}
+	}
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(ek, dw.encodeValue(hllEncode(regs)))
+	if err := dw.flush(db.wb); err != nil {
+		return err
+	}
+	return db.writeBatch(db.wb)
+}
+
+// PFAdd add the elements to the HyperLogLog, return 1 if the
+// approximated cardinality is changed (or the key is created), otherwise 0.
+func (db *RockDB) PFAdd(key []byte, elems ...[]byte) (int64, error) {
+	if len(elems) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	table, ek, stored, regs, err := db.hllGet(key)
+	if err != nil {
+		return 0, err
+	}
+	changed := stored == nil
+	for _, elem := range elems {
+		index, rank := hllHashElem(elem)
+		if rank > regs[index] {
+			regs[index] = rank
+			changed = true
+		}
+	}
+	if !changed {
+		return 0, nil
+	}
+	if err := db.hllPut(table, ek, stored, regs); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// PFCount return the approximated cardinality of the union of all the keys.
+func (db *RockDB) PFCount(keys ...[]byte) (int64, error) {
+	if len(keys) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	union := make([]byte, hllRegisters)
+	for _, key := range keys {
+		_, _, _, regs, err := db.hllGet(key)
+		if err != nil {
+			return 0, err
+		}
+		hllMerge(union, regs)
+	}
+	return hllEstimate(union), nil
+}
+
+// PFMerge merge the registers of all the source keys into the dest key.
+func (db *RockDB) PFMerge(dest []byte, srcs ...[]byte) error {
+	if len(srcs) >= MAX_BATCH_NUM {
+		return errTooMuchBatchSize
+	}
+	table, ek, stored, regs, err := db.hllGet(dest)
+	if err != nil {
+		return err
+	}
+	for _, src := range srcs {
+		_, _, _, srcRegs, err := db.hllGet(src)
+		if err != nil {
+			return err
+		}
+		hllMerge(regs, srcRegs)
+	}
+	return db.hllPut(table, ek, stored, regs)
+}
@@ -0,0 +1,131 @@
+package rockredis
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func checkHLLError(t *testing.T, est int64, expected int64) {
+	if math.Abs(float64(est-expected)) > float64(expected)*0.01 {
+		t.Fatalf("the estimated cardinality %v is out of the error range of %v", est, expected)
+	}
+}
+
+func TestHLLCodec(t *testing.T) {
+	regs := make([]byte, hllRegisters)
+	regs[1] = 3
+	regs[100] = 5
+	v := hllEncode(regs)
+	if v[len(hllMagic)] != hllSparse {
+		t.Fatalf("should be sparse encoded: %v", v[len(hllMagic)])
+	}
+	if decoded, err := hllDecode(v); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded, regs) {
+		t.Fatal("sparse decoded registers mismatch")
+	}
+	for i := range regs {
+		regs[i] = byte(i%10 + 1)
+	}
+	v = hllEncode(regs)
+	if v[len(hllMagic)] != hllDense {
+		t.Fatalf("should be dense encoded: %v", v[len(hllMagic)])
+	}
+	if decoded, err := hllDecode(v); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(decoded, regs) {
+		t.Fatal("dense decoded registers mismatch")
+	}
+	if _, err := hllDecode([]byte("not a hll")); err != ErrInvalidHLL {
+		t.Fatalf("should be invalid hll: %v", err)
+	}
+}
+
+func TestDBHyperLogLog(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key1 := []byte("test:testdb_hll_a")
+	key2 := []byte("test:testdb_hll_b")
+	if n, err := db.PFCount(key1); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+	if n, err := db.PFAdd(key1, []byte("a")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := db.PFAdd(key1, []byte("a")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("add the same element should not change: %v", n)
+	}
+
+	batch := make([][]byte, 0, 1000)
+	for i := 0; i < 10000; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("elem_%d", i)))
+		if len(batch) == cap(batch) {
+			if _, err := db.PFAdd(key1, batch...); err != nil {
+				t.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+	for i := 5000; i < 15000; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("elem_%d", i)))
+		if len(batch) == cap(batch) {
+			if _, err := db.PFAdd(key2, batch...); err != nil {
+				t.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+	n1, err := db.PFCount(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the element "a" also added
+	checkHLLError(t, n1, 10001)
+	n2, err := db.PFCount(key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHLLError(t, n2, 10000)
+
+	union, err := db.PFCount(key1, key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHLLError(t, union, 15001)
+	dest := []byte("test:testdb_hll_merged")
+	if err := db.PFMerge(dest, key1, key2); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.PFCount(dest); err != nil {
+		t.Fatal(err)
+	} else if n != union {
+		t.Fatalf("the merged cardinality %v should be equal to the union %v", n, union)
+	}
+	if v, err := db.KVGet(dest); err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasPrefix(v, hllMagic) {
+		t.Fatalf("the hll should be stored as string value: %v", v[:hllHeaderLen])
+	}
+
+	strKey := []byte("test:testdb_hll_str")
+	if err := db.KVSet(strKey, []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PFAdd(strKey, []byte("a")); err != ErrInvalidHLL {
+		t.Fatalf("pfadd on the non hll value should fail: %v", err)
+	}
+	if _, err := db.PFCount(strKey); err != ErrInvalidHLL {
+		t.Fatalf("pfcount on the non hll value should fail: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package rockredis
 
 import (
+	"bytes"
 	"errors"
 	"github.com/absolute8511/ZanRedisDB/common"
 )
@@ -66,15 +67,16 @@ func encodeKVMaxKey() []byte {
 }
 
 func (db *RockDB) incr(key []byte, delta int64) (int64, error) {
-	table, key, err := convertRedisKeyToDBKVKey(key)
+	table, key, err := db.convertKVWriteKey(key)
 	if err != nil {
 		return 0, err
 	}
-	v, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, key)
 	created := false
-	if v == nil {
+	if stored == nil {
 		created = true
 	}
+	v, err := db.decodeKVValue(stored)
 	var n int64
 	n, err = StrInt64(v, err)
 	if err != nil {
@@ -82,12 +84,17 @@ func (db *RockDB) incr(key []byte, delta int64) (int64, error) {
 	}
 	db.wb.Clear()
 	n += delta
-	db.wb.Put(key, FormatInt64ToSlice(n))
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(key, dw.encodeValue(FormatInt64ToSlice(n)))
 	if created {
 		db.IncrTableKeyCount(table, 1, db.wb)
 	}
+	if err = dw.flush(db.wb); err != nil {
+		return 0, err
+	}
 
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	err = db.writeBatch(db.wb)
 	return n, err
 }
 
@@ -98,6 +105,10 @@ func (db *RockDB) KVDel(key []byte) error {
 	if err != nil {
 		return err
 	}
+	if db.isKVKeyDropped(key) {
+		// the key will be removed by the compaction
+		return nil
+	}
 	v, err := db.eng.GetBytes(db.defaultReadOpts, key)
 
 	db.wb.Clear()
@@ -105,7 +116,12 @@ func (db *RockDB) KVDel(key []byte) error {
 		db.IncrTableKeyCount(table, -1, db.wb)
 	}
 	db.wb.Delete(key)
-	return db.eng.Write(db.defaultWriteOpts, db.wb)
+	dw := db.newDedupWriter()
+	dw.releaseValue(v)
+	if err = dw.flush(db.wb); err != nil {
+		return err
+	}
+	return db.writeBatch(db.wb)
 }
 
 func (db *RockDB) Decr(key []byte) (int64, error) {
@@ -131,6 +147,9 @@ func (db *RockDB) KVExists(key []byte) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	if db.isKVKeyDropped(key) {
+		return 0, nil
+	}
 
 	var v []byte
 	v, err = db.eng.GetBytes(db.defaultReadOpts, key)
@@ -145,8 +164,11 @@ func (db *RockDB) KVGet(key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if db.isKVKeyDropped(key) {
+		return nil, nil
+	}
 
-	return db.eng.GetBytes(db.defaultReadOpts, key)
+	return db.kvGet(key)
 }
 
 func (db *RockDB) Incr(key []byte) (int64, error) {
@@ -170,10 +192,33 @@ func (db *RockDB) MGet(keys ...[]byte) ([][]byte, []error) {
 		}
 	}
 	db.eng.MultiGetBytes(db.defaultReadOpts, keyList, keyList, errs)
+	for i, k := range keys {
+		if errs[i] == nil && db.isKVKeyDropped(encodeKVKey(k)) {
+			keyList[i] = nil
+		}
+	}
+	if db.valueRef {
+		for i, v := range keyList {
+			if errs[i] != nil {
+				continue
+			}
+			keyList[i], errs[i] = db.decodeKVValue(v)
+		}
+	}
 	return keyList, errs
 }
 
 func (db *RockDB) MSet(args ...common.KVRecord) error {
+	return db.mset(false, args...)
+}
+
+// MSetOverwrite is the same as MSet, but the keys holding the other types are
+// deleted in the same batch, so the keys are overwritten atomically as MSET.
+func (db *RockDB) MSetOverwrite(args ...common.KVRecord) error {
+	return db.mset(true, args...)
+}
+
+func (db *RockDB) mset(overwrite bool, args ...common.KVRecord) error {
 	if len(args) == 0 {
 		return nil
 	}
@@ -189,55 +234,179 @@ func (db *RockDB) MSet(args ...common.KVRecord) error {
 	var value []byte
 	tableCnt := make(map[string]int)
 	var table []byte
+	dw := db.newDedupWriter()
+	d := newFieldExpireDelta()
+	// the value written before in this batch for the same key
+	written := make(map[string][]byte)
 	for i := 0; i < len(args); i++ {
-		table, key, err = convertRedisKeyToDBKVKey(args[i].Key)
+		table, key, err = db.convertKVWriteKey(args[i].Key)
 		if err != nil {
 			return err
 		} else if err = checkValueSize(args[i].Value); err != nil {
 			return err
 		}
-		value = args[i].Value
-		v, _ := db.eng.GetBytes(db.defaultReadOpts, key)
-		if v == nil {
-			n := tableCnt[string(table)]
-			n++
-			tableCnt[string(table)] = n
+		value = dw.encodeValue(args[i].Value)
+		if old, ok := written[string(key)]; ok {
+			dw.releaseValue(old)
+		} else {
+			cleared := false
+			if overwrite {
+				if cleared, err = db.clearOtherKVTypes(args[i].Key, wb, d); err != nil {
+					return err
+				}
+			}
+			v, _ := db.eng.GetBytes(db.defaultReadOpts, key)
+			if v == nil || cleared {
+				n := tableCnt[string(table)]
+				// the cleared key is still counted as the kv
+				if !cleared {
+					n++
+				}
+				tableCnt[string(table)] = n
+			}
+			dw.releaseValue(v)
 		}
+		written[string(key)] = value
 		wb.Put(key, value)
 	}
+	// the table count is put after the deletes of the cleared keys
 	for t, num := range tableCnt {
 		_, err = db.IncrTableKeyCount([]byte(t), int64(num), wb)
 		if err != nil {
 			return err
 		}
 	}
+	if err = dw.flush(wb); err != nil {
+		return err
+	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return err
 }
 
 func (db *RockDB) KVSet(key []byte, value []byte) error {
-	table, key, err := convertRedisKeyToDBKVKey(key)
+	return db.kvSet(key, value, false)
+}
+
+// KVSetOverwrite is the same as KVSet, but the key holding the other type is
+// deleted in the same batch, so the key is overwritten atomically as SET.
+func (db *RockDB) KVSetOverwrite(key []byte, value []byte) error {
+	return db.kvSet(key, value, true)
+}
+
+func (db *RockDB) kvSet(rawKey []byte, value []byte, overwrite bool) error {
+	table, key, err := db.convertKVWriteKey(rawKey)
 	if err != nil {
 		return err
 	} else if err = checkValueSize(value); err != nil {
 		return err
 	}
 	db.wb.Clear()
+	d := newFieldExpireDelta()
+	cleared := false
+	if overwrite {
+		if cleared, err = db.clearOtherKVTypes(rawKey, db.wb, d); err != nil {
+			return err
+		}
+	}
 	v, _ := db.eng.GetBytes(db.defaultReadOpts, key)
-	if v == nil {
-		_, err = db.IncrTableKeyCount(table, 1, db.wb)
+	if v == nil || cleared {
+		// the cleared key is still counted as the kv, and the count is put
+		// after the deletes
+		delta := int64(1)
+		if cleared {
+			delta = 0
+		}
+		_, err = db.IncrTableKeyCount(table, delta, db.wb)
 		if err != nil {
 			return err
 		}
 	}
-	db.wb.Put(key, value)
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	dw := db.newDedupWriter()
+	dw.releaseValue(v)
+	db.wb.Put(key, dw.encodeValue(value))
+	if err = dw.flush(db.wb); err != nil {
+		return err
+	}
+	err = db.writeBatch(db.wb)
+	if err == nil {
+		db.applyFieldExpireDelta(d)
+	}
 	return err
 }
 
+// KVCompareAndSet set the value only if the current value equals the expected value,
+// return 1 if the value is set, otherwise return 0.
+func (db *RockDB) KVCompareAndSet(key []byte, expected []byte, value []byte) (int64, error) {
+	_, key, err := db.convertKVWriteKey(key)
+	if err != nil {
+		return 0, err
+	} else if err := checkValueSize(value); err != nil {
+		return 0, err
+	}
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	if err != nil {
+		return 0, err
+	}
+	v, err := db.decodeKVValue(stored)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil || !bytes.Equal(v, expected) {
+		return 0, nil
+	}
+	db.wb.Clear()
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(key, dw.encodeValue(value))
+	if err = dw.flush(db.wb); err != nil {
+		return 0, err
+	}
+	err = db.writeBatch(db.wb)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// KVCompareAndDel delete the key only if the current value equals the expected value,
+// return 1 if the key is deleted, otherwise return 0.
+func (db *RockDB) KVCompareAndDel(key []byte, expected []byte) (int64, error) {
+	table, key, err := db.convertKVWriteKey(key)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	if err != nil {
+		return 0, err
+	}
+	v, err := db.decodeKVValue(stored)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil || !bytes.Equal(v, expected) {
+		return 0, nil
+	}
+	db.wb.Clear()
+	db.IncrTableKeyCount(table, -1, db.wb)
+	db.wb.Delete(key)
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	if err = dw.flush(db.wb); err != nil {
+		return 0, err
+	}
+	err = db.writeBatch(db.wb)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
 func (db *RockDB) SetNX(key []byte, value []byte) (int64, error) {
-	table, key, err := convertRedisKeyToDBKVKey(key)
+	table, key, err := db.convertKVWriteKey(key)
 	if err != nil {
 		return 0, err
 	} else if err := checkValueSize(value); err != nil {
@@ -257,8 +426,12 @@ func (db *RockDB) SetNX(key []byte, value []byte) (int64, error) {
 		if err != nil {
 			return 0, err
 		}
-		db.wb.Put(key, value)
-		err = db.eng.Write(db.defaultWriteOpts, db.wb)
+		dw := db.newDedupWriter()
+		db.wb.Put(key, dw.encodeValue(value))
+		if err = dw.flush(db.wb); err != nil {
+			return 0, err
+		}
+		err = db.writeBatch(db.wb)
 	}
 	return n, err
 }
@@ -268,19 +441,23 @@ func (db *RockDB) SetRange(key []byte, offset int, value []byte) (int64, error)
 		return 0, nil
 	}
 
-	table, key, err := convertRedisKeyToDBKVKey(key)
+	table, key, err := db.convertKVWriteKey(key)
 	if err != nil {
 		return 0, err
 	} else if len(value)+offset > MaxValueSize {
 		return 0, errValueSize
 	}
 
-	oldValue, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	if err != nil {
+		return 0, err
+	}
+	oldValue, err := db.decodeKVValue(stored)
 	if err != nil {
 		return 0, err
 	}
 	db.wb.Clear()
-	if oldValue == nil {
+	if stored == nil {
 		_, err = db.IncrTableKeyCount(table, 1, db.wb)
 		if err != nil {
 			return 0, err
@@ -292,9 +469,14 @@ func (db *RockDB) SetRange(key []byte, offset int, value []byte) (int64, error)
 		oldValue = append(oldValue, make([]byte, extra)...)
 	}
 	copy(oldValue[offset:], value)
-	db.wb.Put(key, oldValue)
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(key, dw.encodeValue(oldValue))
+	if err = dw.flush(db.wb); err != nil {
+		return 0, err
+	}
 
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	err = db.writeBatch(db.wb)
 
 	if err != nil {
 		return 0, err
@@ -356,12 +538,16 @@ func (db *RockDB) Append(key []byte, value []byte) (int64, error) {
 		return 0, nil
 	}
 
-	table, key, err := convertRedisKeyToDBKVKey(key)
+	table, key, err := db.convertKVWriteKey(key)
 	if err != nil {
 		return 0, err
 	}
 
-	oldValue, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	stored, err := db.eng.GetBytes(db.defaultReadOpts, key)
+	if err != nil {
+		return 0, err
+	}
+	oldValue, err := db.decodeKVValue(stored)
 	if err != nil {
 		return 0, err
 	}
@@ -370,7 +556,7 @@ func (db *RockDB) Append(key []byte, value []byte) (int64, error) {
 		return 0, errValueSize
 	}
 	db.wb.Clear()
-	if oldValue == nil {
+	if stored == nil {
 		_, err = db.IncrTableKeyCount(table, 1, db.wb)
 		if err != nil {
 			return 0, err
@@ -379,8 +565,13 @@ func (db *RockDB) Append(key []byte, value []byte) (int64, error) {
 
 	oldValue = append(oldValue, value...)
 
-	db.wb.Put(key, oldValue)
-	err = db.eng.Write(db.defaultWriteOpts, db.wb)
+	dw := db.newDedupWriter()
+	dw.releaseValue(stored)
+	db.wb.Put(key, dw.encodeValue(oldValue))
+	if err = dw.flush(db.wb); err != nil {
+		return 0, err
+	}
+	err = db.writeBatch(db.wb)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,172 @@
+package rockredis
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+var ErrImportKeyExists = errors.New("ERR the imported key already exists")
+
+// the max keys imported in one batch, the batch is ingested as a sst file so
+// it can be much larger than the write batch.
+const MaxImportBatchNum = 50000
+
+type importRecord struct {
+	table []byte
+	ek    []byte
+	value []byte
+}
+
+type importRecordSorter []importRecord
+
+func (self importRecordSorter) Len() int {
+	return len(self)
+}
+
+func (self importRecordSorter) Less(i, j int) bool {
+	return bytes.Compare(self[i].ek, self[j].ek) < 0
+}
+
+func (self importRecordSorter) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+
+func (db *RockDB) GetImportDir() string {
+	return path.Join(db.cfg.DataDir, "import")
+}
+
+// KVImport write all the key values in one batch for the bulk loading, the
+// whole batch is refused if any key exists unless force. The batch is written
+// to a sst file with the updated table key counts and ingested atomically, so
+// the keys are not written through the memtable. The values stored with the
+// reference header need the reference counts updated, so they are written in
+// the write batch and limited by the write batch size. Return the number of the imported keys.
+func (db *RockDB) KVImport(force bool, args ...common.KVRecord) (int64, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if len(args) > MaxImportBatchNum {
+		return 0, errTooMuchBatchSize
+	}
+	if db.valueRef {
+		return db.kvImportBatch(force, args...)
+	}
+	recs := make(importRecordSorter, 0, len(args))
+	for _, kv := range args {
+		table, ek, err := db.convertKVWriteKey(kv.Key)
+		if err != nil {
+			return 0, err
+		}
+		if err := checkValueSize(kv.Value); err != nil {
+			return 0, err
+		}
+		recs = append(recs, importRecord{table: table, ek: ek, value: kv.Value})
+	}
+	// the keys in the sst file should be sorted and unique, the last value
+	// is imported for the same key
+	sort.Stable(recs)
+	uniq := recs[:0]
+	for _, r := range recs {
+		if len(uniq) > 0 && bytes.Equal(uniq[len(uniq)-1].ek, r.ek) {
+			uniq[len(uniq)-1] = r
+		} else {
+			uniq = append(uniq, r)
+		}
+	}
+	recs = uniq
+
+	eks := make([][]byte, len(recs))
+	for i, r := range recs {
+		eks[i] = r.ek
+	}
+	vals := make([][]byte, len(eks))
+	errs := make([]error, len(eks))
+	db.eng.MultiGetBytes(db.defaultReadOpts, eks, vals, errs)
+	tableCnt := make(map[string]int64)
+	for i, r := range recs {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		if vals[i] != nil {
+			if !force {
+				return 0, ErrImportKeyExists
+			}
+			continue
+		}
+		tableCnt[string(r.table)]++
+	}
+	if err := db.ingestImport(recs, tableCnt); err != nil {
+		return 0, err
+	}
+	return int64(len(args)), nil
+}
+
+// write the table key counts and the sorted records to a sst file and ingest
+// it, the table meta keys are sorted before all the kv keys.
+func (db *RockDB) ingestImport(recs importRecordSorter, tableCnt map[string]int64) error {
+	tables := make([]string, 0, len(tableCnt))
+	for t := range tableCnt {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	os.MkdirAll(db.GetImportDir(), common.DIR_PERM)
+	f := path.Join(db.GetImportDir(), "import-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".sst")
+	defer os.Remove(f)
+	envOpts := gorocksdb.NewDefaultEnvOptions()
+	defer envOpts.Destroy()
+	w := gorocksdb.NewSSTFileWriter(envOpts, db.dbOpts)
+	defer w.Destroy()
+	if err := w.Open(f); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		n, err := db.GetTableKeyCount([]byte(t))
+		if err != nil {
+			return err
+		}
+		if err := w.Add(encodeTableMetaKey([]byte(t)), PutInt64(n+tableCnt[t])); err != nil {
+			return err
+		}
+	}
+	for _, r := range recs {
+		if err := w.Add(r.ek, r.value); err != nil {
+			return err
+		}
+	}
+	if err := w.Finish(); err != nil {
+		return err
+	}
+	opts := gorocksdb.NewDefaultIngestExternalFileOptions()
+	defer opts.Destroy()
+	opts.SetMoveFiles(true)
+	return db.doWrite(func() error {
+		return db.eng.IngestExternalFile([]string{f}, opts)
+	})
+}
+
+func (db *RockDB) kvImportBatch(force bool, args ...common.KVRecord) (int64, error) {
+	if !force {
+		for _, kv := range args {
+			n, err := db.KVExists(kv.Key)
+			if err != nil {
+				return 0, err
+			}
+			if n > 0 {
+				return 0, ErrImportKeyExists
+			}
+		}
+	}
+	if err := db.MSet(args...); err != nil {
+		return 0, err
+	}
+	return int64(len(args)), nil
+}
@@ -0,0 +1,92 @@
+package rockredis
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestKVImport(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	kvs := make([]common.KVRecord, 0, 100)
+	for i := 0; i < 100; i++ {
+		kvs = append(kvs, common.KVRecord{
+			Key:   []byte("test:import_" + strconv.Itoa(i)),
+			Value: []byte(strconv.Itoa(i)),
+		})
+	}
+	if n, err := db.KVImport(false, kvs...); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(kvs)) {
+		t.Fatalf("all the keys should be imported: %v", n)
+	}
+	for _, kv := range kvs {
+		if v, err := db.KVGet(kv.Key); err != nil {
+			t.Fatal(err)
+		} else if string(v) != string(kv.Value) {
+			t.Fatalf("the imported value mismatch: %v, %v", string(v), string(kv.Value))
+		}
+	}
+
+	// the batch with any existing key is refused as a whole
+	newKV := common.KVRecord{Key: []byte("test:import_new"), Value: []byte("new")}
+	exist := common.KVRecord{Key: kvs[0].Key, Value: []byte("changed")}
+	if _, err := db.KVImport(false, newKV, exist); err != ErrImportKeyExists {
+		t.Fatalf("the existing key should be refused: %v", err)
+	}
+	if n, err := db.KVExists(newKV.Key); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("nothing in the refused batch should be written")
+	}
+	if v, err := db.KVGet(exist.Key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != string(kvs[0].Value) {
+		t.Fatalf("the existing key should be unchanged: %v", string(v))
+	}
+
+	if n, err := db.KVImport(true, newKV, exist); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	if v, err := db.KVGet(exist.Key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "changed" {
+		t.Fatalf("the existing key should be overwritten by force: %v", string(v))
+	}
+	if n, err := db.GetTableKeyCount([]byte("test")); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(kvs)+1) {
+		t.Fatalf("the table key count should be updated by the new keys: %v", n)
+	}
+
+	// the import batch can be larger than the write batch
+	kvs = kvs[:0]
+	for i := 0; i < MAX_BATCH_NUM*2; i++ {
+		kvs = append(kvs, common.KVRecord{
+			Key:   []byte("test:import_large_" + strconv.Itoa(i)),
+			Value: []byte(strconv.Itoa(i)),
+		})
+	}
+	if n, err := db.KVImport(false, kvs...); err != nil {
+		t.Fatal(err)
+	} else if n != int64(len(kvs)) {
+		t.Fatalf("all the keys should be imported: %v", n)
+	}
+	if v, err := db.KVGet(kvs[len(kvs)-1].Key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != string(kvs[len(kvs)-1].Value) {
+		t.Fatalf("the imported value mismatch: %v", string(v))
+	}
+	if n, err := db.GetTableKeyCount([]byte("test")); err != nil {
+		t.Fatal(err)
+	} else if n != int64(MAX_BATCH_NUM*2+101) {
+		t.Fatalf("the table key count mismatch: %v", n)
+	}
+}
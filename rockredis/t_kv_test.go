@@ -2,9 +2,13 @@ package rockredis
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"github.com/absolute8511/ZanRedisDB/common"
+	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestKVCodec(t *testing.T) {
@@ -236,3 +240,148 @@ func TestDBKVWithNoTable(t *testing.T) {
 		t.Error("should get no value")
 	}
 }
+
+func TestKVValueDedup(t *testing.T) {
+	cfg := NewRockConfig()
+	var err error
+	cfg.DataDir, err = ioutil.TempDir("", fmt.Sprintf("rockredis-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.EnableValueDedup = true
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	value := make([]byte, 1024*1024)
+	for i := range value {
+		value[i] = byte(i % 256)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("test:kv_dedup_%d", i))
+		if err := db.KVSet(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.KVSet([]byte("test:kv_dedup_small"), []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.KVGet([]byte("test:kv_dedup_small")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "small" {
+		t.Fatal(string(v))
+	}
+	stats, err := db.GetDedupStats()
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != 1 || stats.TotalValues != 100 {
+		t.Fatal(stats)
+	}
+	totalSize := 0
+	it := NewDBRangeIterator(db.eng, nil, nil, common.RangeClose, false)
+	for ; it.Valid(); it.Next() {
+		totalSize += len(it.RefKey()) + len(it.RefValue())
+	}
+	it.Close()
+	if totalSize > len(value)+100*1024 {
+		t.Fatalf("the deduplicated values use too much space: %v", totalSize)
+	}
+
+	if v, err := db.KVGet([]byte("test:kv_dedup_10")); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, value) {
+		t.Fatal("value mismatch")
+	}
+	vals, errs := db.MGet([]byte("test:kv_dedup_1"), []byte("test:kv_dedup_small"))
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	} else if !bytes.Equal(vals[0], value) || string(vals[1]) != "small" {
+		t.Fatal("mget value mismatch")
+	}
+
+	// overwrite and delete should decrease the reference
+	if err := db.KVSet([]byte("test:kv_dedup_0"), []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 50; i++ {
+		if err := db.KVDel([]byte(fmt.Sprintf("test:kv_dedup_%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err = db.GetDedupStats()
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != 1 || stats.TotalValues != 50 {
+		t.Fatal(stats)
+	}
+	// set the same value for the same key in one batch
+	if err := db.MSet(common.KVRecord{Key: []byte("test:kv_dedup_50"), Value: value},
+		common.KVRecord{Key: []byte("test:kv_dedup_50"), Value: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 51; i < 100; i++ {
+		if err := db.KVDel([]byte(fmt.Sprintf("test:kv_dedup_%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err = db.GetDedupStats()
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != 0 || stats.TotalValues != 0 {
+		t.Fatal(stats)
+	}
+	h := sha256.Sum256(value)
+	if v, err := db.eng.GetBytes(db.defaultReadOpts, encodeDedupValueKey(h[:])); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal("the shared value should be deleted")
+	}
+}
+
+func TestKVCompareAndSet(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:kv_cas")
+	if n, err := db.KVCompareAndSet(key, []byte("v1"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("cas on the non-exist key should fail: %v", n)
+	}
+	db.KVSet(key, []byte("v1"))
+	if n, err := db.KVCompareAndSet(key, []byte("v0"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("cas with the unexpected value should fail: %v", n)
+	}
+	if n, err := db.KVCompareAndSet(key, []byte("v1"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("cas with the expected value should success: %v", n)
+	}
+	if v, _ := db.KVGet(key); string(v) != "v2" {
+		t.Fatalf("value should be changed: %v", string(v))
+	}
+	if n, err := db.KVCompareAndDel(key, []byte("v1")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("cad with the unexpected value should fail: %v", n)
+	}
+	if n, err := db.KVCompareAndDel(key, []byte("v2")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("cad with the expected value should success: %v", n)
+	}
+	if v, _ := db.KVGet(key); v != nil {
+		t.Fatalf("key should be deleted: %v", string(v))
+	}
+	if num, err := db.GetTableKeyCount([]byte("test")); err != nil {
+		t.Fatal(err)
+	} else if num != 0 {
+		t.Fatalf("table count should be decreased: %v", num)
+	}
+}
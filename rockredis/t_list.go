@@ -163,7 +163,7 @@ func (db *RockDB) lpush(key []byte, whereSeq int64, args ...[]byte) (int64, erro
 	}
 
 	db.lSetMeta(metaKey, headSeq, tailSeq, wb)
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return int64(size) + int64(pushCnt), err
 }
 
@@ -223,7 +223,7 @@ func (db *RockDB) lpop(key []byte, whereSeq int64) ([]byte, error) {
 			return nil, err
 		}
 	}
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return value, err
 }
 
@@ -287,7 +287,7 @@ func (db *RockDB) ltrim2(key []byte, startP, stopP int64) error {
 		}
 	}
 
-	return db.eng.Write(db.defaultWriteOpts, wb)
+	return db.writeBatch(wb)
 }
 
 func (db *RockDB) ltrim(key []byte, trimSize, whereSeq int64) (int64, error) {
@@ -351,7 +351,7 @@ func (db *RockDB) ltrim(key []byte, trimSize, whereSeq int64) (int64, error) {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return trimEndSeq - trimStartSeq + 1, err
 }
 
@@ -386,7 +386,7 @@ func (db *RockDB) lDelete(key []byte, wb *gorocksdb.WriteBatch) int64 {
 		db.eng.CompactRange(r)
 	}
 
-	rit := NewDBRangeIterator(db.eng, startKey, stopKey, common.RangeClose, false)
+	rit := db.newRangeIterator(startKey, stopKey, common.RangeClose, false)
 	for ; rit.Valid(); rit.Next() {
 		wb.Delete(rit.RefKey())
 		num++
@@ -572,7 +572,7 @@ func (db *RockDB) LRange(key []byte, start int64, stop int64) ([][]byte, error)
 	v := make([][]byte, 0, limit)
 
 	startKey := lEncodeListKey(key, headSeq)
-	rit := NewDBRangeLimitIterator(db.eng, startKey, nil, common.RangeClose, 0, int(limit), false)
+	rit := db.newRangeLimitIterator(startKey, nil, common.RangeClose, 0, int(limit), false)
 	for ; rit.Valid(); rit.Next() {
 		v = append(v, rit.Value())
 	}
@@ -597,7 +597,7 @@ func (db *RockDB) LClear(key []byte) (int64, error) {
 	}
 	db.wb.Clear()
 	num := db.lDelete(key, db.wb)
-	err := db.eng.Write(db.defaultWriteOpts, db.wb)
+	err := db.writeBatch(db.wb)
 	if err != nil {
 		// TODO: log here , the list maybe corrupt
 	}
@@ -617,7 +617,7 @@ func (db *RockDB) LMclear(keys ...[]byte) (int64, error) {
 		}
 		db.lDelete(key, db.wb)
 	}
-	err := db.eng.Write(db.defaultWriteOpts, db.wb)
+	err := db.writeBatch(db.wb)
 	if err != nil {
 		// TODO: log here , the list maybe corrupt
 	}
@@ -0,0 +1,145 @@
+package rockredis
+
+import (
+	"bytes"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+// RPopLPush pop the last element of the source list and push it to the head
+// of the destination list in the same write batch, so the element is never
+// lost between the lists. Return nil if the source list is empty.
+func (db *RockDB) RPopLPush(src []byte, dst []byte) ([]byte, error) {
+	if err := checkKeySize(src); err != nil {
+		return nil, err
+	}
+	if err := checkKeySize(dst); err != nil {
+		return nil, err
+	}
+	srcTable := extractTableFromRedisKey(src)
+	dstTable := extractTableFromRedisKey(dst)
+	if len(srcTable) == 0 || len(dstTable) == 0 {
+		return nil, errTableName
+	}
+
+	wb := db.wb
+	wb.Clear()
+	srcMeta := lEncodeMetaKey(src)
+	headSeq, tailSeq, size, err := db.lGetMeta(srcMeta)
+	if err != nil {
+		return nil, err
+	} else if size == 0 {
+		return nil, nil
+	}
+	itemKey := lEncodeListKey(src, tailSeq)
+	value, err := db.eng.GetBytes(db.defaultReadOpts, itemKey)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(src, dst) {
+		// rotate the list, the single element list is not changed
+		if size == 1 {
+			return value, nil
+		}
+		if headSeq-1 <= listMinSeq {
+			return nil, errListSeq
+		}
+		wb.Delete(itemKey)
+		wb.Put(lEncodeListKey(src, headSeq-1), value)
+		if _, err = db.lSetMeta(srcMeta, headSeq-1, tailSeq-1, wb); err != nil {
+			return nil, err
+		}
+		return value, db.writeBatch(wb)
+	}
+
+	dstMeta := lEncodeMetaKey(dst)
+	dstHead, dstTail, dstSize, err := db.lGetMeta(dstMeta)
+	if err != nil {
+		return nil, err
+	}
+	if dstSize > 0 {
+		dstHead--
+	}
+	if dstHead <= listMinSeq {
+		return nil, errListSeq
+	}
+	wb.Delete(itemKey)
+	size, err = db.lSetMeta(srcMeta, headSeq, tailSeq-1, wb)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		if _, err = db.IncrTableKeyCount(srcTable, -1, wb); err != nil {
+			return nil, err
+		}
+	}
+	wb.Put(lEncodeListKey(dst, dstHead), value)
+	if dstSize == 0 {
+		if _, err = db.IncrTableKeyCount(dstTable, 1, wb); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = db.lSetMeta(dstMeta, dstHead, dstTail, wb); err != nil {
+		return nil, err
+	}
+	return value, db.writeBatch(wb)
+}
+
+// LAck remove the oldest element equal to the value from the list, the
+// elements behind it are moved forward to keep the list continuous.
+// Return 1 if removed and 0 if not found.
+func (db *RockDB) LAck(key []byte, value []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+	table := extractTableFromRedisKey(key)
+	if len(table) == 0 {
+		return 0, errTableName
+	}
+
+	metaKey := lEncodeMetaKey(key)
+	headSeq, tailSeq, size, err := db.lGetMeta(metaKey)
+	if err != nil {
+		return 0, err
+	} else if size == 0 {
+		return 0, nil
+	}
+	if size >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	// the element pushed by RPopLPush earliest is nearest to the tail
+	values := make([][]byte, 0, size)
+	rit := db.newRangeLimitIterator(lEncodeListKey(key, headSeq),
+		lEncodeListKey(key, tailSeq), common.RangeClose, 0, int(size), false)
+	for ; rit.Valid(); rit.Next() {
+		values = append(values, rit.Value())
+	}
+	rit.Close()
+	pos := -1
+	for i := len(values) - 1; i >= 0; i-- {
+		if bytes.Equal(values[i], value) {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return 0, nil
+	}
+
+	wb := db.wb
+	wb.Clear()
+	for i := pos; i < len(values)-1; i++ {
+		wb.Put(lEncodeListKey(key, headSeq+int64(i)), values[i+1])
+	}
+	wb.Delete(lEncodeListKey(key, tailSeq))
+	size, err = db.lSetMeta(metaKey, headSeq, tailSeq-1, wb)
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 {
+		if _, err = db.IncrTableKeyCount(table, -1, wb); err != nil {
+			return 0, err
+		}
+	}
+	return 1, db.writeBatch(wb)
+}
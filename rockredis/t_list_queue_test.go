@@ -0,0 +1,99 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+)
+
+func checkListValues(t *testing.T, db *RockDB, key []byte, values ...string) {
+	v, err := db.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != len(values) {
+		t.Fatalf("the list %s mismatch: %q, %v", key, v, values)
+	}
+	for i := range values {
+		if string(v[i]) != values[i] {
+			t.Fatalf("the list %s mismatch: %q, %v", key, v, values)
+		}
+	}
+	if n, _ := db.LLen(key); n != int64(len(values)) {
+		t.Fatalf("the list %s length mismatch: %v, %v", key, n, values)
+	}
+}
+
+func TestListReliableQueue(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	work := []byte("test:queue_work")
+	processing := []byte("test:queue_processing")
+	if _, err := db.LPush(work, []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	checkListValues(t, db, work, "c", "b", "a")
+
+	for _, expected := range []string{"a", "b"} {
+		v, err := db.RPopLPush(work, processing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) != expected {
+			t.Fatalf("the popped element mismatch: %s, %v", v, expected)
+		}
+	}
+	checkListValues(t, db, work, "c")
+	checkListValues(t, db, processing, "b", "a")
+
+	// the acked element is removed and the others kept in order
+	if n, err := db.LAck(processing, []byte("a")); err != nil || n != 1 {
+		t.Fatalf("the ack should remove the element: %v, %v", n, err)
+	}
+	if n, err := db.LAck(processing, []byte("a")); err != nil || n != 0 {
+		t.Fatalf("the acked element should be gone: %v, %v", n, err)
+	}
+	checkListValues(t, db, processing, "b")
+
+	// requeue the unacked element from the processing list
+	if v, err := db.RPopLPush(processing, work); err != nil || string(v) != "b" {
+		t.Fatalf("the unacked element should be requeued: %s, %v", v, err)
+	}
+	checkListValues(t, db, processing)
+	checkListValues(t, db, work, "b", "c")
+	if n, _ := db.LKeyExists(processing); n != 0 {
+		t.Fatal("the empty processing list should be removed")
+	}
+
+	// rotate the same list
+	if v, err := db.RPopLPush(work, work); err != nil || string(v) != "c" {
+		t.Fatalf("the rotated element mismatch: %s, %v", v, err)
+	}
+	checkListValues(t, db, work, "c", "b")
+
+	if v, err := db.RPopLPush([]byte("test:queue_empty"), processing); err != nil || v != nil {
+		t.Fatalf("pop from the empty list should return nil: %s, %v", v, err)
+	}
+	checkListValues(t, db, processing)
+}
+
+func TestListAckMiddle(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:queue_ack")
+	if _, err := db.RPush(key, []byte("a"), []byte("b"), []byte("a"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	// the oldest one nearest to the tail is removed first
+	if n, err := db.LAck(key, []byte("a")); err != nil || n != 1 {
+		t.Fatalf("the ack should remove the element: %v, %v", n, err)
+	}
+	checkListValues(t, db, key, "a", "b", "c")
+	if _, err := db.RPush(key, []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	checkListValues(t, db, key, "a", "b", "c", "d")
+}
@@ -0,0 +1,485 @@
+package rockredis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+// The kv keys under the dropped prefix are hidden from read as soon as the
+// drop marker is written, and removed physically by the compaction filter.
+// The writes to the dropping prefix are refused until the marker is removed
+// after the compaction, so the filter will never drop a key written after
+// the drop. Only the kv keys can be dropped by prefix since the data keys of
+// the collections are encoded with the key length before the key.
+// The table key count and the deduplicated value references of the dropped
+// keys are scanned by the leader out of the apply, and proposed in chunks
+// which are applied in order. The filter keeps the keys until accounted.
+// The keys are still visible to the snapshots pinned before the drop, so the
+// filter keeps them until those snapshots released, and the keys left are
+// deleted while the drop finished.
+var (
+	ErrKeyPrefixDropping      = errors.New("ERR the key prefix is dropping")
+	errKeyPrefixNotDrop       = errors.New("the key prefix is not dropping")
+	errKeyPrefixNotAccounted  = errors.New("the dropped key prefix is not accounted")
+	errInvalidPrefixDropChunk = errors.New("invalid prefix drop account chunk")
+)
+
+const (
+	// the drop marker value after all the account chunks applied, the
+	// marker value is the next chunk to be applied before
+	prefixDropAccounted int64 = -1
+	// the max deduplicated values released in one account chunk
+	maxPrefixDropChunkRefs = 10000
+	// seq, table key count and the last flag before the value references
+	prefixDropChunkHeaderLen = 17
+	prefixDropChunkRefLen    = sha256.Size + 8
+)
+
+func encodeKVPrefixDropKey(prefix []byte) []byte {
+	buf := make([]byte, len(prefix)+1)
+	buf[0] = KVPrefixDropType
+	copy(buf[1:], prefix)
+	return buf
+}
+
+// return the stop key of the range which has the given start as the prefix
+func prefixRangeStop(start []byte) []byte {
+	stop := make([]byte, len(start))
+	copy(stop, start)
+	for i := len(stop) - 1; i >= 0; i-- {
+		stop[i]++
+		if stop[i] != 0 {
+			return stop[:i+1]
+		}
+	}
+	return nil
+}
+
+type droppedPrefixes struct {
+	sync.RWMutex
+	// the encoded kv key prefixes which are dropping
+	prefixes [][]byte
+	// the drop generation of each prefix
+	gens []uint64
+	// whether the table key count and the value references of the keys
+	// under each prefix are updated, the keys are kept until accounted
+	accounted []bool
+	// the number of the dropping prefixes, checked without the lock by
+	// the compaction filter and the writes since mostly no prefix dropping.
+	num int32
+}
+
+// should be called with the lock held while the prefixes changed
+func (d *droppedPrefixes) updateNum() {
+	atomic.StoreInt32(&d.num, int32(len(d.prefixes)))
+}
+
+func (d *droppedPrefixes) empty() bool {
+	return atomic.LoadInt32(&d.num) == 0
+}
+
+// snapshotPins track the drop generation at which each live snapshot is
+// pinned, the snapshot can see the keys dropped after the generation.
+type snapshotPins struct {
+	sync.Mutex
+	// increased by each drop
+	dropGen uint64
+	// the number of the live snapshots pinned at each generation
+	live map[uint64]int
+}
+
+func newSnapshotPins() *snapshotPins {
+	return &snapshotPins{
+		live: make(map[uint64]int),
+	}
+}
+
+// pin should be called before the snapshot created
+func (p *snapshotPins) pin() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	p.live[p.dropGen]++
+	return p.dropGen
+}
+
+func (p *snapshotPins) unpin(gen uint64) {
+	p.Lock()
+	defer p.Unlock()
+	p.live[gen]--
+	if p.live[gen] <= 0 {
+		delete(p.live, gen)
+	}
+}
+
+func (p *snapshotPins) nextDropGen() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	p.dropGen++
+	return p.dropGen
+}
+
+// return the oldest generation pinned by the live snapshots, false if none
+func (p *snapshotPins) oldest() (uint64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	var oldest uint64
+	pinned := false
+	for gen := range p.live {
+		if !pinned || gen < oldest {
+			oldest = gen
+			pinned = true
+		}
+	}
+	return oldest, pinned
+}
+
+type prefixDropFilter struct {
+	db *RockDB
+}
+
+func (f *prefixDropFilter) Filter(level int, key, val []byte) (bool, []byte) {
+	return f.db.isKVKeyRemovable(key), nil
+}
+
+func (f *prefixDropFilter) Name() string {
+	return "zanredisdb.prefixdrop"
+}
+
+func (db *RockDB) newPrefixDropFilter() gorocksdb.CompactionFilter {
+	return &prefixDropFilter{db: db}
+}
+
+func (db *RockDB) isKVKeyDropped(ek []byte) bool {
+	if db.dropped.empty() {
+		return false
+	}
+	db.dropped.RLock()
+	defer db.dropped.RUnlock()
+	for _, p := range db.dropped.prefixes {
+		if bytes.HasPrefix(ek, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// the dropped key can be removed only if no live snapshot can see it
+func (db *RockDB) isKVKeyRemovable(ek []byte) bool {
+	if db.dropped.empty() {
+		return false
+	}
+	// the oldest generation of the prefixes matched, the key is removable if
+	// any dropping prefix is older than all the live snapshots
+	var gen uint64
+	matched := false
+	db.dropped.RLock()
+	for i, p := range db.dropped.prefixes {
+		if !db.dropped.accounted[i] {
+			continue
+		}
+		if bytes.HasPrefix(ek, p) && (!matched || db.dropped.gens[i] < gen) {
+			gen = db.dropped.gens[i]
+			matched = true
+		}
+	}
+	db.dropped.RUnlock()
+	if !matched {
+		return false
+	}
+	oldest, pinned := db.pins.oldest()
+	return !pinned || gen <= oldest
+}
+
+func (db *RockDB) isDroppingPrefix(start []byte) bool {
+	db.dropped.RLock()
+	defer db.dropped.RUnlock()
+	for _, p := range db.dropped.prefixes {
+		if bytes.Equal(p, start) {
+			return true
+		}
+	}
+	return false
+}
+
+// the key is hidden by the dropping prefix other than the given one
+func (db *RockDB) isKVKeyDroppedByOther(ek []byte, start []byte) bool {
+	db.dropped.RLock()
+	defer db.dropped.RUnlock()
+	for _, p := range db.dropped.prefixes {
+		if !bytes.Equal(p, start) && bytes.HasPrefix(ek, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKVPrefixDropAccounted return true if all the account chunks of the
+// dropping prefix are applied.
+func (db *RockDB) IsKVPrefixDropAccounted(prefix []byte) bool {
+	start := encodeKVKey(prefix)
+	db.dropped.RLock()
+	defer db.dropped.RUnlock()
+	for i, p := range db.dropped.prefixes {
+		if bytes.Equal(p, start) {
+			return db.dropped.accounted[i]
+		}
+	}
+	return false
+}
+
+// load the dropping prefixes from the store while the db opened or restored
+func (db *RockDB) loadDroppedPrefixes() {
+	start := []byte{KVPrefixDropType}
+	it := db.newRangeIterator(start, prefixRangeStop(start), common.RangeROpen, false)
+	defer it.Close()
+	prefixes := make([][]byte, 0)
+	gens := make([]uint64, 0)
+	accounted := make([]bool, 0)
+	// the snapshots pinned before the restore may still see the keys
+	gen := db.pins.nextDropGen()
+	for ; it.Valid(); it.Next() {
+		prefixes = append(prefixes, encodeKVKey(it.Key()[1:]))
+		gens = append(gens, gen)
+		next, _ := Int64(it.Value(), nil)
+		accounted = append(accounted, next == prefixDropAccounted)
+	}
+	db.dropped.Lock()
+	db.dropped.prefixes = prefixes
+	db.dropped.gens = gens
+	db.dropped.accounted = accounted
+	db.dropped.updateNum()
+	db.dropped.Unlock()
+}
+
+// convert the kv key for the write, the write to the dropping prefix is refused
+func (db *RockDB) convertKVWriteKey(key []byte) ([]byte, []byte, error) {
+	table, ek, err := convertRedisKeyToDBKVKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if db.isKVKeyDropped(ek) {
+		return nil, nil, ErrKeyPrefixDropping
+	}
+	return table, ek, nil
+}
+
+// GetDroppingKVPrefixes return the kv key prefixes which are dropped but
+// not compacted yet.
+func (db *RockDB) GetDroppingKVPrefixes() [][]byte {
+	db.dropped.RLock()
+	defer db.dropped.RUnlock()
+	prefixes := make([][]byte, 0, len(db.dropped.prefixes))
+	for _, p := range db.dropped.prefixes {
+		prefixes = append(prefixes, p[1:])
+	}
+	return prefixes
+}
+
+// DropKVPrefix drop all the kv keys with the given prefix, the prefix should
+// contain the table. Return 1 if the prefix is dropped, or 0 if the keys are
+// already hidden by the dropping prefix. The keys are hidden immediately and
+// removed while compacting after accounted.
+func (db *RockDB) DropKVPrefix(prefix []byte) (int64, error) {
+	_, start, err := convertRedisKeyToDBKVKey(prefix)
+	if err != nil {
+		return 0, err
+	}
+	if db.isKVKeyDropped(start) {
+		return 0, nil
+	}
+	db.wb.Clear()
+	db.wb.Put(encodeKVPrefixDropKey(prefix), nil)
+	if err := db.writeBatch(db.wb); err != nil {
+		return 0, err
+	}
+	db.dropped.Lock()
+	db.dropped.prefixes = append(db.dropped.prefixes, start)
+	db.dropped.gens = append(db.dropped.gens, db.pins.nextDropGen())
+	db.dropped.accounted = append(db.dropped.accounted, false)
+	db.dropped.updateNum()
+	db.dropped.Unlock()
+	return 1, nil
+}
+
+type prefixDropRefs []string
+
+func (s prefixDropRefs) Len() int           { return len(s) }
+func (s prefixDropRefs) Less(i, j int) bool { return s[i] < s[j] }
+func (s prefixDropRefs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// GetKVPrefixDropChunks scan the keys under the dropping prefix, and return
+// the account chunks not applied yet, which should be applied in order by
+// ApplyKVPrefixDropChunk. The keys under the dropping prefix are not changed
+// until accounted, so the chunks are the same on all the replicas.
+func (db *RockDB) GetKVPrefixDropChunks(prefix []byte) ([][]byte, error) {
+	start := encodeKVKey(prefix)
+	if !db.isDroppingPrefix(start) {
+		return nil, errKeyPrefixNotDrop
+	}
+	next, err := Int64(db.eng.GetBytes(db.defaultReadOpts, encodeKVPrefixDropKey(prefix)))
+	if err != nil {
+		return nil, err
+	}
+	if next == prefixDropAccounted {
+		return nil, nil
+	}
+	var num int64
+	refDelta := make(map[string]int64)
+	it := db.newRangeIterator(start, prefixRangeStop(start), common.RangeROpen, false)
+	for ; it.Valid(); it.Next() {
+		// the keys under the narrower dropping prefix are accounted by it
+		if db.isKVKeyDroppedByOther(it.Key(), start) {
+			continue
+		}
+		num++
+		if v := it.Value(); db.valueRef && len(v) > 0 && v[0] == dedupRefValue {
+			refDelta[string(v[1:])]--
+		}
+	}
+	it.Close()
+	refs := make(prefixDropRefs, 0, len(refDelta))
+	for h := range refDelta {
+		refs = append(refs, h)
+	}
+	sort.Sort(refs)
+	chunks := make([][]byte, 0, len(refs)/maxPrefixDropChunkRefs+1)
+	for seq := 0; seq == 0 || seq*maxPrefixDropChunkRefs < len(refs); seq++ {
+		end := (seq + 1) * maxPrefixDropChunkRefs
+		if end > len(refs) {
+			end = len(refs)
+		}
+		chunkRefs := refs[seq*maxPrefixDropChunkRefs : end]
+		chunk := make([]byte, prefixDropChunkHeaderLen, prefixDropChunkHeaderLen+len(chunkRefs)*prefixDropChunkRefLen)
+		binary.BigEndian.PutUint64(chunk[:8], uint64(seq))
+		if seq == 0 {
+			binary.BigEndian.PutUint64(chunk[8:16], uint64(num))
+		}
+		if end == len(refs) {
+			chunk[16] = 1
+		}
+		for _, h := range chunkRefs {
+			chunk = append(chunk, h...)
+			chunk = append(chunk, PutInt64(refDelta[h])...)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if next > int64(len(chunks)) {
+		return nil, errInvalidPrefixDropChunk
+	}
+	return chunks[next:], nil
+}
+
+// ApplyKVPrefixDropChunk decrease the table key count and release the value
+// references of the dropped keys by the account chunk. The chunk applied
+// already is ignored, since it may be proposed again after the leader changed.
+func (db *RockDB) ApplyKVPrefixDropChunk(prefix []byte, chunk []byte) error {
+	table, start, err := convertRedisKeyToDBKVKey(prefix)
+	if err != nil {
+		return err
+	}
+	if !db.isDroppingPrefix(start) {
+		return errKeyPrefixNotDrop
+	}
+	if len(chunk) < prefixDropChunkHeaderLen ||
+		(len(chunk)-prefixDropChunkHeaderLen)%prefixDropChunkRefLen != 0 {
+		return errInvalidPrefixDropChunk
+	}
+	markerKey := encodeKVPrefixDropKey(prefix)
+	next, err := Int64(db.eng.GetBytes(db.defaultReadOpts, markerKey))
+	if err != nil {
+		return err
+	}
+	if int64(binary.BigEndian.Uint64(chunk[:8])) != next {
+		return nil
+	}
+	num := int64(binary.BigEndian.Uint64(chunk[8:16]))
+	last := chunk[16] == 1
+	wb := db.wb
+	wb.Clear()
+	if num > 0 {
+		if _, err := db.IncrTableKeyCount(table, -num, wb); err != nil {
+			return err
+		}
+	}
+	if dw := db.newDedupWriter(); dw != nil {
+		for off := prefixDropChunkHeaderLen; off < len(chunk); off += prefixDropChunkRefLen {
+			h := string(chunk[off : off+sha256.Size])
+			dw.refDelta[h] += int64(binary.BigEndian.Uint64(chunk[off+sha256.Size : off+prefixDropChunkRefLen]))
+		}
+		if err := dw.flush(wb); err != nil {
+			return err
+		}
+	}
+	if last {
+		wb.Put(markerKey, PutInt64(prefixDropAccounted))
+	} else {
+		wb.Put(markerKey, PutInt64(next+1))
+	}
+	if err := db.writeBatch(wb); err != nil {
+		return err
+	}
+	if last {
+		db.dropped.Lock()
+		for i, p := range db.dropped.prefixes {
+			if bytes.Equal(p, start) {
+				db.dropped.accounted[i] = true
+				break
+			}
+		}
+		db.dropped.Unlock()
+	}
+	return nil
+}
+
+// CompactKVPrefix compact the range of the kv prefix, the keys under
+// the dropping prefix will be removed by the compaction filter.
+func (db *RockDB) CompactKVPrefix(prefix []byte) {
+	start := encodeKVKey(prefix)
+	db.limitedCompactRange(gorocksdb.Range{Start: start, Limit: prefixRangeStop(start)})
+}
+
+// FinishDropKVPrefix remove the drop marker of the accounted prefix, so the
+// prefix can be written again. The prefix should be compacted by
+// CompactKVPrefix in background before.
+func (db *RockDB) FinishDropKVPrefix(prefix []byte) error {
+	start := encodeKVKey(prefix)
+	if !db.isDroppingPrefix(start) {
+		return errKeyPrefixNotDrop
+	}
+	if !db.IsKVPrefixDropAccounted(prefix) {
+		return errKeyPrefixNotAccounted
+	}
+	db.wb.Clear()
+	// the keys kept for the live snapshots or not compacted yet are deleted,
+	// so they will not be visible again after the marker removed, and the
+	// snapshots still see them.
+	it := db.newRangeIterator(start, prefixRangeStop(start), common.RangeROpen, false)
+	for ; it.Valid(); it.Next() {
+		db.wb.Delete(it.Key())
+	}
+	it.Close()
+	db.wb.Delete(encodeKVPrefixDropKey(prefix))
+	if err := db.writeBatch(db.wb); err != nil {
+		return err
+	}
+	db.dropped.Lock()
+	defer db.dropped.Unlock()
+	for i, p := range db.dropped.prefixes {
+		if bytes.Equal(p, start) {
+			db.dropped.prefixes = append(db.dropped.prefixes[:i], db.dropped.prefixes[i+1:]...)
+			db.dropped.gens = append(db.dropped.gens[:i], db.dropped.gens[i+1:]...)
+			db.dropped.accounted = append(db.dropped.accounted[:i], db.dropped.accounted[i+1:]...)
+			db.dropped.updateNum()
+			break
+		}
+	}
+	return nil
+}
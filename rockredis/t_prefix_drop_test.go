@@ -0,0 +1,256 @@
+package rockredis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+// apply the account chunks of the dropping prefix as the leader proposed
+func accountDroppedKVPrefix(t *testing.T, db *RockDB, prefix []byte) {
+	chunks, err := db.GetKVPrefixDropChunks(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		if err := db.ApplyKVPrefixDropChunk(prefix, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !db.IsKVPrefixDropAccounted(prefix) {
+		t.Fatal("the dropping prefix should be accounted")
+	}
+}
+
+func TestDBDropKVPrefix(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := db.KVSet([]byte(fmt.Sprintf("prefixdrop:tenant1:%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.KVSet([]byte(fmt.Sprintf("prefixdrop:tenant2:%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := []byte("prefixdrop:tenant1:")
+	if n, err := db.DropKVPrefix(prefix); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the prefix should be dropped: %v", n)
+	}
+	if n, err := db.DropKVPrefix([]byte("prefixdrop:tenant1:1")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("the prefix hidden by the dropping prefix should not be dropped again: %v", n)
+	}
+	start := encodeKVKey(prefix)
+	if db.IsKVPrefixDropAccounted(prefix) || db.isKVKeyRemovable(start) {
+		t.Fatal("the dropped keys should be kept until accounted")
+	}
+	if err := db.FinishDropKVPrefix(prefix); err != errKeyPrefixNotAccounted {
+		t.Fatalf("the drop should not be finished before accounted: %v", err)
+	}
+	chunks, err := db.GetKVPrefixDropChunks(prefix)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(chunks) != 1 {
+		t.Fatalf("the account chunks mismatch: %v", len(chunks))
+	}
+	for i := 0; i < 2; i++ {
+		// the chunk applied again is ignored
+		if err := db.ApplyKVPrefixDropChunk(prefix, chunks[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !db.IsKVPrefixDropAccounted(prefix) || !db.isKVKeyRemovable(start) {
+		t.Fatal("the dropped keys should be removable after accounted")
+	}
+	if chunks, err := db.GetKVPrefixDropChunks(prefix); err != nil || len(chunks) != 0 {
+		t.Fatalf("no chunk should be left after accounted: %v, %v", chunks, err)
+	}
+	if n, err := db.GetTableKeyCount([]byte("prefixdrop")); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatalf("the table key count should exclude the dropped keys: %v", n)
+	}
+	key := []byte("prefixdrop:tenant1:1")
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the dropped key should be hidden: %v", v)
+	}
+	if n, err := db.KVExists(key); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal("the dropped key should not exist")
+	}
+	vlist, errs := db.MGet(key, []byte("prefixdrop:tenant2:1"))
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if vlist[0] != nil || string(vlist[1]) != "v" {
+		t.Fatalf("only the dropped key should be hidden: %v", vlist)
+	}
+	if keys, err := db.Scan(common.KV, []byte("prefixdrop:"), 200, ""); err != nil {
+		t.Fatal(err)
+	} else if len(keys) != 10 {
+		t.Fatalf("the dropped keys should not be scanned: %v", len(keys))
+	}
+	if err := db.KVSet(key, []byte("v2")); err != ErrKeyPrefixDropping {
+		t.Fatalf("write to the dropping prefix should be refused: %v", err)
+	}
+	if prefixes := db.GetDroppingKVPrefixes(); len(prefixes) != 1 || string(prefixes[0]) != string(prefix) {
+		t.Fatalf("the dropping prefixes mismatch: %v", prefixes)
+	}
+
+	db.CompactKVPrefix(prefix)
+	it := NewDBRangeIterator(db.eng, start, prefixRangeStop(start), common.RangeROpen, false)
+	if it.Valid() {
+		t.Fatalf("the dropped keys should be removed by the compaction: %v", it.Key())
+	}
+	it.Close()
+	if err := db.FinishDropKVPrefix(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if prefixes := db.GetDroppingKVPrefixes(); len(prefixes) != 0 {
+		t.Fatalf("the prefix should not be dropping after finished: %v", prefixes)
+	}
+	if err := db.KVSet(key, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v2" {
+		t.Fatalf("the new key under the dropped prefix should be visible: %v", v)
+	}
+	if v, err := db.KVGet([]byte("prefixdrop:tenant2:1")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v" {
+		t.Fatalf("the key not under the dropped prefix should be kept: %v", v)
+	}
+}
+
+func TestDBDropKVPrefixWithReadView(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := db.KVSet([]byte(fmt.Sprintf("prefixdrop:tenant1:%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := []byte("prefixdrop:tenant1:1")
+	view, err := db.NewReadView(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefixdrop:tenant1:")
+	if _, err := db.DropKVPrefix(prefix); err != nil {
+		t.Fatal(err)
+	}
+	accountDroppedKVPrefix(t, db, prefix)
+	start := encodeKVKey(prefix)
+	if !db.isKVKeyDropped(start) || db.isKVKeyRemovable(start) {
+		t.Fatal("the dropped keys should be kept while the read view pinned before the drop")
+	}
+	db.CompactKVPrefix(prefix)
+	if v, err := view.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v" {
+		t.Fatalf("the read view pinned before the drop should see the key: %v", v)
+	}
+	if err := db.FinishDropKVPrefix(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := view.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v" {
+		t.Fatalf("the read view should see the key after the drop finished: %v", v)
+	}
+	if v, err := db.KVGet(key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the key left for the read view should be deleted: %v", v)
+	}
+	view.ReleaseReadView()
+
+	if _, err := db.DropKVPrefix([]byte("prefixdrop:tenant2:")); err != nil {
+		t.Fatal(err)
+	}
+	accountDroppedKVPrefix(t, db, []byte("prefixdrop:tenant2:"))
+	view, err = db.NewReadView(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view.ReleaseReadView()
+	if !db.isKVKeyRemovable(encodeKVKey([]byte("prefixdrop:tenant2:1"))) {
+		t.Fatal("the dropped keys should be removable if the read view pinned after the drop")
+	}
+}
+
+func TestDBDropKVPrefixDedup(t *testing.T) {
+	cfg := NewRockConfig()
+	var err error
+	cfg.DataDir, err = ioutil.TempDir("", fmt.Sprintf("rockredis-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.EnableValueDedup = true
+	cfg.BlobMinValueSize = 16
+	db, err := OpenRockDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	shared := []byte("prefixdrop_shared_value")
+	for i := 0; i < 20; i++ {
+		if err := db.KVSet([]byte(fmt.Sprintf("prefixdrop:tenant1:%d", i)), shared); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.KVSet([]byte(fmt.Sprintf("prefixdrop:tenant1:unique%d", i)),
+			[]byte(fmt.Sprintf("prefixdrop_unique_value_%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.KVSet([]byte("prefixdrop:tenant2:0"), shared); err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefixdrop:tenant1:")
+	if _, err := db.DropKVPrefix(prefix); err != nil {
+		t.Fatal(err)
+	}
+	// the references are released while accounted, not while dropped
+	if stats, err := db.GetDedupStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != 21 || stats.TotalValues != 41 {
+		t.Fatal(stats)
+	}
+	accountDroppedKVPrefix(t, db, prefix)
+	if stats, err := db.GetDedupStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.UniqueValues != 1 || stats.TotalValues != 1 {
+		t.Fatal(stats)
+	}
+	if n, err := db.GetTableKeyCount([]byte("prefixdrop")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the table key count should exclude the dropped keys: %v", n)
+	}
+	if v, err := db.KVGet([]byte("prefixdrop:tenant2:0")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != string(shared) {
+		t.Fatalf("the shared value should be kept for the key not dropped: %v", v)
+	}
+}
@@ -0,0 +1,51 @@
+package rockredis
+
+import (
+	"errors"
+	"math"
+)
+
+// The sequence counter stores the last allocated id, and is separated from
+// the kv keys, so it can not be changed by SET or removed by DEL and the
+// allocated ids will never be reused.
+var (
+	ErrInvalidSeqCount = errors.New("ERR the sequence count should be positive")
+	ErrSeqOverflow     = errors.New("ERR the sequence would overflow")
+)
+
+func seqEncodeKey(key []byte) []byte {
+	buf := make([]byte, len(key)+1)
+	buf[0] = SeqType
+	copy(buf[1:], key)
+	return buf
+}
+
+// GetSeq return the last allocated id of the sequence, 0 if none allocated.
+func (db *RockDB) GetSeq(key []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+	v, err := db.eng.GetBytes(db.defaultReadOpts, seqEncodeKey(key))
+	return Int64(v, err)
+}
+
+// NextID allocate count ids from the sequence and return the first id of the
+// allocated range [start, start+count), the first id of a new sequence is 1.
+func (db *RockDB) NextID(key []byte, count int64) (int64, error) {
+	if count <= 0 {
+		return 0, ErrInvalidSeqCount
+	}
+	last, err := db.GetSeq(key)
+	if err != nil {
+		return 0, err
+	}
+	if last > math.MaxInt64-count {
+		return 0, ErrSeqOverflow
+	}
+	db.wb.Clear()
+	db.wb.Put(seqEncodeKey(key), PutInt64(last+count))
+	if err := db.writeBatch(db.wb); err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
@@ -0,0 +1,53 @@
+package rockredis
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestDBNextID(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:seq")
+	if n, err := db.GetSeq(key); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("the new sequence should be 0: %v", n)
+	}
+	if n, err := db.NextID(key, 1); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the first id should be 1: %v", n)
+	}
+	if n, err := db.NextID(key, 10); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("the range start mismatch: %v", n)
+	}
+	if n, err := db.NextID(key, 1); err != nil {
+		t.Fatal(err)
+	} else if n != 12 {
+		t.Fatalf("the range should follow the last range: %v", n)
+	}
+	if _, err := db.NextID(key, 0); err != ErrInvalidSeqCount {
+		t.Fatalf("the zero count should fail: %v", err)
+	}
+	// the sequence is not the kv key
+	if err := db.KVSet(key, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.KVDel(key); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.GetSeq(key); err != nil {
+		t.Fatal(err)
+	} else if n != 12 {
+		t.Fatalf("the sequence should not be changed by the kv: %v", n)
+	}
+	if _, err := db.NextID(key, math.MaxInt64); err != ErrSeqOverflow {
+		t.Fatalf("the overflow should fail: %v", err)
+	}
+}
@@ -126,7 +126,7 @@ func (db *RockDB) sDelete(key []byte, wb *gorocksdb.WriteBatch) int64 {
 	stop := sEncodeStopKey(key)
 
 	var num int64 = 0
-	it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 	for ; it.Valid(); it.Next() {
 		wb.Delete(it.RefKey())
 		num++
@@ -228,7 +228,7 @@ func (db *RockDB) SAdd(key []byte, args ...[]byte) (int64, error) {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return num, err
 
 }
@@ -276,7 +276,7 @@ func (db *RockDB) SMembers(key []byte) ([][]byte, error) {
 
 	v := make([][]byte, 0, 16)
 
-	it := NewDBRangeIterator(db.eng, start, stop, common.RangeROpen, false)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
 	defer it.Close()
 	for ; it.Valid(); it.Next() {
 		_, m, err := sDecodeSetKey(it.Key())
@@ -327,7 +327,7 @@ func (db *RockDB) SRem(key []byte, args ...[]byte) (int64, error) {
 		}
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return num, err
 }
 
@@ -338,7 +338,7 @@ func (db *RockDB) SClear(key []byte) (int64, error) {
 
 	wb := gorocksdb.NewWriteBatch()
 	num := db.sDelete(key, wb)
-	err := db.eng.Write(db.defaultWriteOpts, wb)
+	err := db.writeBatch(wb)
 	return num, err
 }
 
@@ -354,6 +354,6 @@ func (db *RockDB) SMclear(keys ...[]byte) (int64, error) {
 		db.sDelete(key, wb)
 	}
 
-	err := db.eng.Write(db.defaultWriteOpts, wb)
+	err := db.writeBatch(wb)
 	return int64(len(keys)), err
 }
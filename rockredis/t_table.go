@@ -73,7 +73,7 @@ func (db *RockDB) GetTables() chan []byte {
 	go func() {
 		s := encodeTableMetaStartKey()
 		e := encodeTableMetaStopKey()
-		it := NewDBRangeIterator(db.eng, s, e, common.RangeOpen, false)
+		it := db.newRangeIterator(s, e, common.RangeOpen, false)
 		defer it.Close()
 		for ; it.Valid(); it.Next() {
 			rk := it.Key()
@@ -117,3 +117,78 @@ func (db *RockDB) GetTableKeyCount(table []byte) (int64, error) {
 	}
 	return size, err
 }
+
+// the data types stored with the redis key (table:key) as the prefix,
+// so all the keys in the table can be found by scanning the table prefix.
+var tableKeyTypes = []byte{KVType, HSizeType, LMetaType, ZSizeType, SSizeType}
+
+func encodeTableKeyRange(dataType byte, table []byte) ([]byte, []byte) {
+	start := make([]byte, len(table)+2)
+	start[0] = dataType
+	copy(start[1:], table)
+	start[len(start)-1] = tableStartSep
+	stop := make([]byte, len(start))
+	copy(stop, start)
+	stop[len(stop)-1] = tableStopSep
+	return start, stop
+}
+
+func (db *RockDB) scanTableKeys(dataType byte, table []byte, limit int) [][]byte {
+	start, stop := encodeTableKeyRange(dataType, table)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
+	defer it.Close()
+	keys := make([][]byte, 0)
+	for ; it.Valid() && len(keys) < limit; it.Next() {
+		// the keys under the dropping prefix are not counted in the table
+		if dataType == KVType && db.isKVKeyDropped(it.Key()) {
+			continue
+		}
+		keys = append(keys, it.Key()[1:])
+	}
+	return keys
+}
+
+// DropTableKeys delete at most limit keys in the table, and the table meta will be
+// deleted if all the keys in the table are deleted. Return the number of the deleted keys,
+// so the caller can drop the whole table in bounded batches until 0 returned.
+func (db *RockDB) DropTableKeys(table []byte, limit int) (int64, error) {
+	if err := checkTableName(table); err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > RANGE_DELETE_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	var num int64
+	for _, dt := range tableKeyTypes {
+		if num >= int64(limit) {
+			break
+		}
+		for _, key := range db.scanTableKeys(dt, table, limit-int(num)) {
+			var err error
+			switch dt {
+			case KVType:
+				err = db.KVDel(key)
+			case HSizeType:
+				_, err = db.HClear(key)
+			case LMetaType:
+				_, err = db.LClear(key)
+			case ZSizeType:
+				_, err = db.ZClear(key)
+			case SSizeType:
+				_, err = db.SClear(key)
+			}
+			if err != nil {
+				return num, err
+			}
+			num++
+		}
+	}
+	if num < int64(limit) {
+		db.wb.Clear()
+		db.wb.Delete(encodeTableMetaKey(table))
+		if err := db.writeBatch(db.wb); err != nil {
+			return num, err
+		}
+	}
+	return num, nil
+}
@@ -0,0 +1,91 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func getTableNames(db *RockDB) map[string]bool {
+	tables := make(map[string]bool)
+	for t := range db.GetTables() {
+		tables[string(t)] = true
+	}
+	return tables
+}
+
+func TestDBDropTable(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	for _, table := range []string{"droptable", "droptable2"} {
+		if err := db.KVSet([]byte(table+":kv"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte(table+":hash"), []byte("f"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.RPush([]byte(table+":list"), []byte("a"), []byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ZAdd([]byte(table+":zset"), common.ScorePair{Score: 1, Member: []byte("m")}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd([]byte(table+":set"), []byte("m")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tables := getTableNames(db)
+	if !tables["droptable"] || !tables["droptable2"] {
+		t.Fatalf("tables mismatch: %v", tables)
+	}
+	if n, err := db.GetTableKeyCount([]byte("droptable")); err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("table key count mismatch: %v", n)
+	}
+
+	var total int64
+	for {
+		n, err := db.DropTableKeys([]byte("droptable"), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 2 {
+			t.Fatalf("deleted keys should be bounded by the limit: %v", n)
+		}
+		total += n
+		if n == 0 {
+			break
+		}
+	}
+	if total != 5 {
+		t.Fatalf("dropped keys mismatch: %v", total)
+	}
+	tables = getTableNames(db)
+	if tables["droptable"] || !tables["droptable2"] {
+		t.Fatalf("only the dropped table should be removed: %v", tables)
+	}
+	if v, err := db.KVGet([]byte("droptable:kv")); err != nil || v != nil {
+		t.Fatalf("the key in dropped table should be deleted: %v, %v", v, err)
+	}
+	if n, err := db.HLen([]byte("droptable:hash")); err != nil || n != 0 {
+		t.Fatalf("the hash in dropped table should be deleted: %v, %v", n, err)
+	}
+	if n, err := db.LLen([]byte("droptable:list")); err != nil || n != 0 {
+		t.Fatalf("the list in dropped table should be deleted: %v, %v", n, err)
+	}
+	if v, err := db.KVGet([]byte("droptable2:kv")); err != nil || string(v) != "v" {
+		t.Fatalf("the key in other table should not be deleted: %v, %v", v, err)
+	}
+	if n, err := db.SCard([]byte("droptable2:set")); err != nil || n != 1 {
+		t.Fatalf("the set in other table should not be deleted: %v, %v", n, err)
+	}
+	if n, err := db.GetTableKeyCount([]byte("droptable2")); err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("table key count mismatch: %v", n)
+	}
+}
@@ -226,6 +226,10 @@ func (db *RockDB) zSetItem(key []byte, score int64, member []byte, wb *gorocksdb
 			sk := zEncodeScoreKey(key, member, s)
 			wb.Delete(sk)
 		}
+		// the member set again is never expired
+		if err := db.zDelMemberExpire(key, member, wb); err != nil {
+			return 0, err
+		}
 	}
 
 	wb.Put(ek, PutInt64(score))
@@ -253,12 +257,15 @@ func (db *RockDB) zDelItem(key []byte, member []byte,
 			wb.Delete(sk)
 		}
 	}
+	if err := db.zDelMemberExpire(key, member, wb); err != nil {
+		return 0, err
+	}
 	wb.Delete(ek)
 	return 1, nil
 }
 
 func (db *RockDB) zDelete(key []byte, wb *gorocksdb.WriteBatch) (int64, error) {
-	delMembCnt, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, wb)
+	delMembCnt, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, 0, wb)
 	//	TODO : log err
 	return delMembCnt, err
 }
@@ -303,7 +310,7 @@ func (db *RockDB) ZAdd(key []byte, args ...common.ScorePair) (int64, error) {
 		}
 	}
 
-	err := db.eng.Write(db.defaultWriteOpts, wb)
+	err := db.writeBatch(wb)
 	return num, err
 }
 
@@ -323,6 +330,7 @@ func (db *RockDB) zIncrSize(key []byte, delta int64, wb *gorocksdb.WriteBatch) (
 		if size <= 0 {
 			size = 0
 			wb.Delete(sk)
+			wb.Delete(zEncodeMemberExpFlagKey(key))
 		} else {
 			wb.Put(sk, PutInt64(size))
 		}
@@ -331,15 +339,47 @@ func (db *RockDB) zIncrSize(key []byte, delta int64, wb *gorocksdb.WriteBatch) (
 }
 
 func (db *RockDB) ZCard(key []byte) (int64, error) {
+	return db.zCard(key, nowMs())
+}
+
+// the size of the zset without the members expired at the given time
+func (db *RockDB) zCard(key []byte, now int64) (int64, error) {
 	if err := checkKeySize(key); err != nil {
 		return 0, err
 	}
 
 	sk := zEncodeSizeKey(key)
-	return Int64(db.eng.GetBytes(db.defaultReadOpts, sk))
+	size, err := Int64(db.eng.GetBytes(db.defaultReadOpts, sk))
+	if err != nil || size <= 0 {
+		return size, err
+	}
+	// the expired members not deleted yet are not counted
+	return size - int64(len(db.zExpiredMembers(key, now))), nil
+}
+
+// ZStoredSize return the number of the stored members including the expired
+// ones not deleted yet, so the result does not depend on the local time.
+func (db *RockDB) ZStoredSize(key []byte) (int64, error) {
+	if err := checkKeySize(key); err != nil {
+		return 0, err
+	}
+	return Int64(db.eng.GetBytes(db.defaultReadOpts, zEncodeSizeKey(key)))
+}
+
+// ZMemberStored return whether the member is stored even if it is expired.
+func (db *RockDB) ZMemberStored(key []byte, member []byte) (bool, error) {
+	if err := checkZSetKMSize(key, member); err != nil {
+		return false, err
+	}
+	v, err := db.eng.GetBytes(db.defaultReadOpts, zEncodeSetKey(key, member))
+	return v != nil, err
 }
 
 func (db *RockDB) ZScore(key []byte, member []byte) (int64, error) {
+	return db.zScore(key, member, nowMs())
+}
+
+func (db *RockDB) zScore(key []byte, member []byte, now int64) (int64, error) {
 	if err := checkZSetKMSize(key, member); err != nil {
 		return InvalidScore, err
 	}
@@ -349,7 +389,7 @@ func (db *RockDB) ZScore(key []byte, member []byte) (int64, error) {
 	k := zEncodeSetKey(key, member)
 	if v, err := db.eng.GetBytes(db.defaultReadOpts, k); err != nil {
 		return InvalidScore, err
-	} else if v == nil {
+	} else if v == nil || db.zIsMemberExpired(key, member, now) {
 		return InvalidScore, errScoreMiss
 	} else {
 		if score, err = Int64(v, nil); err != nil {
@@ -359,7 +399,7 @@ func (db *RockDB) ZScore(key []byte, member []byte) (int64, error) {
 	return score, nil
 }
 
-func (db *RockDB) ZRem(key []byte, members ...[]byte) (int64, error) {
+func (db *RockDB) ZRem(key []byte, now int64, members ...[]byte) (int64, error) {
 	if len(members) == 0 {
 		return 0, nil
 	}
@@ -374,7 +414,9 @@ func (db *RockDB) ZRem(key []byte, members ...[]byte) (int64, error) {
 	wb := db.wb
 	wb.Clear()
 
-	var num int64 = 0
+	// the expired members are deleted but not counted
+	expired := db.zExpiredMembers(key, now)
+	var num, delNum int64
 	for i := 0; i < len(members); i++ {
 		if err := checkZSetKMSize(key, members[i]); err != nil {
 			return 0, err
@@ -382,24 +424,27 @@ func (db *RockDB) ZRem(key []byte, members ...[]byte) (int64, error) {
 		if n, err := db.zDelItem(key, members[i], wb); err != nil {
 			return 0, err
 		} else if n == 1 {
-			num++
+			delNum++
+			if !expired[string(members[i])] {
+				num++
+			}
 		}
 	}
 
-	if newNum, err := db.zIncrSize(key, -num, wb); err != nil {
+	if newNum, err := db.zIncrSize(key, -delNum, wb); err != nil {
 		return 0, err
-	} else if num > 0 && newNum == 0 {
+	} else if delNum > 0 && newNum == 0 {
 		_, err = db.IncrTableKeyCount(table, -1, wb)
 		if err != nil {
 			return InvalidScore, err
 		}
 	}
 
-	err := db.eng.Write(db.defaultWriteOpts, wb)
+	err := db.writeBatch(wb)
 	return num, err
 }
 
-func (db *RockDB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error) {
+func (db *RockDB) ZIncrBy(key []byte, now int64, delta int64, member []byte) (int64, error) {
 	if err := checkZSetKMSize(key, member); err != nil {
 		return InvalidScore, err
 	}
@@ -413,7 +458,7 @@ func (db *RockDB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error)
 
 	ek := zEncodeSetKey(key, member)
 
-	var oldScore int64 = 0
+	var oldScore, storedScore int64
 	v, err := db.eng.GetBytes(db.defaultReadOpts, ek)
 	if err != nil {
 		return InvalidScore, err
@@ -431,6 +476,14 @@ func (db *RockDB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error)
 		if oldScore, err = Int64(v, err); err != nil {
 			return InvalidScore, err
 		}
+		storedScore = oldScore
+		if db.zIsMemberExpired(key, member, now) {
+			// the expired member is added again without the expire time
+			oldScore = 0
+			if err := db.zDelMemberExpire(key, member, wb); err != nil {
+				return InvalidScore, err
+			}
+		}
 	}
 
 	newScore := oldScore + delta
@@ -444,11 +497,11 @@ func (db *RockDB) ZIncrBy(key []byte, delta int64, member []byte) (int64, error)
 
 	if v != nil {
 		// so as to update score, we must delete the old one
-		oldSk := zEncodeScoreKey(key, member, oldScore)
+		oldSk := zEncodeScoreKey(key, member, storedScore)
 		wb.Delete(oldSk)
 	}
 
-	err = db.eng.Write(db.defaultWriteOpts, wb)
+	err = db.writeBatch(wb)
 	return newScore, err
 }
 
@@ -458,10 +511,16 @@ func (db *RockDB) ZCount(key []byte, min int64, max int64) (int64, error) {
 	}
 	minKey := zEncodeStartScoreKey(key, min)
 	maxKey := zEncodeStopScoreKey(key, max)
-	it := NewDBRangeIterator(db.eng, minKey, maxKey, common.RangeClose, false)
+	it := db.newRangeIterator(minKey, maxKey, common.RangeClose, false)
 
+	expired := db.zExpiredMembers(key, nowMs())
 	var n int64 = 0
 	for ; it.Valid(); it.Next() {
+		if len(expired) > 0 {
+			if _, m, _, err := zDecodeScoreKey(it.RefKey()); err == nil && expired[string(m)] {
+				continue
+			}
+		}
 		n++
 	}
 
@@ -477,7 +536,8 @@ func (db *RockDB) zrank(key []byte, member []byte, reverse bool) (int64, error)
 	k := zEncodeSetKey(key, member)
 
 	v, _ := db.eng.GetBytes(db.defaultReadOpts, k)
-	if v == nil {
+	expired := db.zExpiredMembers(key, nowMs())
+	if v == nil || expired[string(member)] {
 		return -1, nil
 	} else {
 		if s, err := Int64(v, nil); err != nil {
@@ -487,10 +547,10 @@ func (db *RockDB) zrank(key []byte, member []byte, reverse bool) (int64, error)
 			var rit *RangeLimitedIterator
 			if !reverse {
 				minKey := zEncodeStartScoreKey(key, MinScore)
-				rit = NewDBRangeIterator(db.eng, minKey, sk, common.RangeClose, reverse)
+				rit = db.newRangeIterator(minKey, sk, common.RangeClose, reverse)
 			} else {
 				maxKey := zEncodeStopScoreKey(key, MaxScore)
-				rit = NewDBRangeIterator(db.eng, sk, maxKey, common.RangeClose, reverse)
+				rit = db.newRangeIterator(sk, maxKey, common.RangeClose, reverse)
 			}
 			defer rit.Close()
 
@@ -499,6 +559,11 @@ func (db *RockDB) zrank(key []byte, member []byte, reverse bool) (int64, error)
 
 			for ; rit.Valid(); rit.Next() {
 				rawk := rit.RefKey()
+				if len(expired) > 0 {
+					if _, m, _, err := zDecodeScoreKey(rawk); err == nil && expired[string(m)] {
+						continue
+					}
+				}
 				n++
 				lastKey = lastKey[0:0]
 				lastKey = append(lastKey, rawk...)
@@ -515,8 +580,10 @@ func (db *RockDB) zrank(key []byte, member []byte, reverse bool) (int64, error)
 	return -1, nil
 }
 
+// the members expired at now are deleted but not counted, no member is
+// treated as expired if now is 0.
 func (db *RockDB) zRemRange(key []byte, min int64, max int64, offset int,
-	count int, wb *gorocksdb.WriteBatch) (int64, error) {
+	count int, now int64, wb *gorocksdb.WriteBatch) (int64, error) {
 	if len(key) > MaxKeySize {
 		return 0, errKeySize
 	}
@@ -531,26 +598,47 @@ func (db *RockDB) zRemRange(key []byte, min int64, max int64, offset int,
 
 	minKey := zEncodeStartScoreKey(key, min)
 	maxKey := zEncodeStopScoreKey(key, max)
-	it := NewDBRangeLimitIterator(db.eng, minKey, maxKey, common.RangeClose, offset, count, false)
+	// the expired members have no rank, so the offset and count are
+	// applied after the expired members skipped
+	expired := db.zExpiredMembers(key, now)
+	itOffset, itCount := offset, count
+	if len(expired) > 0 {
+		itOffset, itCount = 0, -1
+	}
+	it := db.newRangeLimitIterator(minKey, maxKey, common.RangeClose, itOffset, itCount, false)
 	num := int64(0)
+	delNum := int64(0)
 	for ; it.Valid(); it.Next() {
 		sk := it.RefKey()
 		_, m, _, err := zDecodeScoreKey(sk)
 		if err != nil {
 			continue
 		}
+		isExpired := expired[string(m)]
+		if len(expired) > 0 && !isExpired {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if count >= 0 && num >= int64(count) {
+				break
+			}
+		}
 
 		if n, err := db.zDelItem(key, m, wb); err != nil {
 			return 0, err
 		} else if n == 1 {
-			num++
+			delNum++
+			if !isExpired {
+				num++
+			}
 		}
 	}
 	it.Close()
 
-	if newNum, err := db.zIncrSize(key, -num, wb); err != nil {
+	if newNum, err := db.zIncrSize(key, -delNum, wb); err != nil {
 		return 0, err
-	} else if num > 0 && newNum == 0 {
+	} else if delNum > 0 && newNum == 0 {
 		_, err = db.IncrTableKeyCount(table, -1, wb)
 		if err != nil {
 			return 0, err
@@ -578,6 +666,10 @@ func (db *RockDB) zRange(key []byte, min int64, max int64, offset int, count int
 		nv = 64
 	}
 
+	if expired := db.zExpiredMembers(key, nowMs()); len(expired) > 0 {
+		return db.zRangeSkipExpired(key, min, max, offset, count, reverse, expired), nil
+	}
+
 	v := make([]common.ScorePair, 0, nv)
 
 	var it *RangeLimitedIterator
@@ -586,9 +678,9 @@ func (db *RockDB) zRange(key []byte, min int64, max int64, offset int, count int
 	//if reverse and offset is 0, count < 0, we may use forward iterator then reverse
 	//because store iterator prev is slower than next
 	if !reverse || (offset == 0 && count < 0) {
-		it = NewDBRangeLimitIterator(db.eng, minKey, maxKey, common.RangeClose, offset, count, false)
+		it = db.newRangeLimitIterator(minKey, maxKey, common.RangeClose, offset, count, false)
 	} else {
-		it = NewDBRangeLimitIterator(db.eng, minKey, maxKey, common.RangeClose, offset, count, true)
+		it = db.newRangeLimitIterator(minKey, maxKey, common.RangeClose, offset, count, true)
 	}
 	for ; it.Valid(); it.Next() {
 		rawk := it.Key()
@@ -609,11 +701,11 @@ func (db *RockDB) zRange(key []byte, min int64, max int64, offset int, count int
 	return v, nil
 }
 
-func (db *RockDB) zParseLimit(key []byte, start int, stop int) (offset int, count int, err error) {
+func (db *RockDB) zParseLimit(key []byte, start int, stop int, now int64) (offset int, count int, err error) {
 	if start < 0 || stop < 0 {
 		//refer redis implementation
 		var size int64
-		size, err = db.ZCard(key)
+		size, err = db.zCard(key, now)
 		if err != nil {
 			return
 		}
@@ -647,11 +739,12 @@ func (db *RockDB) zParseLimit(key []byte, start int, stop int) (offset int, coun
 	return
 }
 
+// the expired members not deleted yet are counted too
 func (db *RockDB) ZClear(key []byte) (int64, error) {
 	db.wb.Clear()
-	rmCnt, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, db.wb)
+	rmCnt, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, 0, db.wb)
 	if err == nil {
-		err = db.eng.Write(db.defaultWriteOpts, db.wb)
+		err = db.writeBatch(db.wb)
 	}
 	return rmCnt, err
 }
@@ -662,12 +755,12 @@ func (db *RockDB) ZMclear(keys ...[]byte) (int64, error) {
 	}
 	db.wb.Clear()
 	for _, key := range keys {
-		if _, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, db.wb); err != nil {
+		if _, err := db.zRemRange(key, MinScore, MaxScore, 0, -1, 0, db.wb); err != nil {
 			return 0, err
 		}
 	}
 
-	err := db.eng.Write(db.defaultWriteOpts, db.wb)
+	err := db.writeBatch(db.wb)
 	return int64(len(keys)), err
 }
 
@@ -686,8 +779,8 @@ func (db *RockDB) ZRank(key []byte, member []byte) (int64, error) {
 	return db.zrank(key, member, false)
 }
 
-func (db *RockDB) ZRemRangeByRank(key []byte, start int, stop int) (int64, error) {
-	offset, count, err := db.zParseLimit(key, start, stop)
+func (db *RockDB) ZRemRangeByRank(key []byte, now int64, start int, stop int) (int64, error) {
+	offset, count, err := db.zParseLimit(key, start, stop, now)
 	if err != nil {
 		return 0, err
 	}
@@ -695,20 +788,20 @@ func (db *RockDB) ZRemRangeByRank(key []byte, start int, stop int) (int64, error
 	var rmCnt int64
 
 	db.wb.Clear()
-	rmCnt, err = db.zRemRange(key, MinScore, MaxScore, offset, count, db.wb)
+	rmCnt, err = db.zRemRange(key, MinScore, MaxScore, offset, count, now, db.wb)
 	if err == nil {
-		err = db.eng.Write(db.defaultWriteOpts, db.wb)
+		err = db.writeBatch(db.wb)
 	}
 	return rmCnt, err
 }
 
 //min and max must be inclusive
-func (db *RockDB) ZRemRangeByScore(key []byte, min int64, max int64) (int64, error) {
+func (db *RockDB) ZRemRangeByScore(key []byte, now int64, min int64, max int64) (int64, error) {
 	db.wb.Clear()
 
-	rmCnt, err := db.zRemRange(key, min, max, 0, -1, db.wb)
+	rmCnt, err := db.zRemRange(key, min, max, 0, -1, now, db.wb)
 	if err == nil {
-		err = db.eng.Write(db.defaultWriteOpts, db.wb)
+		err = db.writeBatch(db.wb)
 	}
 
 	return rmCnt, err
@@ -729,7 +822,7 @@ func (db *RockDB) ZRevRangeByScore(key []byte, min int64, max int64, offset int,
 }
 
 func (db *RockDB) ZRangeGeneric(key []byte, start int, stop int, reverse bool) ([]common.ScorePair, error) {
-	offset, count, err := db.zParseLimit(key, start, stop)
+	offset, count, err := db.zParseLimit(key, start, stop, nowMs())
 	if err != nil {
 		return nil, err
 	}
@@ -784,13 +877,25 @@ func (db *RockDB) ZRangeByLex(key []byte, min []byte, max []byte, rangeType uint
 		return nil, errTooMuchBatchSize
 	}
 
-	it := NewDBRangeLimitIterator(db.eng, min, max, rangeType, offset, count, false)
+	expired := db.zExpiredMembers(key, nowMs())
+	itOffset, itCount := offset, count
+	if len(expired) > 0 {
+		itOffset, itCount = 0, -1
+	}
+	it := db.newRangeLimitIterator(min, max, rangeType, itOffset, itCount, false)
 	defer it.Close()
 
 	ay := make([][]byte, 0, 16)
 	for ; it.Valid(); it.Next() {
 		rawk := it.Key()
 		if _, m, err := zDecodeSetKey(rawk); err == nil {
+			if expired[string(m)] {
+				continue
+			}
+			if len(expired) > 0 && offset > 0 {
+				offset--
+				continue
+			}
 			ay = append(ay, m)
 		}
 		// TODO: err for iterator step would match the final count?
@@ -801,7 +906,7 @@ func (db *RockDB) ZRangeByLex(key []byte, min []byte, max []byte, rangeType uint
 	return ay, nil
 }
 
-func (db *RockDB) ZRemRangeByLex(key []byte, min []byte, max []byte, rangeType uint8) (int64, error) {
+func (db *RockDB) ZRemRangeByLex(key []byte, now int64, min []byte, max []byte, rangeType uint8) (int64, error) {
 	if min == nil {
 		min = zEncodeStartSetKey(key)
 	} else {
@@ -819,9 +924,10 @@ func (db *RockDB) ZRemRangeByLex(key []byte, min []byte, max []byte, rangeType u
 
 	wb := db.wb
 	wb.Clear()
-	it := NewDBRangeIterator(db.eng, min, max, rangeType, false)
+	it := db.newRangeIterator(min, max, rangeType, false)
 	defer it.Close()
-	var num int64 = 0
+	expired := db.zExpiredMembers(key, now)
+	var num, delNum int64
 	for ; it.Valid(); it.Next() {
 		sk := it.RefKey()
 		_, m, err := zDecodeSetKey(sk)
@@ -831,20 +937,23 @@ func (db *RockDB) ZRemRangeByLex(key []byte, min []byte, max []byte, rangeType u
 		if n, err := db.zDelItem(key, m, wb); err != nil {
 			return 0, err
 		} else if n == 1 {
-			num++
+			delNum++
+			if !expired[string(m)] {
+				num++
+			}
 		}
 	}
 
-	if newNum, err := db.zIncrSize(key, -num, wb); err != nil {
+	if newNum, err := db.zIncrSize(key, -delNum, wb); err != nil {
 		return 0, err
-	} else if num > 0 && newNum == 0 {
+	} else if delNum > 0 && newNum == 0 {
 		_, err = db.IncrTableKeyCount(table, -1, wb)
 		if err != nil {
 			return 0, err
 		}
 	}
 
-	if err := db.eng.Write(db.defaultWriteOpts, wb); err != nil {
+	if err := db.writeBatch(wb); err != nil {
 		return 0, err
 	}
 
@@ -863,9 +972,15 @@ func (db *RockDB) ZLexCount(key []byte, min []byte, max []byte, rangeType uint8)
 		max = zEncodeSetKey(key, max)
 	}
 
-	it := NewDBRangeIterator(db.eng, min, max, rangeType, false)
+	it := db.newRangeIterator(min, max, rangeType, false)
+	expired := db.zExpiredMembers(key, nowMs())
 	var n int64 = 0
 	for ; it.Valid(); it.Next() {
+		if len(expired) > 0 {
+			if _, m, err := zDecodeSetKey(it.RefKey()); err == nil && expired[string(m)] {
+				continue
+			}
+		}
 		n++
 	}
 	it.Close()
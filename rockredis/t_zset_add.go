@@ -0,0 +1,98 @@
+package rockredis
+
+import (
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+// ZAddFlags is the options of the ZADD, the same as redis.
+type ZAddFlags struct {
+	NX bool
+	XX bool
+	GT bool
+	LT bool
+	CH bool
+}
+
+// whether the member can be set to the score, the GT and LT never prevent
+// adding the new member.
+func (f ZAddFlags) allow(exists bool, old int64, score int64) bool {
+	if !exists {
+		return !f.XX
+	}
+	if f.NX {
+		return false
+	}
+	if f.GT && score <= old {
+		return false
+	}
+	if f.LT && score >= old {
+		return false
+	}
+	return true
+}
+
+func (db *RockDB) zGetScore(key []byte, member []byte, now int64) (int64, bool, error) {
+	score, err := db.zScore(key, member, now)
+	if err == errScoreMiss {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return score, true, nil
+}
+
+// ZAddWithFlags add the members allowed by the flags, return the number of
+// the added members, or the number of the added and updated members if CH.
+func (db *RockDB) ZAddWithFlags(key []byte, now int64, flags ZAddFlags, args ...common.ScorePair) (int64, error) {
+	if len(args) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	// the member may be given more than once, the later one see the score
+	// set by the former one.
+	scores := make(map[string]int64, len(args))
+	mlist := make([]common.ScorePair, 0, len(args))
+	var changed int64
+	for _, p := range args {
+		old, exists := scores[string(p.Member)]
+		if !exists {
+			var err error
+			old, exists, err = db.zGetScore(key, p.Member, now)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if !flags.allow(exists, old, p.Score) {
+			continue
+		}
+		if !exists || old != p.Score {
+			changed++
+		}
+		scores[string(p.Member)] = p.Score
+		mlist = append(mlist, p)
+	}
+	added, err := db.ZAdd(key, mlist...)
+	if err != nil {
+		return 0, err
+	}
+	if flags.CH {
+		return changed, nil
+	}
+	return added, nil
+}
+
+// ZIncrByWithFlags increase the score of the member only if the new score is
+// allowed by the flags, and return false if not increased.
+func (db *RockDB) ZIncrByWithFlags(key []byte, now int64, flags ZAddFlags, delta int64, member []byte) (int64, bool, error) {
+	old, exists, err := db.zGetScore(key, member, now)
+	if err != nil {
+		return InvalidScore, false, err
+	}
+	if !flags.allow(exists, old, old+delta) {
+		return InvalidScore, false, nil
+	}
+	score, err := db.ZIncrBy(key, now, delta, member)
+	if err != nil {
+		return InvalidScore, false, err
+	}
+	return score, true, nil
+}
@@ -0,0 +1,72 @@
+package rockredis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestZAddWithFlags(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := []byte("test:testdb_zadd_flags")
+	pair := func(score int64, member string) common.ScorePair {
+		return common.ScorePair{Score: score, Member: []byte(member)}
+	}
+	if n, err := db.ZAdd(key, pair(10, "a"), pair(10, "b")); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	// the xx only updates the existing members
+	if n, err := db.ZAddWithFlags(key, nowMs(), ZAddFlags{XX: true, CH: true}, pair(11, "a"), pair(1, "c")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if _, err := db.ZScore(key, []byte("c")); err != errScoreMiss {
+		t.Fatal(err)
+	}
+	// the nx only adds the new members
+	if n, err := db.ZAddWithFlags(key, nowMs(), ZAddFlags{NX: true}, pair(1, "a"), pair(1, "c")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if s, _ := db.ZScore(key, []byte("a")); s != 11 {
+		t.Fatal(s)
+	}
+	// the gt updates only the greater score and still adds the new member
+	if n, err := db.ZAddWithFlags(key, nowMs(), ZAddFlags{GT: true, CH: true}, pair(5, "a"), pair(20, "b"), pair(1, "d")); err != nil || n != 2 {
+		t.Fatal(n, err)
+	}
+	if s, _ := db.ZScore(key, []byte("a")); s != 11 {
+		t.Fatal(s)
+	}
+	if s, _ := db.ZScore(key, []byte("b")); s != 20 {
+		t.Fatal(s)
+	}
+	if n, err := db.ZAddWithFlags(key, nowMs(), ZAddFlags{LT: true}, pair(5, "a"), pair(30, "b")); err != nil || n != 0 {
+		t.Fatal(n, err)
+	}
+	if s, _ := db.ZScore(key, []byte("a")); s != 5 {
+		t.Fatal(s)
+	}
+	if s, _ := db.ZScore(key, []byte("b")); s != 20 {
+		t.Fatal(s)
+	}
+
+	// the increment not allowed is not applied
+	if s, ok, err := db.ZIncrByWithFlags(key, nowMs(), ZAddFlags{GT: true}, -1, []byte("a")); err != nil || ok {
+		t.Fatal(s, ok, err)
+	}
+	if s, _ := db.ZScore(key, []byte("a")); s != 5 {
+		t.Fatal(s)
+	}
+	if s, ok, err := db.ZIncrByWithFlags(key, nowMs(), ZAddFlags{}, -1, []byte("a")); err != nil || !ok || s != 4 {
+		t.Fatal(s, ok, err)
+	}
+	if s, ok, err := db.ZIncrByWithFlags(key, nowMs(), ZAddFlags{XX: true}, 1, []byte("e")); err != nil || ok {
+		t.Fatal(s, ok, err)
+	}
+	if n, err := db.ZCard(key); err != nil || n != 4 {
+		t.Fatal(n, err)
+	}
+}
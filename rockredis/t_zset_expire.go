@@ -0,0 +1,290 @@
+package rockredis
+
+import (
+	"errors"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+var (
+	errZMemberExpKey     = errors.New("invalid zset member expire key")
+	errZMemberExpTimeKey = errors.New("invalid zset member expire time key")
+)
+
+// the member expire is stored the same as the hash field expire, only the
+// data types are different.
+func zEncodeMemberExpKey(key []byte, member []byte) []byte {
+	buf := zEncodeSetKey(key, member)
+	buf[0] = ZMemberExpType
+	return buf
+}
+
+func zDecodeMemberExpKey(ek []byte) ([]byte, []byte, error) {
+	if len(ek) == 0 || ek[0] != ZMemberExpType {
+		return nil, nil, errZMemberExpKey
+	}
+	buf := make([]byte, len(ek))
+	copy(buf, ek)
+	buf[0] = ZSetType
+	return zDecodeSetKey(buf)
+}
+
+func zEncodeMemberExpStartKey(key []byte) []byte {
+	return zEncodeMemberExpKey(key, nil)
+}
+
+func zEncodeMemberExpStopKey(key []byte) []byte {
+	k := zEncodeMemberExpKey(key, nil)
+	k[len(k)-1] = zsetStopMemSep
+	return k
+}
+
+func zEncodeMemberExpTimeKey(when int64, key []byte, member []byte) []byte {
+	buf := hEncodeFieldExpTimeKey(when, key, member)
+	buf[0] = ZMemberExpTimeType
+	return buf
+}
+
+func zDecodeMemberExpTimeKey(ek []byte) (int64, []byte, []byte, error) {
+	if len(ek) == 0 || ek[0] != ZMemberExpTimeType {
+		return 0, nil, nil, errZMemberExpTimeKey
+	}
+	buf := make([]byte, len(ek))
+	copy(buf, ek)
+	buf[0] = HFieldExpTimeType
+	return hDecodeFieldExpTimeKey(buf)
+}
+
+func zEncodeMemberExpTimeStopKey(when int64) []byte {
+	buf := hEncodeFieldExpTimeStopKey(when)
+	buf[0] = ZMemberExpTimeType
+	return buf
+}
+
+// the marker of the zset having the member expire, stored before all the
+// member expire records of the zset, so it is swapped together with them. The
+// zset without the marker never look up the member expire, and the marker is
+// kept until the zset deleted.
+func zEncodeMemberExpFlagKey(key []byte) []byte {
+	return encodeDataKeyPrefix(ZMemberExpType, key, []byte{zsetStartMemSep - 1})
+}
+
+func (db *RockDB) zHasMemberExpire(key []byte) bool {
+	v, err := db.eng.GetBytes(db.defaultReadOpts, zEncodeMemberExpFlagKey(key))
+	return err != nil || v != nil
+}
+
+// return the expire time (unix time in milliseconds) of the member, 0 if no expire
+func (db *RockDB) zGetMemberExpire(key []byte, member []byte) (int64, error) {
+	v, err := db.eng.GetBytes(db.defaultReadOpts, zEncodeMemberExpKey(key, member))
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return Int64(v, err)
+}
+
+func (db *RockDB) zSetMemberExpire(key []byte, member []byte, when int64, wb *gorocksdb.WriteBatch) error {
+	old, err := db.zGetMemberExpire(key, member)
+	if err != nil {
+		return err
+	}
+	if old > 0 {
+		wb.Delete(zEncodeMemberExpTimeKey(old, key, member))
+	}
+	wb.Put(zEncodeMemberExpKey(key, member), PutInt64(when))
+	wb.Put(zEncodeMemberExpTimeKey(when, key, member), nil)
+	wb.Put(zEncodeMemberExpFlagKey(key), []byte{1})
+	return nil
+}
+
+func (db *RockDB) zDelMemberExpire(key []byte, member []byte, wb *gorocksdb.WriteBatch) error {
+	old, err := db.zGetMemberExpire(key, member)
+	if err != nil || old <= 0 {
+		return err
+	}
+	wb.Delete(zEncodeMemberExpKey(key, member))
+	wb.Delete(zEncodeMemberExpTimeKey(old, key, member))
+	return nil
+}
+
+func (db *RockDB) zIsMemberExpired(key []byte, member []byte, now int64) bool {
+	if !db.zHasMemberExpire(key) {
+		return false
+	}
+	when, err := db.zGetMemberExpire(key, member)
+	if err != nil {
+		return false
+	}
+	return when > 0 && when <= now
+}
+
+// return all the expired members of the zset at the given time, the expired
+// members are hidden from read until deleted by the expire sweeper.
+func (db *RockDB) zExpiredMembers(key []byte, now int64) map[string]bool {
+	if now <= 0 || !db.zHasMemberExpire(key) {
+		return nil
+	}
+	start := zEncodeMemberExpStartKey(key)
+	stop := zEncodeMemberExpStopKey(key)
+	it := db.newRangeIterator(start, stop, common.RangeROpen, false)
+	defer it.Close()
+	var expired map[string]bool
+	for ; it.Valid(); it.Next() {
+		when, _ := Int64(it.Value(), nil)
+		if when <= 0 || when > now {
+			continue
+		}
+		_, member, err := zDecodeMemberExpKey(it.Key())
+		if err != nil {
+			continue
+		}
+		if expired == nil {
+			expired = make(map[string]bool)
+		}
+		expired[string(member)] = true
+	}
+	return expired
+}
+
+// ZAddExAt add the members with the expire time (unix time in milliseconds),
+// the expire time of the existing members is replaced. Return the number of
+// the added members.
+func (db *RockDB) ZAddExAt(key []byte, when int64, args ...common.ScorePair) (int64, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if len(args) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	if when <= 0 {
+		return 0, common.ErrInvalidArgs
+	}
+	table := extractTableFromRedisKey(key)
+	if len(table) == 0 {
+		return 0, errTableName
+	}
+
+	wb := db.wb
+	wb.Clear()
+	var num int64
+	for _, p := range args {
+		if err := checkZSetKMSize(key, p.Member); err != nil {
+			return 0, err
+		}
+		n, err := db.zSetItem(key, p.Score, p.Member, wb)
+		if err != nil {
+			return 0, err
+		} else if n == 0 {
+			num++
+		}
+		if err := db.zSetMemberExpire(key, p.Member, when, wb); err != nil {
+			return 0, err
+		}
+	}
+	if newNum, err := db.zIncrSize(key, num, wb); err != nil {
+		return 0, err
+	} else if newNum > 0 && newNum == num {
+		if _, err := db.IncrTableKeyCount(table, 1, wb); err != nil {
+			return 0, err
+		}
+	}
+	err := db.writeBatch(wb)
+	return num, err
+}
+
+// ScanExpiredZSetMembers return at most limit zset members which expired before the given time.
+// The Key of the returned record is the zset key and the Value is the member.
+func (db *RockDB) ScanExpiredZSetMembers(now int64, limit int) ([]common.KVRecord, error) {
+	start := []byte{ZMemberExpTimeType}
+	stop := zEncodeMemberExpTimeStopKey(now)
+	it := db.newRangeLimitIterator(start, stop, common.RangeClose, 0, limit, false)
+	defer it.Close()
+	ret := make([]common.KVRecord, 0)
+	for ; it.Valid(); it.Next() {
+		when, key, member, err := zDecodeMemberExpTimeKey(it.Key())
+		if err != nil {
+			return ret, err
+		}
+		if when > now {
+			break
+		}
+		ret = append(ret, common.KVRecord{Key: key, Value: member})
+	}
+	return ret, nil
+}
+
+// ZDelExpiredMembers delete the members which expired before the given time,
+// the members not expired (maybe changed after scanned) will be ignored.
+// Since the time is given in the command, this can be applied deterministically
+// on all the replicas.
+func (db *RockDB) ZDelExpiredMembers(key []byte, now int64, members ...[]byte) (int64, error) {
+	if len(members) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+	table := extractTableFromRedisKey(key)
+	if len(table) == 0 {
+		return 0, errTableName
+	}
+	wb := db.wb
+	wb.Clear()
+	var num int64
+	for _, member := range members {
+		if err := checkZSetKMSize(key, member); err != nil {
+			return 0, err
+		}
+		when, err := db.zGetMemberExpire(key, member)
+		if err != nil {
+			return 0, err
+		}
+		if when <= 0 || when > now {
+			continue
+		}
+		// the expire records are deleted with the member
+		n, err := db.zDelItem(key, member, wb)
+		if err != nil {
+			return 0, err
+		}
+		num += n
+	}
+	if num > 0 {
+		if newNum, err := db.zIncrSize(key, -num, wb); err != nil {
+			return 0, err
+		} else if newNum == 0 {
+			if _, err := db.IncrTableKeyCount(table, -1, wb); err != nil {
+				return 0, err
+			}
+		}
+	}
+	err := db.writeBatch(wb)
+	return num, err
+}
+
+// the range of the zset having the expired members, the expired members have
+// no rank, so the offset and count are applied after they are skipped.
+func (db *RockDB) zRangeSkipExpired(key []byte, min int64, max int64, offset int, count int,
+	reverse bool, expired map[string]bool) []common.ScorePair {
+	v := make([]common.ScorePair, 0, 16)
+	minKey := zEncodeStartScoreKey(key, min)
+	maxKey := zEncodeStopScoreKey(key, max)
+	it := db.newRangeIterator(minKey, maxKey, common.RangeClose, reverse)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		if count >= 0 && len(v) >= count {
+			break
+		}
+		_, m, s, err := zDecodeScoreKey(it.Key())
+		if err != nil || expired[string(m)] {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		v = append(v, common.ScorePair{Member: m, Score: s})
+	}
+	return v
+}
@@ -0,0 +1,286 @@
+package rockredis
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+type zsetSource struct {
+	it     *RangeLimitedIterator
+	member []byte
+	score  int64
+	// the expired members are skipped
+	expired map[string]bool
+}
+
+// move to the next member of the source, the member is nil if no more
+func (s *zsetSource) next() error {
+	for ; s.it.Valid(); s.it.Next() {
+		_, m, err := zDecodeSetKey(s.it.Key())
+		if err != nil {
+			return err
+		}
+		if s.expired[string(m)] {
+			continue
+		}
+		score, err := Int64(s.it.Value(), nil)
+		if err != nil {
+			return err
+		}
+		s.member = m
+		s.score = score
+		s.it.Next()
+		return nil
+	}
+	s.member = nil
+	return nil
+}
+
+func checkZSetSrcKeys(keys [][]byte) error {
+	if len(keys) == 0 {
+		return errInvalidSrcKeyNum
+	}
+	if len(keys) >= MAX_BATCH_NUM {
+		return errTooMuchBatchSize
+	}
+	for _, key := range keys {
+		if err := checkKeySize(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zMergeMembers iterate the members of all the source zsets in the member order
+// at the same time, the handler is called once for each member with the scores
+// in all the sources, the exists is false if the member is not in the source.
+func (db *RockDB) zMergeMembers(keys [][]byte, now int64, f func(member []byte, scores []int64, exists []bool)) error {
+	srcs := make([]*zsetSource, len(keys))
+	for i, key := range keys {
+		it := db.newRangeIterator(zEncodeStartSetKey(key), zEncodeStopSetKey(key), common.RangeROpen, false)
+		defer it.Close()
+		srcs[i] = &zsetSource{it: it, expired: db.zExpiredMembers(key, now)}
+		if err := srcs[i].next(); err != nil {
+			return err
+		}
+	}
+	scores := make([]int64, len(keys))
+	exists := make([]bool, len(keys))
+	for {
+		var min []byte
+		for _, s := range srcs {
+			if s.member != nil && (min == nil || bytes.Compare(s.member, min) < 0) {
+				min = s.member
+			}
+		}
+		if min == nil {
+			return nil
+		}
+		for i, s := range srcs {
+			exists[i] = s.member != nil && bytes.Equal(s.member, min)
+			if !exists[i] {
+				continue
+			}
+			scores[i] = s.score
+			if err := s.next(); err != nil {
+				return err
+			}
+		}
+		f(min, scores, exists)
+	}
+}
+
+// sort the result by the score and then the member, the same as zrange
+type scorePairSorter []common.ScorePair
+
+func (self scorePairSorter) Less(i, j int) bool {
+	if self[i].Score != self[j].Score {
+		return self[i].Score < self[j].Score
+	}
+	return bytes.Compare(self[i].Member, self[j].Member) < 0
+}
+func (self scorePairSorter) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+}
+func (self scorePairSorter) Len() int {
+	return len(self)
+}
+
+func (db *RockDB) zUnionInter(keys [][]byte, weights []int64, aggregate byte,
+	isInter bool) ([]common.ScorePair, error) {
+	if err := checkZSetSrcKeys(keys); err != nil {
+		return nil, err
+	}
+	if weights != nil && len(weights) != len(keys) {
+		return nil, errInvalidWeightNum
+	}
+	aggFunc := getAggregateFunc(aggregate)
+	if aggFunc == nil {
+		return nil, errInvalidAggregate
+	}
+	ret := make([]common.ScorePair, 0)
+	err := db.zMergeMembers(keys, nowMs(), func(member []byte, scores []int64, exists []bool) {
+		var score int64
+		first := true
+		for i := range keys {
+			if !exists[i] {
+				if isInter {
+					return
+				}
+				continue
+			}
+			s := scores[i]
+			if weights != nil {
+				s *= weights[i]
+			}
+			if first {
+				score = s
+				first = false
+			} else {
+				score = aggFunc(score, s)
+			}
+		}
+		ret = append(ret, common.ScorePair{Score: score, Member: member})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(scorePairSorter(ret))
+	return ret, nil
+}
+
+// ZUnion return the union of the zsets without storing, the score of the member
+// is aggregated from the weighted scores in all the zsets containing it.
+func (db *RockDB) ZUnion(keys [][]byte, weights []int64, aggregate byte) ([]common.ScorePair, error) {
+	return db.zUnionInter(keys, weights, aggregate, false)
+}
+
+// ZInter return the intersection of the zsets without storing.
+func (db *RockDB) ZInter(keys [][]byte, weights []int64, aggregate byte) ([]common.ScorePair, error) {
+	return db.zUnionInter(keys, weights, aggregate, true)
+}
+
+// ZDiff return the members of the first zset which are not in all the
+// other zsets, with the scores in the first zset.
+func (db *RockDB) ZDiff(keys ...[]byte) ([]common.ScorePair, error) {
+	return db.zDiff(keys, nowMs())
+}
+
+// ZDiffAt is the same as ZDiff with the given time (unix time in
+// milliseconds) to check the expired members, used while applying the write.
+func (db *RockDB) ZDiffAt(now int64, keys ...[]byte) ([]common.ScorePair, error) {
+	return db.zDiff(keys, now)
+}
+
+func (db *RockDB) zDiff(keys [][]byte, now int64) ([]common.ScorePair, error) {
+	if err := checkZSetSrcKeys(keys); err != nil {
+		return nil, err
+	}
+	ret := make([]common.ScorePair, 0)
+	err := db.zMergeMembers(keys, now, func(member []byte, scores []int64, exists []bool) {
+		if !exists[0] {
+			return
+		}
+		for _, e := range exists[1:] {
+			if e {
+				return
+			}
+		}
+		ret = append(ret, common.ScorePair{Score: scores[0], Member: member})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(scorePairSorter(ret))
+	return ret, nil
+}
+
+// ZDiffStore store the diff of the zsets to the dest key, the old members
+// in dest are replaced. Return the number of the members in dest.
+func (db *RockDB) ZDiffStore(dest []byte, now int64, keys ...[]byte) (int64, error) {
+	if err := checkKeySize(dest); err != nil {
+		return 0, err
+	}
+	table := extractTableFromRedisKey(dest)
+	if len(table) == 0 {
+		return 0, errTableName
+	}
+	pairs, err := db.zDiff(keys, now)
+	if err != nil {
+		return 0, err
+	}
+	if len(pairs) >= MAX_BATCH_NUM {
+		return 0, errTooMuchBatchSize
+	}
+	for _, p := range pairs {
+		if err := checkZSetKMSize(dest, p.Member); err != nil {
+			return 0, err
+		}
+	}
+	// the expired members not deleted yet are overwritten too
+	oldSize, err := Int64(db.eng.GetBytes(db.defaultReadOpts, zEncodeSizeKey(dest)))
+	if err != nil {
+		return 0, err
+	}
+	newMembers := make(map[string]int64, len(pairs))
+	for _, p := range pairs {
+		newMembers[string(p.Member)] = p.Score
+	}
+
+	wb := db.wb
+	wb.Clear()
+	// only write the changed members, since the write batch can not be read
+	// while the old members are still in the db
+	it := db.newRangeIterator(zEncodeStartSetKey(dest), zEncodeStopSetKey(dest), common.RangeROpen, false)
+	for ; it.Valid(); it.Next() {
+		_, m, err := zDecodeSetKey(it.Key())
+		if err != nil {
+			it.Close()
+			return 0, err
+		}
+		old, err := Int64(it.Value(), nil)
+		if err != nil {
+			it.Close()
+			return 0, err
+		}
+		// the stored members never expire
+		if err := db.zDelMemberExpire(dest, m, wb); err != nil {
+			it.Close()
+			return 0, err
+		}
+		score, ok := newMembers[string(m)]
+		if ok && score == old {
+			delete(newMembers, string(m))
+			continue
+		}
+		wb.Delete(zEncodeScoreKey(dest, m, old))
+		if !ok {
+			wb.Delete(zEncodeSetKey(dest, m))
+		}
+	}
+	it.Close()
+	wb.Delete(zEncodeMemberExpFlagKey(dest))
+	for m, score := range newMembers {
+		wb.Put(zEncodeSetKey(dest, []byte(m)), PutInt64(score))
+		wb.Put(zEncodeScoreKey(dest, []byte(m), score), []byte{})
+	}
+
+	newSize := int64(len(pairs))
+	sk := zEncodeSizeKey(dest)
+	if newSize == 0 {
+		wb.Delete(sk)
+	} else {
+		wb.Put(sk, PutInt64(newSize))
+	}
+	if oldSize == 0 && newSize > 0 {
+		_, err = db.IncrTableKeyCount(table, 1, wb)
+	} else if oldSize > 0 && newSize == 0 {
+		_, err = db.IncrTableKeyCount(table, -1, wb)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newSize, db.writeBatch(wb)
+}
@@ -0,0 +1,97 @@
+package rockredis
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func TestDBZSetOps(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key1 := []byte("test:testdb_zsetops_a")
+	key2 := []byte("test:testdb_zsetops_b")
+	key3 := []byte("test:testdb_zsetops_c")
+	if _, err := db.ZAdd(key1, common.ScorePair{Score: 1, Member: []byte("a")},
+		common.ScorePair{Score: 2, Member: []byte("b")},
+		common.ScorePair{Score: 3, Member: []byte("c")},
+		common.ScorePair{Score: 4, Member: []byte("d")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(key2, common.ScorePair{Score: 10, Member: []byte("b")},
+		common.ScorePair{Score: 20, Member: []byte("e")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(key3, common.ScorePair{Score: 30, Member: []byte("c")},
+		common.ScorePair{Score: 40, Member: []byte("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := db.ZDiff(key1, key2, key3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.ScorePair{{Score: 1, Member: []byte("a")}, {Score: 4, Member: []byte("d")}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("zdiff mismatch: %v", diff)
+	}
+
+	union, err := db.ZUnion([][]byte{key1, key2}, []int64{1, 2}, AggregateSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []common.ScorePair{{Score: 1, Member: []byte("a")}, {Score: 3, Member: []byte("c")},
+		{Score: 4, Member: []byte("d")}, {Score: 22, Member: []byte("b")}, {Score: 40, Member: []byte("e")}}
+	if !reflect.DeepEqual(union, expected) {
+		t.Fatalf("zunion mismatch: %v", union)
+	}
+
+	inter, err := db.ZInter([][]byte{key1, key2, key3}, nil, AggregateMax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []common.ScorePair{{Score: 40, Member: []byte("b")}}
+	if !reflect.DeepEqual(inter, expected) {
+		t.Fatalf("zinter mismatch: %v", inter)
+	}
+	if _, err := db.ZUnion([][]byte{key1, key2}, []int64{1}, AggregateSum); err != errInvalidWeightNum {
+		t.Fatalf("the weights should match the keys: %v", err)
+	}
+
+	dest := []byte("test:testdb_zsetops_dest")
+	if _, err := db.ZAdd(dest, common.ScorePair{Score: 100, Member: []byte("a")},
+		common.ScorePair{Score: 5, Member: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.ZDiffStore(dest, nowMs(), key1, key2, key3); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("zdiffstore should store all the diff members: %v", n)
+	}
+	if n, err := db.ZCard(dest); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("the old members in dest should be replaced: %v", n)
+	}
+	stored, err := db.ZRange(dest, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, diff) {
+		t.Fatalf("the stored members should be the same as zdiff: %v", stored)
+	}
+	if n, err := db.ZDiffStore(dest, nowMs(), key1, key1); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+	if n, err := db.ZCard(dest); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("the dest should be empty: %v", n)
+	}
+}
@@ -87,13 +87,13 @@ func TestDBZSet(t *testing.T) {
 	}
 
 	// {c':2, 'd':3}
-	if n, err := db.ZRem(key, bin("a"), bin("b")); err != nil {
+	if n, err := db.ZRem(key, nowMs(), bin("a"), bin("b")); err != nil {
 		t.Fatal(err)
 	} else if n != 2 {
 		t.Fatal(n)
 	}
 
-	if n, err := db.ZRem(key, bin("a"), bin("b")); err != nil {
+	if n, err := db.ZRem(key, nowMs(), bin("a"), bin("b")); err != nil {
 		t.Fatal(err)
 	} else if n != 0 {
 		t.Fatal(n)
@@ -193,7 +193,7 @@ func TestZSetOrder(t *testing.T) {
 	}
 
 	// {'a':0, 'b':1, 'c':2, 'd':999, 'e':6, 'f':5}
-	if s, err := db.ZIncrBy(key, 2, bin("e")); err != nil {
+	if s, err := db.ZIncrBy(key, nowMs(), 2, bin("e")); err != nil {
 		t.Fatal(err)
 	} else if s != 6 {
 		t.Fatal(s)
@@ -265,7 +265,7 @@ func TestZLex(t *testing.T) {
 		t.Fatal(n)
 	}
 
-	if n, err := db.ZRemRangeByLex(key, []byte("aaa"), []byte("g"), common.RangeROpen); err != nil {
+	if n, err := db.ZRemRangeByLex(key, nowMs(), []byte("aaa"), []byte("g"), common.RangeROpen); err != nil {
 		t.Fatal(err)
 	} else if n != 5 {
 		t.Fatal(n)
@@ -297,3 +297,138 @@ func TestZKeyExists(t *testing.T) {
 		t.Fatal("invalid value ", n)
 	}
 }
+
+func TestZSetMemberExpire(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	key := bin("test:testdb_zset_member_expire")
+	if _, err := db.ZAdd(key, pair("a", 1), pair("b", 2), pair("c", 3)); err != nil {
+		t.Fatal(err)
+	}
+	now := nowMs()
+	if n, err := db.ZAddExAt(key, now-1, pair("b", 2), pair("d", 4)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if _, err := db.ZAddExAt(key, now+100000, pair("e", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := db.ZCard(key); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+	if _, err := db.ZScore(key, bin("b")); err != errScoreMiss {
+		t.Fatalf("expired member should not have score: %v", err)
+	}
+	if n, err := db.ZRank(key, bin("b")); err != nil {
+		t.Fatal(err)
+	} else if n != -1 {
+		t.Fatal(n)
+	}
+	if n, err := db.ZRank(key, bin("c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if v, err := db.ZRange(key, 0, -1); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []common.ScorePair{pair("a", 1), pair("c", 3), pair("e", 5)}) {
+		t.Fatal(v)
+	}
+	if v, err := db.ZRange(key, 1, 1); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []common.ScorePair{pair("c", 3)}) {
+		t.Fatal(v)
+	}
+	if v, err := db.ZRevRange(key, 0, 0); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, []common.ScorePair{pair("e", 5)}) {
+		t.Fatal(v)
+	}
+	if n, err := db.ZCount(key, 0, 10); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+
+	expired, err := db.ScanExpiredZSetMembers(nowMs(), 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 2 {
+		t.Fatal(expired)
+	}
+	// the not expired member should be ignored while deleting
+	if n, err := db.ZDelExpiredMembers(key, nowMs(), bin("b"), bin("d"), bin("e")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	if n, err := db.ZCard(key); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+	if expired, err := db.ScanExpiredZSetMembers(nowMs(), 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+
+	// add member again should clear the expire time
+	if _, err := db.ZAdd(key, pair("e", 6)); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := db.ScanExpiredZSetMembers(now+200000, 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+	if _, err := db.ZAddExAt(key, now+100000, pair("f", 7)); err != nil {
+		t.Fatal(err)
+	}
+	// the writes depend on the given time instead of the local clock
+	if s, err := db.ZIncrBy(key, now, 1, bin("f")); err != nil {
+		t.Fatal(err)
+	} else if s != 8 {
+		t.Fatal(s)
+	}
+	if _, err := db.ZAddExAt(key, now+100000, pair("g", 8)); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := db.ZIncrBy(key, now+200000, 1, bin("g")); err != nil {
+		t.Fatal(err)
+	} else if s != 1 {
+		t.Fatal(s)
+	}
+	if _, err := db.ZAddExAt(key, now+100000, pair("h", 9)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.ZRemRangeByRank(key, now+200000, -1, -1); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	// the last one not expired at the given time is removed
+	if _, err := db.ZScore(key, bin("e")); err != errScoreMiss {
+		t.Fatalf("member should be removed: %v", err)
+	}
+	if _, err := db.ZScore(key, bin("c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZClear(key); err != nil {
+		t.Fatal(err)
+	}
+	if expired, err := db.ScanExpiredZSetMembers(now+200000, 100); err != nil {
+		t.Fatal(err)
+	} else if len(expired) != 0 {
+		t.Fatal(expired)
+	}
+	if db.zHasMemberExpire(key) {
+		t.Fatal("the member expire flag should be deleted with the zset")
+	}
+}
@@ -0,0 +1,107 @@
+package rockredis
+
+import (
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+// UsageStats is the approximate stored bytes (after compression) of the
+// tables and the data types, estimated from the approximate sizes of the
+// key ranges in the sst files.
+type UsageStats struct {
+	TableBytes map[string]int64 `json:"table_bytes"`
+	TypeBytes  map[string]int64 `json:"type_bytes"`
+}
+
+// the data types stored with the keys of the profiled type, the first is the
+// meta type prefixed by the table of the key.
+var usageStoreTypes = map[string][]byte{
+	"string": {KVType, DedupValueType, DedupRefType},
+	"hash":   {HSizeType, HashType, HFieldExpType},
+	"list":   {LMetaType, ListType},
+	"set":    {SSizeType, SetType},
+	"zset":   {ZSizeType, ZSetType, ZScoreType, ZMemberExpType},
+}
+
+func encodeUsageTypeRange(storeType byte) gorocksdb.Range {
+	return gorocksdb.Range{Start: []byte{storeType}, Limit: []byte{storeType + 1}}
+}
+
+func encodeUsageTableRange(storeType byte, table []byte) gorocksdb.Range {
+	start := make([]byte, len(table)+2)
+	start[0] = storeType
+	copy(start[1:], table)
+	limit := make([]byte, len(start))
+	copy(limit, start)
+	start[len(start)-1] = tableStartSep
+	limit[len(limit)-1] = tableStopSep
+	return gorocksdb.Range{Start: start, Limit: limit}
+}
+
+func (db *RockDB) getTableKeyNums() (map[string]int64, int64) {
+	nums := make(map[string]int64)
+	var total int64
+	it := NewDBRangeIterator(db.eng, encodeTableMetaStartKey(), encodeTableMetaStopKey(),
+		common.RangeOpen, false)
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		table, err := decodeTableMetaKey(it.Key())
+		if err != nil {
+			continue
+		}
+		n, err := Int64(it.Value(), nil)
+		if err != nil {
+			continue
+		}
+		nums[string(table)] = n
+		total += n
+	}
+	return nums, total
+}
+
+// EstimateUsage return the estimated bytes of each table and each data type
+// from the approximate sizes of the key ranges, so no key is read except the
+// table meta. The data in the memtable is not counted. The element bytes of
+// the collections are split to the tables by the approximate size of their
+// meta keys, or by the key number of the tables if the meta keys are too few
+// to be measured.
+func (db *RockDB) EstimateUsage() (*UsageStats, error) {
+	tableNums, keyNum := db.getTableKeyNums()
+	us := &UsageStats{
+		TableBytes: make(map[string]int64, len(tableNums)),
+		TypeBytes:  make(map[string]int64, len(profileTypes)),
+	}
+	for _, tp := range profileTypes {
+		storeTypes := usageStoreTypes[tp.name]
+		ranges := make([]gorocksdb.Range, 0, len(storeTypes)+len(tableNums))
+		for _, st := range storeTypes {
+			ranges = append(ranges, encodeUsageTypeRange(st))
+		}
+		tables := make([]string, 0, len(tableNums))
+		for t := range tableNums {
+			tables = append(tables, t)
+			ranges = append(ranges, encodeUsageTableRange(tp.storeType, []byte(t)))
+		}
+		sizes := db.eng.GetApproximateSizes(ranges)
+		metaBytes := int64(sizes[0])
+		var typeBytes int64
+		for _, s := range sizes[:len(storeTypes)] {
+			typeBytes += int64(s)
+		}
+		us.TypeBytes[tp.name] = typeBytes
+		if typeBytes == 0 {
+			continue
+		}
+		for i, t := range tables {
+			tableMetaBytes := int64(sizes[len(storeTypes)+i])
+			var n int64
+			if metaBytes > 0 {
+				n = int64(float64(typeBytes) * float64(tableMetaBytes) / float64(metaBytes))
+			} else if keyNum > 0 {
+				n = int64(float64(typeBytes) * float64(tableNums[t]) / float64(keyNum))
+			}
+			us.TableBytes[t] += n
+		}
+	}
+	return us, nil
+}
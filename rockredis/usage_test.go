@@ -0,0 +1,77 @@
+package rockredis
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+)
+
+func randomUsageValue(n int) []byte {
+	v := make([]byte, n)
+	rand.Read(v)
+	return v
+}
+
+func TestEstimateUsage(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	// the random values are not compressed in the sst files
+	for i := 0; i < 100; i++ {
+		if err := db.KVSet([]byte("big:usage_"+strconv.Itoa(i)), randomUsageValue(10240)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.KVSet([]byte("small:usage_"+strconv.Itoa(i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fields := make([]common.KVRecord, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		fields = append(fields, common.KVRecord{Key: []byte("f" + strconv.Itoa(i)), Value: randomUsageValue(100)})
+	}
+	if err := db.HMset([]byte("small:usage_hash"), fields...); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data in the memtable is not counted
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	us, err := db.EstimateUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if us.TableBytes["big"] < 90*10240 || us.TableBytes["big"] < us.TableBytes["small"]*5 {
+		t.Fatalf("the table with the large values should dominate: %v", us.TableBytes)
+	}
+	if us.TableBytes["small"] <= 0 {
+		t.Fatalf("the usage of the small table mismatch: %v", us.TableBytes)
+	}
+	if us.TypeBytes["hash"] < 1000*90 || us.TypeBytes["hash"] > 1000*200 {
+		t.Fatalf("the hash usage mismatch: %v", us.TypeBytes)
+	}
+	if us.TypeBytes["string"] < us.TableBytes["big"] || us.TypeBytes["list"] != 0 {
+		t.Fatalf("the type usage mismatch: %v", us.TypeBytes)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.KVDel([]byte("big:usage_" + strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.CompactRange()
+	after, err := db.EstimateUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.TableBytes["big"] >= us.TableBytes["big"]/100 || after.TypeBytes["string"] >= us.TypeBytes["string"]/10 {
+		t.Fatalf("the usage should be reduced after deleted: %v, %v", after.TableBytes, after.TypeBytes)
+	}
+	if after.TypeBytes["hash"] < 1000*90 {
+		t.Fatalf("the usage of the other type should not be reduced: %v, %v", after.TypeBytes, us.TypeBytes)
+	}
+}
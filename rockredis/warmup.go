@@ -0,0 +1,37 @@
+package rockredis
+
+import (
+	"time"
+
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/gorocksdb"
+)
+
+// Warmup read the data from the start with the block cache filled, so the
+// first reads after the data restored will not be slow. The warmup stops
+// after maxBytes of the keys and values read or the timeout.
+func (db *RockDB) Warmup(maxBytes int64, timeout time.Duration) common.WarmupStats {
+	start := time.Now()
+	readOpts := gorocksdb.NewDefaultReadOptions()
+	readOpts.SetVerifyChecksums(false)
+	readOpts.SetFillCache(true)
+	it := &DBIterator{
+		Iterator: db.eng.NewIterator(readOpts),
+		ro:       readOpts,
+	}
+	defer it.Close()
+	var ws common.WarmupStats
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		if ws.Bytes >= maxBytes {
+			break
+		}
+		if ws.Keys%scanBudgetCheckNum == 0 && time.Since(start) >= timeout {
+			break
+		}
+		ws.Keys++
+		ws.Bytes += int64(len(it.RefKey()) + len(it.RefValue()))
+	}
+	ws.Done = !it.Valid()
+	ws.CostMs = int64(time.Since(start) / time.Millisecond)
+	return ws
+}
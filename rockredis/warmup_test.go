@@ -0,0 +1,32 @@
+package rockredis
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	db := getTestDB(t)
+	defer os.RemoveAll(db.cfg.DataDir)
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		if err := db.KVSet([]byte(fmt.Sprintf("test:warmup_%04d", i)), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := db.Warmup(1024*1024*1024, time.Minute)
+	if !ws.Done || ws.Keys < 1000 || ws.Bytes <= 0 {
+		t.Fatalf("all the data should be warmed up: %v", ws)
+	}
+	ws = db.Warmup(100, time.Minute)
+	if ws.Done || ws.Bytes < 100 || ws.Keys >= 1000 {
+		t.Fatalf("the warmup should be bounded by the size: %v", ws)
+	}
+	ws = db.Warmup(1024*1024*1024, 0)
+	if ws.Done || ws.Keys != 0 {
+		t.Fatalf("the warmup should be bounded by the time: %v", ws)
+	}
+}
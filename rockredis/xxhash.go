@@ -0,0 +1,129 @@
+package rockredis
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// the 64-bit xxHash (XXH64) with the zero seed, which is much faster than the
+// crc32 and sha256 for the checksum of the large data.
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+type xxhash64 struct {
+	v1    uint64
+	v2    uint64
+	v3    uint64
+	v4    uint64
+	total uint64
+	mem   [32]byte
+	n     int
+}
+
+func newXXHash64() hash.Hash64 {
+	h := &xxhash64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxhash64) Reset() {
+	// the seed is 0, and the sums wrap around as the reference
+	var seed uint64
+	h.v1 = seed + xxPrime1
+	h.v1 += xxPrime2
+	h.v2 = seed + xxPrime2
+	h.v3 = seed
+	h.v4 = seed - xxPrime1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxhash64) Size() int      { return 8 }
+func (h *xxhash64) BlockSize() int { return 32 }
+
+func (h *xxhash64) Write(b []byte) (int, error) {
+	n := len(b)
+	h.total += uint64(n)
+	if h.n+n < 32 {
+		h.n += copy(h.mem[h.n:], b)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], b)
+		h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(h.mem[0:8]))
+		h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(h.mem[8:16]))
+		h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(h.mem[16:24]))
+		h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(h.mem[24:32]))
+		b = b[c:]
+		h.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		h.v1 = xxRound(h.v1, binary.LittleEndian.Uint64(b[0:8]))
+		h.v2 = xxRound(h.v2, binary.LittleEndian.Uint64(b[8:16]))
+		h.v3 = xxRound(h.v3, binary.LittleEndian.Uint64(b[16:24]))
+		h.v4 = xxRound(h.v4, binary.LittleEndian.Uint64(b[24:32]))
+	}
+	h.n = copy(h.mem[:], b)
+	return n, nil
+}
+
+func (h *xxhash64) Sum64() uint64 {
+	var v uint64
+	if h.total >= 32 {
+		v = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) +
+			bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		v = xxMergeRound(v, h.v1)
+		v = xxMergeRound(v, h.v2)
+		v = xxMergeRound(v, h.v3)
+		v = xxMergeRound(v, h.v4)
+	} else {
+		v = h.v3 + xxPrime5
+	}
+	v += h.total
+
+	b := h.mem[:h.n]
+	for ; len(b) >= 8; b = b[8:] {
+		v ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		v = bits.RotateLeft64(v, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		v ^= uint64(binary.LittleEndian.Uint32(b)) * xxPrime1
+		v = bits.RotateLeft64(v, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		v ^= uint64(c) * xxPrime5
+		v = bits.RotateLeft64(v, 11) * xxPrime1
+	}
+
+	v ^= v >> 33
+	v *= xxPrime2
+	v ^= v >> 29
+	v *= xxPrime3
+	v ^= v >> 32
+	return v
+}
+
+// Sum append the big-endian sum to b
+func (h *xxhash64) Sum(b []byte) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], h.Sum64())
+	return append(b, buf[:]...)
+}
+
+func xxRound(acc uint64, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc uint64, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+const (
+	pauseNone = iota
+	pauseWrite
+	pauseAll
+)
+
+// the pause of the commands from the clients on this server, the paused
+// commands are blocked until unpaused or the pause timeout.
+type clientPause struct {
+	sync.Mutex
+	mode  int
+	until time.Time
+	// closed once unpaused
+	resumeC chan struct{}
+}
+
+func (p *clientPause) isPausedLocked() bool {
+	return p.mode != pauseNone && time.Now().Before(p.until)
+}
+
+// the new pause never shortens the pause in effect or relaxes the mode of it
+func (p *clientPause) pause(d time.Duration, mode int) {
+	p.Lock()
+	defer p.Unlock()
+	until := time.Now().Add(d)
+	if p.isPausedLocked() {
+		if mode < p.mode {
+			mode = p.mode
+		}
+		if until.Before(p.until) {
+			until = p.until
+		}
+	} else {
+		p.resumeC = make(chan struct{})
+	}
+	p.mode = mode
+	p.until = until
+}
+
+func (p *clientPause) unpause() {
+	p.Lock()
+	defer p.Unlock()
+	if p.resumeC != nil {
+		close(p.resumeC)
+		p.resumeC = nil
+	}
+	p.mode = pauseNone
+}
+
+// return the pause mode in effect, the channel closed once unpaused and the
+// time the pause ends.
+func (p *clientPause) current() (int, chan struct{}, time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.isPausedLocked() {
+		return pauseNone, nil, time.Time{}
+	}
+	return p.mode, p.resumeC, p.until
+}
+
+func (self *Server) isWriteCommand(cmdName string, cmd redcon.Command) bool {
+	n, err := self.getCommandNamespace(cmdName, cmd)
+	if err != nil {
+		return false
+	}
+	return !n.node.IsReadCommand(cmdName)
+}
+
+// block the command of the clients while paused, the pause may be extended
+// while waiting, so it is checked again after the pause ends.
+func (self *Server) waitClientPause(cmdName string, cmd redcon.Command) {
+	for {
+		mode, resumeC, until := self.pause.current()
+		if mode == pauseNone {
+			return
+		}
+		if mode == pauseWrite && !self.isWriteCommand(cmdName, cmd) {
+			return
+		}
+		timer := time.NewTimer(until.Sub(time.Now()))
+		select {
+		case <-resumeC:
+		case <-timer.C:
+		case <-self.stopC:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// client pause timeout-ms [WRITE|ALL]
+// client unpause
+// pause the data commands of all the clients on this server, the admin
+// commands (such as cluster and client) are never paused.
+func (self *Server) clientCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'client' command")
+		return
+	}
+	switch qcmdlower(cmd.Args[1]) {
+	case "pause":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'client pause' command")
+			return
+		}
+		ms, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil || ms < 0 {
+			conn.WriteError("ERR timeout is not an integer or out of range")
+			return
+		}
+		mode := pauseAll
+		if len(cmd.Args) == 4 {
+			switch qcmdlower(cmd.Args[3]) {
+			case "write":
+				mode = pauseWrite
+			case "all":
+			default:
+				conn.WriteError("ERR syntax error")
+				return
+			}
+		}
+		self.pause.pause(time.Duration(ms)*time.Millisecond, mode)
+		sLog.Infof("client paused for %vms, mode: %v", ms, mode)
+		conn.WriteString("OK")
+	case "unpause":
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'client unpause' command")
+			return
+		}
+		self.pause.unpause()
+		sLog.Infof("client unpaused")
+		conn.WriteString("OK")
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'client'")
+	}
+}
@@ -1,20 +1,57 @@
 package server
 
 type ServerConfig struct {
-	BroadcastInterface string                `json:"broadcast_interface"`
-	BroadcastAddr      string                `json:"broadcast_addr"`
-	RedisAPIPort       int                   `json:"redis_api_port"`
-	HttpAPIPort        int                   `json:"http_api_port"`
-	DataDir            string                `json:"data_dir"`
-	Namespaces         []NamespaceNodeConfig `json:"namespaces"`
+	BroadcastInterface   string                `json:"broadcast_interface"`
+	BroadcastAddr        string                `json:"broadcast_addr"`
+	RedisAPIPort         int                   `json:"redis_api_port"`
+	HttpAPIPort          int                   `json:"http_api_port"`
+	DataDir              string                `json:"data_dir"`
+	MaxValueSize         int                   `json:"max_value_size"`
+	MaxCollectionSize    int                   `json:"max_collection_size"`
+	ProposeQueueSize     int                   `json:"propose_queue_size"`
+	MaxProposeBatchBytes int                   `json:"max_propose_batch_bytes"`
+	MaxProposalBytes     int                   `json:"max_proposal_bytes"`
+	ReadOnlyRedisAPIPort int                   `json:"read_only_redis_api_port"`
+	ReadOnlyCommands     []string              `json:"read_only_commands"`
+	SnapRetainNum        int                   `json:"snap_retain_num"`
+	SnapRetainSeconds    int                   `json:"snap_retain_seconds"`
+	MaxCompactingNum     int                   `json:"max_compacting_num"`
+	MaxFullReadSize      int                   `json:"max_full_read_size"`
+	ChecksumAlgo         string                `json:"checksum_algo"`
+	AuditLogDir          string                `json:"audit_log_dir"`
+	AuditLogMaxSize      int64                 `json:"audit_log_max_size"`
+	AuditRotateSeconds   int                   `json:"audit_rotate_seconds"`
+	AuditReads           bool                  `json:"audit_reads"`
+	ApplyStallTimeoutMs  int                   `json:"apply_stall_timeout_ms"`
+	ApplyStallCrash      bool                  `json:"apply_stall_crash"`
+	LatencyThresholdMs   int                   `json:"latency_threshold_ms"`
+	ExpireSweepMinRate   int                   `json:"expire_sweep_min_rate"`
+	ExpireSweepMaxRate   int                   `json:"expire_sweep_max_rate"`
+	MaxCommandArgs       int                   `json:"max_command_args"`
+	ProtoMaxBulkLen      int                   `json:"proto_max_bulk_len"`
+	RenameCommands       map[string]string     `json:"rename_commands"`
+	Namespaces           []NamespaceNodeConfig `json:"namespaces"`
 }
 
 type NamespaceConfig struct {
-	Name        string        `json:"name"`
-	EngType     string        `json:"eng_type"`
-	SnapCount   int           `json:"snap_count"`
-	SnapCatchup int           `json:"snap_catchup"`
-	ClusterConf ClusterConfig `json:"cluster_conf"`
+	Name                     string        `json:"name"`
+	EngType                  string        `json:"eng_type"`
+	SnapCount                int           `json:"snap_count"`
+	SnapCatchup              int           `json:"snap_catchup"`
+	ValueDedup               bool          `json:"value_dedup"`
+	BlobMinValueSize         int           `json:"blob_min_value_size"`
+	MaxBackgroundCompactions int           `json:"max_background_compactions"`
+	MaxBackgroundFlushes     int           `json:"max_background_flushes"`
+	BackgroundLowThreads     int           `json:"background_low_threads"`
+	BackgroundHighThreads    int           `json:"background_high_threads"`
+	MaxConcurrentScans       int           `json:"max_concurrent_scans"`
+	ScanTimeBudgetMs         int           `json:"scan_time_budget_ms"`
+	MinISR                   int           `json:"min_isr"`
+	MinISRReads              bool          `json:"min_isr_reads"`
+	WarmupMaxBytes           int64         `json:"warmup_max_bytes"`
+	WarmupTimeoutMs          int           `json:"warmup_timeout_ms"`
+	ProposeTimeoutMs         int           `json:"propose_timeout_ms"`
+	ClusterConf              ClusterConfig `json:"cluster_conf"`
 }
 
 type NamespaceNodeConfig struct {
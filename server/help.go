@@ -0,0 +1,102 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+type subCommandHelp struct {
+	name  string
+	usage string
+}
+
+// the usage of the subcommands for each multi-subcommand command, the
+// HELP subcommand and the unknown subcommand are handled here before
+// the command is dispatched.
+var subCommandHelps = map[string][]subCommandHelp{
+	"object": {
+		{"encoding", "ENCODING <key> -- Return the kind of internal representation used in order to store the value associated with a key."},
+	},
+	"cluster": {
+		{"readreplicas", "READREPLICAS <namespace> [partition] -- Return the replicas which can serve the read for the partition."},
+		{"catchup", "CATCHUP <namespace> <node> -- Return the catch-up progress of the replica, should be called on the leader."},
+		{"compactlog", "COMPACTLOG <namespace> -- Take a snapshot and truncate the raft log immediately, the log needed by the live followers is kept."},
+		{"snapshot", "SNAPSHOT <namespace> -- Take a snapshot at the applied index immediately, return the term, index and the backup dir."},
+		{"raftstate", "RAFTSTATE <namespace> -- Return the raft conf state, hard state and the members progress in json, read only."},
+		{"myid", "MYID <namespace> -- Return the raft member id of this node in the namespace."},
+		{"identity", "IDENTITY <namespace> -- Return the namespace, partition, raft member id and cluster id served by this node."},
+		{"snapcatchup", "SNAPCATCHUP <namespace> [entries] -- Get or set the log entries kept after the snapshot, the follower behind within them catches up from the log."},
+	},
+	"debug": {
+		{"set-active-expire", "SET-ACTIVE-EXPIRE <0|1> -- Pause or resume the active expire of all the namespaces."},
+		{"profile", "PROFILE <namespace> [samples] -- Return the histogram of the key types, sizes and ttl from the sampled keys."},
+		{"object", "OBJECT <key> -- Return the internal info of the key in the same format as redis."},
+	},
+	"client": {
+		{"pause", "PAUSE <timeout-ms> [WRITE|ALL] -- Suspend the data commands (or only the writes) of all the clients on this server for the timeout."},
+		{"unpause", "UNPAUSE -- Resume the paused clients."},
+	},
+	"latency": {
+		{"latest", "LATEST -- Return the latest latency sample, the time and the max latency of all the events (command names)."},
+		{"history", "HISTORY <event> -- Return the latency samples of the event, at most one sample each second."},
+		{"reset", "RESET [event ...] -- Reset the latency samples of the events, all the events if no event given."},
+	},
+	"readtxn": {
+		{"begin", "BEGIN <namespace> [timeout-ms] -- Begin the read transaction, the reads on the connection see the same snapshot until commit."},
+		{"commit", "COMMIT -- Commit the read transaction and release the snapshot."},
+	},
+	"table": {
+		{"list", "LIST <namespace> -- Return all the table names in the namespace."},
+		{"stats", "STATS <namespace> <table> -- Return the stats of the table."},
+		{"drop", "DROP <namespace> <table> -- Delete all the keys in the table."},
+	},
+}
+
+func getSubCommandHelp(cmdName string, subCmd string) (string, bool) {
+	for _, h := range subCommandHelps[cmdName] {
+		if h.name == subCmd {
+			return h.usage, true
+		}
+	}
+	return "", false
+}
+
+func unknownSubCommandError(cmdName string, subCmd string) string {
+	helps := subCommandHelps[cmdName]
+	names := make([]string, 0, len(helps)+1)
+	for _, h := range helps {
+		names = append(names, strings.ToUpper(h.name))
+	}
+	names = append(names, "HELP")
+	return "ERR unknown subcommand '" + subCmd + "' for '" + cmdName +
+		"'. Valid subcommands are: " + strings.Join(names, ", ")
+}
+
+func writeSubCommandHelp(conn redcon.Conn, cmdName string) {
+	helps := subCommandHelps[cmdName]
+	conn.WriteArray(len(helps) + 2)
+	conn.WriteString(strings.ToUpper(cmdName) + " <subcommand> arg arg ... arg. Subcommands are:")
+	for _, h := range helps {
+		conn.WriteString(h.usage)
+	}
+	conn.WriteString("HELP -- Return this help.")
+}
+
+// handle the HELP and the unknown subcommand for the multi-subcommand command,
+// return true if the command is handled.
+func handleSubCommandHelp(conn redcon.Conn, cmdName string, cmd redcon.Command) bool {
+	if _, ok := subCommandHelps[cmdName]; !ok || len(cmd.Args) < 2 {
+		return false
+	}
+	subCmd := qcmdlower(cmd.Args[1])
+	if subCmd == "help" {
+		writeSubCommandHelp(conn, cmdName)
+		return true
+	}
+	if _, ok := getSubCommandHelp(cmdName, subCmd); !ok {
+		conn.WriteError(unknownSubCommandError(cmdName, string(cmd.Args[1])))
+		return true
+	}
+	return false
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"strconv"
+	"time"
 
 	"github.com/absolute8511/ZanRedisDB/common"
 	"github.com/absolute8511/ZanRedisDB/node"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/julienschmidt/httprouter"
 )
@@ -96,6 +98,39 @@ func (self *Server) getLeader(w http.ResponseWriter, req *http.Request, ps httpr
 	return l, nil
 }
 
+func (self *Server) getNamespaceNodes() map[string]*NamespaceNode {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	nsNodes := make(map[string]*NamespaceNode, len(self.kvNodes))
+	for k, n := range self.kvNodes {
+		nsNodes[k] = n
+	}
+	return nsNodes
+}
+
+// the liveness is ok if the raft loop of all the namespaces are running,
+// the orchestration should restart the process if not alive.
+func (self *Server) checkLive(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	for ns, n := range self.getNamespaceNodes() {
+		if !n.node.IsAlive() {
+			return nil, Err{Code: http.StatusServiceUnavailable, Text: "namespace not alive: " + ns}
+		}
+	}
+	return "OK", nil
+}
+
+// the readiness is ok if all the namespaces can serve the read, the node
+// should be removed from the service while restoring the snapshot or
+// catching up the logs.
+func (self *Server) checkReady(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	for ns, n := range self.getNamespaceNodes() {
+		if !n.node.IsReadReady() {
+			return nil, Err{Code: http.StatusServiceUnavailable, Text: "namespace not ready: " + ns}
+		}
+	}
+	return "OK", nil
+}
+
 func (self *Server) getMembers(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	ns := ps.ByName("namespace")
 	v := self.GetNamespace(ns)
@@ -105,6 +140,112 @@ func (self *Server) getMembers(w http.ResponseWriter, req *http.Request, ps http
 	return v.node.GetMembers(), nil
 }
 
+func (self *Server) getInflightBackups(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	v := self.GetNamespace(ns)
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	return v.node.GetInflightBackups(), nil
+}
+
+func (self *Server) doCancelInflightBackups(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	v := self.GetNamespace(ns)
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	return map[string]interface{}{
+		"canceled": v.node.CancelInflightBackups(),
+	}, nil
+}
+
+func (self *Server) getReadStats(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	v := self.GetNamespace(ns)
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	return v.node.GetReplicaReadStats(), nil
+}
+
+// get the catch-up progress of the replica, should be requested to the leader
+func (self *Server) getReplicaCatchup(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	v := self.GetNamespace(ps.ByName("namespace"))
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	id, err := strconv.ParseUint(ps.ByName("node"), 10, 64)
+	if err != nil {
+		return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+	}
+	cs, err := v.node.GetReplicaCatchup(id)
+	if err != nil {
+		return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+	}
+	return cs, nil
+}
+
+func (self *Server) getRaftState(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	v := self.GetNamespace(ps.ByName("namespace"))
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	rs, err := v.node.DumpRaftState()
+	if err != nil {
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+	return rs, nil
+}
+
+// take a snapshot and truncate the raft log of the namespace immediately
+func (self *Server) doCompactLog(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	v := self.GetNamespace(ps.ByName("namespace"))
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	cs, err := v.node.CompactLog()
+	if err != nil {
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+	return cs, nil
+}
+
+// take a snapshot of the namespace at the applied index immediately
+func (self *Server) doSnapshot(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	v := self.GetNamespace(ps.ByName("namespace"))
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	sr, err := v.node.SnapshotNow()
+	if err == node.ErrBackupBusy {
+		return nil, Err{Code: http.StatusServiceUnavailable, Text: err.Error()}
+	} else if err != nil {
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+	return sr, nil
+}
+
+func (self *Server) doTransferLeader(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	nodeIdStr := ps.ByName("node")
+	nodeId, err := strconv.ParseUint(nodeIdStr, 10, 64)
+	if err != nil {
+		return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+	}
+	err = self.TransferLeader(ns, nodeId)
+	switch err {
+	case nil:
+		return nil, nil
+	case errNamespaceNotFound:
+		return nil, Err{Code: http.StatusNotFound, Text: err.Error()}
+	case node.ErrNotLeader, node.ErrNotMember, node.ErrTransfereeLagged:
+		return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+	default:
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+}
+
 func (self *Server) checkNodeBackup(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	ns := ps.ByName("namespace")
 	v := self.GetNamespace(ns)
@@ -122,16 +263,102 @@ func (self *Server) checkNodeBackup(w http.ResponseWriter, req *http.Request, ps
 	return nil, nil
 }
 
+func (self *Server) verifyRangeChecksums(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	v := self.GetNamespace(ns)
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	mismatched, err := v.node.VerifyRangeChecksums(req.Body)
+	if err != nil {
+		return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+	}
+	return mismatched, nil
+}
+
+func (self *Server) getChecksumAlgos(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	return rockredis.GetChecksumAlgos(), nil
+}
+
+func (self *Server) doCheckConsistency(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	v := self.GetNamespace(ns)
+	if v == nil {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	}
+	reqParams := req.URL.Query()
+	remote := reqParams.Get("remote")
+	if remote == "" {
+		return nil, Err{Code: http.StatusBadRequest, Text: "missing remote replica address"}
+	}
+	rangeKeyNum := 0
+	if rangeStr := reqParams.Get("range_keys"); rangeStr != "" {
+		var err error
+		rangeKeyNum, err = strconv.Atoi(rangeStr)
+		if err != nil {
+			return nil, Err{Code: http.StatusBadRequest, Text: err.Error()}
+		}
+	}
+	// the idle timeout in seconds of the connection to the replica
+	var timeout time.Duration
+	if timeoutStr := reqParams.Get("timeout"); timeoutStr != "" {
+		sec, err := strconv.Atoi(timeoutStr)
+		if err != nil || sec <= 0 {
+			return nil, Err{Code: http.StatusBadRequest, Text: "invalid timeout"}
+		}
+		timeout = time.Duration(sec) * time.Second
+	}
+	mismatched, err := v.node.CheckReplicaConsistency(remote, rangeKeyNum, timeout)
+	if err != nil {
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+	return map[string]interface{}{
+		"remote":            remote,
+		"consistent":        len(mismatched) == 0,
+		"mismatched_ranges": mismatched,
+	}, nil
+}
+
+// force restart the namespace as the standalone raft group, the confirm
+// parameter should be the namespace name to avoid the misuse.
+func (self *Server) doForceNewCluster(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	ns := ps.ByName("namespace")
+	if req.URL.Query().Get("confirm") != ns {
+		return nil, Err{Code: http.StatusBadRequest, Text: "the confirm should be the namespace name"}
+	}
+	err := self.ForceNewCluster(ns)
+	if err == errNamespaceNotFound {
+		return nil, Err{Code: http.StatusNotFound, Text: "no namespace found"}
+	} else if err != nil {
+		return nil, Err{Code: http.StatusInternalServerError, Text: err.Error()}
+	}
+	return nil, nil
+}
+
 func (self *Server) initHttpHandler() {
 	log := Log(1)
 	router := httprouter.New()
+	router.Handle("GET", "/health/live", Decorate(self.checkLive, V1))
+	router.Handle("GET", "/health/ready", Decorate(self.checkReady, V1))
 	router.Handle("GET", "/cluster/leader/:namespace", Decorate(self.getLeader, V1))
 	router.Handle("GET", "/cluster/members/:namespace", Decorate(self.getMembers, V1))
 	router.Handle("GET", "/cluster/checkbackup/:namespace", Decorate(self.checkNodeBackup, V1))
+	router.Handle("GET", "/cluster/readstats/:namespace", Decorate(self.getReadStats, V1))
+	router.Handle("GET", "/cluster/backups/:namespace", Decorate(self.getInflightBackups, V1))
+	router.Handle("GET", "/cluster/catchup/:namespace/:node", Decorate(self.getReplicaCatchup, V1))
+	router.Handle("POST", "/cluster/compactlog/:namespace", Decorate(self.doCompactLog, V1))
+	router.Handle("POST", "/cluster/snapshot/:namespace", Decorate(self.doSnapshot, V1))
+	router.Handle("GET", "/cluster/raftstate/:namespace", Decorate(self.getRaftState, V1))
 	router.Handle("GET", "/kv/get/:namespace", Decorate(self.getKey, PlainText))
 	router.Handle("POST", "/kv/optimize", Decorate(self.doOptimize, log, V1))
 	router.Handle("POST", "/cluster/node/add", Decorate(self.doAddNode, log, V1))
 	router.Handle("DELETE", "/cluster/node/remove/:namespace/:node", Decorate(self.doRemoveNode, log, V1))
+	router.Handle("POST", "/cluster/leader/transfer/:namespace/:node", Decorate(self.doTransferLeader, log, V1))
+	router.Handle("POST", "/cluster/backups/cancel/:namespace", Decorate(self.doCancelInflightBackups, log, V1))
+	router.Handle("GET", "/cluster/checksum/algos", Decorate(self.getChecksumAlgos, V1))
+	router.Handle("POST", "/cluster/checksum/verify/:namespace", Decorate(self.verifyRangeChecksums, log, V1))
+	router.Handle("POST", "/cluster/consistency/check/:namespace", Decorate(self.doCheckConsistency, log, V1))
+	router.Handle("POST", "/cluster/forcenew/:namespace", Decorate(self.doForceNewCluster, log, V1))
 	self.router = router
 }
 
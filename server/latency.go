@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/tidwall/redcon"
+)
+
+// latency latest
+// latency history event
+// latency reset [event ...]
+// the same replies as the redis latency monitor, the event is the command
+// name and the latency is in milliseconds.
+func (self *Server) latencyCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'latency' command")
+		return
+	}
+	switch qcmdlower(cmd.Args[1]) {
+	case "latest":
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'latency latest' command")
+			return
+		}
+		events := self.latency.Latest()
+		conn.WriteArray(len(events))
+		for _, e := range events {
+			conn.WriteArray(4)
+			conn.WriteBulkString(e.Event)
+			conn.WriteInt64(e.Time)
+			conn.WriteInt64(e.LatencyMs)
+			conn.WriteInt64(e.MaxMs)
+		}
+	case "history":
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'latency history' command")
+			return
+		}
+		samples := self.latency.History(qcmdlower(cmd.Args[2]))
+		conn.WriteArray(len(samples))
+		for _, s := range samples {
+			conn.WriteArray(2)
+			conn.WriteInt64(s.Time)
+			conn.WriteInt64(s.LatencyMs)
+		}
+	case "reset":
+		events := make([]string, 0, len(cmd.Args)-2)
+		for _, e := range cmd.Args[2:] {
+			events = append(events, qcmdlower(e))
+		}
+		conn.WriteInt(self.latency.Reset(events...))
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'latency'")
+	}
+}
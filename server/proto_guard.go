@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+const (
+	// the same as the redis limits
+	defaultMaxCommandArgs  = 1024 * 1024
+	defaultProtoMaxBulkLen = 512 * 1024 * 1024
+)
+
+var (
+	errProtoMultibulkLen = errors.New("Protocol error: invalid multibulk length")
+	errProtoBulkLen      = errors.New("Protocol error: invalid bulk length")
+	errProtoInlineLen    = errors.New("Protocol error: too big inline request")
+)
+
+const (
+	guardCmdStart = iota
+	guardInline
+	guardArgNum
+	guardBulkStart
+	guardBulkLen
+	guardBulkData
+)
+
+// respGuard check the headers of the commands in the RESP stream from the
+// client. The client can only send the multibulk of the bulks (or the inline
+// command), so the nested multibulk is refused as well.
+type respGuard struct {
+	maxArgs    int64
+	maxBulkLen int64
+	state      int
+	num        int64
+	argsLeft   int64
+	bytesLeft  int64
+}
+
+// check the data read, return the length of the data before the refused
+// command, so the commands pipelined before can be handled.
+func (g *respGuard) check(b []byte) (int, error) {
+	done := 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch g.state {
+		case guardCmdStart:
+			switch c {
+			case '*':
+				g.state = guardArgNum
+				g.num = 0
+			case '\r', '\n':
+			default:
+				g.state = guardInline
+				g.num = 1
+			}
+		case guardInline:
+			if c == '\n' {
+				g.state = guardCmdStart
+				done = i + 1
+				continue
+			}
+			g.num++
+			if g.num > g.maxBulkLen {
+				return done, errProtoInlineLen
+			}
+		case guardArgNum:
+			switch {
+			case c >= '0' && c <= '9':
+				g.num = g.num*10 + int64(c-'0')
+				if g.num > g.maxArgs {
+					return done, errProtoMultibulkLen
+				}
+			case c == '\r':
+			case c == '\n':
+				if g.num == 0 {
+					g.state = guardCmdStart
+					done = i + 1
+				} else {
+					g.argsLeft = g.num
+					g.state = guardBulkStart
+				}
+			default:
+				return done, errProtoMultibulkLen
+			}
+		case guardBulkStart:
+			if c != '$' {
+				return done, errors.New("Protocol error: expected '$', got '" + string(c) + "'")
+			}
+			g.state = guardBulkLen
+			g.num = 0
+		case guardBulkLen:
+			switch {
+			case c >= '0' && c <= '9':
+				g.num = g.num*10 + int64(c-'0')
+				if g.num > g.maxBulkLen {
+					return done, errProtoBulkLen
+				}
+			case c == '\r':
+			case c == '\n':
+				// the data and the ending CRLF
+				g.bytesLeft = g.num + 2
+				g.state = guardBulkData
+			default:
+				return done, errProtoBulkLen
+			}
+		case guardBulkData:
+			n := int64(len(b) - i)
+			if n > g.bytesLeft {
+				n = g.bytesLeft
+			}
+			g.bytesLeft -= n
+			i += int(n) - 1
+			if g.bytesLeft > 0 {
+				continue
+			}
+			g.argsLeft--
+			if g.argsLeft > 0 {
+				g.state = guardBulkStart
+			} else {
+				g.state = guardCmdStart
+				done = i + 1
+			}
+		}
+	}
+	return len(b), nil
+}
+
+// protoGuardConn check the protocol before the data is parsed by redcon, so
+// the abusive command will never be buffered. The connection is replied with
+// the protocol error and closed by redcon once the read failed.
+type protoGuardConn struct {
+	net.Conn
+	guard respGuard
+	err   error
+}
+
+func (c *protoGuardConn) Read(p []byte) (int, error) {
+	if c.err == nil {
+		n, err := c.Conn.Read(p)
+		if n == 0 {
+			return n, err
+		}
+		done, perr := c.guard.check(p[:n])
+		if perr == nil {
+			return n, err
+		}
+		c.err = perr
+		if done > 0 {
+			// refuse in the next read after the commands before replied
+			return done, nil
+		}
+	}
+	c.Conn.Write([]byte("-ERR " + c.err.Error() + "\r\n"))
+	return 0, c.err
+}
+
+type protoGuardListener struct {
+	net.Listener
+	maxArgs    int64
+	maxBulkLen int64
+}
+
+func newProtoGuardListener(ln net.Listener, maxArgs int, maxBulkLen int) net.Listener {
+	if maxArgs <= 0 {
+		maxArgs = defaultMaxCommandArgs
+	}
+	if maxBulkLen <= 0 {
+		maxBulkLen = defaultProtoMaxBulkLen
+	}
+	return &protoGuardListener{
+		Listener:   ln,
+		maxArgs:    int64(maxArgs),
+		maxBulkLen: int64(maxBulkLen),
+	}
+}
+
+func (l *protoGuardListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &protoGuardConn{
+		Conn:  c,
+		guard: respGuard{maxArgs: l.maxArgs, maxBulkLen: l.maxBulkLen},
+	}, nil
+}
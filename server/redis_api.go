@@ -1,18 +1,59 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/node"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/tidwall/redcon"
+	"net"
 	"runtime"
 	"strconv"
+	"time"
 )
 
+// wait the followers replicating the replping marker by default
+const defaultReplPingTimeout = time.Second
+
 var (
-	errInvalidCommand = errors.New("invalid command")
+	errInvalidCommand    = errors.New("invalid command")
+	errPartitionNotFound = errors.New("partition not found")
+	errReadOnlyCommand   = errors.New("ERR only the read commands are allowed on the read only port")
 )
 
+// renameCommand convert the command name from client to the original
+// command name, the renamed or disabled command is unknown.
+func (self *Server) renameCommand(cmd redcon.Command) (redcon.Command, bool, error) {
+	if len(self.renames) == 0 {
+		return cmd, false, nil
+	}
+	name, ok := self.renames[qcmdlower(cmd.Args[0])]
+	if !ok {
+		return cmd, false, nil
+	}
+	if name == "" {
+		return cmd, false, ErrUnknownCommand
+	}
+	// rebuild the command so the original name is proposed to raft
+	args := make([][]byte, len(cmd.Args))
+	copy(args, cmd.Args)
+	args[0] = []byte(name)
+	return buildCommand(args), true, nil
+}
+
 func (self *Server) serverRedis(conn redcon.Conn, cmd redcon.Command) {
+	cmd, renamed, err := self.renameCommand(cmd)
+	if err != nil {
+		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+		return
+	}
+	self.handleRedisCommand(conn, cmd, renamed)
+}
+
+func (self *Server) handleRedisCommand(conn redcon.Conn, cmd redcon.Command, renamed bool) {
 	defer func() {
 		if e := recover(); e != nil {
 			buf := make([]byte, 4096)
@@ -22,12 +63,20 @@ func (self *Server) serverRedis(conn redcon.Conn, cmd redcon.Command) {
 		}
 	}()
 
-	_, cmd, err := pipelineCommand(conn, cmd)
-	if err != nil {
-		conn.WriteError("pipeline error '" + err.Error() + "'")
-		return
+	// the pipelined commands are sent with the renamed name, so the
+	// renamed command is not merged with them.
+	if !renamed {
+		var err error
+		_, cmd, err = pipelineCommand(conn, cmd)
+		if err != nil {
+			conn.WriteError("pipeline error '" + err.Error() + "'")
+			return
+		}
 	}
 	cmdName := qcmdlower(cmd.Args[0])
+	if handleSubCommandHelp(conn, cmdName, cmd) {
+		return
+	}
 	switch cmdName {
 	case "detach":
 		hconn := conn.Detach()
@@ -43,10 +92,37 @@ func (self *Server) serverRedis(conn redcon.Conn, cmd redcon.Command) {
 		conn.WriteString("OK")
 		conn.Close()
 	case "info":
+		if len(cmd.Args) > 1 && qcmdlower(cmd.Args[1]) == "keyspace" {
+			self.infoKeyspace(conn)
+			return
+		}
 		s := self.GetStats()
 		d, _ := json.MarshalIndent(s, "", " ")
 		conn.WriteBulkString(string(d))
+	case "cluster":
+		self.clusterCommand(conn, cmd)
+	case "debug":
+		self.debugCommand(conn, cmd)
+	case "table":
+		self.tableCommand(conn, cmd)
+	case "waitflush":
+		self.waitFlushCommand(conn, cmd)
+	case "replping":
+		self.replPingCommand(conn, cmd)
+	case "staleread":
+		self.staleReadCommand(conn, cmd)
+	case "readtxn":
+		self.readTxnCommand(conn, cmd)
+	case "client":
+		self.clientCommand(conn, cmd)
+	case "latency":
+		self.latencyCommand(conn, cmd)
 	default:
+		self.waitClientPause(cmdName, cmd)
+		if rt, ok := conn.Context().(*connReadTxn); ok {
+			self.handleReadTxnCommand(conn, cmdName, cmd, rt)
+			return
+		}
 		h, cmd, err := self.GetHandler(cmdName, cmd)
 		if err == nil {
 			h(conn, cmd)
@@ -56,10 +132,624 @@ func (self *Server) serverRedis(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
-func (self *Server) serveRedisAPI(port int, stopC <-chan struct{}) {
+// the commands on the read only port are checked before handled,
+// any command may change the data will be rejected.
+func (self *Server) serverReadOnlyRedis(conn redcon.Conn, cmd redcon.Command) {
+	cmd, renamed, err := self.renameCommand(cmd)
+	if err != nil {
+		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+		return
+	}
+	cmdName := qcmdlower(cmd.Args[0])
+	if len(cmd.Args) >= 2 && qcmdlower(cmd.Args[1]) == "help" &&
+		handleSubCommandHelp(conn, cmdName, cmd) {
+		return
+	}
+	switch cmdName {
+	case "ping", "quit", "info":
+	default:
+		n, err := self.getCommandNamespace(cmdName, cmd)
+		if err != nil {
+			conn.WriteError("ERR handle command '" + string(cmd.Args[0]) + "' : " + err.Error())
+			return
+		}
+		if !n.node.IsReadOnlyAllowed(cmdName) {
+			conn.WriteError(errReadOnlyCommand.Error())
+			return
+		}
+	}
+	self.handleRedisCommand(conn, cmd, renamed)
+}
+
+// the keyspace info is a line for each namespace, the expires is the number
+// of the data with expire time and the expired is the number of the expired
+// data deleted by the sweeper since started.
+func (self *Server) infoKeyspace(conn redcon.Conn) {
+	s := self.GetStats()
+	var buf bytes.Buffer
+	buf.WriteString("# Keyspace\r\n")
+	for _, ns := range s.NSStats {
+		var keys int64
+		for _, ts := range ns.TStats {
+			keys += ts.KeyNum
+		}
+		es := ns.ExpireStats
+		fmt.Fprintf(&buf, "%s:keys=%d,expires=%d,avg_ttl=%d,expired=%d,expire_backlog=%d\r\n",
+			ns.Name, keys, es.TTLNum, es.AvgTTLMs, es.ExpiredNum, es.Backlog)
+	}
+	conn.WriteBulkString(buf.String())
+}
+
+func (self *Server) clusterCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError(errInvalidCommand.Error())
+		return
+	}
+	switch qcmdlower(cmd.Args[1]) {
+	case "readreplicas":
+		// cluster readreplicas namespace [partition]
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster readreplicas' command")
+			return
+		}
+		// each namespace has only one partition currently
+		if len(cmd.Args) == 4 && string(cmd.Args[3]) != "0" {
+			conn.WriteError(errPartitionNotFound.Error())
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		replicas := nsNode.node.GetReadReplicas()
+		conn.WriteArray(len(replicas))
+		for _, rs := range replicas {
+			conn.WriteArray(14)
+			conn.WriteBulkString("id")
+			conn.WriteInt64(int64(rs.ID))
+			conn.WriteBulkString("addr")
+			conn.WriteBulkString(rs.Addr)
+			conn.WriteBulkString("leader")
+			if rs.IsLeader {
+				conn.WriteInt(1)
+			} else {
+				conn.WriteInt(0)
+			}
+			conn.WriteBulkString("lag")
+			conn.WriteInt64(int64(rs.Lag))
+			conn.WriteBulkString("applied_index")
+			conn.WriteInt64(int64(rs.AppliedIndex))
+			conn.WriteBulkString("read_num")
+			conn.WriteInt64(rs.ReadNum)
+			conn.WriteBulkString("inflight_reads")
+			conn.WriteInt64(rs.InflightReads)
+		}
+	case "catchup":
+		// cluster catchup namespace node
+		if len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster catchup' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		id, err := strconv.ParseUint(string(cmd.Args[3]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR invalid node id: " + err.Error())
+			return
+		}
+		cs, err := nsNode.node.GetReplicaCatchup(id)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteArray(24)
+		conn.WriteBulkString("id")
+		conn.WriteInt64(int64(cs.ID))
+		conn.WriteBulkString("state")
+		conn.WriteBulkString(cs.State)
+		conn.WriteBulkString("snapshotting")
+		if cs.Snapshotting {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+		conn.WriteBulkString("match")
+		conn.WriteInt64(int64(cs.Match))
+		conn.WriteBulkString("commit_index")
+		conn.WriteInt64(int64(cs.CommitIndex))
+		conn.WriteBulkString("progress")
+		conn.WriteBulkString(strconv.FormatFloat(cs.Progress, 'f', 4, 64))
+		conn.WriteBulkString("in_sync")
+		if cs.InSync {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+		conn.WriteBulkString("estimated_ms")
+		conn.WriteInt64(cs.EstimatedMs)
+		conn.WriteBulkString("first_index")
+		conn.WriteInt64(int64(cs.FirstIndex))
+		conn.WriteBulkString("snap_catchup")
+		conn.WriteInt64(int64(cs.SnapCatchup))
+		conn.WriteBulkString("from_log")
+		if cs.FromLog {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+		conn.WriteBulkString("sent_snapshots")
+		conn.WriteInt64(cs.SentSnapshots)
+	case "compactlog":
+		// cluster compactlog namespace
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster compactlog' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		cs, err := nsNode.node.CompactLog()
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteArray(10)
+		conn.WriteBulkString("snap_index")
+		conn.WriteInt64(int64(cs.SnapIndex))
+		conn.WriteBulkString("compact_index")
+		conn.WriteInt64(int64(cs.CompactIndex))
+		conn.WriteBulkString("first_index")
+		conn.WriteInt64(int64(cs.FirstIndex))
+		conn.WriteBulkString("wal_files")
+		conn.WriteInt(cs.WALFiles)
+		conn.WriteBulkString("purged_wals")
+		conn.WriteInt(cs.PurgedWALs)
+	case "snapshot":
+		// cluster snapshot namespace
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster snapshot' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		sr, err := nsNode.node.SnapshotNow()
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteArray(6)
+		conn.WriteBulkString("term")
+		conn.WriteInt64(int64(sr.Term))
+		conn.WriteBulkString("index")
+		conn.WriteInt64(int64(sr.Index))
+		conn.WriteBulkString("backup_dir")
+		conn.WriteBulkString(sr.BackupDir)
+	case "raftstate":
+		// cluster raftstate namespace
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster raftstate' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		rs, err := nsNode.node.DumpRaftState()
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		d, _ := json.MarshalIndent(rs, "", " ")
+		conn.WriteBulkString(string(d))
+	case "myid":
+		// cluster myid namespace
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster myid' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		conn.WriteInt64(int64(nsNode.node.GetIdentity().ID))
+	case "identity":
+		// cluster identity namespace
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster identity' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		ni := nsNode.node.GetIdentity()
+		conn.WriteArray(10)
+		conn.WriteBulkString("namespace")
+		conn.WriteBulkString(ni.Namespace)
+		conn.WriteBulkString("partition")
+		conn.WriteInt(ni.Partition)
+		conn.WriteBulkString("id")
+		conn.WriteInt64(int64(ni.ID))
+		conn.WriteBulkString("cluster_id")
+		conn.WriteInt64(int64(ni.ClusterID))
+		conn.WriteBulkString("raft_addr")
+		conn.WriteBulkString(ni.RaftAddr)
+	case "snapcatchup":
+		// cluster snapcatchup namespace [entries]
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'cluster snapcatchup' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		if len(cmd.Args) == 4 {
+			n, err := strconv.ParseUint(string(cmd.Args[3]), 10, 64)
+			if err != nil {
+				conn.WriteError("ERR invalid snap catchup: " + err.Error())
+				return
+			}
+			if err := nsNode.node.SetSnapCatchup(n); err != nil {
+				conn.WriteError("ERR " + err.Error())
+				return
+			}
+		}
+		conn.WriteInt64(int64(nsNode.node.GetSnapCatchup()))
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'cluster'")
+	}
+}
+
+// waitflush namespace
+// wait until all the writes responded before are flushed to the local
+// store data files, return the durable applied index.
+func (self *Server) waitFlushCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		conn.WriteError("ERR wrong number of arguments for 'waitflush' command")
+		return
+	}
+	nsNode := self.GetNamespace(string(cmd.Args[1]))
+	if nsNode == nil {
+		conn.WriteError(errNamespaceNotFound.Error())
+		return
+	}
+	index, err := nsNode.node.FlushApplied()
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteInt64(int64(index))
+}
+
+// replping namespace [timeout-ms]
+// measure the replication latency by the marker proposed on the leader, reply
+// the id, the state (leader, ok or timeout) and the latency in microseconds
+// of each member, the latency of the timed out follower is -1.
+func (self *Server) replPingCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		conn.WriteError("ERR wrong number of arguments for 'replping' command")
+		return
+	}
+	timeout := defaultReplPingTimeout
+	if len(cmd.Args) == 3 {
+		ms, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil || ms <= 0 {
+			conn.WriteError("ERR invalid timeout")
+			return
+		}
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	nsNode := self.GetNamespace(string(cmd.Args[1]))
+	if nsNode == nil {
+		conn.WriteError(errNamespaceNotFound.Error())
+		return
+	}
+	stats, err := nsNode.node.ReplPing(timeout)
+	if err != nil {
+		conn.WriteError("ERR " + err.Error())
+		return
+	}
+	conn.WriteArray(len(stats))
+	for _, s := range stats {
+		state := "ok"
+		if s.Leader {
+			state = "leader"
+		} else if s.TimedOut {
+			state = "timeout"
+		}
+		conn.WriteArray(3)
+		conn.WriteInt64(int64(s.ID))
+		conn.WriteString(state)
+		conn.WriteInt64(s.LatencyUs)
+	}
+}
+
+// the read transaction began on the connection
+type connReadTxn struct {
+	ns  string
+	txn *node.ReadTxn
+}
+
+// readtxn begin namespace [timeout-ms]
+// readtxn commit
+// the read commands on the connection between begin and commit are served
+// from the same store snapshot of the namespace, the begin reply the applied
+// index of the snapshot at least.
+func (self *Server) readTxnCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'readtxn' command")
+		return
+	}
+	subCmd := qcmdlower(cmd.Args[1])
+	switch subCmd {
+	case "begin":
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'readtxn begin' command")
+			return
+		}
+		if _, ok := conn.Context().(*connReadTxn); ok {
+			conn.WriteError("ERR read transaction can not be nested")
+			return
+		}
+		var timeout time.Duration
+		if len(cmd.Args) == 4 {
+			ms, err := strconv.ParseInt(string(cmd.Args[3]), 10, 64)
+			if err != nil || ms <= 0 {
+				conn.WriteError("ERR invalid timeout")
+				return
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+		ns := string(cmd.Args[2])
+		nsNode := self.GetNamespace(ns)
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		txn, err := nsNode.node.BeginReadTxn(timeout)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.SetContext(&connReadTxn{ns: ns, txn: txn})
+		conn.WriteInt64(int64(txn.AppliedIndex()))
+	case "commit":
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'readtxn commit' command")
+			return
+		}
+		rt, ok := conn.Context().(*connReadTxn)
+		if !ok {
+			conn.WriteError("ERR readtxn commit without begin")
+			return
+		}
+		rt.txn.Commit()
+		conn.SetContext(nil)
+		conn.WriteString("OK")
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'readtxn'")
+	}
+}
+
+// the command in the read transaction should be the read command of the
+// keys in the namespace of the transaction.
+func (self *Server) handleReadTxnCommand(conn redcon.Conn, cmdName string,
+	cmd redcon.Command, rt *connReadTxn) {
+	n, err := self.getCommandNamespace(cmdName, cmd)
+	if err != nil {
+		conn.WriteError("ERR handle command '" + string(cmd.Args[0]) + "' : " + err.Error())
+		return
+	}
+	if n.conf.Name != rt.ns {
+		conn.WriteError("ERR the key is not in the namespace of the read transaction")
+		return
+	}
+	rt.txn.Handle(conn, cmd)
+}
+
+// staleread max-lag max-lag-ms command [args ...]
+// serve the read command on this replica only if the applied index is within
+// max-lag entries and max-lag-ms milliseconds of the commit index, otherwise
+// the STALE error with the leader is returned. The negative limit is ignored.
+func (self *Server) staleReadCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 5 {
+		conn.WriteError("ERR wrong number of arguments for 'staleread' command")
+		return
+	}
+	maxLag, err := strconv.ParseInt(string(cmd.Args[1]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid max lag: " + err.Error())
+		return
+	}
+	maxLagMs, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid max lag ms: " + err.Error())
+		return
+	}
+	args := make([][]byte, len(cmd.Args)-3)
+	copy(args, cmd.Args[3:])
+	rcmd, _, err := self.renameCommand(buildCommand(args))
+	if err != nil {
+		conn.WriteError("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	cmdName := qcmdlower(rcmd.Args[0])
+	n, err := self.getCommandNamespace(cmdName, rcmd)
+	if err != nil {
+		conn.WriteError("ERR handle command '" + string(args[0]) + "' : " + err.Error())
+		return
+	}
+	if !n.node.IsReadCommand(cmdName) {
+		conn.WriteError("ERR only the read commands are allowed for 'staleread'")
+		return
+	}
+	if err := n.node.CheckReadStaleness(maxLag, maxLagMs); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	h, _ := n.node.GetHandler(cmdName)
+	h(conn, rcmd)
+}
+
+// table list namespace
+// table stats namespace table
+// table drop namespace table
+func (self *Server) tableCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		conn.WriteError(errInvalidCommand.Error())
+		return
+	}
+	subCmd := qcmdlower(cmd.Args[1])
+	nsNode := self.GetNamespace(string(cmd.Args[2]))
+	if nsNode == nil {
+		conn.WriteError(errNamespaceNotFound.Error())
+		return
+	}
+	switch subCmd {
+	case "list":
+		names := nsNode.node.GetTableNames()
+		conn.WriteArray(len(names))
+		for _, name := range names {
+			conn.WriteBulkString(name)
+		}
+	case "stats", "drop":
+		if len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'table " + subCmd + "' command")
+			return
+		}
+		table := string(cmd.Args[3])
+		if subCmd == "drop" {
+			n, err := nsNode.node.DropTable(table)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+			conn.WriteInt64(n)
+			return
+		}
+		ts, err := nsNode.node.GetTableStats(table)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteArray(4)
+		conn.WriteBulkString("name")
+		conn.WriteBulkString(ts.Name)
+		conn.WriteBulkString("key_num")
+		conn.WriteInt64(ts.KeyNum)
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'table'")
+	}
+}
+
+func (self *Server) debugCommand(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		conn.WriteError(errInvalidCommand.Error())
+		return
+	}
+	switch qcmdlower(cmd.Args[1]) {
+	case "set-active-expire":
+		// debug set-active-expire 0|1
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'debug set-active-expire' command")
+			return
+		}
+		enable, err := strconv.Atoi(string(cmd.Args[2]))
+		if err != nil || (enable != 0 && enable != 1) {
+			conn.WriteError(errInvalidCommand.Error())
+			return
+		}
+		self.mutex.Lock()
+		for _, n := range self.kvNodes {
+			n.node.SetActiveExpire(enable == 1)
+		}
+		self.mutex.Unlock()
+		conn.WriteString("OK")
+	case "profile":
+		// debug profile namespace [samples]
+		if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'debug profile' command")
+			return
+		}
+		nsNode := self.GetNamespace(string(cmd.Args[2]))
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		samples := 0
+		if len(cmd.Args) == 4 {
+			var err error
+			samples, err = strconv.Atoi(string(cmd.Args[3]))
+			if err != nil || samples <= 0 {
+				conn.WriteError("ERR invalid sample number")
+				return
+			}
+		}
+		p, err := nsNode.node.ProfileKeys(samples)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		d, _ := json.MarshalIndent(p, "", " ")
+		conn.WriteBulkString(string(d))
+	case "object":
+		// debug object key
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'debug object' command")
+			return
+		}
+		ns, key, err := common.ExtractNamesapce(cmd.Args[2])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		nsNode := self.GetNamespace(ns)
+		if nsNode == nil {
+			conn.WriteError(errNamespaceNotFound.Error())
+			return
+		}
+		info, err := nsNode.node.DebugObject(key)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		if info == nil {
+			conn.WriteError("ERR no such key")
+			return
+		}
+		conn.WriteString(formatDebugObject(info))
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(cmd.Args[1]) + "' for 'debug'")
+	}
+}
+
+// the same fields as the redis DEBUG OBJECT, the address and the lru are
+// always zero since the object is not in memory. The quicklist fields are
+// not reported since the list elements are not packed into the nodes.
+func formatDebugObject(info *rockredis.ObjectDebugInfo) string {
+	return fmt.Sprintf("Value at:0x0 refcount:1 encoding:%s serializedlength:%d lru:0 lru_seconds_idle:0",
+		info.Encoding, info.SerializedLength)
+}
+
+func (self *Server) serveRedisAPI(port int, handler func(redcon.Conn, redcon.Command),
+	stopC <-chan struct{}) {
 	redisS := redcon.NewServer(
 		":"+strconv.Itoa(port),
-		self.serverRedis,
+		handler,
 		func(conn redcon.Conn) bool {
 			//sLog.Infof("accept: %s", conn.RemoteAddr())
 			return true
@@ -68,10 +758,18 @@ func (self *Server) serveRedisAPI(port int, stopC <-chan struct{}) {
 			if err != nil {
 				sLog.Infof("closed: %s, err: %v", conn.RemoteAddr(), err)
 			}
+			if rt, ok := conn.Context().(*connReadTxn); ok {
+				rt.txn.Commit()
+			}
 		},
 	)
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		sLog.Fatalf("failed to start the redis server: %v", err)
+	}
+	ln = newProtoGuardListener(ln, self.conf.MaxCommandArgs, self.conf.ProtoMaxBulkLen)
 	go func() {
-		err := redisS.ListenAndServe()
+		err := redisS.Serve(ln)
 		if err != nil {
 			sLog.Fatalf("failed to start the redis server: %v", err)
 		}
@@ -1,12 +1,29 @@
 package server
 
 import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/absolute8511/ZanRedisDB/common"
+	"github.com/absolute8511/ZanRedisDB/node"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
+	"github.com/coreos/etcd/raft/raftpb"
+	"github.com/coreos/etcd/wal"
+	"github.com/julienschmidt/httprouter"
 	"github.com/siddontang/goredis"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -16,6 +33,17 @@ var kvs *Server
 var redisport int
 var OK = "OK"
 
+const (
+	testMaxValueSize      = 64 * 1024
+	testMaxCollectionSize = 1000
+	testReadOnlyRedisPort = 22346
+	testMaxFullReadSize   = 500
+	testMaxProposalBytes  = 256 * 1024
+	testApplyStallTimeout = 500
+	testMaxCommandArgs    = 20000
+	testProtoMaxBulkLen   = 1024 * 1024
+)
+
 func startTestServer(t *testing.T) (*Server, int, string) {
 	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
 	if err != nil {
@@ -27,8 +55,16 @@ func startTestServer(t *testing.T) (*Server, int, string) {
 	clusterNodes := make(map[int]string)
 	clusterNodes[1] = raftAddr
 	kvOpts := ServerConfig{
-		DataDir:      tmpDir,
-		RedisAPIPort: redisport,
+		DataDir:              tmpDir,
+		RedisAPIPort:         redisport,
+		MaxValueSize:         testMaxValueSize,
+		MaxCollectionSize:    testMaxCollectionSize,
+		ReadOnlyRedisAPIPort: testReadOnlyRedisPort,
+		MaxFullReadSize:      testMaxFullReadSize,
+		MaxProposalBytes:     testMaxProposalBytes,
+		ApplyStallTimeoutMs:  testApplyStallTimeout,
+		MaxCommandArgs:       testMaxCommandArgs,
+		ProtoMaxBulkLen:      testProtoMaxBulkLen,
 	}
 	nsConf := &NamespaceConfig{
 		Name:    "default",
@@ -39,7 +75,14 @@ func startTestServer(t *testing.T) (*Server, int, string) {
 		clusterNodes, false, nsConf)
 
 	kv.ServeAPI()
-	time.Sleep(time.Millisecond * 10)
+	// wait the leader elected, the write will be refused without leader
+	start := time.Now()
+	for kv.GetNamespace("default").node.GetStats().RaftStats.Leader == 0 {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the leader is not elected")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
 	return kv, redisport, tmpDir
 }
 
@@ -467,6 +510,125 @@ func TestHashGetAll(t *testing.T) {
 	}
 }
 
+func TestHashFieldExpire(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:hfieldexpire"
+	if ok, err := goredis.String(c.Do("hmset", key, 1, 1, 2, 2, 3, 3)); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hpexpire", key, 100, "FIELDS", 2, 1, 4)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0].(int64) != 1 || v[1].(int64) != -2 {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hexpire", key, 100, "FIELDS", 1, 2)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || v[0].(int64) != 1 {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("httl", key, "FIELDS", 3, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 3 || v[0].(int64) <= 0 || v[1].(int64) <= 0 || v[2].(int64) != -1 {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hpersist", key, "FIELDS", 2, 2, 3)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0].(int64) != 1 || v[1].(int64) != -1 {
+		t.Fatal(v)
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	if v, err := goredis.MultiBulk(c.Do("hgetall", key)); err != nil {
+		t.Fatal(err)
+	} else {
+		if err := testHashArray(v, 2, 2, 3, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := goredis.MultiBulk(c.Do("hkeys", key)); err != nil {
+		t.Fatal(err)
+	} else {
+		if err := testHashArray(v, 2, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// wait the expired field deleted by the sweeper
+	time.Sleep(time.Second * 2)
+	if n, err := goredis.Int(c.Do("hlen", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("hexpire", key, 100, "FIELDS", 2, 2); err == nil {
+		t.Fatal("invalid err of fields number")
+	}
+	if _, err := c.Do("hexpire", key, 100, 2); err == nil {
+		t.Fatal("invalid err of args")
+	}
+}
+
+func TestHashFieldExpireCond(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:hfieldexpire_cond"
+	if _, err := c.Do("hmset", key, "ttl", 1, "nottl", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hexpire", key, 1000, "FIELDS", 1, "ttl"); err != nil {
+		t.Fatal(err)
+	}
+	fieldTTLs := func() (int64, int64) {
+		v, err := goredis.MultiBulk(c.Do("httl", key, "FIELDS", 2, "ttl", "nottl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v[0].(int64), v[1].(int64)
+	}
+	tests := []struct {
+		cmd      string
+		t        int64
+		cond     string
+		expected []int64
+	}{
+		{"hexpire", 2000, "NX", []int64{0, 1}},
+		{"hexpire", 2000, "XX", []int64{1, 0}},
+		// no ttl is infinite for GT and LT
+		{"hexpire", 3000, "gt", []int64{1, 0}},
+		{"hexpire", 1000, "GT", []int64{0, 0}},
+		{"hpexpire", 1000 * 1000, "LT", []int64{1, 1}},
+		{"hexpireat", time.Now().Unix() + 3000, "LT", []int64{0, 1}},
+	}
+	for _, tt := range tests {
+		if _, err := c.Do("hpersist", key, "FIELDS", 1, "nottl"); err != nil {
+			t.Fatal(err)
+		}
+		oldTTL, _ := fieldTTLs()
+		v, err := goredis.MultiBulk(c.Do(tt.cmd, key, tt.t, tt.cond, "FIELDS", 2, "ttl", "nottl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(v) != 2 || v[0].(int64) != tt.expected[0] || v[1].(int64) != tt.expected[1] {
+			t.Fatalf("%v %v %v should return %v: %v", tt.cmd, tt.t, tt.cond, tt.expected, v)
+		}
+		ttl, nottl := fieldTTLs()
+		if tt.expected[0] == 0 && (ttl > oldTTL || ttl < oldTTL-1) {
+			t.Fatalf("the skipped ttl should be unchanged: %v, %v", oldTTL, ttl)
+		}
+		if tt.expected[1] == 0 && nottl != -1 {
+			t.Fatalf("the skipped field should have no ttl: %v", nottl)
+		}
+	}
+	if _, err := c.Do("hexpire", key, 100, "NX", "XX", "FIELDS", 1, "ttl"); err == nil {
+		t.Fatal("only one condition is allowed")
+	}
+}
+
 func TestHashErrorParams(t *testing.T) {
 	c := getTestConn(t)
 	defer c.Close()
@@ -1708,27 +1870,30 @@ func checkScanValues(t *testing.T, ay interface{}, values ...interface{}) {
 }
 
 func checkAdvanceScan(t *testing.T, c *goredis.PoolConn, tp string) {
-	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:testscan:"+"", tp, "count", 5)); err != nil {
+	// the keys of each data type are written under the different prefix,
+	// since the key can only hold one data type
+	prefix := "testscan_" + strings.ToLower(tp) + ":"
+	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:"+prefix+"", tp, "count", 5)); err != nil {
 		t.Fatal(err)
 	} else if len(ay) != 2 {
 		t.Fatal(len(ay))
-	} else if n := ay[0].([]byte); string(n) != "testscan:4" {
+	} else if n := ay[0].([]byte); string(n) != prefix+"4" {
 		t.Fatal(string(n))
 	} else {
-		checkScanValues(t, ay[1], "testscan:0", "testscan:1", "testscan:2", "testscan:3", "testscan:4")
+		checkScanValues(t, ay[1], prefix+"0", prefix+"1", prefix+"2", prefix+"3", prefix+"4")
 	}
 
-	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:testscan:"+"4", tp, "count", 6)); err != nil {
+	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:"+prefix+"4", tp, "count", 6)); err != nil {
 		t.Fatal(err)
 	} else if len(ay) != 2 {
 		t.Fatal(len(ay))
 	} else if n := ay[0].([]byte); string(n) != "" {
 		t.Fatal(string(n))
 	} else {
-		checkScanValues(t, ay[1], "testscan:5", "testscan:6", "testscan:7", "testscan:8", "testscan:9")
+		checkScanValues(t, ay[1], prefix+"5", prefix+"6", prefix+"7", prefix+"8", prefix+"9")
 	}
 
-	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:testscan:"+"9", tp, "count", 0)); err != nil {
+	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:"+prefix+"9", tp, "count", 0)); err != nil {
 		t.Fatal(err)
 	} else if len(ay) != 2 {
 		t.Fatal(len(ay))
@@ -1754,7 +1919,7 @@ func TestScan(t *testing.T) {
 
 func testKVScan(t *testing.T, c *goredis.PoolConn) {
 	for i := 0; i < 10; i++ {
-		if _, err := c.Do("set", "default:testscan:"+fmt.Sprintf("%d", i), []byte("value")); err != nil {
+		if _, err := c.Do("set", "default:testscan_kv:"+fmt.Sprintf("%d", i), []byte("value")); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1763,7 +1928,7 @@ func testKVScan(t *testing.T, c *goredis.PoolConn) {
 
 func testHashKeyScan(t *testing.T, c *goredis.PoolConn) {
 	for i := 0; i < 10; i++ {
-		if _, err := c.Do("hset", "default:testscan:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i), []byte("value")); err != nil {
+		if _, err := c.Do("hset", "default:testscan_hash:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i), []byte("value")); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1773,7 +1938,7 @@ func testHashKeyScan(t *testing.T, c *goredis.PoolConn) {
 
 func testListKeyScan(t *testing.T, c *goredis.PoolConn) {
 	for i := 0; i < 10; i++ {
-		if _, err := c.Do("lpush", "default:testscan:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i)); err != nil {
+		if _, err := c.Do("lpush", "default:testscan_list:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i)); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1783,7 +1948,7 @@ func testListKeyScan(t *testing.T, c *goredis.PoolConn) {
 
 func testZSetKeyScan(t *testing.T, c *goredis.PoolConn) {
 	for i := 0; i < 10; i++ {
-		if _, err := c.Do("zadd", "default:testscan:"+fmt.Sprintf("%d", i), i, []byte("value")); err != nil {
+		if _, err := c.Do("zadd", "default:testscan_zset:"+fmt.Sprintf("%d", i), i, []byte("value")); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1793,7 +1958,7 @@ func testZSetKeyScan(t *testing.T, c *goredis.PoolConn) {
 
 func testSetKeyScan(t *testing.T, c *goredis.PoolConn) {
 	for i := 0; i < 10; i++ {
-		if _, err := c.Do("sadd", "default:testscan:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i)); err != nil {
+		if _, err := c.Do("sadd", "default:testscan_set:"+fmt.Sprintf("%d", i), fmt.Sprintf("%d", i)); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -1848,3 +2013,4559 @@ func TestZSetScan(t *testing.T) {
 		checkScanValues(t, ay[1], "a", 1, "b", 2)
 	}
 }
+
+func TestHashScanPaginated(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:testscan:scan_huge_hash"
+	fieldNum := 20000
+	for i := 0; i < fieldNum; i += 1000 {
+		args := make([]interface{}, 0, 2001)
+		args = append(args, key)
+		for j := i; j < i+1000; j++ {
+			args = append(args, fmt.Sprintf("f_%05d", j), j)
+		}
+		if _, err := c.Do("HMSET", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ay, err := goredis.Values(c.Do("HSCAN", key, "", "count", 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ay[0].([]byte)) != "f_00009" || len(ay[1].([]interface{})) != 20 {
+		t.Fatalf("the scan should return only the count fields: %s, %v", ay[0], len(ay[1].([]interface{})))
+	}
+
+	// the scan matching only the last field return the empty pages with the
+	// cursor instead of iterating the whole hash
+	found := 0
+	pages := 0
+	cursor := ""
+	for {
+		ay, err := goredis.Values(c.Do("HSCAN", key, cursor, "match", "f_19999", "count", 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		found += len(ay[1].([]interface{})) / 2
+		cursor = string(ay[0].([]byte))
+		if cursor == "" {
+			break
+		}
+	}
+	if found != 1 || pages < 100 {
+		t.Fatalf("the scan should be paginated: %v, %v", found, pages)
+	}
+
+	seen := make(map[string]bool, fieldNum)
+	cursor = ""
+	for {
+		ay, err := goredis.Values(c.Do("HSCAN", key, cursor, "count", 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fv, err := goredis.Strings(ay[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(fv); i += 2 {
+			if seen[fv[i]] {
+				t.Fatalf("the field %v scanned twice", fv[i])
+			}
+			seen[fv[i]] = true
+		}
+		cursor = string(ay[0].([]byte))
+		if cursor == "" {
+			break
+		}
+	}
+	if len(seen) != fieldNum {
+		t.Fatalf("the scan should cover all the fields: %v", len(seen))
+	}
+}
+
+func TestTransferLeader(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// make sure the leader is elected
+	if _, err := c.Do("set", "default:test:transfer_leader", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.TransferLeader("default", 2); err == nil {
+		t.Fatal("transfer leader to the non-member should be refused")
+	}
+	if err := kvs.TransferLeader("default", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.TransferLeader("nonexist_ns", 1); err == nil {
+		t.Fatal("transfer leader for the non-exist namespace should fail")
+	}
+
+	ns := "transfer_leader_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1020, nsConf),
+		2: getTestRaftAddr(t),
+		3: getTestRaftAddr(t),
+	}
+	doTransfer := func(ns string, nodeID string) int {
+		req := httptest.NewRequest("POST", "/cluster/leader/transfer/"+ns+"/"+nodeID, nil)
+		_, err := kvs.doTransferLeader(nil, req, httprouter.Params{
+			{Key: "namespace", Value: ns}, {Key: "node", Value: nodeID}})
+		if err == nil {
+			return http.StatusOK
+		}
+		return err.(Err).Code
+	}
+	if code := doTransfer("nonexist_ns", "1"); code != http.StatusNotFound {
+		t.Fatalf("the non-exist namespace should be not found: %v", code)
+	}
+	if code := doTransfer(ns, "a"); code != http.StatusBadRequest {
+		t.Fatalf("the invalid node should be refused: %v", code)
+	}
+	if code := doTransfer(ns, "2"); code != http.StatusBadRequest {
+		t.Fatalf("the non-member should be refused: %v", code)
+	}
+
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	startTestReplica(t, 1020, 2, map[int]string{1: raftAddrs[1], 2: raftAddrs[2]}, nsConf)
+	start := time.Now()
+	for {
+		cs, err := kvs.GetNamespace(ns).node.GetReplicaCatchup(2)
+		if err == nil && cs.InSync {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the replica should catch up: %v, %v", cs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	// the third member is never started, so it is lagging behind
+	addUnreachableMember(ns, 3, raftAddrs[3])
+	waitNamespaceMembers(t, ns, 1, 2, 3)
+	for i := 0; i < 200; {
+		// the writes may fail while the leader is waiting the new member
+		if _, err := c.Do("set", ns+":test:transfer_"+strconv.Itoa(i), "1"); err != nil {
+			if time.Since(start) > time.Second*30 {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		i++
+	}
+	if code := doTransfer(ns, "3"); code != http.StatusBadRequest {
+		t.Fatalf("the lagging member should be refused: %v", code)
+	}
+
+	start = time.Now()
+	for kvs.GetNamespace(ns).node.GetRaftStats().Leader != 2 {
+		// the transfer may be refused while the replica is catching up the writes
+		code := doTransfer(ns, "2")
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the leader should be transferred to the replica: %v", code)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if code := doTransfer(ns, "2"); code != http.StatusBadRequest {
+		t.Fatalf("the transfer on the follower should be refused: %v", code)
+	}
+}
+
+func TestStoreUnhealthyStepDown(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "store_health_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1022, nsConf),
+		2: getTestRaftAddr(t),
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	startTestReplica(t, 1022, 2, raftAddrs, nsConf)
+	leader := kvs.GetNamespace(ns).node
+	start := time.Now()
+	for {
+		cs, err := leader.GetReplicaCatchup(2)
+		if err == nil && cs.InSync {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the replica should catch up: %v, %v", cs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	key := ns + ":test:store_health"
+	leader.InjectStoreWriteError(errors.New("IO error: No space left on device"))
+	defer leader.InjectStoreWriteError(nil)
+	for i := 0; leader.GetStats().StoreHealthy; i++ {
+		if i > 10 {
+			t.Fatal("the store should be unhealthy after the write failures")
+		}
+		if _, err := c.Do("set", key, "v1"); err == nil {
+			t.Fatal("the write should fail on the leader store")
+		}
+	}
+	if _, err := c.Do("set", key, "v1"); err == nil || err.Error() != common.ErrStoreUnhealthy.Error() {
+		t.Fatalf("the write should be refused while the store is unhealthy: %v", err)
+	}
+	start = time.Now()
+	for leader.GetRaftStats().Leader != 2 {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the leader with the unhealthy store should step down")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if leader.GetStats().StoreHealthy {
+		t.Fatal("the store should be unhealthy while the probe failed")
+	}
+
+	leader.InjectStoreWriteError(nil)
+	start = time.Now()
+	for !leader.GetStats().StoreHealthy {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the store should be healthy again after the probe succeeded")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := c.Do("set", key, "v2"); err != nil {
+		t.Fatalf("the write should be accepted after the store recovered: %v", err)
+	}
+}
+
+func getReadReplicas(t *testing.T, c *goredis.PoolConn) []map[string]interface{} {
+	ay, err := goredis.Values(c.Do("cluster", "readreplicas", "default", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicas := make([]map[string]interface{}, 0, len(ay))
+	for _, r := range ay {
+		fields, err := goredis.Values(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fields)%2 != 0 {
+			t.Fatalf("invalid replica fields: %v", fields)
+		}
+		m := make(map[string]interface{})
+		for i := 0; i < len(fields); i += 2 {
+			m[string(fields[i].([]byte))] = fields[i+1]
+		}
+		replicas = append(replicas, m)
+	}
+	return replicas
+}
+
+func TestClusterReadReplicas(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:read_replicas"
+	if _, err := c.Do("set", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+	replicas := getReadReplicas(t, c)
+	if len(replicas) != 1 {
+		t.Fatalf("should have only one replica: %v", replicas)
+	}
+	for _, f := range []string{"id", "addr", "leader", "lag", "applied_index", "read_num", "inflight_reads"} {
+		if _, ok := replicas[0][f]; !ok {
+			t.Fatalf("missing field %v in replica: %v", f, replicas[0])
+		}
+	}
+	if replicas[0]["leader"].(int64) != 1 {
+		t.Fatalf("the replica should be leader: %v", replicas[0])
+	}
+	oldReadNum := replicas[0]["read_num"].(int64)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("get", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replicas = getReadReplicas(t, c)
+	if readNum := replicas[0]["read_num"].(int64); readNum < oldReadNum+10 {
+		t.Fatalf("read num should increase under load: %v, %v", oldReadNum, readNum)
+	}
+	if _, err := c.Do("cluster", "readreplicas", "default", 1); err == nil {
+		t.Fatal("should fail for the non-exist partition")
+	}
+	if _, err := c.Do("cluster", "readreplicas", "nonexist_ns"); err == nil {
+		t.Fatal("should fail for the non-exist namespace")
+	}
+}
+
+func TestObjectEncoding(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:object_encoding_hash"
+	if v, err := c.Do("object", "encoding", key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("non-exist key should have no encoding: %v", v)
+	}
+	if _, err := c.Do("hset", key, "f", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if enc, err := goredis.String(c.Do("object", "encoding", key)); err != nil {
+		t.Fatal(err)
+	} else if enc != "hashtable" {
+		t.Fatalf("the hash encoding mismatch: %v", enc)
+	}
+	if _, err := c.Do("object", "freq", key); err == nil {
+		t.Fatal("unknown object subcommand should fail")
+	}
+}
+
+func TestKVCompareAndSet(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:cas_lock"
+	if _, err := c.Do("set", key, "free"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var success int64
+	var mutex sync.Mutex
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn := getTestConn(t)
+			defer conn.Close()
+			n, err := goredis.Int64(conn.Do("cas", key, "free", "owner"+strconv.Itoa(id)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			success += n
+			mutex.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Fatalf("only one client should acquire the lock: %v", success)
+	}
+	owner, err := goredis.String(c.Do("get", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := goredis.Int(c.Do("cad", key, "free")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("cad with the unexpected value should fail: %v", n)
+	}
+	if n, err := goredis.Int(c.Do("cad", key, owner)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("cad by the owner should success: %v", n)
+	}
+	if n, err := goredis.Int(c.Do("exists", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("key should be deleted: %v", n)
+	}
+	if _, err := c.Do("cas", key, "free"); err == nil {
+		t.Fatal("cas with wrong number of arguments should fail")
+	}
+}
+
+func TestAdvanceScanWithSnapshot(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", "default:testsnapscan:"+fmt.Sprintf("%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ay, err := goredis.Values(c.Do("ADVSCAN", "default:testsnapscan:", "KV", "count", 5, "snapshot", "new"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ay) != 3 {
+		t.Fatal(len(ay))
+	} else if n := ay[0].([]byte); string(n) != "testsnapscan:4" {
+		t.Fatal(string(n))
+	}
+	snapID := string(ay[2].([]byte))
+	// keys deleted while scanning should still be scanned from the snapshot
+	for i := 5; i < 10; i++ {
+		if _, err := c.Do("del", "default:testsnapscan:"+fmt.Sprintf("%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:testsnapscan:4", "KV", "count", 6, "snapshot", snapID)); err != nil {
+		t.Fatal(err)
+	} else if len(ay) != 3 {
+		t.Fatal(len(ay))
+	} else if n := ay[0].([]byte); string(n) != "" {
+		t.Fatal(string(n))
+	} else {
+		checkScanValues(t, ay[1], "testsnapscan:5", "testsnapscan:6", "testsnapscan:7", "testsnapscan:8", "testsnapscan:9")
+	}
+	// the snapshot is released after the scan finished
+	if _, err := c.Do("ADVSCAN", "default:testsnapscan:", "KV", "snapshot", snapID); err == nil {
+		t.Fatal("scan with the released snapshot should fail")
+	}
+
+	ay, err = goredis.Values(c.Do("ADVSCAN", "default:testsnapscan:", "KV", "count", 2,
+		"snapshot", "new", "snapshotttl", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapID = string(ay[2].([]byte))
+	time.Sleep(time.Millisecond * 1500)
+	if _, err := c.Do("ADVSCAN", "default:testsnapscan:1", "KV", "snapshot", snapID); err == nil {
+		t.Fatal("scan with the expired snapshot should fail")
+	}
+}
+
+func TestDrainNamespace(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsConf := &NamespaceConfig{
+		Name:    "drain_test",
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kvs, 1001, nsConf)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	successNum := 0
+	rejectedNum := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn := getTestConn(t)
+			defer conn.Close()
+			for j := 0; ; j++ {
+				key := fmt.Sprintf("drain_test:test:drain_key_%d_%d", id, j)
+				_, err := conn.Do("set", key, "v")
+				mutex.Lock()
+				if err == nil {
+					successNum++
+					mutex.Unlock()
+					continue
+				}
+				mutex.Unlock()
+				if strings.Contains(err.Error(), "draining") {
+					mutex.Lock()
+					rejectedNum++
+					mutex.Unlock()
+				} else if !strings.Contains(err.Error(), errNamespaceNotFound.Error()) {
+					t.Errorf("the write should not fail while draining: %v", err)
+				}
+				return
+			}
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if err := kvs.DrainNamespace("drain_test", time.Second*5); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if successNum == 0 {
+		t.Fatal("the in-flight writes should be done")
+	}
+	if rejectedNum == 0 {
+		t.Fatal("the new writes should be rejected while draining")
+	}
+	t.Logf("writes done: %v, rejected: %v", successNum, rejectedNum)
+	time.Sleep(time.Millisecond * 100)
+	if kvs.GetNamespace("drain_test") != nil {
+		t.Fatal("the namespace should be stopped after drained")
+	}
+}
+
+func TestCrossNamespaceKeys(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key1 := "default:test:cross_ns_a"
+	key2 := "other:test:cross_ns_b"
+	for _, args := range [][]interface{}{
+		{"mset", key1, "1", key2, "2"},
+		{"mget", key1, key2},
+		{"del", key1, key2},
+	} {
+		_, err := c.Do(args[0].(string), args[1:]...)
+		if err == nil || !strings.HasPrefix(err.Error(), "CROSSSLOT") {
+			t.Fatalf("%v with keys in different namespaces should fail with CROSSSLOT: %v", args[0], err)
+		}
+	}
+	if v, err := c.Do("get", key1); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the key should not be written by the rejected command: %v", v)
+	}
+
+	if ok, err := goredis.String(c.Do("mset", key1, "1", "default:test:cross_ns_c", "2")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+	if ay, err := goredis.Strings(c.Do("mget", key1, "default:test:cross_ns_c")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ay, []string{"1", "2"}) {
+		t.Fatalf("mget mismatch: %v", ay)
+	}
+}
+
+func TestValueSizeLimit(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:append_limit"
+	half := strings.Repeat("a", testMaxValueSize/2)
+	for i := 0; i < 2; i++ {
+		if n, err := goredis.Int(c.Do("append", key, half)); err != nil {
+			t.Fatal(err)
+		} else if n != len(half)*(i+1) {
+			t.Fatalf("append length mismatch: %v", n)
+		}
+	}
+	if _, err := c.Do("append", key, "b"); err == nil {
+		t.Fatal("append past the value size limit should fail")
+	}
+	if _, err := c.Do("setrange", key, testMaxValueSize, "b"); err == nil {
+		t.Fatal("setrange past the value size limit should fail")
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != half+half {
+		t.Fatalf("the value should be unchanged after the rejected writes: %v", len(v))
+	}
+	if _, err := c.Do("set", "default:test:set_limit", half+half+"b"); err == nil {
+		t.Fatal("set past the value size limit should fail")
+	} else if err.Error() != "ERR the value size exceed the limit" {
+		t.Fatalf("the value size error mismatch: %v", err)
+	}
+	if _, err := c.Do("setrange", key, -1, "b"); err == nil {
+		t.Fatal("setrange with the negative offset should fail")
+	} else if err.Error() != "ERR offset is out of range" {
+		t.Fatalf("the offset error mismatch: %v", err)
+	}
+
+	lkey := "default:test:list_limit"
+	args := make([]interface{}, 0, testMaxCollectionSize+1)
+	args = append(args, lkey)
+	for i := 0; i < testMaxCollectionSize; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	if n, err := goredis.Int(c.Do("rpush", args...)); err != nil {
+		t.Fatal(err)
+	} else if n != testMaxCollectionSize {
+		t.Fatalf("rpush length mismatch: %v", n)
+	}
+	if _, err := c.Do("rpush", lkey, "overflow"); err == nil {
+		t.Fatal("rpush past the collection limit should fail")
+	} else if err.Error() != "ERR the collection element count exceed the limit" {
+		t.Fatalf("the collection size error mismatch: %v", err)
+	}
+	if n, err := goredis.Int(c.Do("llen", lkey)); err != nil {
+		t.Fatal(err)
+	} else if n != testMaxCollectionSize {
+		t.Fatalf("the list should be unchanged after the rejected push: %v", n)
+	}
+}
+
+func TestValueSizeLimitReplicated(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "limit_replicate_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1021, nsConf),
+		2: getTestRaftAddr(t),
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+
+	// the replica configured with the smaller limit should apply the write
+	// with the limit proposed by the leader
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	replica := NewServer(ServerConfig{DataDir: tmpDir, RedisAPIPort: getTestFreePort(t),
+		MaxValueSize: 16})
+	if err := replica.InitKVNamespace(1021, 2, raftAddrs[2], raftAddrs, true, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	replica.ServeAPI()
+	defer replica.Stop()
+
+	key := ns + ":test:value_limit"
+	value := strings.Repeat("v", 1024)
+	start := time.Now()
+	for {
+		_, err := c.Do("set", key, value)
+		if err == nil {
+			break
+		}
+		// the leader may step down while the new replica is starting
+		if time.Since(start) > time.Second*10 {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	start = time.Now()
+	for {
+		v, err := replica.GetNamespace(ns).node.Lookup([]byte(key))
+		if err == nil && string(v) == value {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the write should be applied on the replica: %v, %v", len(v), err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func TestCollectionLimitWithExpiredReplicated(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+	// the expired entries stay stored without the active expire
+	if _, err := c.Do("debug", "set-active-expire", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do("debug", "set-active-expire", 1)
+
+	ns := "limit_expired_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1028, nsConf)
+	replicaRaftAddr := getTestRaftAddr(t)
+	addUnreachableMember(ns, 2, replicaRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1028, 2, map[int]string{1: raftAddr, 2: replicaRaftAddr}, nsConf)
+	rc := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replica.conf.RedisAPIPort), "")
+	replicaConn, err := rc.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaConn.Close()
+
+	hkey := ns + ":test:limit_expired_hash"
+	zkey := ns + ":test:limit_expired_zset"
+	hargs := []interface{}{hkey}
+	zargs := []interface{}{zkey}
+	for i := 0; i < testMaxCollectionSize; i++ {
+		hargs = append(hargs, "f"+strconv.Itoa(i), "v")
+		zargs = append(zargs, i, "m"+strconv.Itoa(i))
+	}
+	start := time.Now()
+	for {
+		_, err := c.Do("hmset", hargs...)
+		if err == nil {
+			break
+		}
+		// the leader may step down while the new replica is starting
+		if time.Since(start) > time.Second*10 {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := c.Do("zadd", zargs...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hpexpire", hkey, 10, "FIELDS", 1, "f0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zrem", zkey, "m0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zaddex", zkey, 1, 0, "m0"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 1100)
+	if n, err := goredis.Int(c.Do("hlen", hkey)); err != nil || n != testMaxCollectionSize-1 {
+		t.Fatalf("the expired field should be hidden: %v, %v", n, err)
+	}
+
+	// the stored expired entries are counted on all the replicas whatever
+	// the local time is
+	if _, err := c.Do("hset", hkey, "new", "v"); err == nil {
+		t.Fatal("the hset past the stored collection limit should fail")
+	}
+	if _, err := c.Do("zadd", zkey, 1, "new"); err == nil {
+		t.Fatal("the zadd past the stored collection limit should fail")
+	}
+	marker := ns + ":test:limit_expired_marker"
+	if _, err := c.Do("set", marker, "1"); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	for {
+		v, err := goredis.String(replicaConn.Do("get", marker))
+		if err == nil && v == "1" {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the writes should be applied on the replica: %v, %v", v, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	for _, conn := range []*goredis.PoolConn{c, replicaConn} {
+		if n, err := goredis.Int(conn.Do("hexists", hkey, "new")); err != nil || n != 0 {
+			t.Fatalf("the rejected field should not be written: %v, %v", n, err)
+		}
+		if _, err := goredis.Int64(conn.Do("zscore", zkey, "new")); err != goredis.ErrNil {
+			t.Fatalf("the rejected member should not be written: %v", err)
+		}
+	}
+}
+
+// the size of the command proposed to raft, the namespace of the keys removed
+func respCommandSize(args []string) int {
+	n := 1 + len(strconv.Itoa(len(args))) + 2
+	for _, arg := range args {
+		n += 1 + len(strconv.Itoa(len(arg))) + 2 + len(arg) + 2
+	}
+	return n
+}
+
+func TestProposalSizeLimit(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// build the mset command with the size just at the limit
+	pairs := 5
+	proposed := []string{"mset"}
+	for i := 0; i < pairs; i++ {
+		proposed = append(proposed, fmt.Sprintf("test:proposal_limit_%d", i), strings.Repeat("v", 50000))
+	}
+	last := len(proposed) - 1
+	proposed[last] += strings.Repeat("v", testMaxProposalBytes-respCommandSize(proposed))
+	if respCommandSize(proposed) != testMaxProposalBytes || len(proposed[last]) > testMaxValueSize {
+		t.Fatalf("the command size should be at the limit: %v", respCommandSize(proposed))
+	}
+	args := make([]interface{}, 0, len(proposed)-1)
+	for i, arg := range proposed[1:] {
+		if i%2 == 0 {
+			arg = "default:" + arg
+		}
+		args = append(args, arg)
+	}
+	if v, err := goredis.String(c.Do("mset", args...)); err != nil {
+		t.Fatalf("the command just under the limit should succeed: %v", err)
+	} else if v != OK {
+		t.Fatal(v)
+	}
+
+	args[len(args)-1] = proposed[last] + "v"
+	_, err := c.Do("mset", args...)
+	if err == nil {
+		t.Fatal("the command just over the limit should fail")
+	}
+	if !strings.Contains(err.Error(), strconv.Itoa(testMaxProposalBytes+1)) ||
+		!strings.Contains(err.Error(), strconv.Itoa(testMaxProposalBytes)) {
+		t.Fatalf("the error should name the command size and the limit: %v", err)
+	}
+	if v, err := goredis.String(c.Do("get", "default:test:proposal_limit_0")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 50000 {
+		t.Fatalf("the value should be written by the command under the limit: %v", len(v))
+	}
+	// the node still serve the writes after the command refused
+	if _, err := c.Do("set", "default:test:proposal_limit_after", "v"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeConcurrently(t *testing.T, ns string, writerNum int, writeNum int) {
+	var wg sync.WaitGroup
+	for i := 0; i < writerNum; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn := getTestConn(t)
+			defer conn.Close()
+			for j := 0; j < writeNum; j++ {
+				key := fmt.Sprintf("%s:test:propose_queue_%d_%d", ns, id, j)
+				if _, err := conn.Do("set", key, "v"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestProposeQueueSaturation(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// the writers can never fill the default propose queue
+	defaultNode := kvs.GetNamespace("default").node
+	before := defaultNode.GetStats().ProposeQueueFull
+	writeConcurrently(t, "default", 16, 20)
+	stats := defaultNode.GetStats()
+	if stats.ProposeQueueFull != before {
+		t.Fatalf("the propose queue should not be saturated: %v, %v", before, stats.ProposeQueueFull)
+	}
+
+	// use a separate server for the tiny propose queue, so the shared test
+	// server config is not changed
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+	})
+	small := NewServer(ServerConfig{DataDir: tmpDir, RedisAPIPort: getTestFreePort(t), ProposeQueueSize: 1})
+	t.Cleanup(small.Stop)
+	ns := "propose_queue_test"
+	startTestNamespace(t, small, 1002, &NamespaceConfig{Name: ns, EngType: "rocksdb"})
+	smallNode := small.GetNamespace(ns).node
+
+	// block the apply of the first write, the proposing loop waits it
+	// applied, so the next write stays in the queue and the others find the
+	// queue full.
+	applying := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	var unblockOnce sync.Once
+	release := func() {
+		unblockOnce.Do(func() {
+			close(unblock)
+		})
+	}
+	defer release()
+	smallNode.SetApplyHook(func(string) {
+		select {
+		case applying <- struct{}{}:
+		default:
+		}
+		<-unblock
+	})
+	defer smallNode.SetApplyHook(nil)
+
+	var wg sync.WaitGroup
+	propose := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := buildCommand([][]byte{[]byte("set"), []byte("test:propose_queue_" + strconv.Itoa(i)), []byte("v")})
+			if _, err := smallNode.Propose(cmd.Raw); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	propose(0)
+	select {
+	case <-applying:
+	case <-time.After(time.Second * 10):
+		t.Fatal("the first write is not applying")
+	}
+	for i := 1; i <= 3; i++ {
+		propose(i)
+	}
+	start := time.Now()
+	for smallNode.GetStats().ProposeQueueFull < 2 {
+		if time.Since(start) > time.Second*2 {
+			t.Fatalf("the tiny propose queue should be saturated: %v", smallNode.GetStats().ProposeQueueFull)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	release()
+	wg.Wait()
+	stats = smallNode.GetStats()
+	if stats.ProposeQueueSize != 1 {
+		t.Fatalf("propose queue size mismatch: %v", stats.ProposeQueueSize)
+	}
+	if stats.ProposeQueueFull != 2 {
+		t.Fatalf("only the writes finding the queue full should be counted: %v", stats.ProposeQueueFull)
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key1 := "default:test:hll_a"
+	key2 := "default:test:hll_b"
+	args1 := []interface{}{key1}
+	args2 := []interface{}{key2}
+	for i := 0; i < 1000; i++ {
+		args1 = append(args1, fmt.Sprintf("elem_%d", i))
+		args2 = append(args2, fmt.Sprintf("elem_%d", i+500))
+	}
+	if n, err := goredis.Int(c.Do("pfadd", args1...)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("pfadd", args2...)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("pfadd", key1, "elem_0")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("the existing element should not change the hll: %v", n)
+	}
+	if n, err := goredis.Int(c.Do("pfcount", key1)); err != nil {
+		t.Fatal(err)
+	} else if n < 990 || n > 1010 {
+		t.Fatalf("the estimated cardinality is out of range: %v", n)
+	}
+	union, err := goredis.Int(c.Do("pfcount", key1, key2))
+	if err != nil {
+		t.Fatal(err)
+	} else if union < 1485 || union > 1515 {
+		t.Fatalf("the estimated union cardinality is out of range: %v", union)
+	}
+	dest := "default:test:hll_merged"
+	if ok, err := goredis.String(c.Do("pfmerge", dest, key1, key2)); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+	if n, err := goredis.Int(c.Do("pfcount", dest)); err != nil {
+		t.Fatal(err)
+	} else if n != union {
+		t.Fatalf("the merged cardinality %v should be equal to the union %v", n, union)
+	}
+}
+
+func TestActiveExpireSwitch(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:active_expire_hash"
+	if _, err := c.Do("hmset", key, "f1", "v1", "f2", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := goredis.String(c.Do("debug", "set-active-expire", 0)); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+	defer c.Do("debug", "set-active-expire", 1)
+	if _, err := c.Do("hpexpire", key, 10, "FIELDS", 1, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second * 3)
+	if v, err := c.Do("hget", key, "f1"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the expired field should be hidden: %v", v)
+	}
+	if n, err := goredis.Int(c.Do("hlen", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("the expired field should not be reclaimed while active expire is off: %v", n)
+	}
+	stats := kvs.GetNamespace("default").node.GetStats()
+	if stats.ExpireStats.ActiveExpire || stats.ExpireStats.Backlog < 1 {
+		t.Fatalf("the expired field should be in the backlog: %v", stats.ExpireStats)
+	}
+
+	if _, err := c.Do("debug", "set-active-expire", 1); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for {
+		n, err := goredis.Int(c.Do("hlen", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		if time.Since(start) > time.Second*5 {
+			t.Fatalf("the expired field should be reclaimed after active expire enabled: %v", n)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	stats = kvs.GetNamespace("default").node.GetStats()
+	if stats.ExpireStats.ExpiredNum < 1 {
+		t.Fatalf("the expired num should be counted: %v", stats.ExpireStats)
+	}
+}
+
+func TestProposeBatchStats(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsNode := kvs.GetNamespace("default").node
+	before := nsNode.GetStats().BatchStats
+	// the trickle writes wait the response one by one, so each batch has only one request
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", fmt.Sprintf("default:test:batch_trickle_%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after := nsNode.GetStats().BatchStats
+	if after.BatchNumStats[0]-before.BatchNumStats[0] < 10 {
+		t.Fatalf("the trickle writes should be proposed in single batches: %v, %v",
+			before.BatchNumStats, after.BatchNumStats)
+	}
+
+	before = after
+	writeConcurrently(t, "default", 32, 20)
+	after = nsNode.GetStats().BatchStats
+	var largeBatches int64
+	for i := 1; i < len(after.BatchNumStats); i++ {
+		largeBatches += after.BatchNumStats[i] - before.BatchNumStats[i]
+	}
+	if largeBatches <= 0 {
+		t.Fatalf("the burst writes should be proposed in large batches: %v, %v",
+			before.BatchNumStats, after.BatchNumStats)
+	}
+}
+
+func TestTableCommands(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for _, table := range []string{"tblcmd_a", "tblcmd_b"} {
+		for i := 0; i < 3; i++ {
+			if _, err := c.Do("set", fmt.Sprintf("default:%s:key_%d", table, i), "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := c.Do("hset", "default:"+table+":hash", "f", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tables, err := goredis.Strings(c.Do("table", "list", "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, table := range tables {
+		if table == "tblcmd_a" || table == "tblcmd_b" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("the tables created by writes should be listed: %v", tables)
+	}
+	if stats, err := goredis.MultiBulk(c.Do("table", "stats", "default", "tblcmd_a")); err != nil {
+		t.Fatal(err)
+	} else if len(stats) != 4 || stats[3].(int64) != 4 {
+		t.Fatalf("table stats mismatch: %v", stats)
+	}
+
+	if n, err := goredis.Int(c.Do("table", "drop", "default", "tblcmd_a")); err != nil {
+		t.Fatal(err)
+	} else if n != 4 {
+		t.Fatalf("dropped keys mismatch: %v", n)
+	}
+	tables, err = goredis.Strings(c.Do("table", "list", "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		if table == "tblcmd_a" {
+			t.Fatalf("the dropped table should not be listed: %v", tables)
+		}
+	}
+	if v, err := c.Do("get", "default:tblcmd_a:key_0"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the key in dropped table should be deleted: %v", v)
+	}
+	if v, err := goredis.String(c.Do("get", "default:tblcmd_b:key_0")); err != nil {
+		t.Fatal(err)
+	} else if v != "v" {
+		t.Fatalf("the key in other table should not be deleted: %v", v)
+	}
+	if n, err := goredis.Int(c.Do("hlen", "default:tblcmd_b:hash")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the hash in other table should not be deleted: %v", n)
+	}
+}
+
+func TestReadOnlyPort(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(testReadOnlyRedisPort), "")
+	rc, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	key := "default:test:read_only_port"
+	if _, err := rc.Do("set", key, "v1"); err == nil {
+		t.Fatal("the write command should be rejected on the read only port")
+	}
+	if ok, err := goredis.String(c.Do("set", key, "v2")); err != nil {
+		t.Fatal(err)
+	} else if ok != OK {
+		t.Fatal(ok)
+	}
+	if v, err := goredis.String(rc.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v2" {
+		t.Fatalf("the read command should be allowed on the read only port: %v", v)
+	}
+	for _, args := range [][]interface{}{
+		{"del", key},
+		{"hset", "default:test:read_only_hash", "f", "v"},
+		{"pfadd", "default:test:read_only_hll", "a"},
+	} {
+		if _, err := rc.Do(args[0].(string), args[1:]...); err == nil {
+			t.Fatalf("the write command %v should be rejected on the read only port", args[0])
+		}
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v2" {
+		t.Fatalf("the value should not be changed by the read only port: %v", v)
+	}
+}
+
+func TestSubCommandHelp(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for cmdName := range subCommandHelps {
+		lines, err := goredis.Strings(c.Do(cmdName, "help"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != len(subCommandHelps[cmdName])+2 {
+			t.Fatalf("%v help should return the usage for all the subcommands: %v", cmdName, lines)
+		}
+		for _, l := range lines {
+			if l == "" {
+				t.Fatalf("%v help should not have empty usage: %v", cmdName, lines)
+			}
+		}
+		_, err = c.Do(cmdName, "nonexist_subcmd", "default:test:help")
+		if err == nil {
+			t.Fatalf("%v unknown subcommand should fail", cmdName)
+		}
+		for _, h := range subCommandHelps[cmdName] {
+			if !strings.Contains(err.Error(), strings.ToUpper(h.name)) {
+				t.Fatalf("the error should name the valid subcommand %v: %v", h.name, err)
+			}
+		}
+	}
+}
+
+func TestDropKeyPrefix(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		if _, err := c.Do("set", "default:test:dropprefix_a:"+strconv.Itoa(i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Do("set", "default:test:dropprefix_b:0", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := goredis.Int(c.Do("dropprefix", "default:test:dropprefix_a:")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the prefix should be dropped: %v", n)
+	}
+	key := "default:test:dropprefix_a:1"
+	if v, err := c.Do("get", key); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the dropped key should be hidden immediately: %v", v)
+	}
+	if v, err := goredis.String(c.Do("get", "default:test:dropprefix_b:0")); err != nil {
+		t.Fatal(err)
+	} else if v != "v" {
+		t.Fatalf("the key not under the prefix should be kept: %v", v)
+	}
+	// the prefix can be written again after the drop accounted, compacted and
+	// finished in background
+	start := time.Now()
+	for {
+		_, err := c.Do("set", key, "v2")
+		if err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the prefix drop should be finished: %v", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v2" {
+		t.Fatalf("the new key under the dropped prefix should be visible: %v", v)
+	}
+	if v, err := c.Do("get", "default:test:dropprefix_a:2"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the dropped key should be removed: %v", v)
+	}
+}
+
+func TestRaftStats(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	if _, err := c.Do("set", "default:test:raft_stats", "v"); err != nil {
+		t.Fatal(err)
+	}
+	rs := kvs.GetNamespace("default").node.GetStats().RaftStats
+	if rs.Role != "StateLeader" {
+		t.Fatalf("the single replica should be leader: %v", rs)
+	}
+	if rs.Term == 0 || rs.Leader != rs.ID {
+		t.Fatalf("the leader and term should be reported after elected: %v", rs)
+	}
+	if len(rs.Members) != 1 || rs.Members[0].ID != rs.ID {
+		t.Fatalf("the members should be reported on the leader: %v", rs)
+	}
+	if rs.Members[0].Match == 0 || rs.Members[0].Lag != 0 {
+		t.Fatalf("the leader itself should not lag: %v", rs.Members[0])
+	}
+}
+
+func TestRaftStatsAfterElection(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "raft_stats_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1023, nsConf),
+		2: getTestRaftAddr(t),
+		3: getTestRaftAddr(t),
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1023, 2, map[int]string{1: raftAddrs[1], 2: raftAddrs[2]}, nsConf)
+	leader := kvs.GetNamespace(ns).node
+	start := time.Now()
+	for {
+		cs, err := leader.GetReplicaCatchup(2)
+		if err == nil && cs.InSync {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the replica should catch up: %v, %v", cs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	before := leader.GetRaftStats()
+
+	// the third member is never started, so it is lagging behind
+	addUnreachableMember(ns, 3, raftAddrs[3])
+	waitNamespaceMembers(t, ns, 1, 2, 3)
+	for i := 0; i < 10; {
+		// the writes may fail while the leader is waiting the new member
+		if _, err := c.Do("set", ns+":test:raft_stats_"+strconv.Itoa(i), "v"); err != nil {
+			if time.Since(start) > time.Second*30 {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		i++
+	}
+	rs := leader.GetRaftStats()
+	if len(rs.Members) != 3 || rs.Members[2].ID != 3 {
+		t.Fatalf("all the members should be reported on the leader: %v", rs)
+	}
+	if rs.Members[2].Lag == 0 || rs.Members[2].Match >= rs.Members[0].Match {
+		t.Fatalf("the unreachable member should lag: %v", rs.Members)
+	}
+
+	start = time.Now()
+	for replica.GetNamespace(ns).node.GetRaftStats().Role != "StateLeader" {
+		// the transfer may be refused while the replica is catching up the writes
+		leader.TransferLeadership(2)
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the leader should be transferred to the replica")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	rs = replica.GetNamespace(ns).node.GetRaftStats()
+	if rs.Leader != 2 || rs.Term <= before.Term {
+		t.Fatalf("the new leader and term should be reported after elected: %v, %v", before, rs)
+	}
+	if len(rs.Members) != 3 {
+		t.Fatalf("all the members should be reported on the new leader: %v", rs)
+	}
+	start = time.Now()
+	for {
+		rs = leader.GetRaftStats()
+		if rs.Role == "StateFollower" && rs.Leader == 2 && rs.Term > before.Term {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the old leader should report the new leader and term: %v, %v", before, rs)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if len(rs.Members) != 0 {
+		t.Fatalf("the members progress should only be reported on the leader: %v", rs.Members)
+	}
+}
+
+func TestFollowerWrite(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "follower_write_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1024, nsConf),
+		2: getTestRaftAddr(t),
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1024, 2, raftAddrs, nsConf)
+	start := time.Now()
+	for replica.GetNamespace(ns).node.GetRaftStats().Leader != 1 {
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the replica should follow the leader")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replica.conf.RedisAPIPort), "")
+	rc, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	// the write on the follower is forwarded to the leader by raft, and the
+	// result is returned after applied on the follower
+	key := ns + ":test:follower_write"
+	if v, err := goredis.String(rc.Do("set", key, "v1")); err != nil {
+		t.Fatal(err)
+	} else if v != OK {
+		t.Fatal(v)
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatalf("the write on the follower should be applied on the leader: %v", v)
+	}
+	if _, err := rc.Do("hset", key, "f", "v"); err == nil || err.Error() != rockredis.ErrWrongType.Error() {
+		t.Fatalf("the write error should be returned on the follower: %v", err)
+	}
+
+	// the follower can not elect itself without the stopped leader
+	stopTestNamespace(t, kvs, ns)
+	start = time.Now()
+	for replica.GetNamespace(ns).node.GetRaftStats().Leader != 0 {
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the follower should lose the leader")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := rc.Do("set", key, "v2"); err == nil || err.Error() != common.ErrNoLeader.Error() {
+		t.Fatalf("the write without leader should be refused: %v", err)
+	}
+}
+
+func TestFollowerWriteRefusedByLeader(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// the leader refuses the writes without enough in-sync replicas since the
+	// third member is never started
+	ns := "follower_write_refused_test"
+	nsConf := &NamespaceConfig{
+		Name:             ns,
+		EngType:          "rocksdb",
+		MinISR:           3,
+		ProposeTimeoutMs: 500,
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1029, nsConf),
+		2: getTestRaftAddr(t),
+		3: getTestRaftAddr(t),
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1029, 2, raftAddrs, nsConf)
+	start := time.Now()
+	for replica.GetNamespace(ns).node.GetRaftStats().Leader != 1 {
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the replica should follow the leader")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	addUnreachableMember(ns, 3, raftAddrs[3])
+	waitNamespaceMembers(t, ns, 1, 2, 3)
+
+	key := ns + ":test:follower_write_refused"
+	if _, err := c.Do("set", key, "v1"); err == nil || !strings.Contains(err.Error(), "NOREPLICAS") {
+		t.Fatalf("the write on the leader should be refused: %v", err)
+	}
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replica.conf.RedisAPIPort), "")
+	rc, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	// the forwarded write is dropped by the leader, the follower stops
+	// waiting after the propose timeout
+	start = time.Now()
+	if _, err := rc.Do("set", key, "v2"); err == nil || err.Error() != common.ErrProposeTimeout.Error() {
+		t.Fatalf("the write on the follower should be timeout: %v", err)
+	}
+	if cost := time.Since(start); cost > time.Second*5 {
+		t.Fatalf("the write on the follower should return in the propose timeout: %v", cost)
+	}
+	if v, err := c.Do("get", key); err != nil || v != nil {
+		t.Fatalf("the refused write should not be applied: %v, %v", v, err)
+	}
+	// the proposing is not blocked by the dropped write
+	if _, err := rc.Do("set", key, "v3"); err == nil || err.Error() != common.ErrProposeTimeout.Error() {
+		t.Fatalf("the write on the follower should be timeout: %v", err)
+	}
+}
+
+func TestWaitFlush(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsNode := kvs.GetNamespace("default").node
+	if _, err := c.Do("set", "default:test:wait_flush", "v"); err != nil {
+		t.Fatal(err)
+	}
+	stats := nsNode.GetStats()
+	if stats.InternalStats["num-entries-active-mem-table"] == "0" {
+		t.Fatal("the write should be buffered in the memtable before flushed")
+	}
+	index, err := goredis.Int64(c.Do("waitflush", "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(index) < stats.AppliedIndex {
+		t.Fatalf("the durable index %v should cover the applied writes %v", index, stats.AppliedIndex)
+	}
+	stats = nsNode.GetStats()
+	if stats.InternalStats["num-entries-active-mem-table"] != "0" {
+		t.Fatalf("the memtable should be flushed: %v", stats.InternalStats)
+	}
+	if stats.DurableIndex != uint64(index) {
+		t.Fatalf("the durable index mismatch: %v, %v", stats.DurableIndex, index)
+	}
+	if _, err := c.Do("waitflush", "nonexist_ns"); err == nil {
+		t.Fatal("wait flush on the non exist namespace should fail")
+	}
+}
+
+func TestZSetDiffUnionInter(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key1 := "default:test:zsetops_a"
+	key2 := "default:test:zsetops_b"
+	if _, err := c.Do("zadd", key1, 1, "a", 2, "b", 3, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", key2, 10, "b", 20, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if ay, err := goredis.Strings(c.Do("zdiff", 2, key1, key2, "withscores")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ay, []string{"a", "1", "c", "3"}) {
+		t.Fatalf("zdiff should return the members only in the first set: %v", ay)
+	}
+	if ay, err := goredis.Strings(c.Do("zunion", 2, key1, key2, "weights", 1, 2,
+		"aggregate", "max", "withscores")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ay, []string{"a", "1", "c", "3", "b", "20", "d", "40"}) {
+		t.Fatalf("zunion mismatch: %v", ay)
+	}
+	if ay, err := goredis.Strings(c.Do("zinter", 2, key1, key2)); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ay, []string{"b"}) {
+		t.Fatalf("zinter mismatch: %v", ay)
+	}
+	dest := "default:test:zsetops_dest"
+	if n, err := goredis.Int(c.Do("zdiffstore", dest, 2, key1, key2)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("zdiffstore should store the diff members: %v", n)
+	}
+	if ay, err := goredis.Strings(c.Do("zrange", dest, 0, -1, "withscores")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ay, []string{"a", "1", "c", "3"}) {
+		t.Fatalf("zdiffstore should write the same set as zdiff: %v", ay)
+	}
+	if _, err := c.Do("zdiff", 3, key1, key2); err == nil {
+		t.Fatal("numkeys more than the keys should fail")
+	}
+	if _, err := c.Do("zdiffstore", dest, 1, "other_ns:test:zsetops_a"); err == nil {
+		t.Fatal("zdiffstore across namespaces should fail")
+	}
+}
+
+func TestHealthLiveReady(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	if _, err := kvs.checkLive(nil, nil, nil); err != nil {
+		t.Fatalf("the server should be alive: %v", err)
+	}
+	start := time.Now()
+	for {
+		_, err := kvs.checkReady(nil, nil, nil)
+		if err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the server should be ready after the leader elected: %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	n := kvs.GetNamespace("default").node
+	if !n.IsAlive() || !n.IsReadReady() {
+		t.Fatal("the namespace should be alive and ready")
+	}
+}
+
+func TestHealthReadyWhileRestoring(t *testing.T) {
+	// the snapshot is copied from the member with the same broadcast address
+	// by the data dir, so both servers need the http api for the backup check
+	newServer := func() *Server {
+		tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(tmpDir)
+		})
+		s := NewServer(ServerConfig{
+			DataDir:       tmpDir,
+			BroadcastAddr: "127.0.0.1",
+			RedisAPIPort:  getTestFreePort(t),
+			HttpAPIPort:   getTestFreePort(t),
+		})
+		s.ServeAPI()
+		t.Cleanup(s.Stop)
+		return s
+	}
+	ns := "health_restore_test"
+	nsConf := &NamespaceConfig{
+		Name:        ns,
+		EngType:     "rocksdb",
+		SnapCatchup: 10,
+	}
+	leader := newServer()
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, leader, 1025, nsConf),
+		2: getTestRaftAddr(t),
+	}
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(leader.conf.RedisAPIPort), "")
+	c, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	leader.ProposeConfChange(ns, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  2,
+		Context: mustMarshalMember(ns, 2, raftAddrs[2]),
+	})
+	for i := 0; i < 100; i++ {
+		if _, err := c.Do("set", ns+":test:restore_"+strconv.Itoa(i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaderNode := leader.GetNamespace(ns).node
+	start := time.Now()
+	for {
+		// the log is kept for the new member until it is inactive
+		if _, err := leaderNode.CompactLog(); err != nil {
+			t.Fatal(err)
+		}
+		cs, err := leaderNode.GetReplicaCatchup(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cs.FromLog {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the log should be compacted beyond the new member: %v", cs)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+
+	restoring := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	var unblockOnce sync.Once
+	release := func() {
+		unblockOnce.Do(func() {
+			close(unblock)
+		})
+	}
+	defer release()
+	node.SetRestoreHook(func(restoreNs string) {
+		if restoreNs != ns {
+			return
+		}
+		select {
+		case restoring <- struct{}{}:
+		default:
+		}
+		<-unblock
+	})
+	defer node.SetRestoreHook(nil)
+
+	replica := newServer()
+	if err := replica.InitKVNamespace(1025, 2, raftAddrs[2], raftAddrs, true, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-restoring:
+	case <-time.After(time.Second * 20):
+		t.Fatal("the new member should restore from the snapshot")
+	}
+	if _, err := replica.checkReady(nil, nil, nil); err == nil || err.(Err).Code != http.StatusServiceUnavailable {
+		t.Fatalf("the replica should not be ready while restoring: %v", err)
+	}
+	if _, err := replica.checkLive(nil, nil, nil); err != nil {
+		t.Fatalf("the replica should be alive while restoring: %v", err)
+	}
+	release()
+	start = time.Now()
+	for {
+		if _, err := replica.checkLive(nil, nil, nil); err != nil {
+			t.Fatalf("the replica should be alive after restored: %v", err)
+		}
+		if _, err := replica.checkReady(nil, nil, nil); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the replica should be ready after restored")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if v, err := replica.GetNamespace(ns).node.Lookup([]byte(ns + ":test:restore_1")); err != nil || string(v) != "v" {
+		t.Fatalf("the restored data mismatch: %v, %v", v, err)
+	}
+}
+
+func TestFullReadLimit(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:full_read_limit"
+	args := make([]interface{}, 0, testMaxFullReadSize*2+1)
+	args = append(args, key)
+	for i := 0; i < testMaxFullReadSize; i++ {
+		args = append(args, strconv.Itoa(i), "v")
+	}
+	if _, err := c.Do("hmset", args...); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hgetall", key)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != testMaxFullReadSize*2 {
+		t.Fatalf("hgetall in the limit should return all: %v", len(v))
+	}
+	if _, err := c.Do("hset", key, "overflow", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hgetall", key); err == nil {
+		t.Fatal("hgetall exceed the read limit should fail")
+	} else if !strings.Contains(err.Error(), "HSCAN") {
+		t.Fatalf("the error should suggest the scan: %v", err)
+	}
+	if _, err := c.Do("hkeys", key); err == nil {
+		t.Fatal("hkeys exceed the read limit should fail")
+	}
+
+	lkey := "default:test:full_read_limit_list"
+	args = make([]interface{}, 0, testMaxFullReadSize+2)
+	args = append(args, lkey)
+	for i := 0; i <= testMaxFullReadSize; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	if _, err := c.Do("rpush", args...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("lrange", lkey, 0, -1); err == nil {
+		t.Fatal("lrange exceed the read limit should fail")
+	}
+	if v, err := goredis.MultiBulk(c.Do("lrange", lkey, 1, -1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != testMaxFullReadSize {
+		t.Fatalf("lrange in the limit should return all: %v", len(v))
+	}
+
+	skey := "default:test:full_read_limit_set"
+	args = make([]interface{}, 0, testMaxFullReadSize+2)
+	args = append(args, skey)
+	for i := 0; i <= testMaxFullReadSize; i++ {
+		args = append(args, strconv.Itoa(i))
+	}
+	if _, err := c.Do("sadd", args...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("smembers", skey); err == nil {
+		t.Fatal("smembers exceed the read limit should fail")
+	}
+	// the page larger than the read limit is limited, and all the members
+	// can still be read by the cursor
+	members := make(map[string]bool)
+	cursor := ""
+	for {
+		ay, err := goredis.Values(c.Do("sscan", skey, cursor, "count", testMaxFullReadSize*10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := goredis.Strings(ay[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(page) > testMaxFullReadSize {
+			t.Fatalf("the scan page exceed the read limit: %v", len(page))
+		}
+		for _, m := range page {
+			members[m] = true
+		}
+		cursor = string(ay[0].([]byte))
+		if cursor == "" {
+			break
+		}
+	}
+	if len(members) != testMaxFullReadSize+1 {
+		t.Fatalf("all the members should be scanned: %v", len(members))
+	}
+}
+
+func TestNextID(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:nextid"
+	if n, err := goredis.Int64(c.Do("nextid", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("the first id should be 1: %v", n)
+	}
+	if _, err := c.Do("nextid", key, 0); err == nil {
+		t.Fatal("nextid with zero count should fail")
+	}
+
+	// the ranges allocated by the concurrent clients should be disjoint
+	// and cover all the ids without gaps.
+	const clientNum = 2
+	const callNum = 50
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allocated := make(map[int64]bool)
+	for i := 0; i < clientNum; i++ {
+		wg.Add(1)
+		go func(count int64) {
+			defer wg.Done()
+			conn := getTestConn(t)
+			defer conn.Close()
+			last := int64(0)
+			for j := 0; j < callNum; j++ {
+				start, err := goredis.Int64(conn.Do("nextid", key, count))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if start <= last {
+					t.Errorf("the range should be increasing: %v, %v", start, last)
+					return
+				}
+				last = start
+				mutex.Lock()
+				for id := start; id < start+count; id++ {
+					if allocated[id] {
+						t.Errorf("the id %v allocated twice", id)
+					}
+					allocated[id] = true
+				}
+				mutex.Unlock()
+			}
+		}(int64(i + 1))
+	}
+	wg.Wait()
+	total := int64(0)
+	for i := 0; i < clientNum; i++ {
+		total += int64(i+1) * callNum
+	}
+	if int64(len(allocated)) != total {
+		t.Fatalf("the allocated ids mismatch: %v, %v", len(allocated), total)
+	}
+	for id := int64(2); id < 2+total; id++ {
+		if !allocated[id] {
+			t.Fatalf("the id %v should be allocated", id)
+		}
+	}
+	if _, err := c.Do("set", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := goredis.Int64(c.Do("nextid", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 2+total {
+		t.Fatalf("the sequence should not be changed by the kv: %v", n)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	auditDir, err := ioutil.TempDir("", fmt.Sprintf("audit-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(auditDir)
+	kvs.conf.AuditLogDir = auditDir
+	kvs.conf.AuditLogMaxSize = 4096
+	nsConf := &NamespaceConfig{
+		Name:    "audit_test",
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kvs, 1003, nsConf)
+	kvs.conf.AuditLogDir = ""
+	kvs.conf.AuditLogMaxSize = 0
+	for i := 0; i < 100; i++ {
+		if _, err := c.Do("set", fmt.Sprintf("audit_test:test:audit_%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Do("get", "audit_test:test:audit_read"); err != nil {
+		t.Fatal(err)
+	}
+
+	readAll := func() (string, int) {
+		files, _ := filepath.Glob(filepath.Join(auditDir, "audit_test.audit.log*"))
+		var all string
+		for _, f := range files {
+			d, _ := ioutil.ReadFile(f)
+			all += string(d)
+		}
+		return all, len(files)
+	}
+	start := time.Now()
+	for {
+		all, _ := readAll()
+		if strings.Contains(all, "\tset\ttest:audit_99\n") {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the applied writes should be in the audit log")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	all, fileNum := readAll()
+	for i := 0; i < 100; i++ {
+		if !strings.Contains(all, fmt.Sprintf("\taudit_test\tset\ttest:audit_%d\n", i)) {
+			t.Fatalf("the write %v should be in the audit log", i)
+		}
+	}
+	if strings.Contains(all, "audit_read") {
+		t.Fatal("the read should not be in the audit log")
+	}
+	if fileNum < 2 {
+		t.Fatalf("the audit log should be rotated by the size: %v", fileNum)
+	}
+}
+
+func TestAuditLogReplay(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+	})
+	auditDir := filepath.Join(tmpDir, "audit")
+	s := NewServer(ServerConfig{DataDir: tmpDir, RedisAPIPort: getTestFreePort(t), AuditLogDir: auditDir})
+	s.ServeAPI()
+	t.Cleanup(s.Stop)
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(s.conf.RedisAPIPort), "")
+	c, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ns := "audit_replay_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, s, 1026, nsConf)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", ns+":test:audit_"+strconv.Itoa(i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the index is saved after the log flushed while stopping
+	stopTestNamespace(t, s, ns)
+	if err := s.InitKVNamespace(1026, 1, raftAddr, map[int]string{1: raftAddr}, false, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for {
+		if _, err := c.Do("set", ns+":test:audit_restarted", "v"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the restarted namespace should accept the writes")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	stopTestNamespace(t, s, ns)
+
+	d, err := ioutil.ReadFile(filepath.Join(auditDir, ns+".audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := string(d)
+	for i := 0; i < 10; i++ {
+		if n := strings.Count(all, "\tset\ttest:audit_"+strconv.Itoa(i)+"\n"); n != 1 {
+			t.Fatalf("the write %v should be logged once after the log replayed: %v", i, n)
+		}
+	}
+	if n := strings.Count(all, "\tset\ttest:audit_restarted\n"); n != 1 {
+		t.Fatalf("the write after restarted should be logged once: %v", n)
+	}
+}
+
+func TestAdminProposeUnderLoad(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+	})
+	s := NewServer(ServerConfig{DataDir: tmpDir, RedisAPIPort: getTestFreePort(t), ProposeQueueSize: 1})
+	t.Cleanup(s.Stop)
+	ns := "admin_propose_test"
+	startTestNamespace(t, s, 1004, &NamespaceConfig{Name: ns, EngType: "rocksdb"})
+	nsNode := s.GetNamespace(ns).node
+
+	// block the apply of the first write and record the applied order
+	var mutex sync.Mutex
+	applied := make([]string, 0)
+	applying := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	var unblockOnce sync.Once
+	release := func() {
+		unblockOnce.Do(func() {
+			close(unblock)
+		})
+	}
+	defer release()
+	nsNode.SetApplyHook(func(cmdName string) {
+		mutex.Lock()
+		applied = append(applied, cmdName)
+		mutex.Unlock()
+		select {
+		case applying <- struct{}{}:
+		default:
+		}
+		<-unblock
+	})
+	defer nsNode.SetApplyHook(nil)
+
+	var wg sync.WaitGroup
+	propose := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := buildCommand([][]byte{[]byte("set"), []byte("test:admin_propose_" + strconv.Itoa(i)), []byte("v")})
+			if _, err := nsNode.Propose(cmd.Raw); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	propose(0)
+	select {
+	case <-applying:
+	case <-time.After(time.Second * 10):
+		t.Fatal("the first write is not applying")
+	}
+	propose(1)
+	start := time.Now()
+	for nsNode.GetStats().ProposeQueueLen != 1 {
+		if time.Since(start) > time.Second*2 {
+			t.Fatal("the write should be queued")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := nsNode.ReplPing(time.Second); err != nil {
+			t.Error(err)
+		}
+	}()
+	start = time.Now()
+	for nsNode.GetStats().AdminQueueLen != 1 {
+		if time.Since(start) > time.Second*2 {
+			t.Fatal("the replication ping should be queued")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	release()
+	wg.Wait()
+
+	// the control command is proposed ahead of the data write queued before
+	mutex.Lock()
+	if !reflect.DeepEqual(applied, []string{"set", "replping", "set"}) {
+		t.Fatalf("the control command should be applied ahead of the queued write: %v", applied)
+	}
+	mutex.Unlock()
+	nsNode.SetApplyHook(nil)
+
+	// the table drop is a data write and keeps the order with the writes
+	n, err := nsNode.DropTable("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("dropped keys mismatch: %v", n)
+	}
+}
+
+func TestBitField(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:bitfield"
+	rets, err := goredis.Values(c.Do("bitfield", key, "set", "u8", "#1", 255,
+		"get", "u8", 8, "get", "i4", "#2", "incrby", "u8", 8, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 4 || rets[0].(int64) != 0 || rets[1].(int64) != 255 ||
+		rets[2].(int64) != -1 || rets[3].(int64) != 9 {
+		t.Fatalf("bitfield result mismatch: %v", rets)
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "\x00\x09" {
+		t.Fatalf("bitfield value mismatch: %q", v)
+	}
+
+	// the signed incrby saturates at the type max and the failed one is nil
+	rets, err = goredis.Values(c.Do("bitfield", key, "overflow", "sat",
+		"incrby", "i8", 16, 100, "incrby", "i8", 16, 100,
+		"overflow", "fail", "incrby", "i8", 16, 1, "incrby", "i8", 16, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 4 || rets[0].(int64) != 100 || rets[1].(int64) != 127 ||
+		rets[2] != nil || rets[3].(int64) != 126 {
+		t.Fatalf("bitfield overflow result mismatch: %v", rets)
+	}
+	if _, err := c.Do("bitfield", key, "get", "u64", 0); err == nil {
+		t.Fatal("bitfield with u64 should fail")
+	}
+	if _, err := c.Do("bitfield", key, "overflow", "none", "get", "u8", 0); err == nil {
+		t.Fatal("bitfield with invalid overflow should fail")
+	}
+	if _, err := c.Do("bitfield", key, "incrby", "i8", 0); err == nil {
+		t.Fatal("bitfield with missing increment should fail")
+	}
+}
+
+func TestExpireKeyspaceStats(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsNode := kvs.GetNamespace("default").node
+	before := nsNode.GetStats().ExpireStats
+	key := "default:test:expire_stats_hash"
+	if _, err := c.Do("hmset", key, "f1", "v1", "f2", "v2", "f3", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hpexpire", key, 300, "FIELDS", 2, "f1", "f2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hpexpire", key, 100000, "FIELDS", 1, "f3"); err != nil {
+		t.Fatal(err)
+	}
+	stats := nsNode.GetStats().ExpireStats
+	if stats.TTLNum != before.TTLNum+3 || stats.AvgTTLMs <= 0 {
+		t.Fatalf("the fields with expire time should be counted: %v, %v", before, stats)
+	}
+	info, err := goredis.String(c.Do("info", "keyspace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(info, "# Keyspace\r\n") ||
+		!strings.Contains(info, "default:keys=") ||
+		!strings.Contains(info, fmt.Sprintf(",expires=%d,", stats.TTLNum)) {
+		t.Fatalf("the keyspace info mismatch: %v", info)
+	}
+
+	start := time.Now()
+	for {
+		n, err := goredis.Int(c.Do("hlen", key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		if time.Since(start) > time.Second*5 {
+			t.Fatalf("the expired fields should be reclaimed: %v", n)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	stats = nsNode.GetStats().ExpireStats
+	if stats.TTLNum != before.TTLNum+1 {
+		t.Fatalf("the reclaimed fields should not be counted: %v, %v", before, stats)
+	}
+	if stats.ExpiredNum < before.ExpiredNum+2 {
+		t.Fatalf("the reclaimed fields should be counted as expired: %v, %v", before, stats)
+	}
+	if _, err := c.Do("hclear", key); err != nil {
+		t.Fatal(err)
+	}
+	if stats = nsNode.GetStats().ExpireStats; stats.TTLNum != before.TTLNum {
+		t.Fatalf("the cleared fields should not be counted: %v, %v", before, stats)
+	}
+}
+
+// getTestFreePort return a free local port, the port may be taken by others
+// before used but it is good enough for the test.
+func getTestFreePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func getTestRaftAddr(t *testing.T) string {
+	return "127.0.0.1:" + strconv.Itoa(getTestFreePort(t))
+}
+
+// startTestNamespace start the namespace as the only member on a free raft
+// port and wait the leader elected, the namespace is stopped after the test.
+func startTestNamespace(t *testing.T, s *Server, clusterID uint64, nsConf *NamespaceConfig) string {
+	raftAddr := getTestRaftAddr(t)
+	if err := s.InitKVNamespace(clusterID, 1, raftAddr, map[int]string{1: raftAddr}, false, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stopTestNamespace(t, s, nsConf.Name)
+	})
+	start := time.Now()
+	for s.GetNamespace(nsConf.Name).node.GetStats().RaftStats.Leader == 0 {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the leader is not elected")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return raftAddr
+}
+
+// startTestReplica start the server with the new replica joined to the
+// namespace, the server and the data are removed after the test.
+func startTestReplica(t *testing.T, clusterID uint64, id int, clusterNodes map[int]string,
+	nsConf *NamespaceConfig) *Server {
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+	})
+	replica := NewServer(ServerConfig{DataDir: tmpDir, RedisAPIPort: getTestFreePort(t)})
+	if err := replica.InitKVNamespace(clusterID, id, clusterNodes[id], clusterNodes, true, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	replica.ServeAPI()
+	t.Cleanup(replica.Stop)
+	return replica
+}
+
+func stopTestNamespace(t *testing.T, s *Server, ns string) {
+	nsNode := s.GetNamespace(ns)
+	if nsNode == nil {
+		return
+	}
+	nsNode.node.Stop()
+	// the namespace is removed by the delete callback after stopped
+	start := time.Now()
+	for s.GetNamespace(ns) != nil {
+		if time.Since(start) > time.Second*10 {
+			t.Errorf("the namespace %v is not stopped", ns)
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func waitNamespaceMembers(t *testing.T, ns string, ids ...uint64) {
+	start := time.Now()
+	for {
+		nsNode := kvs.GetNamespace(ns)
+		if nsNode != nil {
+			mems := nsNode.node.GetMembers()
+			matched := len(mems) == len(ids)
+			for i := 0; matched && i < len(ids); i++ {
+				matched = mems[i].ID == ids[i]
+			}
+			if matched {
+				return
+			}
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the members of %v should be %v", ns, ids)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+func mustMarshalMember(ns string, id uint64, raftAddr string) []byte {
+	var m node.MemberInfo
+	m.ID = id
+	m.Namespace = ns
+	m.RaftURLs = append(m.RaftURLs, "http://"+raftAddr)
+	d, _ := json.Marshal(m)
+	return d
+}
+
+func addUnreachableMember(ns string, id uint64, raftAddr string) {
+	kvs.ProposeConfChange(ns, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  id,
+		Context: mustMarshalMember(ns, id, raftAddr),
+	})
+}
+
+func TestForceNewCluster(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "force_new_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kvs, 1005, nsConf)
+	if _, err := c.Do("set", ns+":test:force_new", "1"); err != nil {
+		t.Fatal(err)
+	}
+	// the new member never starts, so the quorum is lost
+	addUnreachableMember(ns, 2, getTestRaftAddr(t))
+	waitNamespaceMembers(t, ns, 1, 2)
+	start := time.Now()
+	for kvs.GetNamespace(ns).node.GetLeadMember() != nil {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the leader should step down without the quorum")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	req := httptest.NewRequest("POST", "/cluster/forcenew/"+ns+"?confirm=wrong", nil)
+	if _, err := kvs.doForceNewCluster(nil, req, httprouter.Params{{Key: "namespace", Value: ns}}); err == nil {
+		t.Fatal("the force new cluster should be confirmed by the namespace name")
+	}
+	if err := kvs.ForceNewCluster(ns); err != nil {
+		t.Fatal(err)
+	}
+	waitNamespaceMembers(t, ns, 1)
+	start = time.Now()
+	for {
+		if _, err := c.Do("set", ns+":test:force_new_after", "1"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the standalone namespace should accept the writes")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if v, err := goredis.String(c.Do("get", ns+":test:force_new")); err != nil {
+		t.Fatal(err)
+	} else if v != "1" {
+		t.Fatalf("the committed write should be kept: %v", v)
+	}
+
+	// the recovered group can grow again
+	addUnreachableMember(ns, 3, getTestRaftAddr(t))
+	waitNamespaceMembers(t, ns, 1, 3)
+}
+
+func TestScanExcludeExpiredHash(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	if _, err := c.Do("debug", "set-active-expire", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do("debug", "set-active-expire", 1)
+	for _, k := range []string{"a", "b"} {
+		if _, err := c.Do("hmset", "default:testscanexpired:"+k, "f1", "v1", "f2", "v2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Do("hpexpire", "default:testscanexpired:a", 10, "FIELDS", 2, "f1", "f2"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if ay, err := goredis.Values(c.Do("ADVSCAN", "default:testscanexpired:", "HASH", "count", 5)); err != nil {
+		t.Fatal(err)
+	} else if len(ay) != 2 {
+		t.Fatal(len(ay))
+	} else {
+		checkScanValues(t, ay[1], "testscanexpired:b")
+	}
+}
+
+func TestRenameCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	redisport := getTestFreePort(t)
+	kv := NewServer(ServerConfig{
+		DataDir:      tmpDir,
+		RedisAPIPort: redisport,
+		RenameCommands: map[string]string{
+			"set":   "SAFESET",
+			"debug": "",
+			"ping":  "get",
+			"get":   "ping",
+		},
+	})
+	nsConf := &NamespaceConfig{
+		Name:    "default",
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kv, 1006, nsConf)
+	kv.ServeAPI()
+	defer kv.Stop()
+
+	client := goredis.NewClient("127.0.0.1:"+strconv.Itoa(redisport), "")
+	c, err := client.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	key := "default:test:rename_command"
+	start := time.Now()
+	for {
+		if _, err := c.Do("safeset", key, "v1"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the renamed command should be handled")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := c.Do("set", key, "v2"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("the renamed command should only respond to the new name: %v", err)
+	}
+	if _, err := c.Do("debug", "set-active-expire", 0); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Fatalf("the disabled command should be rejected: %v", err)
+	}
+	// the swapped commands
+	if v, err := goredis.String(c.Do("ping", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatalf("the value mismatch: %v", v)
+	}
+	if v, err := goredis.String(c.Do("get")); err != nil {
+		t.Fatal(err)
+	} else if v != "PONG" {
+		t.Fatal(v)
+	}
+}
+
+func TestReplicaCatchup(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "catchup_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1007, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+	for i := 0; i < 100; i++ {
+		if _, err := c.Do("set", ns+":test:catchup_"+strconv.Itoa(i), "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Do("cluster", "catchup", ns, 2); err == nil {
+		t.Fatal("the non-member should have no catch-up progress")
+	}
+
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	cs, err := kvs.GetNamespace(ns).node.GetReplicaCatchup(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Match != 0 || cs.Progress != 0 || cs.InSync || cs.CommitIndex == 0 {
+		t.Fatalf("the new replica should begin from 0: %v", cs)
+	}
+
+	startTestReplica(t, 1007, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, nsConf)
+
+	start := time.Now()
+	lastProgress := cs.Progress
+	for {
+		cs, err = kvs.GetNamespace(ns).node.GetReplicaCatchup(2)
+		// the leader may step down while the new replica is starting
+		if err == nil {
+			if cs.Progress < lastProgress {
+				t.Fatalf("the progress should not go back: %v, %v", lastProgress, cs)
+			}
+			lastProgress = cs.Progress
+			if cs.InSync {
+				break
+			}
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the new replica should catch up: %v, %v", cs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if cs.Match == 0 || cs.EstimatedMs != 0 {
+		t.Fatalf("the in sync replica stats mismatch: %v", cs)
+	}
+}
+
+func TestStaleRead(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:stale_read"
+	if _, err := c.Do("set", key, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.String(c.Do("staleread", 0, 0, "get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatal(v)
+	}
+	if v, err := goredis.String(c.Do("staleread", -1, -1, "get", key)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatal(v)
+	}
+	if _, err := c.Do("staleread", 0, 0, "set", key, "v2"); err == nil {
+		t.Fatal("the write command should not be allowed")
+	}
+	if _, err := c.Do("staleread", "a", 0, "get", key); err == nil {
+		t.Fatal("the invalid max lag should fail")
+	}
+
+	// the lag is unknown while the replica has no leader
+	ns := "stale_read_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kvs, 1008, nsConf)
+	if _, err := c.Do("set", ns+":test:stale_read", "1"); err != nil {
+		t.Fatal(err)
+	}
+	addUnreachableMember(ns, 2, getTestRaftAddr(t))
+	waitNamespaceMembers(t, ns, 1, 2)
+	start := time.Now()
+	for kvs.GetNamespace(ns).node.GetLeadMember() != nil {
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the leader should step down without the quorum")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if _, err := c.Do("staleread", 100, 1000, "get", ns+":test:stale_read"); err == nil || !strings.HasPrefix(err.Error(), "STALE") {
+		t.Fatalf("the read should be refused while the lag is unknown: %v", err)
+	}
+	// the normal read is still served locally
+	if v, err := goredis.String(c.Do("get", ns+":test:stale_read")); err != nil {
+		t.Fatal(err)
+	} else if v != "1" {
+		t.Fatal(v)
+	}
+}
+
+func TestCompactLog(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// use the small wal segment to have several wal files for the test
+	oldSegmentSize := wal.SegmentSizeBytes
+	wal.SegmentSizeBytes = 32 * 1024
+	defer func() {
+		wal.SegmentSizeBytes = oldSegmentSize
+	}()
+	ns := "compact_log_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1009, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	startTestReplica(t, 1009, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, nsConf)
+
+	waitInSync := func() *common.ReplicaCatchupStats {
+		start := time.Now()
+		for {
+			cs, err := kvs.GetNamespace(ns).node.GetReplicaCatchup(2)
+			if err == nil && cs.InSync && cs.Lag == 0 {
+				return cs
+			}
+			if time.Since(start) > time.Second*20 {
+				t.Fatalf("the replica should catch up: %v, %v", cs, err)
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	value := strings.Repeat("v", 128)
+	for i := 0; i < 2000; i++ {
+		if _, err := c.Do("set", ns+":test:compact_"+strconv.Itoa(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := waitInSync()
+
+	if _, err := c.Do("cluster", "compactlog", "not_exist_ns"); err == nil {
+		t.Fatal("the compact log of the not exist namespace should fail")
+	}
+	v, err := goredis.MultiBulk(c.Do("cluster", "compactlog", ns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := make(map[string]int64)
+	for i := 0; i+1 < len(v); i += 2 {
+		stats[string(v[i].([]byte))] = v[i+1].(int64)
+	}
+	if stats["snap_index"] < int64(cs.Match) {
+		t.Fatalf("the snapshot should be at the applied index: %v, %v", stats, cs)
+	}
+	// the caught up follower need no log before its match index
+	if stats["compact_index"] < int64(cs.Match) || stats["compact_index"] > stats["snap_index"] {
+		t.Fatalf("the compact index mismatch: %v, %v", stats, cs)
+	}
+	if stats["first_index"] != stats["compact_index"]+1 {
+		t.Fatalf("the log should be truncated to the compact index: %v", stats)
+	}
+	if stats["purged_wals"] == 0 || stats["wal_files"] == 0 {
+		t.Fatalf("the wal files should be purged: %v", stats)
+	}
+
+	// the caught up follower should replicate from the log without snapshot
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", ns+":test:compact_after_"+strconv.Itoa(i), "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs = waitInSync()
+	if cs.Snapshotting || cs.Match <= uint64(stats["snap_index"]) {
+		t.Fatalf("the follower should be unaffected by the compaction: %v, %v", cs, stats)
+	}
+}
+
+func TestHashGetDelAndGetEx(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:hgetex"
+	if _, err := c.Do("hmset", key, 1, 1, 2, 2, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hgetex", key, "EX", 100, "FIELDS", 3, 1, 2, 4)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 3 || string(v[0].([]byte)) != "1" || string(v[1].([]byte)) != "2" || v[2] != nil {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("httl", key, "FIELDS", 3, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) <= 0 || v[1].(int64) <= 0 || v[2].(int64) != -1 {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hgetex", key, "PERSIST", "FIELDS", 1, 1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || string(v[0].([]byte)) != "1" {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("httl", key, "FIELDS", 2, 1, 2)); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) != -1 || v[1].(int64) <= 0 {
+		t.Fatal(v)
+	}
+	// no option should keep the expire time
+	if v, err := goredis.MultiBulk(c.Do("hgetex", key, "FIELDS", 1, 2)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || string(v[0].([]byte)) != "2" {
+		t.Fatal(v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("httl", key, "FIELDS", 1, 2)); err != nil {
+		t.Fatal(err)
+	} else if v[0].(int64) <= 0 {
+		t.Fatal(v)
+	}
+	if _, err := c.Do("hgetex", key, "EX", 0, "FIELDS", 1, 1); err == nil {
+		t.Fatal("the invalid expire time should fail")
+	}
+	if _, err := c.Do("hgetex", key, "EX", 100, 1); err == nil {
+		t.Fatal("invalid err of args")
+	}
+	if _, err := c.Do("hgetdel", key, "FIELDS", 2, 1); err == nil {
+		t.Fatal("invalid err of fields number")
+	}
+
+	// hgetdel should delete the fields on all the replicas
+	ns := "hgetdel_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1010, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+	key = ns + ":test:hgetdel"
+	if _, err := c.Do("hmset", key, 1, 1, 2, 2, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1010, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, nsConf)
+	replicaPort := replica.conf.RedisAPIPort
+
+	start := time.Now()
+	for {
+		v, err := goredis.MultiBulk(c.Do("hgetdel", key, "FIELDS", 3, 1, 3, 4))
+		if err == nil {
+			if len(v) != 3 || string(v[0].([]byte)) != "1" || string(v[1].([]byte)) != "3" || v[2] != nil {
+				t.Fatal(v)
+			}
+			break
+		}
+		// the leader may step down while the new replica is starting
+		if time.Since(start) > time.Second*20 {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hgetdel", key, "FIELDS", 1, 1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || v[0] != nil {
+		t.Fatal(v)
+	}
+	rc := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replicaPort), "")
+	replicaConn, err := rc.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaConn.Close()
+	start = time.Now()
+	for {
+		v, err := goredis.MultiBulk(replicaConn.Do("hmget", key, 1, 2, 3))
+		if err == nil && len(v) == 3 && v[0] == nil && v[2] != nil {
+			t.Fatalf("the deleted fields should be gone on the replica: %v", v)
+		}
+		if err == nil && len(v) == 3 && v[0] == nil && v[1] != nil && v[2] == nil {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the replica should apply the hgetdel: %v, %v", v, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if n, err := goredis.Int(replicaConn.Do("hlen", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestListReliableQueue(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	work := "default:test:queue_work"
+	processing := "default:test:queue_processing"
+	if _, err := c.Do("rpush", work, "job1", "job2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.String(c.Do("rpoplpush", work, processing)); err != nil {
+		t.Fatal(err)
+	} else if v != "job2" {
+		t.Fatal(v)
+	}
+	if v, err := goredis.String(c.Do("rpoplpush", work, processing)); err != nil {
+		t.Fatal(err)
+	} else if v != "job1" {
+		t.Fatal(v)
+	}
+	if v, err := c.Do("rpoplpush", work, processing); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("pop from the empty list should return nil: %v", v)
+	}
+
+	// job2 is acked and job1 is left unacked by the crashed consumer
+	if n, err := goredis.Int(c.Do("lack", processing, "job2")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("lack", processing, "job2")); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("the acked item should be gone: %v", n)
+	}
+	if v, err := goredis.MultiBulk(c.Do("lrange", processing, 0, -1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || string(v[0].([]byte)) != "job1" {
+		t.Fatalf("the unacked item should remain: %v", v)
+	}
+
+	// requeue the unacked item
+	if v, err := goredis.String(c.Do("rpoplpush", processing, work)); err != nil {
+		t.Fatal(err)
+	} else if v != "job1" {
+		t.Fatal(v)
+	}
+	if n, err := goredis.Int(c.Do("llen", processing)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("llen", work)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("rpoplpush", work, "other:test:queue_processing"); err == nil {
+		t.Fatal("the keys in the different namespaces should fail")
+	}
+}
+
+func TestSwapKeys(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	kvKey := "default:test:swap_kv"
+	hKey := "default:test:swap_hash"
+	if _, err := c.Do("set", kvKey, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hmset", hKey, "f1", "hv1", "f2", "hv2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hpexpire", hKey, 100000, "FIELDS", 1, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.String(c.Do("swap", kvKey, hKey)); err != nil {
+		t.Fatal(err)
+	} else if v != "OK" {
+		t.Fatal(v)
+	}
+	if v, err := goredis.String(c.Do("get", hKey)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatalf("the hash key should hold the string: %v", v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hmget", kvKey, "f1", "f2")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || string(v[0].([]byte)) != "hv1" || string(v[1].([]byte)) != "hv2" {
+		t.Fatalf("the kv key should hold the hash: %v", v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("hpttl", kvKey, "FIELDS", 2, "f1", "f2")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 2 || v[0].(int64) <= 0 || v[0].(int64) > 100000 || v[1].(int64) != -1 {
+		t.Fatalf("the field ttl should be swapped: %v", v)
+	}
+	if _, err := c.Do("hlen", hKey); err == nil {
+		t.Fatal("the hash key holding the string should be wrong type")
+	}
+
+	// swap with the missing key
+	missing := "default:test:swap_missing"
+	if _, err := c.Do("swap", hKey, missing); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Do("get", hKey); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("the key should be missing after swapped: %v", v)
+	}
+	if v, err := goredis.String(c.Do("get", missing)); err != nil {
+		t.Fatal(err)
+	} else if v != "v1" {
+		t.Fatalf("the string should be moved: %v", v)
+	}
+
+	if _, err := c.Do("swap", kvKey, "other:test:swap_kv"); err == nil {
+		t.Fatal("the keys in the different namespaces should fail")
+	}
+}
+
+func TestDebugProfile(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", "default:test:profile_kv_"+strconv.Itoa(i), "v"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Do("hset", "default:test:profile_hash_"+strconv.Itoa(i), "f", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := goredis.Bytes(c.Do("debug", "profile", "default", 100000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p rockredis.KeyProfile
+	if err := json.Unmarshal(d, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.KeyNum < 20 || p.SampleNum < 20 {
+		t.Fatalf("all the keys should be sampled: %v", string(d))
+	}
+	if p.Types["string"] == nil || p.Types["string"].SampleNum < 10 ||
+		p.Types["hash"] == nil || p.Types["hash"].SampleNum < 10 {
+		t.Fatalf("the type histogram mismatch: %v", string(d))
+	}
+	if _, err := c.Do("debug", "profile", "nonexist_namespace"); err == nil {
+		t.Fatal("the profile of the nonexist namespace should fail")
+	}
+	if _, err := c.Do("debug", "profile", "default", -1); err == nil {
+		t.Fatal("the invalid sample number should fail")
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	keys := map[string]string{
+		"string": "default:test:wrongtype_string",
+		"hash":   "default:test:wrongtype_hash",
+		"list":   "default:test:wrongtype_list",
+		"set":    "default:test:wrongtype_set",
+		"zset":   "default:test:wrongtype_zset",
+	}
+	if _, err := c.Do("set", keys["string"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("hset", keys["hash"], "f", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("rpush", keys["list"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("sadd", keys["set"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("zadd", keys["zset"], 1, "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the read and the write command of each type
+	cmds := []struct {
+		tp   string
+		name string
+		args []interface{}
+	}{
+		{"string", "get", nil},
+		{"string", "append", []interface{}{"v"}},
+		{"hash", "hget", []interface{}{"f"}},
+		{"hash", "hset", []interface{}{"f", "v"}},
+		{"list", "llen", nil},
+		{"list", "lpush", []interface{}{"v"}},
+		{"set", "smembers", nil},
+		{"set", "sadd", []interface{}{"v"}},
+		{"zset", "zscore", []interface{}{"v"}},
+		{"zset", "zadd", []interface{}{1, "v"}},
+	}
+	for _, cmd := range cmds {
+		for tp, key := range keys {
+			_, err := c.Do(cmd.name, append([]interface{}{key}, cmd.args...)...)
+			if tp == cmd.tp {
+				if err != nil {
+					t.Fatalf("%v on the %v key failed: %v", cmd.name, tp, err)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+				t.Fatalf("%v on the %v key should be wrong type: %v", cmd.name, tp, err)
+			}
+		}
+	}
+	// the command with multi keys
+	if _, err := c.Do("rpoplpush", keys["list"], keys["set"]); err == nil ||
+		!strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("rpoplpush to the set key should be wrong type: %v", err)
+	}
+	if _, err := c.Do("zunion", 2, keys["zset"], keys["hash"]); err == nil ||
+		!strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("zunion with the hash key should be wrong type: %v", err)
+	}
+	if v, err := goredis.Int(c.Do("llen", keys["list"])); err != nil || v != 2 {
+		t.Fatalf("the list should not be changed by the failed command: %v, %v", v, err)
+	}
+	// the key can be used as the other type after deleted
+	if _, err := c.Do("del", keys["string"]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("lpush", keys["string"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	// the set never returns the wrong type, setnx on the key of the other
+	// type is not set, and set overwrites the key of any type
+	if v, err := goredis.Int(c.Do("setnx", keys["hash"], "v")); err != nil || v != 0 {
+		t.Fatalf("setnx on the hash key should not be set: %v, %v", v, err)
+	}
+	if v, err := goredis.Int(c.Do("hlen", keys["hash"])); err != nil || v != 1 {
+		t.Fatalf("the hash should not be changed by setnx: %v, %v", v, err)
+	}
+	if _, err := c.Do("set", keys["hash"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("mset", keys["set"], "v", keys["zset"], "v"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tp := range []string{"hash", "set", "zset"} {
+		if v, err := goredis.String(c.Do("get", keys[tp])); err != nil || v != "v" {
+			t.Fatalf("the %v key should be overwritten: %v, %v", tp, v, err)
+		}
+	}
+	if _, err := c.Do("hget", keys["hash"], "f"); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("the hash should be deleted by set: %v", err)
+	}
+	if _, err := c.Do("scard", keys["set"]); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("the set should be deleted by mset: %v", err)
+	}
+}
+
+// zero the data of the last record in the wal file to simulate the torn write
+func tearLastWALRecord(t *testing.T, walDir string) string {
+	names, err := filepath.Glob(filepath.Join(walDir, "*.wal"))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no wal file found: %v", err)
+	}
+	sort.Strings(names)
+	f := names[len(names)-1]
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := -1
+	lastLen := 0
+	for off := 0; off+8 <= len(data); {
+		l := binary.LittleEndian.Uint64(data[off : off+8])
+		if l == 0 {
+			break
+		}
+		recBytes := int(l & ^(uint64(0xff) << 56))
+		padBytes := 0
+		if l>>63 != 0 {
+			padBytes = int((l >> 56) & 0x7)
+		}
+		if off+8+recBytes+padBytes > len(data) {
+			break
+		}
+		last, lastLen = off, recBytes
+		off += 8 + recBytes + padBytes
+	}
+	if last < 0 {
+		t.Fatalf("no record in the wal file %v", f)
+	}
+	for i := last + 8; i < last+8+lastLen; i++ {
+		data[i] = 0
+	}
+	if err := ioutil.WriteFile(f, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestWALTornWriteRepair(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "wal_repair_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1011, nsConf)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", ns+":test:wal_repair_"+strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopTestNamespace(t, kvs, ns)
+	walFile := tearLastWALRecord(t, filepath.Join(kvs.conf.DataDir, ns, "wal-1"))
+
+	// the node should restart from the repaired wal instead of exiting
+	if err := kvs.InitKVNamespace(1011, 1, raftAddr, map[int]string{1: raftAddr}, false, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(walFile + ".broken"); err != nil {
+		t.Fatalf("the broken wal file should be kept: %v", err)
+	}
+	start := time.Now()
+	for {
+		if _, err := c.Do("set", ns+":test:wal_repair", "1"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the repaired namespace should accept the writes")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := goredis.String(c.Do("get", ns+":test:wal_repair_"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		} else if v != strconv.Itoa(i) {
+			t.Fatalf("the applied write should be kept: %v", v)
+		}
+	}
+}
+
+func TestCorruptedStoreRecovery(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "store_corrupt_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1027, nsConf)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do("set", ns+":test:store_corrupt_"+strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stopTestNamespace(t, kvs, ns)
+	dataDir := filepath.Join(kvs.conf.DataDir, ns, "rocksdb")
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "CURRENT"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the corrupted store is moved aside and rebuilt from the raft log
+	if err := kvs.InitKVNamespace(1027, 1, raftAddr, map[int]string{1: raftAddr}, false, nsConf); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, err := filepath.Glob(dataDir + ".corrupt.*"); err != nil || len(dirs) != 1 {
+		t.Fatalf("the corrupted store should be kept: %v, %v", dirs, err)
+	}
+	start := time.Now()
+	for {
+		if _, err := c.Do("set", ns+":test:store_corrupt", "1"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatal("the recovered namespace should accept the writes")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	for i := 0; i < 10; i++ {
+		if v, err := goredis.String(c.Do("get", ns+":test:store_corrupt_"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		} else if v != strconv.Itoa(i) {
+			t.Fatalf("the applied write should be recovered: %v", v)
+		}
+	}
+}
+
+func TestZAddOptions(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:zadd_options"
+	if n, err := goredis.Int(c.Do("zadd", key, 10, "a")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if n, err := goredis.Int(c.Do("zadd", key, "xx", "ch", 11, "a", 1, "b")); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	if v, err := c.Do("zscore", key, "b"); err != nil || v != nil {
+		t.Fatal(v, err)
+	}
+	// the increment violating the GT is not applied
+	if v, err := c.Do("zadd", key, "gt", "incr", -1, "a"); err != nil || v != nil {
+		t.Fatal(v, err)
+	}
+	if s, err := goredis.Int64(c.Do("zscore", key, "a")); err != nil || s != 11 {
+		t.Fatal(s, err)
+	}
+	if s, err := goredis.Int64(c.Do("zadd", key, "incr", -1, "a")); err != nil || s != 10 {
+		t.Fatal(s, err)
+	}
+	if s, err := goredis.Int64(c.Do("zadd", key, "gt", "incr", 2, "a")); err != nil || s != 12 {
+		t.Fatal(s, err)
+	}
+	// the zincrby is the same as the zadd incr
+	if s, err := goredis.Int64(c.Do("zincrby", key, -2, "a")); err != nil || s != 10 {
+		t.Fatal(s, err)
+	}
+
+	if _, err := c.Do("zadd", key, "nx", "xx", 1, "a"); err == nil {
+		t.Fatal("nx and xx should not be compatible")
+	}
+	if _, err := c.Do("zadd", key, "gt", "lt", 1, "a"); err == nil {
+		t.Fatal("gt and lt should not be compatible")
+	}
+	if _, err := c.Do("zadd", key, "incr", 1, "a", 2, "b"); err == nil {
+		t.Fatal("incr should support only one pair")
+	}
+}
+
+func TestScanLimits(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "scan_limit_test"
+	nsConf := &NamespaceConfig{
+		Name:               ns,
+		EngType:            "rocksdb",
+		MaxConcurrentScans: 1,
+		ScanTimeBudgetMs:   1,
+	}
+	startTestNamespace(t, kvs, 1012, nsConf)
+	if _, err := c.Do("set", ns+":test:a", "v"); err != nil {
+		t.Fatal(err)
+	}
+	keyNum := 20000
+	for i := 0; i < keyNum; i += 500 {
+		args := make([]interface{}, 0, 1000)
+		for j := i; j < i+500; j++ {
+			args = append(args, fmt.Sprintf("%v:test:k_%05d", ns, j), "v")
+		}
+		if _, err := c.Do("mset", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the scan matching nothing exceed the budget and return the cursor to resume
+	cursor := "test:"
+	budgetErrs := 0
+	for {
+		ay, err := goredis.Values(c.Do("advscan", ns+":"+cursor, "KV", "match", "nomatch*", "count", 10))
+		if err != nil {
+			pos := strings.Index(err.Error(), "resume with cursor ")
+			if pos < 0 {
+				t.Fatal(err)
+			}
+			next := err.Error()[pos+len("resume with cursor "):]
+			if next <= cursor {
+				t.Fatalf("the scan should be resumed from the later key: %v, %v", next, cursor)
+			}
+			cursor = next
+			budgetErrs++
+			continue
+		}
+		if len(ay[1].([]interface{})) != 0 || string(ay[0].([]byte)) != "" {
+			t.Fatalf("the scan should match nothing: %v", ay)
+		}
+		break
+	}
+	if budgetErrs == 0 {
+		t.Fatal("the scan should exceed the budget")
+	}
+
+	// the partial page is returned with the cursor to resume
+	found := make(map[string]bool)
+	cursor = "test:k_"
+	for {
+		ay, err := goredis.Values(c.Do("advscan", ns+":"+cursor, "KV", "match", "test:k_*", "count", 5000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := goredis.Strings(ay[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			found[k] = true
+		}
+		cursor = string(ay[0].([]byte))
+		if cursor == "" {
+			break
+		}
+	}
+	if len(found) != keyNum {
+		t.Fatalf("all the keys should be scanned: %v", len(found))
+	}
+
+	// the scans beyond the concurrency limit are rejected
+	var rejected int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		conn := getTestConn(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			for j := 0; j < 100 && atomic.LoadInt32(&rejected) == 0; j++ {
+				_, err := conn.Do("advscan", ns+":test:", "KV", "match", "nomatch*", "count", 10)
+				if err != nil && strings.Contains(err.Error(), "too many scans") {
+					atomic.StoreInt32(&rejected, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&rejected) == 0 {
+		t.Fatal("the concurrent scans beyond the limit should be rejected")
+	}
+}
+
+func TestSnapCatchup(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "snap_catchup_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddrs := map[int]string{
+		1: startTestNamespace(t, kvs, 1013, nsConf),
+		2: getTestRaftAddr(t),
+		3: getTestRaftAddr(t),
+	}
+	if _, err := c.Do("cluster", "snapcatchup", ns, 0); err == nil {
+		t.Fatal("the zero snap catchup should be refused")
+	}
+	if n, err := goredis.Int(c.Do("cluster", "snapcatchup", ns, 100)); err != nil || n != 100 {
+		t.Fatalf("the snap catchup should be changed: %v, %v", n, err)
+	}
+	if n, err := goredis.Int(c.Do("cluster", "snapcatchup", ns)); err != nil || n != 100 {
+		t.Fatalf("the snap catchup mismatch: %v, %v", n, err)
+	}
+
+	startReplica := func(id int, dir string) *Server {
+		replica := NewServer(ServerConfig{DataDir: dir})
+		if err := replica.InitKVNamespace(1013, id, raftAddrs[id], raftAddrs, true, nsConf); err != nil {
+			t.Fatal(err)
+		}
+		return replica
+	}
+	waitInSync := func(id uint64) *common.ReplicaCatchupStats {
+		start := time.Now()
+		for {
+			cs, err := kvs.GetNamespace(ns).node.GetReplicaCatchup(id)
+			if err == nil && cs.InSync && cs.Lag == 0 {
+				return cs
+			}
+			if time.Since(start) > time.Second*20 {
+				t.Fatalf("the replica %v should catch up: %v, %v", id, cs, err)
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	// the snap catchup is replicated through the raft
+	waitSnapCatchup := func(s *Server, n uint64) {
+		start := time.Now()
+		for {
+			v := s.GetNamespace(ns).node.GetSnapCatchup()
+			if v == n {
+				return
+			}
+			if time.Since(start) > time.Second*10 {
+				t.Fatalf("the snap catchup should be replicated: %v", v)
+			}
+			time.Sleep(time.Millisecond * 100)
+		}
+	}
+	writeKeys := func(prefix string, num int) {
+		for i := 0; i < num; i++ {
+			if _, err := c.Do("set", ns+":test:"+prefix+strconv.Itoa(i), "1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dirs := make(map[int]string)
+	for _, id := range []int{2, 3} {
+		tmpDir, err := ioutil.TempDir("", fmt.Sprintf("rocksdb-test-%d", time.Now().UnixNano()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		dirs[id] = tmpDir
+	}
+	addUnreachableMember(ns, 2, raftAddrs[2])
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica2 := startReplica(2, dirs[2])
+	defer replica2.Stop()
+	waitInSync(2)
+	waitSnapCatchup(replica2, 100)
+	addUnreachableMember(ns, 3, raftAddrs[3])
+	waitNamespaceMembers(t, ns, 1, 2, 3)
+	replica3 := startReplica(3, dirs[3])
+	waitInSync(3)
+
+	// the follower behind within the snap catchup is caught up from the log
+	replica3.Stop()
+	writeKeys("within_", 20)
+	if _, err := kvs.GetNamespace(ns).node.CompactLog(); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := kvs.GetNamespace(ns).node.GetReplicaCatchup(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cs.FromLog || cs.Lag == 0 || cs.SnapCatchup != 100 || cs.FirstIndex <= 1 {
+		t.Fatalf("the follower within the snap catchup should catch up from the log: %v", cs)
+	}
+	replica3 = startReplica(3, dirs[3])
+	cs = waitInSync(3)
+	if cs.SentSnapshots != 0 {
+		t.Fatalf("no snapshot should be sent to the follower within the snap catchup: %v", cs)
+	}
+
+	// the follower behind beyond the snap catchup needs the snapshot
+	if _, err := c.Do("cluster", "snapcatchup", ns, 10); err != nil {
+		t.Fatal(err)
+	}
+	replica3.Stop()
+	writeKeys("beyond_", 100)
+	start := time.Now()
+	for {
+		// the log is kept for the stopped follower until it is inactive
+		if _, err := kvs.GetNamespace(ns).node.CompactLog(); err != nil {
+			t.Fatal(err)
+		}
+		cs, err = kvs.GetNamespace(ns).node.GetReplicaCatchup(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cs.FromLog {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the log should be compacted beyond the stopped follower: %v", cs)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+	if cs.CommitIndex-cs.FirstIndex > 10 {
+		t.Fatalf("only the snap catchup entries should be kept: %v", cs)
+	}
+	replica3 = startReplica(3, dirs[3])
+	defer replica3.Stop()
+	start = time.Now()
+	for {
+		cs, err = kvs.GetNamespace(ns).node.GetReplicaCatchup(3)
+		if err == nil && cs.SentSnapshots > 0 {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the snapshot should be sent to the follower beyond the snap catchup: %v, %v", cs, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	// the snap catchup is restored with the snapshot
+	waitSnapCatchup(replica2, 10)
+	waitSnapCatchup(replica3, 10)
+}
+
+func TestKVImport(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "kv_import_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1014, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1014, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, nsConf)
+	replicaPort := replica.conf.RedisAPIPort
+
+	importArgs := func(prefix string, from int, to int) []interface{} {
+		args := make([]interface{}, 0, (to-from)*2)
+		for i := from; i < to; i++ {
+			args = append(args, ns+":test:"+prefix+strconv.Itoa(i), strconv.Itoa(i))
+		}
+		return args
+	}
+	if _, err := c.Do("kvimport", ns+":test:import_odd"); err == nil {
+		t.Fatal("the import without value should fail")
+	}
+
+	// the writes may fail while the leader is waiting the new replica
+	keyNum := 100000
+	batch := 4000
+	// the import batch can be larger than the write batch
+	importBatch := 20000
+	start := time.Now()
+	for i := 0; i < keyNum; {
+		n, err := goredis.Int(c.Do("kvimport", importArgs("import_", i, i+importBatch)...))
+		if err != nil {
+			if time.Since(start) > time.Second*30 {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		if n != importBatch {
+			t.Fatalf("the whole batch should be imported: %v", n)
+		}
+		i += importBatch
+	}
+
+	// the batch with any existing key is refused as a whole unless forced
+	args := append(importArgs("import_new_", 0, 10), ns+":test:import_0", "changed")
+	if _, err := c.Do("kvimport", args...); err == nil {
+		t.Fatal("the import should refuse to overwrite the existing key")
+	}
+	if n, err := goredis.Int(c.Do("exists", ns+":test:import_new_0")); err != nil || n != 0 {
+		t.Fatalf("nothing in the refused batch should be written: %v, %v", n, err)
+	}
+	if n, err := goredis.Int(c.Do("kvimport", append(args, "FORCE")...)); err != nil {
+		t.Fatal(err)
+	} else if n != 11 {
+		t.Fatal(n)
+	}
+	if v, err := goredis.String(c.Do("get", ns+":test:import_0")); err != nil {
+		t.Fatal(err)
+	} else if v != "changed" {
+		t.Fatalf("the existing key should be overwritten by force: %v", v)
+	}
+
+	rc := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replicaPort), "")
+	replicaConn, err := rc.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaConn.Close()
+	for _, conn := range []*goredis.PoolConn{c, replicaConn} {
+		start = time.Now()
+		for i := 1; i < keyNum; {
+			keys := make([]interface{}, 0, batch)
+			for j := i; j < i+batch && j < keyNum; j++ {
+				keys = append(keys, ns+":test:import_"+strconv.Itoa(j))
+			}
+			vals, err := goredis.Strings(conn.Do("mget", keys...))
+			if err == nil && vals[len(vals)-1] == "" && time.Since(start) < time.Second*20 {
+				// the replica may be still applying the import
+				time.Sleep(time.Millisecond * 100)
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, v := range vals {
+				if v != strconv.Itoa(i+j) {
+					t.Fatalf("the imported key %v mismatch: %v", i+j, v)
+				}
+			}
+			i += len(vals)
+		}
+	}
+
+	// the import is much faster than the individual writes
+	benchNum := 2000
+	start = time.Now()
+	for i := 0; i < benchNum; i++ {
+		if _, err := c.Do("set", ns+":test:bench_set_"+strconv.Itoa(i), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setCost := time.Since(start)
+	start = time.Now()
+	if _, err := c.Do("kvimport", importArgs("bench_import_", 0, benchNum)...); err != nil {
+		t.Fatal(err)
+	}
+	importCost := time.Since(start)
+	t.Logf("%v keys cost %v by set and %v by import", benchNum, setCost, importCost)
+	if importCost >= setCost {
+		t.Fatalf("the import should be faster than the individual writes: %v, %v", importCost, setCost)
+	}
+}
+
+func TestDebugObject(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:debug_object"
+	if _, err := c.Do("set", key, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := goredis.String(c.Do("debug", "object", key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"refcount:1", "encoding:embstr", "serializedlength:5"} {
+		if !strings.Contains(v, field) {
+			t.Fatalf("the debug object should have %v: %v", field, v)
+		}
+	}
+
+	listKey := "default:test:debug_object_list"
+	for i := 0; i < 300; i++ {
+		if _, err := c.Do("rpush", listKey, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err = goredis.String(c.Do("debug", "object", listKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"encoding:quicklist", "serializedlength:300"} {
+		if !strings.Contains(v, field) {
+			t.Fatalf("the debug object should have %v: %v", field, v)
+		}
+	}
+
+	if _, err := c.Do("debug", "object", "default:test:debug_object_nokey"); err == nil ||
+		!strings.Contains(err.Error(), "no such key") {
+		t.Fatalf("the missing key should fail: %v", err)
+	}
+	if _, err := c.Do("debug", "object", "nonexist_namespace:test:key"); err == nil {
+		t.Fatal("the key of the nonexist namespace should fail")
+	}
+}
+
+func TestClusterIdentity(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	if id, err := goredis.Int(c.Do("cluster", "myid", "default")); err != nil {
+		t.Fatal(err)
+	} else if id != 1 {
+		t.Fatalf("the id should be the configured node id: %v", id)
+	}
+	if _, err := c.Do("cluster", "myid", "nonexist_namespace"); err == nil {
+		t.Fatal("the nonexist namespace should fail")
+	}
+	v, err := goredis.MultiBulk(c.Do("cluster", "identity", "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := make(map[string]interface{})
+	for i := 0; i+1 < len(v); i += 2 {
+		info[string(v[i].([]byte))] = v[i+1]
+	}
+	if string(info["namespace"].([]byte)) != "default" || info["partition"].(int64) != 0 {
+		t.Fatalf("the partition should be the served namespace: %v", info)
+	}
+	if info["id"].(int64) != 1 || info["cluster_id"].(int64) != 1000 ||
+		string(info["raft_addr"].([]byte)) != "127.0.0.1:12345" {
+		t.Fatalf("the identity mismatch: %v", info)
+	}
+}
+
+func TestRaftStateDump(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "raft_state_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	startTestNamespace(t, kvs, 1015, nsConf)
+	if _, err := c.Do("set", ns+":test:raft_state", "1"); err != nil {
+		t.Fatal(err)
+	}
+	getState := func() common.RaftStateDump {
+		d, err := goredis.Bytes(c.Do("cluster", "raftstate", ns))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rs common.RaftStateDump
+		if err := json.Unmarshal(d, &rs); err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	rs := getState()
+	if len(rs.ConfNodes) != 1 || rs.ConfNodes[0] != 1 {
+		t.Fatalf("the conf state should have only myself: %v", rs)
+	}
+	if rs.Term == 0 || rs.Vote != 1 || rs.Commit == 0 || rs.AppliedIndex == 0 {
+		t.Fatalf("the hard state mismatch: %v", rs)
+	}
+
+	addUnreachableMember(ns, 2, getTestRaftAddr(t))
+	waitNamespaceMembers(t, ns, 1, 2)
+	start := time.Now()
+	for {
+		rs = getState()
+		if len(rs.ConfNodes) == 2 {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the conf state should have the new member: %v", rs)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if rs.ConfNodes[0] != 1 || rs.ConfNodes[1] != 2 {
+		t.Fatalf("the conf state mismatch: %v", rs)
+	}
+	// the leader may step down since the new member is unreachable
+	if len(rs.Members) != 0 && (len(rs.Members) != 2 || rs.Members[1].ID != 2 || rs.Members[1].Match != 0) {
+		t.Fatalf("the progress of the new member mismatch: %v", rs)
+	}
+	if _, err := c.Do("cluster", "raftstate", "nonexist_namespace"); err == nil {
+		t.Fatal("the nonexist namespace should fail")
+	}
+}
+
+func TestMinISR(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "min_isr_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+		MinISR:  2,
+	}
+	raftAddr := startTestNamespace(t, kvs, 1016, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+	key := ns + ":test:min_isr"
+	if _, err := c.Do("set", key, "1"); err == nil || !strings.Contains(err.Error(), "NOREPLICAS") {
+		t.Fatalf("the write should be rejected with only the leader: %v", err)
+	}
+	if v, err := c.Do("get", key); err != nil || v != nil {
+		t.Fatalf("the read should be allowed: %v, %v", v, err)
+	}
+
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1016, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, nsConf)
+
+	// the write is resumed once the new replica is in sync
+	start := time.Now()
+	for {
+		_, err := c.Do("set", key, "1")
+		if err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the write should be resumed with the in-sync replica: %v", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	if n := kvs.GetNamespace(ns).node.GetISRCount(); n != 2 {
+		t.Fatalf("the in-sync replicas mismatch: %v", n)
+	}
+	// the in-sync replicas are only evaluated on the leader, the write on the
+	// follower is checked by the leader after forwarded
+	if n := replica.GetNamespace(ns).node.GetISRCount(); n != 0 {
+		t.Fatalf("the follower should not count the in-sync replicas: %v", n)
+	}
+	rc := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replica.conf.RedisAPIPort), "")
+	replicaConn, err := rc.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaConn.Close()
+	if _, err := replicaConn.Do("set", key, "2"); err != nil {
+		t.Fatalf("the write on the follower should be allowed: %v", err)
+	}
+	if v, err := goredis.String(replicaConn.Do("get", key)); err != nil || v != "2" {
+		t.Fatalf("the write on the follower should be applied: %v, %v", v, err)
+	}
+}
+
+func TestReplPing(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "repl_ping_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1017, nsConf)
+	replicaRaftAddr := getTestRaftAddr(t)
+	downRaftAddr := getTestRaftAddr(t)
+	ay, err := goredis.Values(c.Do("replping", ns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ay) != 1 {
+		t.Fatalf("only the leader should be pinged: %v", ay)
+	}
+
+	addUnreachableMember(ns, 2, replicaRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	startTestReplica(t, 1017, 2, map[int]string{1: raftAddr, 2: replicaRaftAddr}, nsConf)
+	key := ns + ":test:repl_ping"
+	start := time.Now()
+	for {
+		if _, err := c.Do("set", key, "1"); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatal("the write should be done with the new replica")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	// the third member is never started
+	addUnreachableMember(ns, 3, downRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2, 3)
+
+	var stats [][]interface{}
+	start = time.Now()
+	for {
+		ay, err := goredis.Values(c.Do("replping", ns, 500))
+		if err != nil {
+			if time.Since(start) > time.Second*20 {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		stats = stats[:0]
+		for _, v := range ay {
+			stats = append(stats, v.([]interface{}))
+		}
+		break
+	}
+	if len(stats) != 3 {
+		t.Fatalf("all the members should be pinged: %v", stats)
+	}
+	for i, s := range stats {
+		id := s[0].(int64)
+		state := s[1].(string)
+		latency := s[2].(int64)
+		switch i {
+		case 0:
+			if id != 1 || state != "leader" || latency <= 0 || latency > 500000 {
+				t.Fatalf("the leader latency is not plausible: %v", s)
+			}
+		case 1:
+			if id != 2 || state != "ok" || latency <= 0 || latency > 500000 {
+				t.Fatalf("the follower latency is not plausible: %v", s)
+			}
+		case 2:
+			if id != 3 || state != "timeout" || latency != -1 {
+				t.Fatalf("the down follower should be timed out: %v", s)
+			}
+		}
+	}
+	if _, err := c.Do("replping", "nonexist_ns"); err == nil {
+		t.Fatal("the ping of the nonexist namespace should fail")
+	}
+}
+
+func TestReadTxn(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+	wc := getTestConn(t)
+	defer wc.Close()
+
+	key := "default:test:read_txn_kv"
+	hkey := "default:test:read_txn_hash"
+	zkey := "default:test:read_txn_zset"
+	if _, err := wc.Do("set", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.Do("hmset", hkey, "f1", "1", "f2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.Do("zadd", zkey, 1, "m1"); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := goredis.Int64(c.Do("readtxn", "begin", "default")); err != nil || index <= 0 {
+		t.Fatalf("the read transaction should begin: %v, %v", index, err)
+	}
+	if _, err := c.Do("readtxn", "begin", "default"); err == nil {
+		t.Fatal("the read transaction should not be nested")
+	}
+
+	// the writes during the transaction are not observed inside it
+	if _, err := wc.Do("set", key, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.Do("hset", hkey, "f3", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.Do("zadd", zkey, 2, "m2"); err != nil {
+		t.Fatal(err)
+	}
+	readNum := kvs.GetNamespace("default").node.GetStats().ReadStats.ReadNum
+	if v, err := goredis.String(c.Do("get", key)); err != nil || v != "1" {
+		t.Fatalf("the read in transaction should see the snapshot: %v, %v", v, err)
+	}
+	// the reads in transaction are accounted on the namespace
+	if n := kvs.GetNamespace("default").node.GetStats().ReadStats.ReadNum; n <= readNum {
+		t.Fatalf("the read in transaction should be counted: %v, %v", n, readNum)
+	}
+	if n, err := goredis.Int(c.Do("hlen", hkey)); err != nil || n != 2 {
+		t.Fatalf("the read in transaction should see the snapshot: %v, %v", n, err)
+	}
+	if vals, err := goredis.Strings(c.Do("hgetall", hkey)); err != nil || len(vals) != 4 {
+		t.Fatalf("the read in transaction should see the snapshot: %v, %v", vals, err)
+	}
+	if vals, err := goredis.Strings(c.Do("zrange", zkey, 0, -1)); err != nil || len(vals) != 1 {
+		t.Fatalf("the read in transaction should see the snapshot: %v, %v", vals, err)
+	}
+	if v, err := goredis.String(wc.Do("get", key)); err != nil || v != "2" {
+		t.Fatalf("the read out of transaction should see the write: %v, %v", v, err)
+	}
+	if _, err := c.Do("set", key, "3"); err == nil {
+		t.Fatal("the write should not be allowed in the read transaction")
+	}
+	if v, err := goredis.String(c.Do("readtxn", "commit")); err != nil || v != "OK" {
+		t.Fatalf("the read transaction should commit: %v, %v", v, err)
+	}
+	if _, err := c.Do("readtxn", "commit"); err == nil {
+		t.Fatal("the commit without begin should fail")
+	}
+	if v, err := goredis.String(c.Do("get", key)); err != nil || v != "2" {
+		t.Fatalf("the read after commit should see the write: %v, %v", v, err)
+	}
+
+	// the snapshot is released after the timeout
+	if _, err := c.Do("readtxn", "begin", "default", 100); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 300)
+	if _, err := c.Do("get", key); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("the read in the expired transaction should fail: %v", err)
+	}
+	if _, err := c.Do("readtxn", "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("readtxn", "begin", "nonexist_ns"); err == nil {
+		t.Fatal("the read transaction of the nonexist namespace should fail")
+	}
+}
+
+func TestWarmupAfterRestore(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "warmup_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1018, nsConf)
+	newRaftAddr := getTestRaftAddr(t)
+	keyNum := 200
+	for i := 0; i < keyNum; i++ {
+		if _, err := c.Do("set", ns+":test:warmup_"+strconv.Itoa(i), "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// all the log is compacted without the followers, so the new replica
+	// can only catch up by the snapshot
+	cs, err := kvs.GetNamespace(ns).node.CompactLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addUnreachableMember(ns, 2, newRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replicaConf := *nsConf
+	replicaConf.WarmupMaxBytes = 1024 * 1024 * 1024
+	replica := startTestReplica(t, 1018, 2, map[int]string{1: raftAddr, 2: newRaftAddr}, &replicaConf)
+
+	// the replica is read ready with the restored data only after warmed up
+	start := time.Now()
+	for {
+		n := replica.GetNamespace(ns).node
+		rs, err := n.DumpRaftState()
+		if err == nil && rs.AppliedIndex >= cs.SnapIndex && n.IsReadReady() {
+			ws, ok := n.GetWarmupStats()
+			if !ok {
+				t.Fatal("the replica should be warmed up before read ready")
+			}
+			if !ws.Done || ws.Keys < int64(keyNum) {
+				t.Fatalf("all the restored data should be warmed up: %v", ws)
+			}
+			break
+		}
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the replica should restore from the snapshot: %v, %v", rs, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if _, ok := kvs.GetNamespace(ns).node.GetWarmupStats(); ok {
+		t.Fatal("the leader never restored should not be warmed up")
+	}
+}
+
+func TestScanResumeAfterFailover(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	ns := "scan_failover_test"
+	nsConf := &NamespaceConfig{
+		Name:    ns,
+		EngType: "rocksdb",
+	}
+	raftAddr := startTestNamespace(t, kvs, 1019, nsConf)
+	replicaRaftAddr := getTestRaftAddr(t)
+	if _, err := c.Do("set", ns+":test:k_000", "0"); err != nil {
+		t.Fatal(err)
+	}
+	addUnreachableMember(ns, 2, replicaRaftAddr)
+	waitNamespaceMembers(t, ns, 1, 2)
+	replica := startTestReplica(t, 1019, 2, map[int]string{1: raftAddr, 2: replicaRaftAddr}, nsConf)
+	replicaPort := replica.conf.RedisAPIPort
+
+	start := time.Now()
+	keyNum := 100
+	for i := 1; i < keyNum; {
+		// the writes may fail while the leader is waiting the new replica
+		if _, err := c.Do("set", fmt.Sprintf("%s:test:k_%03d", ns, i), strconv.Itoa(i)); err != nil {
+			if time.Since(start) > time.Second*30 {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		i++
+	}
+
+	seen := make(map[string]int, keyNum)
+	scanPage := func(conn *goredis.PoolConn, cursor string) string {
+		ay, err := goredis.Values(conn.Do("scan", ns+":"+cursor, "count", 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := goredis.Strings(ay[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			seen[k]++
+		}
+		return string(ay[0].([]byte))
+	}
+	cursor := scanPage(c, "test:")
+	cursor = scanPage(c, cursor)
+	if cursor == "" || len(seen) != 60 {
+		t.Fatalf("the scan should be in progress before the failover: %v, %v", cursor, len(seen))
+	}
+	ay, err := goredis.Values(c.Do("advscan", ns+":"+cursor, "KV", "count", 10, "SNAPSHOT", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapCursor := string(ay[0].([]byte))
+	snapID := string(ay[2].([]byte))
+
+	start = time.Now()
+	for replica.GetNamespace(ns).node.GetRaftStats().Role != "StateLeader" {
+		// the transfer may be refused while the replica is lagging
+		err := kvs.TransferLeader(ns, 2)
+		if time.Since(start) > time.Second*20 {
+			t.Fatalf("the leader should be transferred to the replica: %v", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	rc := goredis.NewClient("127.0.0.1:"+strconv.Itoa(replicaPort), "")
+	replicaConn, err := rc.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replicaConn.Close()
+	// wait the new leader applied all the writes
+	start = time.Now()
+	for {
+		v, err := goredis.String(replicaConn.Do("get", fmt.Sprintf("%s:test:k_%03d", ns, keyNum-1)))
+		if err == nil && v == strconv.Itoa(keyNum-1) {
+			break
+		}
+		if time.Since(start) > time.Second*10 {
+			t.Fatalf("the new leader should apply all the writes: %v, %v", v, err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	for cursor != "" {
+		cursor = scanPage(replicaConn, cursor)
+	}
+	if len(seen) != keyNum {
+		t.Fatalf("the resumed scan should cover all the keys: %v", len(seen))
+	}
+	for i := 0; i < keyNum; i++ {
+		k := fmt.Sprintf("test:k_%03d", i)
+		if seen[k] != 1 {
+			t.Fatalf("the key %v should be scanned exactly once: %v", k, seen[k])
+		}
+	}
+
+	// the snapshot is pinned on the old leader only, but the cursor can be
+	// resumed with a new snapshot on the new leader
+	if _, err := replicaConn.Do("advscan", ns+":"+snapCursor, "KV", "count", 10, "SNAPSHOT", snapID); err == nil {
+		t.Fatal("the snapshot of the old leader should not be found on the new leader")
+	}
+	ay, err = goredis.Values(replicaConn.Do("advscan", ns+":"+snapCursor, "KV", "count", 10, "SNAPSHOT", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := goredis.Strings(ay[1], nil); len(keys) != 10 || keys[0] != "test:k_070" {
+		t.Fatalf("the scan should be resumed from the cursor with the new snapshot: %v", keys)
+	}
+}
+
+func TestApplyStallWatchdog(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsNode := kvs.GetNamespace("default")
+	before := nsNode.node.GetStats().ApplyStallNum
+	key := "default:test:apply_stall"
+	// delay the apply of the incr by the hook
+	var sleepMs int64
+	nsNode.node.SetApplyHook(func(cmdName string) {
+		if cmdName == "incr" {
+			time.Sleep(time.Duration(atomic.LoadInt64(&sleepMs)) * time.Millisecond)
+		}
+	})
+	defer nsNode.node.SetApplyHook(nil)
+	atomic.StoreInt64(&sleepMs, testApplyStallTimeout/5)
+	if _, err := c.Do("incr", key); err != nil {
+		t.Fatal(err)
+	}
+	if n := nsNode.node.GetStats().ApplyStallNum; n != before {
+		t.Fatalf("the apply within the timeout should not be dumped: %v, %v", n, before)
+	}
+
+	// the stall is dumped only once even if much longer than the timeout
+	atomic.StoreInt64(&sleepMs, testApplyStallTimeout*3)
+	start := time.Now()
+	if _, err := c.Do("incr", key); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(start); cost < time.Duration(testApplyStallTimeout*3)*time.Millisecond {
+		t.Fatalf("the apply should be stalled: %v", cost)
+	}
+	if n := nsNode.node.GetStats().ApplyStallNum; n != before+1 {
+		t.Fatalf("the stalled apply should be dumped once: %v, %v", n, before)
+	}
+	// the node still serve the writes after the stall
+	nsNode.node.SetApplyHook(nil)
+	if _, err := c.Do("set", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// send the raw protocol and return the reply until the connection closed
+func sendRawProtocol(t *testing.T, data string) (string, bool) {
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(redisport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		// the connection is still open until the deadline
+		return string(reply), false
+	}
+	return string(reply), true
+}
+
+func TestProtocolGuard(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	// only the header of the huge bulk is sent
+	reply, closed := sendRawProtocol(t, fmt.Sprintf("*3\r\n$3\r\nset\r\n$17\r\ndefault:test:huge\r\n$%d\r\nvvv",
+		testProtoMaxBulkLen+1))
+	if !closed || !strings.HasPrefix(reply, "-ERR Protocol error: invalid bulk length") {
+		t.Fatalf("the bulk exceeding the limit should be refused and closed: %q, %v", reply, closed)
+	}
+	reply, closed = sendRawProtocol(t, fmt.Sprintf("*%d\r\n$4\r\nmget\r\n", testMaxCommandArgs+1))
+	if !closed || !strings.HasPrefix(reply, "-ERR Protocol error: invalid multibulk length") {
+		t.Fatalf("the args exceeding the limit should be refused and closed: %q, %v", reply, closed)
+	}
+	reply, closed = sendRawProtocol(t, "*2\r\n$3\r\nget\r\n*1\r\n$1\r\na\r\n")
+	if !closed || !strings.HasPrefix(reply, "-ERR Protocol error: expected '$'") {
+		t.Fatalf("the nested multibulk should be refused and closed: %q, %v", reply, closed)
+	}
+	// the pipelined commands before the abusive one are replied
+	reply, closed = sendRawProtocol(t, fmt.Sprintf("*1\r\n$4\r\nping\r\n*%d\r\n", testMaxCommandArgs+1))
+	if !closed || reply != "+PONG\r\n-ERR Protocol error: invalid multibulk length\r\n" {
+		t.Fatalf("the command before the abusive one should be handled: %q, %v", reply, closed)
+	}
+
+	// the large but legal commands
+	args := make([]interface{}, 0, common.MAX_BATCH_NUM*2)
+	keys := make([]interface{}, 0, common.MAX_BATCH_NUM)
+	for i := 0; i < common.MAX_BATCH_NUM-1; i++ {
+		key := "default:test:proto_guard_" + strconv.Itoa(i)
+		args = append(args, key, strconv.Itoa(i))
+		keys = append(keys, key)
+	}
+	if v, err := goredis.String(c.Do("mset", args...)); err != nil {
+		t.Fatal(err)
+	} else if v != OK {
+		t.Fatal(v)
+	}
+	if vals, err := goredis.Strings(c.Do("mget", keys...)); err != nil {
+		t.Fatal(err)
+	} else if len(vals) != len(keys) || vals[1] != "1" {
+		t.Fatalf("the command with many args should succeed: %v", len(vals))
+	}
+	value := strings.Repeat("v", testMaxValueSize)
+	if _, err := c.Do("set", "default:test:proto_guard_large", value); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.String(c.Do("get", "default:test:proto_guard_large")); err != nil {
+		t.Fatal(err)
+	} else if v != value {
+		t.Fatalf("the large value should be read back: %v", len(v))
+	}
+}
+
+func TestSnapshotNow(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		if _, err := c.Do("set", "default:test:snapshot_now_"+strconv.Itoa(i), "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nsNode := kvs.GetNamespace("default").node
+	applied := nsNode.GetStats().AppliedIndex
+
+	if _, err := c.Do("cluster", "snapshot", "not_exist_ns"); err == nil {
+		t.Fatal("the snapshot of the not exist namespace should fail")
+	}
+	v, err := goredis.MultiBulk(c.Do("cluster", "snapshot", "default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 6 {
+		t.Fatalf("the snapshot result mismatch: %v", v)
+	}
+	term := uint64(v[1].(int64))
+	index := uint64(v[3].(int64))
+	backupDir := string(v[5].([]byte))
+	if index < applied || index > nsNode.GetStats().AppliedIndex || term == 0 {
+		t.Fatalf("the snapshot should be at the applied index %v: %v", applied, v)
+	}
+	if _, err := os.Stat(backupDir); err != nil {
+		t.Fatalf("the backup of the snapshot should exist: %v", err)
+	}
+	var rs raftpb.Snapshot
+	rs.Metadata.Term = term
+	rs.Metadata.Index = index
+	rs.Data = []byte("{}")
+	data, _ := rs.Marshal()
+	if ok, err := nsNode.CheckLocalBackup(data); err != nil || !ok {
+		t.Fatalf("the snapshot should be restorable: %v, %v", ok, err)
+	}
+
+	// the writes continue after the snapshot
+	if _, err := c.Do("set", "default:test:snapshot_now_after", "1"); err != nil {
+		t.Fatal(err)
+	}
+	sr, err := nsNode.SnapshotNow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Index <= index {
+		t.Fatalf("the new snapshot should be at the new applied index: %v, %v", sr, index)
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	admin := getTestConn(t)
+	defer admin.Close()
+	c := getTestConn(t)
+	defer c.Close()
+	defer admin.Do("client", "unpause")
+
+	key := "default:test:client_pause"
+	if _, err := c.Do("set", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Do("client", "pause", "100", "none"); err == nil {
+		t.Fatal("the invalid pause mode should fail")
+	}
+	if _, err := admin.Do("client", "pause", "-1"); err == nil {
+		t.Fatal("the invalid pause timeout should fail")
+	}
+	if _, err := admin.Do("client", "nosub"); err == nil || !strings.Contains(err.Error(), "unknown subcommand") {
+		t.Fatalf("the unknown subcommand should fail: %v", err)
+	}
+
+	// the writes block until the pause timeout while the reads proceed
+	if v, err := goredis.String(admin.Do("client", "pause", "500", "WRITE")); err != nil || v != OK {
+		t.Fatal(v, err)
+	}
+	start := time.Now()
+	if v, err := goredis.String(c.Do("get", key)); err != nil || v != "1" {
+		t.Fatal(v, err)
+	}
+	if cost := time.Since(start); cost > time.Millisecond*200 {
+		t.Fatalf("the read should not be paused: %v", cost)
+	}
+	if _, err := c.Do("set", key, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(start); cost < time.Millisecond*400 {
+		t.Fatalf("the write should be paused until timeout: %v", cost)
+	}
+
+	// the writes block until unpaused
+	if _, err := admin.Do("client", "pause", "10000", "WRITE"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Do("set", key, "3")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("the write should be paused: %v", err)
+	case <-time.After(time.Millisecond * 300):
+	}
+	if _, err := admin.Do("client", "unpause"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("the write should be resumed after unpaused")
+	}
+
+	// both the reads and writes are paused by ALL
+	if _, err := admin.Do("client", "pause", "500", "ALL"); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if v, err := goredis.String(c.Do("get", key)); err != nil || v != "3" {
+		t.Fatal(v, err)
+	}
+	if cost := time.Since(start); cost < time.Millisecond*400 {
+		t.Fatalf("the read should be paused until timeout: %v", cost)
+	}
+	// the admin command is never paused
+	if _, err := admin.Do("client", "pause", "10000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Do("ping"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Do("client", "unpause"); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if _, err := c.Do("get", key); err != nil {
+		t.Fatal(err)
+	}
+	if cost := time.Since(start); cost > time.Millisecond*200 {
+		t.Fatalf("the read should not be paused after unpaused: %v", cost)
+	}
+}
+
+func TestZSetMemberExpire(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	key := "default:test:zmemberexpire"
+	if n, err := goredis.Int(c.Do("zadd", key, 1, "a", 2, "b")); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("zaddex", key, 1, 3, "c", 4, "b")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("zcard", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Fatal(n)
+	}
+
+	time.Sleep(time.Millisecond * 1100)
+	if n, err := goredis.Int(c.Do("zcard", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if v, err := goredis.Strings(c.Do("zrange", key, 0, -1)); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 || v[0] != "a" {
+		t.Fatal(v)
+	}
+	if v, err := c.Do("zscore", key, "b"); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("expired member should not have score: %v", v)
+	}
+	// wait the expired members deleted by the sweeper
+	time.Sleep(time.Second * 2)
+	if n, err := goredis.Int(c.Do("zadd", key, 5, "c")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if n, err := goredis.Int(c.Do("zcard", key)); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+
+	if _, err := c.Do("zaddex", key, 0, 1, "a"); err == nil {
+		t.Fatal("invalid err of ttl")
+	}
+	if _, err := c.Do("zaddex", key, 10, 1); err == nil {
+		t.Fatal("invalid err of args")
+	}
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	if _, err := goredis.Int(c.Do("latency", "reset")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := goredis.MultiBulk(c.Do("latency", "history", "incr")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 0 {
+		t.Fatal(v)
+	}
+	// inject the slow write applied longer than the default threshold
+	nsNode := kvs.GetNamespace("default")
+	nsNode.node.SetApplyHook(func(cmdName string) {
+		if cmdName == "incr" {
+			time.Sleep(defaultLatencyMonitorThresholdMs * 2 * time.Millisecond)
+		}
+	})
+	_, err := c.Do("incr", "default:test:latency_monitor")
+	nsNode.node.SetApplyHook(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := goredis.MultiBulk(c.Do("latency", "latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range v {
+		event := e.([]interface{})
+		if string(event[0].([]byte)) != "incr" {
+			continue
+		}
+		found = true
+		if event[1].(int64) <= 0 || event[2].(int64) < defaultLatencyMonitorThresholdMs*2 ||
+			event[3].(int64) < event[2].(int64) {
+			t.Fatal(event)
+		}
+	}
+	if !found {
+		t.Fatalf("the slow command should be in the latest events: %v", v)
+	}
+	if v, err := goredis.MultiBulk(c.Do("latency", "history", "incr")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 1 {
+		t.Fatal(v)
+	}
+
+	if n, err := goredis.Int(c.Do("latency", "reset", "incr")); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatal(n)
+	}
+	if v, err := goredis.MultiBulk(c.Do("latency", "latest")); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, e := range v {
+			if string(e.([]interface{})[0].([]byte)) == "incr" {
+				t.Fatalf("the reset event should be cleared: %v", v)
+			}
+		}
+	}
+	if v, err := goredis.MultiBulk(c.Do("latency", "history", "incr")); err != nil {
+		t.Fatal(err)
+	} else if len(v) != 0 {
+		t.Fatal(v)
+	}
+	if _, err := c.Do("latency", "nosub"); err == nil {
+		t.Fatal("the unknown subcommand should fail")
+	}
+}
+
+func TestExpireSweepThrottle(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+
+	nsNode := kvs.GetNamespace("default")
+	maxRate := nsNode.node.GetStats().ExpireStats.SweepRate
+	if maxRate <= 0 {
+		t.Fatal(maxRate)
+	}
+
+	// the in-flight writes fill more than a quarter of the propose queue
+	stopC := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn := getTestConn(t)
+			defer conn.Close()
+			key := "default:test:sweep_throttle_" + strconv.Itoa(id)
+			for {
+				select {
+				case <-stopC:
+					return
+				default:
+				}
+				conn.Do("set", key, "v")
+			}
+		}(i)
+	}
+	dropped := false
+	for start := time.Now(); time.Since(start) < time.Second*10; time.Sleep(time.Millisecond * 100) {
+		if nsNode.node.GetStats().ExpireStats.SweepRate < maxRate {
+			dropped = true
+			break
+		}
+	}
+	close(stopC)
+	wg.Wait()
+	if !dropped {
+		t.Fatal("the sweep rate should drop under the saturating write load")
+	}
+
+	recovered := false
+	for start := time.Now(); time.Since(start) < time.Second*10; time.Sleep(time.Millisecond * 100) {
+		if nsNode.node.GetStats().ExpireStats.SweepRate == maxRate {
+			recovered = true
+			break
+		}
+	}
+	if !recovered {
+		t.Fatalf("the sweep rate should recover after the load subsides: %v",
+			nsNode.node.GetStats().ExpireStats.SweepRate)
+	}
+}
+
+func TestReadOwnWriteWithApplyDelayed(t *testing.T) {
+	c := getTestConn(t)
+	defer c.Close()
+	admin := getTestConn(t)
+	defer admin.Close()
+
+	key := "default:test:read_own_write"
+	if _, err := c.Do("set", key, "0"); err != nil {
+		t.Fatal(err)
+	}
+	// the incr blocks the apply until released, and the applied set is
+	// counted, so the order is checked without the timing
+	var released int32
+	var appliedSets int32
+	blockedC := make(chan struct{}, 1)
+	var releaseC atomic.Value
+	nsNode := kvs.GetNamespace("default")
+	nsNode.node.SetApplyHook(func(cmdName string) {
+		switch cmdName {
+		case "incr":
+			blockedC <- struct{}{}
+			<-releaseC.Load().(chan struct{})
+		case "set":
+			atomic.AddInt32(&appliedSets, 1)
+		}
+	})
+	defer nsNode.node.SetApplyHook(nil)
+	for i := 1; i <= 3; i++ {
+		release := make(chan struct{})
+		releaseC.Store(release)
+		atomic.StoreInt32(&released, 0)
+		done := make(chan error, 1)
+		go func() {
+			_, err := admin.Do("incr", "default:test:read_own_write_delay")
+			done <- err
+		}()
+		<-blockedC
+
+		v := strconv.Itoa(i)
+		applied := atomic.LoadInt32(&appliedSets)
+		setDone := make(chan error, 1)
+		go func() {
+			_, err := c.Do("set", key, v)
+			// the write is acknowledged only after applied
+			if err == nil && atomic.LoadInt32(&released) == 0 {
+				err = errors.New("the write is acknowledged while the apply is blocked")
+			}
+			if err == nil && atomic.LoadInt32(&appliedSets) != applied+1 {
+				err = errors.New("the write is acknowledged before applied")
+			}
+			setDone <- err
+		}()
+		// give the set the chance to be proposed while the apply is blocked,
+		// the checks do not depend on it
+		time.Sleep(time.Millisecond * 20)
+		atomic.StoreInt32(&released, 1)
+		close(release)
+		if err := <-setDone; err != nil {
+			t.Fatal(err)
+		}
+		if got, err := goredis.String(c.Do("get", key)); err != nil {
+			t.Fatal(err)
+		} else if got != v {
+			t.Fatalf("the read should see the write on the same connection: %v, %v", got, v)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -4,18 +4,27 @@ import (
 	"errors"
 	"github.com/absolute8511/ZanRedisDB/common"
 	"github.com/absolute8511/ZanRedisDB/node"
+	"github.com/absolute8511/ZanRedisDB/rockredis"
 	"github.com/absolute8511/ZanRedisDB/store"
 	"github.com/coreos/etcd/raft/raftpb"
 	"github.com/tidwall/redcon"
 	"net/http"
 	"path"
+	"strings"
 	"sync"
+	"time"
 )
 
 var (
 	errNamespaceNotFound = errors.New("namespace not found")
 )
 
+const (
+	drainTimeout                     = time.Second * 3
+	forceNewStopTimeout              = time.Second * 10
+	defaultLatencyMonitorThresholdMs = 100
+)
+
 var sLog = common.NewLevelLogger(common.LOG_INFO, common.NewDefaultLogger("server"))
 
 func SetLogger(level int32, logger common.Logger) {
@@ -31,6 +40,11 @@ type NamespaceNode struct {
 	node        *node.KVNode
 	conf        *NamespaceConfig
 	confChangeC chan raftpb.ConfChange
+	// the raft group to restart the namespace
+	clusterID uint64
+	raftID    int
+	raftAddr  string
+	raftPeers map[int]string
 }
 
 type Server struct {
@@ -40,6 +54,11 @@ type Server struct {
 	stopC   chan struct{}
 	wg      sync.WaitGroup
 	router  http.Handler
+	// the command name from client to the original command name, empty
+	// if the command is renamed or disabled.
+	renames map[string]string
+	pause   clientPause
+	latency *common.LatencyMonitor
 }
 
 func NewServer(conf ServerConfig) *Server {
@@ -47,17 +66,49 @@ func NewServer(conf ServerConfig) *Server {
 		kvNodes: make(map[string]*NamespaceNode),
 		conf:    conf,
 		stopC:   make(chan struct{}),
+		renames: make(map[string]string),
+	}
+	for name := range conf.RenameCommands {
+		s.renames[strings.ToLower(name)] = ""
+	}
+	for name, newName := range conf.RenameCommands {
+		if newName != "" {
+			s.renames[strings.ToLower(newName)] = strings.ToLower(name)
+		}
+		sLog.Infof("command %v renamed to: %q", name, newName)
+	}
+	// the commands costing not less than the threshold are recorded to the
+	// latency monitor, the negative threshold disables the monitor.
+	threshold := int64(conf.LatencyThresholdMs)
+	if threshold == 0 {
+		threshold = defaultLatencyMonitorThresholdMs
+	}
+	s.latency = common.NewLatencyMonitor(threshold)
+	rockredis.SetMaxConcurrentCompactions(conf.MaxCompactingNum)
+	if err := rockredis.SetChecksumAlgo(conf.ChecksumAlgo); err != nil {
+		sLog.Errorf("invalid checksum algorithm %q: %v, use %v", conf.ChecksumAlgo, err,
+			rockredis.GetChecksumAlgo())
 	}
 	return s
 }
 
 func (self *Server) Stop() {
 	self.mutex.Lock()
+	nsNodes := make(map[string]*NamespaceNode, len(self.kvNodes))
 	for k, n := range self.kvNodes {
-		n.node.Stop()
-		sLog.Infof("kv namespace stopped: %v", k)
+		nsNodes[k] = n
 	}
 	self.mutex.Unlock()
+	var wg sync.WaitGroup
+	for k, n := range nsNodes {
+		wg.Add(1)
+		go func(ns string, n *NamespaceNode) {
+			defer wg.Done()
+			n.node.Drain(drainTimeout)
+			sLog.Infof("kv namespace stopped: %v", ns)
+		}(k, n)
+	}
+	wg.Wait()
 	close(self.stopC)
 	self.wg.Wait()
 	sLog.Infof("server stopped")
@@ -80,6 +131,7 @@ func (self *Server) GetStats() common.ServerStats {
 		ss.NSStats = append(ss.NSStats, ns)
 	}
 	self.mutex.Unlock()
+	ss.CompactingNum = rockredis.GetCompactingNum()
 	return ss
 }
 
@@ -109,22 +161,64 @@ func (self *Server) onNamespaceDeleted(ns string) func() {
 
 func (self *Server) InitKVNamespace(clusterID uint64, id int, localRaftAddr string,
 	clusterNodes map[int]string, join bool, conf *NamespaceConfig) error {
+	return self.initKVNamespace(clusterID, id, localRaftAddr, clusterNodes, join, false, conf)
+}
+
+func (self *Server) initKVNamespace(clusterID uint64, id int, localRaftAddr string,
+	clusterNodes map[int]string, join bool, forceNew bool, conf *NamespaceConfig) error {
 	kvOpts := &store.KVOptions{
-		DataDir:     path.Join(self.conf.DataDir, conf.Name),
-		EngType:     conf.EngType,
-		SnapCount:   conf.SnapCount,
-		SnapCatchup: conf.SnapCatchup,
+		DataDir:                  path.Join(self.conf.DataDir, conf.Name),
+		EngType:                  conf.EngType,
+		SnapCount:                conf.SnapCount,
+		SnapCatchup:              conf.SnapCatchup,
+		EnableValueDedup:         conf.ValueDedup,
+		BlobMinValueSize:         conf.BlobMinValueSize,
+		MaxBackgroundCompactions: conf.MaxBackgroundCompactions,
+		MaxBackgroundFlushes:     conf.MaxBackgroundFlushes,
+		BackgroundLowThreads:     conf.BackgroundLowThreads,
+		BackgroundHighThreads:    conf.BackgroundHighThreads,
+		ScanTimeBudgetMs:         conf.ScanTimeBudgetMs,
 	}
 	nc := &node.NodeConfig{
-		BroadcastAddr: self.conf.BroadcastAddr,
-		HttpAPIPort:   self.conf.HttpAPIPort,
+		BroadcastAddr:        self.conf.BroadcastAddr,
+		HttpAPIPort:          self.conf.HttpAPIPort,
+		MaxValueSize:         self.conf.MaxValueSize,
+		MaxCollectionSize:    self.conf.MaxCollectionSize,
+		ProposeQueueSize:     self.conf.ProposeQueueSize,
+		MaxProposeBatchBytes: self.conf.MaxProposeBatchBytes,
+		MaxProposalBytes:     self.conf.MaxProposalBytes,
+		ReadOnlyRedisAPIPort: self.conf.ReadOnlyRedisAPIPort,
+		ReadOnlyCommands:     self.conf.ReadOnlyCommands,
+		SnapRetainNum:        self.conf.SnapRetainNum,
+		SnapRetainSeconds:    self.conf.SnapRetainSeconds,
+		MaxFullReadSize:      self.conf.MaxFullReadSize,
+		MaxConcurrentScans:   conf.MaxConcurrentScans,
+		MinISR:               conf.MinISR,
+		MinISRReads:          conf.MinISRReads,
+		WarmupMaxBytes:       conf.WarmupMaxBytes,
+		WarmupTimeoutMs:      conf.WarmupTimeoutMs,
+		ProposeTimeoutMs:     conf.ProposeTimeoutMs,
+		AuditLogDir:          self.conf.AuditLogDir,
+		AuditLogMaxSize:      self.conf.AuditLogMaxSize,
+		AuditRotateSeconds:   self.conf.AuditRotateSeconds,
+		AuditReads:           self.conf.AuditReads,
+		ApplyStallTimeoutMs:  self.conf.ApplyStallTimeoutMs,
+		ApplyStallCrash:      self.conf.ApplyStallCrash,
+		ForceNewCluster:      forceNew,
+		ExpireSweepMinRate:   self.conf.ExpireSweepMinRate,
+		ExpireSweepMaxRate:   self.conf.ExpireSweepMaxRate,
 	}
 	kv, confC := node.NewKVNode(kvOpts, nc, conf.Name, clusterID, id, localRaftAddr,
 		clusterNodes, join, self.onNamespaceDeleted(conf.Name))
+	kv.SetLatencyMonitor(self.latency)
 	n := &NamespaceNode{
 		node:        kv,
 		conf:        conf,
 		confChangeC: confC,
+		clusterID:   clusterID,
+		raftID:      id,
+		raftAddr:    localRaftAddr,
+		raftPeers:   clusterNodes,
 	}
 	self.mutex.Lock()
 	self.kvNodes[conf.Name] = n
@@ -143,40 +237,108 @@ func (self *Server) ProposeConfChange(ns string, cc raftpb.ConfChange) {
 	}
 }
 
+// DrainNamespace wait the in-flight writes of the namespace done and then stop it,
+// the new writes will be rejected while draining.
+func (self *Server) DrainNamespace(ns string, timeout time.Duration) error {
+	nsNode := self.GetNamespace(ns)
+	if nsNode == nil {
+		return errNamespaceNotFound
+	}
+	nsNode.node.Drain(timeout)
+	return nil
+}
+
+// ForceNewCluster restart the namespace as the only member of the raft group
+// from the local data, all the other members are removed and the uncommitted
+// writes are lost. This is the last resort to recover the namespace after the
+// quorum is lost forever, the replicas should be added back after recovered.
+func (self *Server) ForceNewCluster(ns string) error {
+	nsNode := self.GetNamespace(ns)
+	if nsNode == nil {
+		return errNamespaceNotFound
+	}
+	sLog.Infof("force restart the namespace %v as the standalone node %v", ns, nsNode.raftID)
+	nsNode.node.Stop()
+	// the namespace is removed by the delete callback after stopped
+	start := time.Now()
+	for self.GetNamespace(ns) != nil {
+		if time.Since(start) > forceNewStopTimeout {
+			return errors.New("wait the namespace stopped timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return self.initKVNamespace(nsNode.clusterID, nsNode.raftID, nsNode.raftAddr,
+		nsNode.raftPeers, false, true, nsNode.conf)
+}
+
+// TransferLeader transfer the leader of the namespace to the target node,
+// this should be called on the node of the current leader.
+func (self *Server) TransferLeader(ns string, targetID uint64) error {
+	nsNode := self.GetNamespace(ns)
+	if nsNode == nil {
+		return errNamespaceNotFound
+	}
+	return nsNode.node.TransferLeadership(targetID)
+}
+
 func (self *Server) ServeAPI() {
 	// api server should disable the api request while starting until replay log finished and
 	// also while we recovery we need to disable api.
 	self.wg.Add(2)
 	go func() {
 		defer self.wg.Done()
-		self.serveRedisAPI(self.conf.RedisAPIPort, self.stopC)
+		self.serveRedisAPI(self.conf.RedisAPIPort, self.serverRedis, self.stopC)
 	}()
 	go func() {
 		defer self.wg.Done()
 		self.serveHttpAPI(self.conf.HttpAPIPort, self.stopC)
 	}()
+	if self.conf.ReadOnlyRedisAPIPort > 0 {
+		self.wg.Add(1)
+		go func() {
+			defer self.wg.Done()
+			self.serveRedisAPI(self.conf.ReadOnlyRedisAPIPort, self.serverReadOnlyRedis, self.stopC)
+		}()
+	}
 }
 
 func (self *Server) GetHandler(cmdName string, cmd redcon.Command) (common.CommandFunc, redcon.Command, error) {
+	n, err := self.getCommandNamespace(cmdName, cmd)
+	if err != nil {
+		return nil, cmd, err
+	}
+	h, ok := n.node.GetHandler(cmdName)
+	if !ok {
+		return nil, cmd, common.ErrInvalidCommand
+	}
+	return h, cmd, nil
+}
+
+func (self *Server) getCommandNamespace(cmdName string, cmd redcon.Command) (*NamespaceNode, error) {
 	if len(cmd.Args) < 2 {
-		return nil, cmd, common.ErrInvalidArgs
+		return nil, common.ErrInvalidArgs
 	}
 	rawKey := cmd.Args[1]
+	switch cmdName {
+	case "object", "zunion", "zinter", "zdiff":
+		// object subcommand key
+		// zunion numkeys key [key ...]
+		if len(cmd.Args) < 3 {
+			return nil, common.ErrInvalidArgs
+		}
+		rawKey = cmd.Args[2]
+	}
 
 	namespace, _, err := common.ExtractNamesapce(rawKey)
 	if err != nil {
 		sLog.Infof("failed to get the namespace of the redis command:%v", rawKey)
-		return nil, cmd, err
+		return nil, err
 	}
 	self.mutex.Lock()
 	n, ok := self.kvNodes[namespace]
 	self.mutex.Unlock()
 	if !ok || n == nil {
-		return nil, cmd, errNamespaceNotFound
+		return nil, errNamespaceNotFound
 	}
-	h, ok := n.node.GetHandler(cmdName)
-	if !ok {
-		return nil, cmd, common.ErrInvalidCommand
-	}
-	return h, cmd, nil
+	return n, nil
 }
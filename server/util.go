@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"github.com/absolute8511/ZanRedisDB/common"
 	"github.com/tidwall/redcon"
 	"net"
 	"strconv"
@@ -36,6 +37,20 @@ func GetIPv4ForInterfaceName(ifname string) string {
 	return ""
 }
 
+// the pipeline commands can only be merged if all the keys
+// belong to the same namespace
+func isSameNamespace(rawKey1 []byte, rawKey2 []byte) bool {
+	ns1, _, err := common.ExtractNamesapce(rawKey1)
+	if err != nil {
+		return false
+	}
+	ns2, _, err := common.ExtractNamesapce(rawKey2)
+	if err != nil {
+		return false
+	}
+	return ns1 == ns2
+}
+
 // pipelineCommand creates a single command from a pipeline.
 func pipelineCommand(conn redcon.Conn, cmd redcon.Command) (int, redcon.Command, error) {
 	if conn == nil {
@@ -60,6 +75,9 @@ func pipelineCommand(conn redcon.Conn, cmd redcon.Command) (int, redcon.Command,
 			if qcmdlower(pcmd.Args[0]) != "get" || len(pcmd.Args) != 2 {
 				return 0, cmd, nil
 			}
+			if !isSameNamespace(cmd.Args[1], pcmd.Args[1]) {
+				return 0, cmd, nil
+			}
 		}
 		args = append(args, []byte("plget"))
 		for _, pcmd := range append([]redcon.Command{cmd}, pcmds...) {
@@ -74,6 +92,9 @@ func pipelineCommand(conn redcon.Conn, cmd redcon.Command) (int, redcon.Command,
 			if qcmdlower(pcmd.Args[0]) != "set" || len(pcmd.Args) != 3 {
 				return 0, cmd, nil
 			}
+			if !isSameNamespace(cmd.Args[1], pcmd.Args[1]) {
+				return 0, cmd, nil
+			}
 		}
 		args = append(args, []byte("plset"))
 		for _, pcmd := range append([]redcon.Command{cmd}, pcmds...) {
@@ -3,6 +3,7 @@ package store
 import (
 	"errors"
 	"os"
+	"time"
 
 	"github.com/absolute8511/ZanRedisDB/common"
 	"github.com/absolute8511/ZanRedisDB/rockredis"
@@ -19,6 +20,18 @@ type KVOptions struct {
 	EngType     string
 	SnapCount   int
 	SnapCatchup int
+	// the value deduplication can only be set while the namespace created
+	EnableValueDedup bool
+	// the min size of the kv value separated to the blob column family,
+	// the value separation can only be enabled while the namespace created
+	BlobMinValueSize int
+	// the time budget of each scan call in milliseconds, 0 means no limit
+	ScanTimeBudgetMs int
+	// the rocksdb background jobs parallelism
+	MaxBackgroundCompactions int
+	MaxBackgroundFlushes     int
+	BackgroundLowThreads     int
+	BackgroundHighThreads    int
 }
 
 func NewKVStore(kvopts *KVOptions) *KVStore {
@@ -38,6 +51,13 @@ func (s *KVStore) openDB() error {
 	if s.opts.EngType == "rocksdb" {
 		cfg := rockredis.NewRockConfig()
 		cfg.DataDir = s.opts.DataDir
+		cfg.EnableValueDedup = s.opts.EnableValueDedup
+		cfg.BlobMinValueSize = s.opts.BlobMinValueSize
+		cfg.ScanTimeBudgetMs = s.opts.ScanTimeBudgetMs
+		cfg.MaxBackgroundCompactions = s.opts.MaxBackgroundCompactions
+		cfg.MaxBackgroundFlushes = s.opts.MaxBackgroundFlushes
+		cfg.BackgroundLowThreads = s.opts.BackgroundLowThreads
+		cfg.BackgroundHighThreads = s.opts.BackgroundHighThreads
 		s.RockDB, err = rockredis.OpenRockDB(cfg)
 	} else {
 		return errors.New("Not recognized engine type:" + s.opts.EngType)
@@ -68,3 +88,16 @@ func (s *KVStore) LocalPut(key []byte, value []byte) error {
 func (s *KVStore) LocalWriteBatch(cmd ...common.WriteCmd) error {
 	return nil
 }
+
+// NewReadView return the store reading from a pinned snapshot of the data,
+// the view should be released by ReleaseReadView after used.
+func (s *KVStore) NewReadView(ttl time.Duration) (*KVStore, error) {
+	view, err := s.RockDB.NewReadView(ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &KVStore{
+		RockDB: view,
+		opts:   s.opts,
+	}, nil
+}